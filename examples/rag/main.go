@@ -46,7 +46,7 @@ func main() {
 	}
 
 	// Create an embedding provider
-	embeddings := rag.NewEmbeddings(provider, "text-embedding-ada-002", 1536)
+	embeddings := rag.NewEmbeddings(provider, "text-embedding-3-small", 1536)
 
 	// Create a RAG system
 	system := rag.NewRAGSystem(provider, embeddings)
@@ -8,4 +8,4 @@ replace github.com/GeoloeG-IsT/gollem/pkg/core => /home/ubuntu/gollem_github/pkg
 
 replace github.com/GeoloeG-IsT/gollem/pkg/providers/openai => /home/ubuntu/gollem_github/pkg/providers/openai
 
-require github.com/joho/godotenv v1.5.1 // indirect
+require github.com/joho/godotenv v1.5.1
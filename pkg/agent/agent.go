@@ -0,0 +1,367 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/tracing"
+	"github.com/GeoloeG-IsT/gollem/pkg/validation"
+)
+
+var (
+	// ErrMaxIterations is returned when the agent stops before the model
+	// gives a final answer because it reached the maximum number of iterations
+	ErrMaxIterations = errors.New("agent reached the maximum number of iterations")
+
+	// ErrTokenBudgetExceeded is returned when the agent stops before the model
+	// gives a final answer because it used up its token budget
+	ErrTokenBudgetExceeded = errors.New("agent exceeded its token budget")
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Agent runs a tool calling loop against an LLM provider
+type Agent struct {
+	provider      core.LLMProvider
+	tools         map[string]*tool
+	toolOrder     []string
+	tracer        tracing.Tracer
+	maxIterations int
+	tokenBudget   int
+	parallel      bool
+}
+
+// Option configures an Agent
+type Option func(*Agent)
+
+// WithTracer sets the tracer used to record the agent steps
+func WithTracer(tracer tracing.Tracer) Option {
+	return func(a *Agent) {
+		a.tracer = tracer
+	}
+}
+
+// WithMaxIterations sets the maximum number of generate calls in a run
+func WithMaxIterations(maxIterations int) Option {
+	return func(a *Agent) {
+		a.maxIterations = maxIterations
+	}
+}
+
+// WithTokenBudget sets the maximum number of tokens a run may use. A value
+// of 0 means no limit.
+func WithTokenBudget(tokens int) Option {
+	return func(a *Agent) {
+		a.tokenBudget = tokens
+	}
+}
+
+// WithParallelToolCalls sets whether the tool calls of a step are executed
+// concurrently
+func WithParallelToolCalls(parallel bool) Option {
+	return func(a *Agent) {
+		a.parallel = parallel
+	}
+}
+
+// NewAgent creates a new agent
+func NewAgent(provider core.LLMProvider, opts ...Option) *Agent {
+	a := &Agent{
+		provider:      provider,
+		tools:         make(map[string]*tool),
+		maxIterations: 10,
+		parallel:      true,
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return a
+}
+
+// tool is a registered Go function
+type tool struct {
+	definition core.Tool
+	fn         reflect.Value
+	structType reflect.Type
+}
+
+// RegisterTool registers a Go function as a tool. The function must have the
+// signature func(context.Context, Args) (Result, error), where Args is a
+// struct (or a pointer to one) whose JSON schema is generated with
+// validation.GenerateSchema. Results are sent to the model as is when they
+// are strings and JSON-encoded otherwise.
+func (a *Agent) RegisterTool(name, description string, fn interface{}) error {
+	if name == "" {
+		return errors.New("tool name is required")
+	}
+	if _, exists := a.tools[name]; exists {
+		return fmt.Errorf("tool %s is already registered", name)
+	}
+
+	// Check the function signature
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func || t.NumIn() != 2 || t.NumOut() != 2 ||
+		t.In(0) != contextType || t.Out(1) != errorType {
+		return fmt.Errorf("tool %s must have the signature func(context.Context, Args) (Result, error)", name)
+	}
+
+	// Generate the parameter schema from the arguments type
+	argsType := t.In(1)
+	structType := argsType
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	schema, err := validation.GenerateSchema(reflect.New(structType).Interface())
+	if err != nil {
+		return fmt.Errorf("failed to generate schema for tool %s: %w", name, err)
+	}
+
+	a.tools[name] = &tool{
+		definition: core.Tool{
+			Name:        name,
+			Description: description,
+			Parameters:  schema,
+		},
+		fn:         v,
+		structType: structType,
+	}
+	a.toolOrder = append(a.toolOrder, name)
+
+	return nil
+}
+
+// Tools returns the definitions of the registered tools
+func (a *Agent) Tools() []core.Tool {
+	tools := make([]core.Tool, 0, len(a.toolOrder))
+	for _, name := range a.toolOrder {
+		tools = append(tools, a.tools[name].definition)
+	}
+	return tools
+}
+
+// Result is the outcome of an agent run
+type Result struct {
+	// Response is the last response of the model
+	Response *core.Response
+
+	// Messages is the full conversation, including tool calls and results
+	Messages []core.Message
+
+	// Iterations is the number of generate calls made
+	Iterations int
+
+	// TokensUsed is the total token usage of the run
+	TokensUsed core.TokenUsage
+}
+
+// Run runs the agent loop for a prompt. It generates a response, executes
+// the requested tool calls and feeds their results back to the model until
+// the model gives a final answer. When the loop stops early because of the
+// iteration limit or token budget, the partial result is returned along with
+// ErrMaxIterations or ErrTokenBudgetExceeded.
+func (a *Agent) Run(ctx context.Context, prompt *core.Prompt) (*Result, error) {
+	ctx = a.startSpan(ctx, "agent_run", map[string]interface{}{
+		"provider": a.provider.Name(),
+		"tools":    len(a.tools),
+	})
+
+	result, err := a.run(ctx, prompt)
+
+	if result != nil {
+		a.setAttribute(ctx, "iterations", result.Iterations)
+		a.setAttribute(ctx, "tokens_used", result.TokensUsed.Total)
+	}
+	a.endSpan(ctx, err)
+
+	return result, err
+}
+
+// run runs the agent loop
+func (a *Agent) run(ctx context.Context, prompt *core.Prompt) (*Result, error) {
+	// Work on a copy of the prompt, with the prompt text and parts turned
+	// into a message so that tool calls and results can be appended after it
+	p := *prompt
+	p.Messages = prompt.Conversation()
+	p.Text = ""
+	p.Parts = nil
+	p.Tools = append(append([]core.Tool(nil), prompt.Tools...), a.Tools()...)
+
+	result := &Result{}
+	for result.Iterations < a.maxIterations {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		result.Iterations++
+		stepCtx := a.startSpan(ctx, "agent_step", map[string]interface{}{
+			"iteration": result.Iterations,
+		})
+
+		// Responses returned with an error, such as output failing its
+		// schema, are kept in the result
+		response, err := a.provider.Generate(stepCtx, &p)
+		if response != nil {
+			result.Response = response
+		}
+		if err != nil {
+			a.endSpan(stepCtx, err)
+			return result, fmt.Errorf("failed to generate response: %w", err)
+		}
+
+		if response.TokensUsed != nil {
+			result.TokensUsed.Prompt += response.TokensUsed.Prompt
+			result.TokensUsed.Completion += response.TokensUsed.Completion
+			result.TokensUsed.Total += response.TokensUsed.Total
+			result.TokensUsed.CacheRead += response.TokensUsed.CacheRead
+			result.TokensUsed.CacheWrite += response.TokensUsed.CacheWrite
+		}
+
+		p.Messages = append(p.Messages, core.Message{
+			Role:      core.RoleAssistant,
+			Content:   response.Text,
+			ToolCalls: response.ToolCalls,
+			Thinking:  response.Thinking,
+		})
+		a.setAttribute(stepCtx, "tool_calls", len(response.ToolCalls))
+
+		// The model gave a final answer
+		if len(response.ToolCalls) == 0 {
+			a.endSpan(stepCtx, nil)
+			result.Messages = p.Messages
+			return result, nil
+		}
+
+		// Stop before executing more tools once the budget is spent
+		if a.tokenBudget > 0 && result.TokensUsed.Total >= a.tokenBudget {
+			a.endSpan(stepCtx, ErrTokenBudgetExceeded)
+			result.Messages = p.Messages
+			return result, ErrTokenBudgetExceeded
+		}
+
+		p.Messages = append(p.Messages, a.executeTools(stepCtx, response.ToolCalls)...)
+		a.endSpan(stepCtx, nil)
+	}
+
+	result.Messages = p.Messages
+	return result, ErrMaxIterations
+}
+
+// executeTools executes tool calls and returns the tool result messages in
+// the order of the calls
+func (a *Agent) executeTools(ctx context.Context, calls []core.ToolCall) []core.Message {
+	messages := make([]core.Message, len(calls))
+
+	if !a.parallel || len(calls) == 1 {
+		for i, call := range calls {
+			messages[i] = a.executeTool(ctx, call)
+		}
+		return messages
+	}
+
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		wg.Add(1)
+		go func(i int, call core.ToolCall) {
+			defer wg.Done()
+			messages[i] = a.executeTool(ctx, call)
+		}(i, call)
+	}
+	wg.Wait()
+
+	return messages
+}
+
+// executeTool executes a tool call. Errors are reported to the model as the
+// tool result, so that it can recover from them.
+func (a *Agent) executeTool(ctx context.Context, call core.ToolCall) core.Message {
+	ctx = a.startSpan(ctx, "agent_tool", map[string]interface{}{
+		"tool":         call.Name,
+		"tool_call_id": call.ID,
+	})
+
+	output, err := a.callTool(ctx, call)
+	a.endSpan(ctx, err)
+	if err != nil {
+		output = "error: " + err.Error()
+	}
+
+	return core.ToolResultMessage(call, output)
+}
+
+// callTool decodes the arguments of a tool call and calls the tool function
+func (a *Agent) callTool(ctx context.Context, call core.ToolCall) (string, error) {
+	t, ok := a.tools[call.Name]
+	if !ok {
+		return "", fmt.Errorf("unknown tool %s", call.Name)
+	}
+
+	// Decode the arguments
+	args := reflect.New(t.structType)
+	if call.Arguments != "" {
+		if err := json.Unmarshal([]byte(call.Arguments), args.Interface()); err != nil {
+			return "", fmt.Errorf("invalid arguments: %w", err)
+		}
+	}
+	if t.fn.Type().In(1).Kind() != reflect.Ptr {
+		args = args.Elem()
+	}
+
+	// Call the function
+	out := t.fn.Call([]reflect.Value{reflect.ValueOf(ctx), args})
+	if err, _ := out[1].Interface().(error); err != nil {
+		return "", err
+	}
+
+	// Encode the result
+	if s, ok := out[0].Interface().(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(out[0].Interface())
+	if err != nil {
+		return "", fmt.Errorf("failed to encode result: %w", err)
+	}
+	return string(data), nil
+}
+
+// startSpan starts a span if a tracer is configured
+func (a *Agent) startSpan(ctx context.Context, name string, attributes map[string]interface{}) context.Context {
+	if a.tracer == nil {
+		return ctx
+	}
+	ctx, _ = a.tracer.StartSpan(ctx, name,
+		tracing.WithParent(tracing.SpanFromContext(ctx)),
+		tracing.WithAttributes(attributes),
+	)
+	return ctx
+}
+
+// endSpan ends a span if a tracer is configured
+func (a *Agent) endSpan(ctx context.Context, err error) {
+	if a.tracer == nil {
+		return
+	}
+	if err != nil {
+		a.tracer.SetAttribute(ctx, "error", err.Error())
+		a.tracer.EndSpan(ctx, tracing.SpanStatusError)
+		return
+	}
+	a.tracer.EndSpan(ctx, tracing.SpanStatusOK)
+}
+
+// setAttribute sets a span attribute if a tracer is configured
+func (a *Agent) setAttribute(ctx context.Context, key string, value interface{}) {
+	if a.tracer != nil {
+		a.tracer.SetAttribute(ctx, key, value)
+	}
+}
@@ -0,0 +1,265 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/agent"
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/tracing"
+)
+
+// weatherArgs are the arguments of the weather tool
+type weatherArgs struct {
+	City string `json:"city" doc:"The city to get the weather for"`
+}
+
+// weatherResult is the result of the weather tool
+type weatherResult struct {
+	Temperature int `json:"temperature"`
+}
+
+// TestAgentRun tests the generate, execute and feed back loop
+func TestAgentRun(t *testing.T) {
+	provider := &ScriptedProvider{
+		responses: []*core.Response{
+			{ToolCalls: []core.ToolCall{
+				{Index: 0, ID: "call_1", Name: "get_weather", Arguments: `{"city":"Paris"}`},
+				{Index: 1, ID: "call_2", Name: "get_weather", Arguments: `{"city":"Rome"}`},
+			}, TokensUsed: &core.TokenUsage{Total: 10}},
+			{Text: "Paris is 21 degrees and Rome is 25 degrees.", TokensUsed: &core.TokenUsage{Total: 15}},
+		},
+	}
+
+	tracer := tracing.NewStreamTracer(&strings.Builder{})
+	a := agent.NewAgent(provider, agent.WithTracer(tracer))
+
+	temperatures := map[string]int{"Paris": 21, "Rome": 25}
+	err := a.RegisterTool("get_weather", "Get the current weather", func(ctx context.Context, args weatherArgs) (weatherResult, error) {
+		return weatherResult{Temperature: temperatures[args.City]}, nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to register tool: %v", err)
+	}
+
+	result, err := a.Run(context.Background(), core.NewPrompt("What's the weather in Paris and Rome?"))
+	if err != nil {
+		t.Fatalf("Failed to run agent: %v", err)
+	}
+
+	// Check the result
+	if result.Response.Text != "Paris is 21 degrees and Rome is 25 degrees." {
+		t.Fatalf("Final answer is incorrect: %q", result.Response.Text)
+	}
+	if result.Iterations != 2 {
+		t.Fatalf("Expected 2 iterations, got %d", result.Iterations)
+	}
+	if result.TokensUsed.Total != 25 {
+		t.Fatalf("Expected 25 tokens used, got %d", result.TokensUsed.Total)
+	}
+
+	// The second request must contain the tool calls and their results
+	second := provider.prompts[1]
+	if len(second.Messages) != 4 {
+		t.Fatalf("Expected 4 messages in the second request, got %d", len(second.Messages))
+	}
+	if second.Messages[2].ToolCallID != "call_1" || second.Messages[2].Content != `{"temperature":21}` {
+		t.Fatalf("First tool result is incorrect: %+v", second.Messages[2])
+	}
+	if second.Messages[3].ToolCallID != "call_2" || second.Messages[3].Content != `{"temperature":25}` {
+		t.Fatalf("Second tool result is incorrect: %+v", second.Messages[3])
+	}
+
+	// The tool definitions must be sent with a generated schema
+	tools := provider.prompts[0].Tools
+	if len(tools) != 1 || tools[0].Parameters.Properties["city"].Type != "string" {
+		t.Fatalf("Tool definitions are incorrect: %+v", tools)
+	}
+}
+
+// TestAgentRunParts tests that the parts of the prompt are sent once, before
+// the tool calls and results
+func TestAgentRunParts(t *testing.T) {
+	provider := &ScriptedProvider{
+		responses: []*core.Response{
+			{ToolCalls: []core.ToolCall{{ID: "call_1", Name: "get_weather", Arguments: `{"city":"Paris"}`}}},
+			{Text: "It is 21 degrees in the city of the picture."},
+		},
+	}
+
+	a := agent.NewAgent(provider)
+	err := a.RegisterTool("get_weather", "Get the current weather", func(ctx context.Context, args weatherArgs) (weatherResult, error) {
+		return weatherResult{Temperature: 21}, nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to register tool: %v", err)
+	}
+
+	prompt := &core.Prompt{
+		Text:  "What's the weather in this city?",
+		Parts: []core.ContentPart{core.ImageURLPart("https://example.com/paris.png")},
+	}
+	if _, err := a.Run(context.Background(), prompt); err != nil {
+		t.Fatalf("Failed to run agent: %v", err)
+	}
+
+	second := provider.prompts[1]
+	messages := second.Conversation()
+	if len(messages) != 3 {
+		t.Fatalf("Expected 3 messages in the second request, got %d", len(messages))
+	}
+	if messages[0].Role != core.RoleUser || len(messages[0].Parts) != 1 {
+		t.Fatalf("User message is incorrect: %+v", messages[0])
+	}
+	if messages[1].Role != core.RoleAssistant || messages[2].Role != core.RoleTool {
+		t.Fatalf("Tool call and result are incorrect: %+v", messages[1:])
+	}
+}
+
+// TestAgentStopConditions tests the iteration limit, token budget and tool
+// errors
+func TestAgentStopConditions(t *testing.T) {
+	loop := &core.Response{
+		ToolCalls:  []core.ToolCall{{ID: "call", Name: "fail", Arguments: `{}`}},
+		TokensUsed: &core.TokenUsage{Total: 100},
+	}
+
+	newAgent := func(opts ...agent.Option) (*agent.Agent, *ScriptedProvider) {
+		provider := &ScriptedProvider{repeat: loop}
+		a := agent.NewAgent(provider, opts...)
+		err := a.RegisterTool("fail", "Always fails", func(ctx context.Context, args *struct{}) (string, error) {
+			return "", errors.New("tool failed")
+		})
+		if err != nil {
+			t.Fatalf("Failed to register tool: %v", err)
+		}
+		return a, provider
+	}
+
+	// The iteration limit stops the loop
+	a, provider := newAgent(agent.WithMaxIterations(3))
+	result, err := a.Run(context.Background(), core.NewPrompt("Loop"))
+	if !errors.Is(err, agent.ErrMaxIterations) {
+		t.Fatalf("Expected ErrMaxIterations, got %v", err)
+	}
+	if result.Iterations != 3 || len(provider.prompts) != 3 {
+		t.Fatalf("Expected 3 iterations, got %d", result.Iterations)
+	}
+
+	// Tool errors are fed back to the model
+	last := provider.prompts[2].Messages
+	if content := last[len(last)-1].Content; content != "error: tool failed" {
+		t.Fatalf("Tool error is incorrect: %q", content)
+	}
+
+	// The token budget stops the loop
+	a, _ = newAgent(agent.WithTokenBudget(250))
+	result, err = a.Run(context.Background(), core.NewPrompt("Loop"))
+	if !errors.Is(err, agent.ErrTokenBudgetExceeded) {
+		t.Fatalf("Expected ErrTokenBudgetExceeded, got %v", err)
+	}
+	if result.Iterations != 3 {
+		t.Fatalf("Expected 3 iterations, got %d", result.Iterations)
+	}
+
+	// Responses returned with an error are kept in the result
+	outputErr := &core.StructuredOutputError{Provider: "scripted", Err: errors.New("invalid JSON")}
+	provider = &ScriptedProvider{responses: []*core.Response{{Text: "{"}}, errs: []error{outputErr}}
+	result, err = agent.NewAgent(provider).Run(context.Background(), core.NewPrompt("Answer in JSON"))
+	if !errors.Is(err, outputErr) || result.Response == nil || result.Response.Text != "{" {
+		t.Fatalf("Expected the response with the structured output error, got %+v, %v", result.Response, err)
+	}
+
+	// Invalid tool functions are rejected
+	if err := a.RegisterTool("invalid", "Invalid", func(s string) string { return s }); err == nil {
+		t.Fatal("Expected an error for an invalid tool function")
+	}
+}
+
+// TestParallelToolCalls tests that the tool calls of a step run concurrently
+func TestParallelToolCalls(t *testing.T) {
+	calls := make([]core.ToolCall, 3)
+	for i := range calls {
+		calls[i] = core.ToolCall{Index: i, ID: string(rune('a' + i)), Name: "wait", Arguments: `{}`}
+	}
+	provider := &ScriptedProvider{
+		responses: []*core.Response{{ToolCalls: calls}, {Text: "done"}},
+	}
+
+	// Each call waits until all calls have started
+	var started sync.WaitGroup
+	started.Add(len(calls))
+	a := agent.NewAgent(provider)
+	err := a.RegisterTool("wait", "Waits for the other calls", func(ctx context.Context, args struct{}) (string, error) {
+		started.Done()
+		done := make(chan struct{})
+		go func() {
+			started.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return "ok", nil
+		case <-time.After(time.Second):
+			return "", errors.New("tool calls did not run concurrently")
+		}
+	})
+	if err != nil {
+		t.Fatalf("Failed to register tool: %v", err)
+	}
+
+	if _, err := a.Run(context.Background(), core.NewPrompt("Wait")); err != nil {
+		t.Fatalf("Failed to run agent: %v", err)
+	}
+	for _, msg := range provider.prompts[1].Messages[2:] {
+		if msg.Content != "ok" {
+			t.Fatalf("Tool result is incorrect: %q", msg.Content)
+		}
+	}
+}
+
+// ScriptedProvider is a mock provider that returns scripted responses, with
+// the scripted errors if any
+type ScriptedProvider struct {
+	responses []*core.Response
+	errs      []error
+	repeat    *core.Response
+	prompts   []core.Prompt
+}
+
+// Name returns the name of the provider
+func (p *ScriptedProvider) Name() string {
+	return "scripted"
+}
+
+// Generate returns the next scripted response
+func (p *ScriptedProvider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
+	// Keep a copy of the prompt, as the agent appends to the messages
+	copied := *prompt
+	copied.Messages = append([]core.Message(nil), prompt.Messages...)
+	p.prompts = append(p.prompts, copied)
+
+	if len(p.responses) == 0 {
+		if p.repeat != nil {
+			return p.repeat, nil
+		}
+		return nil, errors.New("no more responses")
+	}
+	response := p.responses[0]
+	p.responses = p.responses[1:]
+	var err error
+	if len(p.errs) > 0 {
+		err = p.errs[0]
+		p.errs = p.errs[1:]
+	}
+	return response, err
+}
+
+// GenerateStream is not supported by the scripted provider
+func (p *ScriptedProvider) GenerateStream(ctx context.Context, prompt *core.Prompt) (core.ResponseStream, error) {
+	return nil, errors.New("streaming is not supported")
+}
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"context"
+	"hash/fnv"
 	"sync"
 	"time"
 
@@ -23,54 +24,145 @@ type Cache interface {
 	Clear(ctx context.Context) error
 }
 
-// MemoryCache is an in-memory implementation of Cache
+// MemoryCache is an in-memory implementation of Cache. Entries are split
+// into shards with their own lock and eviction policy, and evicted when the
+// cache exceeds its number of entries or size in bytes. The limits are
+// divided evenly among the shards.
 type MemoryCache struct {
-	entries     map[string]cacheEntry
-	mu          sync.RWMutex
-	ttl         time.Duration
-	maxEntries  int
-	hashFunc    func(*core.Prompt) string
+	shards          []*cacheShard
+	ttl             time.Duration
+	maxEntries      int
+	maxBytes        int64
+	shardCount      int
+	policy          func(capacity int) EvictionPolicy
+	cleanupInterval time.Duration
+	hasher          *Hasher
+	hashFunc        func(*core.Prompt) string
+	stop            chan struct{}
+	closeOnce       sync.Once
+}
+
+// cacheShard is a part of a MemoryCache with its own lock
+type cacheShard struct {
+	mu         sync.Mutex
+	entries    map[string]*cacheEntry
+	policy     EvictionPolicy
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
 }
 
 type cacheEntry struct {
-	response  *core.Response
-	timestamp time.Time
+	response *core.Response
+	expires  time.Time
+	size     int64
 }
 
 // NewMemoryCache creates a new in-memory cache
 func NewMemoryCache(options ...MemoryCacheOption) *MemoryCache {
 	cache := &MemoryCache{
-		entries:    make(map[string]cacheEntry),
 		ttl:        time.Hour,
 		maxEntries: 1000,
-		hashFunc:   defaultHashFunc,
+		shardCount: 1,
+		policy:     LRU,
+		hasher:     defaultHasher,
 	}
-	
+
 	for _, option := range options {
 		option(cache)
 	}
-	
+
+	// Every shard must be able to hold an entry
+	if cache.maxEntries > 0 && cache.shardCount > cache.maxEntries {
+		cache.shardCount = cache.maxEntries
+	}
+	if cache.shardCount < 1 {
+		cache.shardCount = 1
+	}
+
+	cache.shards = make([]*cacheShard, cache.shardCount)
+	for i := range cache.shards {
+		cache.shards[i] = &cacheShard{
+			maxEntries: int(divideLimit(int64(cache.maxEntries), cache.shardCount)),
+			maxBytes:   divideLimit(cache.maxBytes, cache.shardCount),
+		}
+		cache.shards[i].reset(cache.policy)
+	}
+
+	// Remove the expired entries in the background if requested
+	if cache.cleanupInterval > 0 && cache.ttl > 0 {
+		cache.stop = make(chan struct{})
+		go cache.cleanup()
+	}
+
 	return cache
 }
 
 // MemoryCacheOption is a function that configures a MemoryCache
 type MemoryCacheOption func(*MemoryCache)
 
-// WithTTL sets the time-to-live for cache entries
+// WithTTL sets the time-to-live for cache entries. Entries never expire with
+// a TTL of 0.
 func WithTTL(ttl time.Duration) MemoryCacheOption {
 	return func(c *MemoryCache) {
 		c.ttl = ttl
 	}
 }
 
-// WithMaxEntries sets the maximum number of entries in the cache
+// WithMaxEntries sets the maximum number of entries in the cache, or no
+// limit if 0
 func WithMaxEntries(max int) MemoryCacheOption {
 	return func(c *MemoryCache) {
 		c.maxEntries = max
 	}
 }
 
-// WithHashFunc sets the function used to hash prompts
+// WithMaxBytes sets the maximum estimated size of the cached responses in
+// bytes, or no limit if 0 (the default). Responses larger than the limit of
+// a shard are not cached.
+func WithMaxBytes(max int64) MemoryCacheOption {
+	return func(c *MemoryCache) {
+		c.maxBytes = max
+	}
+}
+
+// WithShards sets the number of shards (defaults to 1). More shards reduce
+// lock contention under concurrent use, but each shard evicts its entries
+// independently.
+func WithShards(shards int) MemoryCacheOption {
+	return func(c *MemoryCache) {
+		c.shardCount = shards
+	}
+}
+
+// WithEvictionPolicy sets the function that creates the eviction policy of
+// each shard from its capacity, such as LRU (the default), LFU or TinyLFU
+func WithEvictionPolicy(policy func(capacity int) EvictionPolicy) MemoryCacheOption {
+	return func(c *MemoryCache) {
+		c.policy = policy
+	}
+}
+
+// WithCleanupInterval removes the expired entries in the background at the
+// given interval, rather than only when they are requested or evicted. The
+// cache must then be closed with Close.
+func WithCleanupInterval(interval time.Duration) MemoryCacheOption {
+	return func(c *MemoryCache) {
+		c.cleanupInterval = interval
+	}
+}
+
+// WithHasher sets the hasher that computes the keys of prompts, such as one
+// excluding some fields
+func WithHasher(hasher *Hasher) MemoryCacheOption {
+	return func(c *MemoryCache) {
+		c.hasher = hasher
+	}
+}
+
+// WithHashFunc sets the function used to hash prompts instead of the hasher.
+// The function is responsible for telling apart the prompts and models that
+// must not share responses.
 func WithHashFunc(hashFunc func(*core.Prompt) string) MemoryCacheOption {
 	return func(c *MemoryCache) {
 		c.hashFunc = hashFunc
@@ -79,78 +171,263 @@ func WithHashFunc(hashFunc func(*core.Prompt) string) MemoryCacheOption {
 
 // Get retrieves a cached response for a prompt
 func (c *MemoryCache) Get(ctx context.Context, prompt *core.Prompt) (*core.Response, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	
-	key := c.hashFunc(prompt)
-	entry, exists := c.entries[key]
+	key := c.key(ctx, prompt)
+	shard := c.shard(key)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.policy.Access(key)
+	entry, exists := shard.entries[key]
 	if !exists {
 		return nil, false
 	}
-	
-	// Check if the entry has expired
-	if time.Since(entry.timestamp) > c.ttl {
+
+	// Remove the entry if it has expired
+	if c.expired(entry, time.Now()) {
+		shard.remove(key)
 		return nil, false
 	}
-	
+
 	return entry.response, true
 }
 
-// Set stores a response for a prompt
+// Set stores a response for a prompt, evicting entries as needed
 func (c *MemoryCache) Set(ctx context.Context, prompt *core.Prompt, response *core.Response) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	
-	// If we've reached the maximum number of entries, remove the oldest one
-	if len(c.entries) >= c.maxEntries {
-		var oldestKey string
-		var oldestTime time.Time
-		
-		// Find the oldest entry
-		for key, entry := range c.entries {
-			if oldestKey == "" || entry.timestamp.Before(oldestTime) {
-				oldestKey = key
-				oldestTime = entry.timestamp
-			}
-		}
-		
-		// Remove the oldest entry
-		delete(c.entries, oldestKey)
+	key := c.key(ctx, prompt)
+	shard := c.shard(key)
+	entry := &cacheEntry{
+		response: response,
+		size:     responseSize(key, response),
 	}
-	
-	key := c.hashFunc(prompt)
-	c.entries[key] = cacheEntry{
-		response:  response,
-		timestamp: time.Now(),
+	if c.ttl > 0 {
+		entry.expires = time.Now().Add(c.ttl)
 	}
-	
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if shard.maxBytes > 0 && entry.size > shard.maxBytes {
+		return nil
+	}
+
+	// Replace the existing entry, keeping its place in the policy
+	if existing, exists := shard.entries[key]; exists {
+		shard.bytes += entry.size - existing.size
+		shard.entries[key] = entry
+		shard.policy.Access(key)
+		c.evict(shard, key)
+		return nil
+	}
+
+	// Make room for the new entry, unless the policy prefers the victim
+	now := time.Now()
+	for shard.full(entry.size) {
+		victim, ok := shard.policy.Victim()
+		if !ok {
+			break
+		}
+		if !c.expired(shard.entries[victim], now) && !shard.policy.Admit(key, victim) {
+			return nil
+		}
+		shard.remove(victim)
+	}
+
+	shard.entries[key] = entry
+	shard.bytes += entry.size
+	shard.policy.Add(key)
+
 	return nil
 }
 
 // Invalidate removes a cached response for a prompt
 func (c *MemoryCache) Invalidate(ctx context.Context, prompt *core.Prompt) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	
-	key := c.hashFunc(prompt)
-	delete(c.entries, key)
-	
+	key := c.key(ctx, prompt)
+	shard := c.shard(key)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.remove(key)
+
 	return nil
 }
 
 // Clear removes all cached responses
 func (c *MemoryCache) Clear(ctx context.Context) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	
-	c.entries = make(map[string]cacheEntry)
-	
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		shard.reset(c.policy)
+		shard.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Len returns the number of entries in the cache, including expired entries
+// not yet removed
+func (c *MemoryCache) Len() int {
+	count := 0
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		count += len(shard.entries)
+		shard.mu.Unlock()
+	}
+	return count
+}
+
+// Size returns the estimated size of the cached responses in bytes
+func (c *MemoryCache) Size() int64 {
+	var size int64
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		size += shard.bytes
+		shard.mu.Unlock()
+	}
+	return size
+}
+
+// Close stops the background removal of expired entries
+func (c *MemoryCache) Close() error {
+	c.closeOnce.Do(func() {
+		if c.stop != nil {
+			close(c.stop)
+		}
+	})
 	return nil
 }
 
-// defaultHashFunc is a simple hash function for prompts
-func defaultHashFunc(prompt *core.Prompt) string {
-	// In a real implementation, this would use a proper hashing algorithm
-	// For simplicity, we're just using the prompt text
-	return prompt.Text
+// RemoveExpired removes the expired entries from the cache
+func (c *MemoryCache) RemoveExpired() {
+	now := time.Now()
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		for key, entry := range shard.entries {
+			if c.expired(entry, now) {
+				shard.remove(key)
+			}
+		}
+		shard.mu.Unlock()
+	}
+}
+
+// cleanup removes the expired entries periodically until the cache is closed
+func (c *MemoryCache) cleanup() {
+	ticker := time.NewTicker(c.cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.RemoveExpired()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// evict removes entries other than the given key until the shard is within
+// its limits
+func (c *MemoryCache) evict(shard *cacheShard, keep string) {
+	for shard.over() {
+		victim, ok := shard.policy.Victim()
+		if !ok || victim == keep {
+			return
+		}
+		shard.remove(victim)
+	}
+}
+
+// expired returns whether an entry has expired
+func (c *MemoryCache) expired(entry *cacheEntry, now time.Time) bool {
+	return !entry.expires.IsZero() && now.After(entry.expires)
+}
+
+// shard returns the shard of a key
+func (c *MemoryCache) shard(key string) *cacheShard {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return c.shards[hash.Sum32()%uint32(len(c.shards))]
+}
+
+// key returns the key of a prompt for the identity of the context
+func (c *MemoryCache) key(ctx context.Context, prompt *core.Prompt) string {
+	if c.hashFunc != nil {
+		return c.hashFunc(prompt)
+	}
+	return c.hasher.Hash(prompt, IdentityFromContext(ctx))
+}
+
+// reset removes all the entries of the shard
+func (s *cacheShard) reset(policy func(capacity int) EvictionPolicy) {
+	s.entries = make(map[string]*cacheEntry)
+	s.policy = policy(s.maxEntries)
+	s.bytes = 0
+}
+
+// full returns whether adding an entry of the given size would exceed the
+// limits of the shard. An empty shard always has room.
+func (s *cacheShard) full(size int64) bool {
+	if len(s.entries) == 0 {
+		return false
+	}
+	if s.maxEntries > 0 && len(s.entries) >= s.maxEntries {
+		return true
+	}
+	return s.maxBytes > 0 && s.bytes+size > s.maxBytes
+}
+
+// over returns whether the shard exceeds its limits
+func (s *cacheShard) over() bool {
+	return s.maxEntries > 0 && len(s.entries) > s.maxEntries || s.maxBytes > 0 && s.bytes > s.maxBytes
+}
+
+// remove removes an entry from the shard
+func (s *cacheShard) remove(key string) {
+	entry, exists := s.entries[key]
+	if !exists {
+		return
+	}
+	delete(s.entries, key)
+	s.bytes -= entry.size
+	s.policy.Remove(key)
+}
+
+// divideLimit divides a limit among the shards, rounding up. A limit of 0
+// stays unlimited.
+func divideLimit(limit int64, shards int) int64 {
+	if limit <= 0 {
+		return 0
+	}
+	return (limit + int64(shards) - 1) / int64(shards)
+}
+
+// entryOverhead approximates the memory used by an entry besides its
+// strings, such as the response structure and the bookkeeping of the cache
+const entryOverhead = 256
+
+// responseSize estimates the memory used by a cached response and its key
+func responseSize(key string, response *core.Response) int64 {
+	size := entryOverhead + len(key) + len(response.ID) + len(response.Text) + len(response.FinishReason)
+
+	// Structured output is parsed from the text, and has about its size
+	if response.StructuredOutput != nil {
+		size += len(response.Text)
+	}
+	for _, call := range response.ToolCalls {
+		size += len(call.ID) + len(call.Name) + len(call.Arguments)
+	}
+	for _, block := range response.Thinking {
+		size += len(block.Text) + len(block.Signature) + len(block.RedactedData)
+	}
+	for _, candidate := range response.Candidates {
+		size += len(candidate.Text) + len(candidate.FinishReason)
+		for _, call := range candidate.ToolCalls {
+			size += len(call.ID) + len(call.Name) + len(call.Arguments)
+		}
+	}
+	return int64(size)
 }
@@ -2,13 +2,17 @@ package cache_test
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/GeoloeG-IsT/gollem/pkg/cache"
 	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/rag"
 )
 
 // TestMemoryCache tests the memory cache implementation
@@ -168,6 +172,314 @@ func TestCacheMiddleware(t *testing.T) {
 	}
 }
 
+// TestMemoryCacheEviction tests the eviction policies and limits of the
+// memory cache
+func TestMemoryCacheEviction(t *testing.T) {
+	ctx := context.Background()
+	byText := cache.WithHashFunc(func(prompt *core.Prompt) string { return prompt.Text })
+	set := func(c *cache.MemoryCache, text string) {
+		if err := c.Set(ctx, core.NewPrompt(text), &core.Response{Text: text}); err != nil {
+			t.Fatalf("Failed to set %s: %v", text, err)
+		}
+	}
+	cached := func(c *cache.MemoryCache, texts ...string) string {
+		var found []string
+		for _, text := range texts {
+			if _, ok := c.Get(ctx, core.NewPrompt(text)); ok {
+				found = append(found, text)
+			}
+		}
+		return strings.Join(found, ",")
+	}
+
+	// LRU evicts the least recently used entry, refreshed by Get
+	lru := cache.NewMemoryCache(byText, cache.WithMaxEntries(2))
+	set(lru, "a")
+	set(lru, "b")
+	lru.Get(ctx, core.NewPrompt("a"))
+	set(lru, "c")
+	if got := cached(lru, "a", "b", "c"); got != "a,c" {
+		t.Fatalf("LRU kept %s, expected a,c", got)
+	}
+
+	// LFU evicts the least frequently used entry
+	lfu := cache.NewMemoryCache(byText, cache.WithMaxEntries(2), cache.WithEvictionPolicy(cache.LFU))
+	set(lfu, "a")
+	set(lfu, "b")
+	lfu.Get(ctx, core.NewPrompt("b"))
+	lfu.Get(ctx, core.NewPrompt("a"))
+	lfu.Get(ctx, core.NewPrompt("a"))
+	set(lfu, "c")
+	lfu.Get(ctx, core.NewPrompt("c"))
+	set(lfu, "d")
+	if got := cached(lfu, "a", "b", "c", "d"); got != "a,d" {
+		t.Fatalf("LFU kept %s, expected a,d", got)
+	}
+
+	// TinyLFU only admits entries requested more often than the victim
+	tiny := cache.NewMemoryCache(byText, cache.WithMaxEntries(2), cache.WithEvictionPolicy(cache.TinyLFU))
+	set(tiny, "a")
+	set(tiny, "b")
+	for i := 0; i < 3; i++ {
+		cached(tiny, "a", "b")
+	}
+	set(tiny, "once")
+	if got := cached(tiny, "a", "b", "once"); got != "a,b" {
+		t.Fatalf("TinyLFU kept %s, expected a,b", got)
+	}
+	for i := 0; i < 10; i++ {
+		cached(tiny, "popular")
+	}
+	set(tiny, "popular")
+	if got := cached(tiny, "popular"); got != "popular" {
+		t.Fatal("TinyLFU did not admit a popular entry")
+	}
+
+	// The size limit evicts entries by their estimated size in bytes
+	sized := cache.NewMemoryCache(byText, cache.WithMaxBytes(3000))
+	set(sized, strings.Repeat("a", 900))
+	set(sized, strings.Repeat("b", 900))
+	if sized.Len() != 1 || sized.Size() > 3000 {
+		t.Fatalf("Cache has %d entries of %d bytes, expected 1", sized.Len(), sized.Size())
+	}
+	set(sized, strings.Repeat("c", 4000))
+	if got := cached(sized, strings.Repeat("c", 4000)); got != "" {
+		t.Fatal("Response larger than the cache was cached")
+	}
+
+	// Expired entries are removed in the background
+	expiring := cache.NewMemoryCache(byText, cache.WithTTL(10*time.Millisecond), cache.WithCleanupInterval(5*time.Millisecond))
+	defer expiring.Close()
+	set(expiring, "a")
+	time.Sleep(50 * time.Millisecond)
+	if expiring.Len() != 0 {
+		t.Fatal("Expired entry was not removed")
+	}
+
+	// Sharded caches can be used concurrently
+	sharded := cache.NewMemoryCache(cache.WithShards(8), cache.WithMaxEntries(100), cache.WithEvictionPolicy(cache.LFU))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				prompt := core.NewPrompt(fmt.Sprintf("prompt %d", (worker*j)%150))
+				if _, ok := sharded.Get(ctx, prompt); !ok {
+					sharded.Set(ctx, prompt, &core.Response{Text: prompt.Text})
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if sharded.Len() > 104 {
+		t.Fatalf("Sharded cache has %d entries, expected at most 104", sharded.Len())
+	}
+}
+
+// TestPromptHashing tests the canonical keys of prompts
+func TestPromptHashing(t *testing.T) {
+	hasher := cache.NewHasher()
+	identity := cache.Identity{Provider: "openai", Model: "gpt-4o"}
+	prompt := core.NewPrompt("What is the capital of France?")
+	key := hasher.Hash(prompt, identity)
+	if !strings.HasPrefix(key, "v1-") || len(key) != 67 {
+		t.Fatalf("Key is incorrect: %s", key)
+	}
+
+	// Prompts that differ in any field get different keys
+	withSystem := core.NewPrompt(prompt.Text)
+	withSystem.SystemMessage = "Answer in French."
+	colder := core.NewPrompt(prompt.Text)
+	colder.Temperature = 0
+	withSchema := core.NewPrompt(prompt.Text)
+	withSchema.Schema = map[string]interface{}{"type": "object"}
+	for name, other := range map[string]string{
+		"system message": hasher.Hash(withSystem, identity),
+		"temperature":    hasher.Hash(colder, identity),
+		"schema":         hasher.Hash(withSchema, identity),
+		"model":          hasher.Hash(prompt, cache.Identity{Provider: "openai", Model: "gpt-4o-mini"}),
+		"provider":       hasher.Hash(prompt, cache.Identity{Provider: "azure", Model: "gpt-4o"}),
+	} {
+		if other == key {
+			t.Fatalf("Prompts with different %s have the same key", name)
+		}
+	}
+
+	// Equal schemas get the same key however they are given
+	rawSchema := core.NewPrompt(prompt.Text)
+	rawSchema.Schema = json.RawMessage(`{ "type" : "object" }`)
+	if hasher.Hash(rawSchema, identity) != hasher.Hash(withSchema, identity) {
+		t.Fatal("Equal schemas have different keys")
+	}
+
+	// Excluded fields are ignored
+	hasher = cache.NewHasher(cache.WithoutFields(cache.FieldTemperature, cache.FieldModel))
+	if hasher.Hash(colder, identity) != hasher.Hash(prompt, cache.Identity{Provider: "openai"}) {
+		t.Fatal("Excluded fields changed the key")
+	}
+
+	// The persistent cache accepts any prompt text
+	persistentCache, err := cache.NewPersistentCache(cache.WithDirectory(t.TempDir()))
+	if err != nil {
+		t.Fatalf("Failed to create persistent cache: %v", err)
+	}
+	ctx := cache.WithIdentity(context.Background(), identity)
+	longPrompt := core.NewPrompt("../" + strings.Repeat("a/b ", 200))
+	if err := persistentCache.Set(ctx, longPrompt, &core.Response{Text: "cached"}); err != nil {
+		t.Fatalf("Failed to set response in cache: %v", err)
+	}
+	if response, found := persistentCache.Get(ctx, longPrompt); !found || response.Text != "cached" {
+		t.Fatalf("Response not found in persistent cache: %+v", response)
+	}
+	if _, found := persistentCache.Get(context.Background(), longPrompt); found {
+		t.Fatal("Response was served for another identity")
+	}
+
+	// The middleware caches the responses of each provider separately
+	memCache := cache.NewMemoryCache()
+	first := &MockProvider{name: "first"}
+	second := &MockProvider{name: "second"}
+	cache.NewCacheMiddleware(first, memCache).Generate(context.Background(), prompt)
+	cache.NewCacheMiddleware(second, memCache).Generate(context.Background(), prompt)
+	if first.callCount != 1 || second.callCount != 1 {
+		t.Fatalf("Providers were called %d and %d times, expected 1", first.callCount, second.callCount)
+	}
+}
+
+// TestSemanticCache tests serving the responses of similar questions
+func TestSemanticCache(t *testing.T) {
+	embeddings := &MockEmbeddings{vectors: map[string][]float32{
+		"What is the capital of France?":    {1, 0, 0},
+		"Which city is France's capital?":   {0.98, 0.2, 0},
+		"What is the population of France?": {0.6, 0.8, 0},
+		"What is the capital of Spain?":     {0, 0, 1},
+	}}
+	semanticCache := cache.NewSemanticCache(embeddings, nil, cache.WithSimilarityThreshold(0.9))
+	ctx := cache.WithIdentity(context.Background(), cache.Identity{Provider: "openai", Model: "gpt-4o"})
+
+	prompt := core.NewPrompt("What is the capital of France?")
+	prompt.SystemMessage = "You are a geography assistant."
+	if err := semanticCache.Set(ctx, prompt, &core.Response{Text: "Paris"}); err != nil {
+		t.Fatalf("Failed to set response in cache: %v", err)
+	}
+
+	// Paraphrases are served the cached response
+	paraphrase := core.NewPrompt("Which city is France's capital?")
+	paraphrase.SystemMessage = prompt.SystemMessage
+	if response, found := semanticCache.Get(ctx, paraphrase); !found || response.Text != "Paris" {
+		t.Fatalf("Paraphrase was not served the cached response: %+v", response)
+	}
+
+	// Questions below the threshold are not
+	for _, text := range []string{"What is the population of France?", "What is the capital of Spain?"} {
+		other := core.NewPrompt(text)
+		other.SystemMessage = prompt.SystemMessage
+		if _, found := semanticCache.Get(ctx, other); found {
+			t.Fatalf("Different question was served the cached response: %s", text)
+		}
+	}
+
+	// Prompts with a different system message, schema or model are not
+	otherSystem := core.NewPrompt(paraphrase.Text)
+	withSchema := core.NewPrompt(paraphrase.Text)
+	withSchema.SystemMessage = prompt.SystemMessage
+	withSchema.Schema = map[string]interface{}{"type": "object"}
+	for name, check := range map[string]func() bool{
+		"system message": func() bool { _, found := semanticCache.Get(ctx, otherSystem); return found },
+		"schema":         func() bool { _, found := semanticCache.Get(ctx, withSchema); return found },
+		"model": func() bool {
+			other := cache.WithIdentity(ctx, cache.Identity{Provider: "openai", Model: "gpt-4o-mini"})
+			_, found := semanticCache.Get(other, paraphrase)
+			return found
+		},
+	} {
+		if check() {
+			t.Fatalf("Prompt with a different %s was served the cached response", name)
+		}
+	}
+
+	// Chat prompts are matched on their last user message
+	chat := core.NewChatPrompt(core.Message{Role: core.RoleUser, Content: "Which city is France's capital?"})
+	chat.SystemMessage = prompt.SystemMessage
+	if _, found := semanticCache.Get(ctx, chat); !found {
+		t.Fatal("Chat prompt was not served the cached response")
+	}
+
+	// Invalidated and cleared responses are no longer served
+	if err := semanticCache.Invalidate(ctx, prompt); err != nil {
+		t.Fatalf("Failed to invalidate response: %v", err)
+	}
+	if _, found := semanticCache.Get(ctx, paraphrase); found {
+		t.Fatal("Response found in cache after invalidation")
+	}
+	semanticCache.Set(ctx, prompt, &core.Response{Text: "Paris"})
+	if err := semanticCache.Clear(ctx); err != nil {
+		t.Fatalf("Failed to clear cache: %v", err)
+	}
+	if _, found := semanticCache.Get(ctx, prompt); found {
+		t.Fatal("Response found in cache after clearing")
+	}
+
+	// Questions of other prompts do not crowd out the matches, and the
+	// scores of stores returning no embeddings are used
+	semanticCache = cache.NewSemanticCache(embeddings, func() rag.VectorStore {
+		return &ScoreOnlyVectorStore{rag.NewMemoryVectorStore(embeddings)}
+	}, cache.WithSimilarityThreshold(0.9), cache.WithSearchLimit(2))
+	for i := 0; i < 5; i++ {
+		other := core.NewPrompt(prompt.Text)
+		other.SystemMessage = fmt.Sprintf("You are assistant %d.", i)
+		semanticCache.Set(ctx, other, &core.Response{Text: "Other"})
+	}
+	semanticCache.Set(ctx, prompt, &core.Response{Text: "Paris"})
+	if response, found := semanticCache.Get(ctx, paraphrase); !found || response.Text != "Paris" {
+		t.Fatalf("Paraphrase was not served the cached response among other prompts: %+v", response)
+	}
+
+	// Expired responses are not served
+	semanticCache = cache.NewSemanticCache(embeddings, nil, cache.WithSimilarityThreshold(0.9), cache.WithSemanticTTL(10*time.Millisecond))
+	semanticCache.Set(ctx, prompt, &core.Response{Text: "Paris"})
+	time.Sleep(20 * time.Millisecond)
+	if _, found := semanticCache.Get(ctx, paraphrase); found {
+		t.Fatal("Expired response found in cache")
+	}
+}
+
+// ScoreOnlyVectorStore is a vector store returning chunks without their
+// embeddings, like remote stores
+type ScoreOnlyVectorStore struct {
+	rag.VectorStore
+}
+
+// SimilaritySearch returns the chunks of the wrapped store without their
+// embeddings
+func (s *ScoreOnlyVectorStore) SimilaritySearch(ctx context.Context, embedding []float32, limit int) ([]*rag.Chunk, error) {
+	chunks, err := s.VectorStore.SimilaritySearch(ctx, embedding, limit)
+	for _, chunk := range chunks {
+		chunk.Embedding = nil
+	}
+	return chunks, err
+}
+
+// MockEmbeddings is a mock embeddings provider with fixed vectors
+type MockEmbeddings struct {
+	vectors map[string][]float32
+}
+
+// EmbedDocument returns the vector of a text
+func (e *MockEmbeddings) EmbedDocument(ctx context.Context, text string) ([]float32, error) {
+	return e.EmbedQuery(ctx, text)
+}
+
+// EmbedQuery returns the vector of a text
+func (e *MockEmbeddings) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	vector, ok := e.vectors[text]
+	if !ok {
+		return nil, fmt.Errorf("unknown text %s", text)
+	}
+	return vector, nil
+}
+
 // MockProvider is a mock implementation of the LLMProvider interface
 type MockProvider struct {
 	name      string
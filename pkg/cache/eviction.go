@@ -0,0 +1,285 @@
+package cache
+
+import (
+	"container/list"
+	"hash/fnv"
+)
+
+// EvictionPolicy decides which entries a MemoryCache evicts when it is full.
+// Each shard of the cache has its own policy, used under the lock of the
+// shard, so policies need not be safe for concurrent use. All the operations
+// of the built-in policies take constant time.
+type EvictionPolicy interface {
+	// Add records a key added to the cache
+	Add(key string)
+
+	// Access records a request for a key, which may not be cached
+	Access(key string)
+
+	// Remove forgets a key removed from the cache
+	Remove(key string)
+
+	// Victim returns the key to evict next, and false if there is none
+	Victim() (string, bool)
+
+	// Admit returns whether a new key should be cached at the expense of
+	// the victim
+	Admit(key, victim string) bool
+}
+
+// LRU creates a policy that evicts the least recently used entry
+func LRU(capacity int) EvictionPolicy {
+	return newLRUPolicy()
+}
+
+// LFU creates a policy that evicts the least frequently used entry, and the
+// least recently used one among equally frequent entries
+func LFU(capacity int) EvictionPolicy {
+	return &lfuPolicy{
+		items:   make(map[string]*list.Element),
+		buckets: list.New(),
+	}
+}
+
+// TinyLFU creates a policy that evicts the least recently used entry, but
+// only admits new entries requested more often than the entry they would
+// evict. Request frequencies are estimated with a count-min sketch sized
+// for the capacity, which keeps one-off requests from flushing popular
+// entries.
+func TinyLFU(capacity int) EvictionPolicy {
+	return &tinyLFUPolicy{
+		lruPolicy: newLRUPolicy(),
+		sketch:    newCountMinSketch(capacity),
+	}
+}
+
+// lruPolicy keeps the keys from the most to the least recently used
+type lruPolicy struct {
+	order    *list.List
+	elements map[string]*list.Element
+}
+
+// newLRUPolicy creates an empty LRU policy
+func newLRUPolicy() *lruPolicy {
+	return &lruPolicy{
+		order:    list.New(),
+		elements: make(map[string]*list.Element),
+	}
+}
+
+// Add records a key added to the cache
+func (p *lruPolicy) Add(key string) {
+	if element, exists := p.elements[key]; exists {
+		p.order.MoveToFront(element)
+		return
+	}
+	p.elements[key] = p.order.PushFront(key)
+}
+
+// Access moves a cached key to the front
+func (p *lruPolicy) Access(key string) {
+	if element, exists := p.elements[key]; exists {
+		p.order.MoveToFront(element)
+	}
+}
+
+// Remove forgets a key
+func (p *lruPolicy) Remove(key string) {
+	if element, exists := p.elements[key]; exists {
+		p.order.Remove(element)
+		delete(p.elements, key)
+	}
+}
+
+// Victim returns the least recently used key
+func (p *lruPolicy) Victim() (string, bool) {
+	element := p.order.Back()
+	if element == nil {
+		return "", false
+	}
+	return element.Value.(string), true
+}
+
+// Admit always admits new keys
+func (p *lruPolicy) Admit(key, victim string) bool {
+	return true
+}
+
+// lfuPolicy keeps the keys in buckets of equal frequency, ordered by
+// increasing frequency, so that accesses move a key to the next bucket
+type lfuPolicy struct {
+	items   map[string]*list.Element
+	buckets *list.List
+}
+
+// lfuBucket holds the keys of a frequency, from the most to the least
+// recently used
+type lfuBucket struct {
+	frequency int
+	keys      *list.List
+}
+
+// lfuItem is a key in a bucket
+type lfuItem struct {
+	key    string
+	bucket *list.Element
+}
+
+// Add records a key added to the cache with a frequency of 1
+func (p *lfuPolicy) Add(key string) {
+	if _, exists := p.items[key]; exists {
+		p.Access(key)
+		return
+	}
+
+	front := p.buckets.Front()
+	if front == nil || front.Value.(*lfuBucket).frequency != 1 {
+		front = p.buckets.PushFront(&lfuBucket{frequency: 1, keys: list.New()})
+	}
+	p.items[key] = front.Value.(*lfuBucket).keys.PushFront(&lfuItem{key: key, bucket: front})
+}
+
+// Access moves a cached key to the bucket of the next frequency
+func (p *lfuPolicy) Access(key string) {
+	element, exists := p.items[key]
+	if !exists {
+		return
+	}
+
+	item := element.Value.(*lfuItem)
+	current := item.bucket
+	bucket := current.Value.(*lfuBucket)
+	next := current.Next()
+	if next == nil || next.Value.(*lfuBucket).frequency != bucket.frequency+1 {
+		next = p.buckets.InsertAfter(&lfuBucket{frequency: bucket.frequency + 1, keys: list.New()}, current)
+	}
+
+	bucket.keys.Remove(element)
+	if bucket.keys.Len() == 0 {
+		p.buckets.Remove(current)
+	}
+	item.bucket = next
+	p.items[key] = next.Value.(*lfuBucket).keys.PushFront(item)
+}
+
+// Remove forgets a key
+func (p *lfuPolicy) Remove(key string) {
+	element, exists := p.items[key]
+	if !exists {
+		return
+	}
+
+	item := element.Value.(*lfuItem)
+	bucket := item.bucket.Value.(*lfuBucket)
+	bucket.keys.Remove(element)
+	if bucket.keys.Len() == 0 {
+		p.buckets.Remove(item.bucket)
+	}
+	delete(p.items, key)
+}
+
+// Victim returns the least recently used key of the lowest frequency
+func (p *lfuPolicy) Victim() (string, bool) {
+	front := p.buckets.Front()
+	if front == nil {
+		return "", false
+	}
+	return front.Value.(*lfuBucket).keys.Back().Value.(*lfuItem).key, true
+}
+
+// Admit always admits new keys
+func (p *lfuPolicy) Admit(key, victim string) bool {
+	return true
+}
+
+// tinyLFUPolicy is an LRU policy with frequency-based admission
+type tinyLFUPolicy struct {
+	*lruPolicy
+	sketch *countMinSketch
+}
+
+// Access counts a request for a key, and moves it to the front if cached
+func (p *tinyLFUPolicy) Access(key string) {
+	p.sketch.increment(key)
+	p.lruPolicy.Access(key)
+}
+
+// Admit returns whether the new key was requested more often than the
+// victim
+func (p *tinyLFUPolicy) Admit(key, victim string) bool {
+	return p.sketch.estimate(key) > p.sketch.estimate(victim)
+}
+
+// sketchDepth is the number of rows of a count-min sketch
+const sketchDepth = 4
+
+// sketchMaxCount is the maximum value of a counter, as counters only need to
+// tell frequent keys from the others
+const sketchMaxCount = 15
+
+// countMinSketch estimates the frequencies of keys in constant space. The
+// counters are halved periodically, so that the estimates follow recent
+// requests.
+type countMinSketch struct {
+	counters  []uint8
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+// newCountMinSketch creates a sketch sized for the given number of keys
+func newCountMinSketch(capacity int) *countMinSketch {
+	width := 16
+	for width < capacity {
+		width *= 2
+	}
+	return &countMinSketch{
+		counters: make([]uint8, sketchDepth*width),
+		mask:     uint64(width - 1),
+		resetAt:  10 * width,
+	}
+}
+
+// indexes returns the counters of a key, one in each row
+func (s *countMinSketch) indexes(key string) [sketchDepth]uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	sum := hash.Sum64()
+
+	// Derive the hashes of the rows from two halves of one hash
+	low, high := sum&0xffffffff, sum>>32|1
+	var indexes [sketchDepth]uint64
+	width := s.mask + 1
+	for row := range indexes {
+		indexes[row] = uint64(row)*width + (low+uint64(row)*high)&s.mask
+	}
+	return indexes
+}
+
+// increment counts a request for a key
+func (s *countMinSketch) increment(key string) {
+	for _, index := range s.indexes(key) {
+		if s.counters[index] < sketchMaxCount {
+			s.counters[index]++
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.resetAt {
+		for i := range s.counters {
+			s.counters[i] /= 2
+		}
+		s.additions /= 2
+	}
+}
+
+// estimate returns the estimated number of requests for a key
+func (s *countMinSketch) estimate(key string) int {
+	count := sketchMaxCount
+	for _, index := range s.indexes(key) {
+		if int(s.counters[index]) < count {
+			count = int(s.counters[index])
+		}
+	}
+	return count
+}
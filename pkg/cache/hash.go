@@ -0,0 +1,269 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+)
+
+// KeyVersion is the version of the key format. It is part of every key, so
+// that entries written with an older format are never served.
+const KeyVersion = 1
+
+// Field is a field of a prompt, or of the identity of its model, covered by
+// the cache keys
+type Field string
+
+const (
+	// FieldProvider is the name of the provider
+	FieldProvider Field = "provider"
+
+	// FieldModel is the name of the model
+	FieldModel Field = "model"
+
+	// FieldText is the prompt text
+	FieldText Field = "text"
+
+	// FieldParts are the content parts of the prompt
+	FieldParts Field = "parts"
+
+	// FieldSystemMessage is the system message
+	FieldSystemMessage Field = "system_message"
+
+	// FieldMessages is the conversation history
+	FieldMessages Field = "messages"
+
+	// FieldTools are the tools the model may call
+	FieldTools Field = "tools"
+
+	// FieldToolChoice is the tool choice
+	FieldToolChoice Field = "tool_choice"
+
+	// FieldTemperature is the temperature
+	FieldTemperature Field = "temperature"
+
+	// FieldMaxTokens is the maximum number of tokens to generate
+	FieldMaxTokens Field = "max_tokens"
+
+	// FieldTopP is the nucleus sampling parameter
+	FieldTopP Field = "top_p"
+
+	// FieldFrequencyPenalty is the frequency penalty
+	FieldFrequencyPenalty Field = "frequency_penalty"
+
+	// FieldPresencePenalty is the presence penalty
+	FieldPresencePenalty Field = "presence_penalty"
+
+	// FieldStopSequences are the stop sequences
+	FieldStopSequences Field = "stop_sequences"
+
+	// FieldSchema is the schema of the structured output
+	FieldSchema Field = "schema"
+
+	// FieldAdditionalParams are the provider-specific parameters
+	FieldAdditionalParams Field = "additional_params"
+)
+
+// Fields returns all the fields covered by default, in the order they are
+// hashed
+func Fields() []Field {
+	return []Field{
+		FieldProvider,
+		FieldModel,
+		FieldText,
+		FieldParts,
+		FieldSystemMessage,
+		FieldMessages,
+		FieldTools,
+		FieldToolChoice,
+		FieldTemperature,
+		FieldMaxTokens,
+		FieldTopP,
+		FieldFrequencyPenalty,
+		FieldPresencePenalty,
+		FieldStopSequences,
+		FieldSchema,
+		FieldAdditionalParams,
+	}
+}
+
+// Identity identifies the provider and model that generate the responses to
+// a prompt, so that they are cached separately
+type Identity struct {
+	// Provider is the name of the provider
+	Provider string
+
+	// Model is the name of the model
+	Model string
+}
+
+// identityKey is the context key of the identity
+type identityKey struct{}
+
+// WithIdentity returns a context whose prompts are cached for the given
+// provider and model. The cache middleware sets it from the provider it
+// wraps.
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the identity of a context
+func IdentityFromContext(ctx context.Context) Identity {
+	identity, _ := ctx.Value(identityKey{}).(Identity)
+	return identity
+}
+
+// Hasher computes the cache keys of prompts. Keys are SHA-256 hashes of a
+// canonical JSON encoding of the selected fields, so that prompts differing
+// in any of them, such as the system message or the temperature, do not
+// share responses.
+type Hasher struct {
+	fields []Field
+}
+
+// HasherOption is a function that configures a Hasher
+type HasherOption func(*Hasher)
+
+// NewHasher creates a new hasher covering all the fields by default
+func NewHasher(options ...HasherOption) *Hasher {
+	hasher := &Hasher{
+		fields: Fields(),
+	}
+
+	for _, option := range options {
+		option(hasher)
+	}
+
+	return hasher
+}
+
+// WithFields sets the fields covered by the keys, replacing the defaults
+func WithFields(fields ...Field) HasherOption {
+	return func(h *Hasher) {
+		h.fields = fields
+	}
+}
+
+// WithoutFields excludes fields from the keys, such as FieldTemperature to
+// share responses across temperatures
+func WithoutFields(fields ...Field) HasherOption {
+	return func(h *Hasher) {
+		excluded := make(map[Field]bool, len(fields))
+		for _, field := range fields {
+			excluded[field] = true
+		}
+
+		kept := make([]Field, 0, len(h.fields))
+		for _, field := range h.fields {
+			if !excluded[field] {
+				kept = append(kept, field)
+			}
+		}
+		h.fields = kept
+	}
+}
+
+// Hash returns the key of a prompt for the given identity, of the form
+// "v1-" followed by the hexadecimal hash
+func (h *Hasher) Hash(prompt *core.Prompt, identity Identity) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "gollem-cache-v%d\n", KeyVersion)
+	for _, field := range h.fields {
+		// Each field is written as its name and length-prefixed value, so
+		// that values cannot run into each other
+		value := canonicalJSON(fieldValue(field, prompt, identity))
+		fmt.Fprintf(hash, "%s:%d:", field, len(value))
+		hash.Write(value)
+	}
+	return "v" + strconv.Itoa(KeyVersion) + "-" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// defaultHasher is the hasher used by the caches unless configured otherwise
+var defaultHasher = NewHasher()
+
+// HashPrompt returns the key of a prompt with all the fields and no identity
+func HashPrompt(prompt *core.Prompt) string {
+	return defaultHasher.Hash(prompt, Identity{})
+}
+
+// fieldValue returns the value of a field
+func fieldValue(field Field, prompt *core.Prompt, identity Identity) interface{} {
+	switch field {
+	case FieldProvider:
+		return identity.Provider
+	case FieldModel:
+		return identity.Model
+	case FieldText:
+		return prompt.Text
+	case FieldParts:
+		return prompt.Parts
+	case FieldSystemMessage:
+		return prompt.SystemMessage
+	case FieldMessages:
+		return prompt.Messages
+	case FieldTools:
+		return prompt.Tools
+	case FieldToolChoice:
+		return prompt.ToolChoice
+	case FieldTemperature:
+		return prompt.Temperature
+	case FieldMaxTokens:
+		return prompt.MaxTokens
+	case FieldTopP:
+		return prompt.TopP
+	case FieldFrequencyPenalty:
+		return prompt.FrequencyPenalty
+	case FieldPresencePenalty:
+		return prompt.PresencePenalty
+	case FieldStopSequences:
+		return prompt.StopSequences
+	case FieldSchema:
+		return prompt.Schema
+	case FieldAdditionalParams:
+		return prompt.AdditionalParams
+	default:
+		return nil
+	}
+}
+
+// canonicalJSON encodes a value as JSON with sorted object keys and no
+// insignificant whitespace, so that equal values such as schemas given as
+// raw JSON or as maps get the same encoding. Empty collections are encoded
+// as null, like unset ones. Values that cannot be encoded fall back to their
+// Go representation.
+func canonicalJSON(value interface{}) []byte {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return []byte(fmt.Sprintf("%#v", value))
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return data
+	}
+
+	switch v := decoded.(type) {
+	case []interface{}:
+		if len(v) == 0 {
+			return []byte("null")
+		}
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return []byte("null")
+		}
+	}
+
+	data, err = json.Marshal(decoded)
+	if err != nil {
+		return []byte(fmt.Sprintf("%#v", value))
+	}
+	return data
+}
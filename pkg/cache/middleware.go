@@ -20,22 +20,44 @@ func NewCacheMiddleware(provider core.LLMProvider, cache Cache) *CacheMiddleware
 	}
 }
 
-// Name returns the name of the middleware
+// Middleware returns a core.Middleware that caches responses in the given
+// cache
+func Middleware(cache Cache) core.Middleware {
+	return func(provider core.LLMProvider) core.LLMProvider {
+		return NewCacheMiddleware(provider, cache)
+	}
+}
+
+// Name returns the name of the wrapped provider
 func (m *CacheMiddleware) Name() string {
-	return m.provider.Name() + "_cached"
+	return m.provider.Name()
+}
+
+// Capabilities returns the capabilities of the model of the wrapped provider
+func (m *CacheMiddleware) Capabilities() (core.ModelCapabilities, bool) {
+	return core.CapabilitiesOf(m.provider)
+}
+
+// Model returns the name of the model of the wrapped provider
+func (m *CacheMiddleware) Model() string {
+	return core.ModelOf(m.provider)
 }
 
-// Generate generates a response for a prompt, using the cache if available
+// Generate generates a response for a prompt, using the cache if available.
+// Responses are cached for the provider and model of the wrapped provider.
 func (m *CacheMiddleware) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
+	ctx = WithIdentity(ctx, Identity{Provider: m.provider.Name(), Model: core.ModelOf(m.provider)})
+
 	// Check if the response is in the cache
 	if response, found := m.cache.Get(ctx, prompt); found {
 		return response, nil
 	}
 
 	// Generate a response
+	// Responses returned with an error are passed through, but not cached
 	response, err := m.provider.Generate(ctx, prompt)
 	if err != nil {
-		return nil, err
+		return response, err
 	}
 
 	// Cache the response
@@ -2,6 +2,8 @@ package cache
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -19,6 +21,7 @@ type PersistentCache struct {
 	directory  string
 	ttl        time.Duration
 	maxEntries int
+	hasher     *Hasher
 	hashFunc   func(*core.Prompt) string
 	mu         sync.RWMutex
 }
@@ -35,7 +38,7 @@ func NewPersistentCache(options ...PersistentCacheOption) (*PersistentCache, err
 		directory:  filepath.Join(os.TempDir(), "gollem-cache"),
 		ttl:        time.Hour,
 		maxEntries: 1000,
-		hashFunc:   defaultHashFunc,
+		hasher:     defaultHasher,
 	}
 	
 	for _, option := range options {
@@ -90,7 +93,16 @@ func WithPersistentMaxEntries(max int) PersistentCacheOption {
 	}
 }
 
-// WithPersistentHashFunc sets the function used to hash prompts
+// WithPersistentHasher sets the hasher that computes the keys of prompts
+func WithPersistentHasher(hasher *Hasher) PersistentCacheOption {
+	return func(c *PersistentCache) {
+		c.hasher = hasher
+	}
+}
+
+// WithPersistentHashFunc sets the function used to hash prompts instead of
+// the hasher. Keys that are not valid file names are hashed again for the
+// names of the response files.
 func WithPersistentHashFunc(hashFunc func(*core.Prompt) string) PersistentCacheOption {
 	return func(c *PersistentCache) {
 		c.hashFunc = hashFunc
@@ -102,7 +114,7 @@ func (c *PersistentCache) Get(ctx context.Context, prompt *core.Prompt) (*core.R
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	
-	key := c.hashFunc(prompt)
+	key := c.key(ctx, prompt)
 	
 	// Load the metadata
 	metadata, err := c.loadMetadata()
@@ -130,7 +142,7 @@ func (c *PersistentCache) Set(ctx context.Context, prompt *core.Prompt, response
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	
-	key := c.hashFunc(prompt)
+	key := c.key(ctx, prompt)
 	
 	// Load the metadata
 	metadata, err := c.loadMetadata()
@@ -167,7 +179,7 @@ func (c *PersistentCache) Invalidate(ctx context.Context, prompt *core.Prompt) e
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	
-	key := c.hashFunc(prompt)
+	key := c.key(ctx, prompt)
 	
 	// Load the metadata
 	metadata, err := c.loadMetadata()
@@ -181,7 +193,7 @@ func (c *PersistentCache) Invalidate(ctx context.Context, prompt *core.Prompt) e
 	}
 	
 	// Delete the response file
-	responsePath := filepath.Join(c.directory, key+".json")
+	responsePath := c.responsePath(key)
 	if err := os.Remove(responsePath); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to delete response file: %w", err)
 	}
@@ -260,7 +272,7 @@ func (c *PersistentCache) saveMetadata(metadata *cacheMetadata) error {
 
 // loadResponse loads a response from a file
 func (c *PersistentCache) loadResponse(key string) (*core.Response, error) {
-	responsePath := filepath.Join(c.directory, key+".json")
+	responsePath := c.responsePath(key)
 	
 	data, err := ioutil.ReadFile(responsePath)
 	if err != nil {
@@ -277,7 +289,7 @@ func (c *PersistentCache) loadResponse(key string) (*core.Response, error) {
 
 // saveResponse saves a response to a file
 func (c *PersistentCache) saveResponse(key string, response *core.Response) error {
-	responsePath := filepath.Join(c.directory, key+".json")
+	responsePath := c.responsePath(key)
 	
 	data, err := json.Marshal(response)
 	if err != nil {
@@ -308,7 +320,7 @@ func (c *PersistentCache) purgeOldEntries(metadata *cacheMetadata) {
 		delete(metadata.Entries, key)
 		
 		// Delete the response file
-		responsePath := filepath.Join(c.directory, key+".json")
+		responsePath := c.responsePath(key)
 		os.Remove(responsePath) // Ignore errors
 	}
 	
@@ -336,7 +348,7 @@ func (c *PersistentCache) purgeOldEntries(metadata *cacheMetadata) {
 			delete(metadata.Entries, key)
 			
 			// Delete the response file
-			responsePath := filepath.Join(c.directory, key+".json")
+			responsePath := c.responsePath(key)
 			os.Remove(responsePath) // Ignore errors
 		}
 	}
@@ -345,4 +357,33 @@ func (c *PersistentCache) purgeOldEntries(metadata *cacheMetadata) {
 	metadata.LastPurged = now
 }
 
-// Use the defaultHashFunc from cache.go instead of redefining it here
+// key returns the key of a prompt for the identity of the context
+func (c *PersistentCache) key(ctx context.Context, prompt *core.Prompt) string {
+	if c.hashFunc != nil {
+		return c.hashFunc(prompt)
+	}
+	return c.hasher.Hash(prompt, IdentityFromContext(ctx))
+}
+
+// responsePath returns the path of the response file of a key. Keys that are
+// not safe file names, such as raw prompt text, are replaced by their hash.
+func (c *PersistentCache) responsePath(key string) string {
+	if !isFileName(key) {
+		sum := sha256.Sum256([]byte(key))
+		key = "k-" + hex.EncodeToString(sum[:])
+	}
+	return filepath.Join(c.directory, key+".json")
+}
+
+// isFileName returns whether a key can be used as a file name as is
+func isFileName(key string) bool {
+	if key == "" || len(key) > 128 || key == "metadata" {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
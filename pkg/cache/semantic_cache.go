@@ -0,0 +1,322 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/rag"
+)
+
+// SemanticCache is an implementation of Cache that also serves the responses
+// of similar questions, such as paraphrases. The question of a prompt is its
+// last user message, which is embedded and searched in a vector store.
+// Cached responses are only served for prompts identical in everything else,
+// such as the system message, conversation history, schema and model, whose
+// questions are kept in a vector store of their own.
+type SemanticCache struct {
+	embeddings  rag.EmbeddingsProvider
+	newStore    func() rag.VectorStore
+	namespaces  map[string]*semanticNamespace
+	threshold   float32
+	searchLimit int
+	ttl         time.Duration
+	maxEntries  int
+	hasher      *Hasher
+	entries     map[string]*semanticEntry
+	order       *list.List
+	mu          sync.RWMutex
+}
+
+// semanticNamespace is the vector store of the questions of a guard
+type semanticNamespace struct {
+	store   rag.VectorStore
+	entries int
+}
+
+// semanticEntry is a cached response with the guard of its prompt
+type semanticEntry struct {
+	response *core.Response
+	guard    string
+	expires  time.Time
+	element  *list.Element
+}
+
+// NewSemanticCache creates a new semantic cache with the given embeddings
+// provider and function creating the vector stores, one for each guard. The
+// stores should only be used by the cache, and default to in-memory stores if
+// newStore is nil.
+func NewSemanticCache(embeddings rag.EmbeddingsProvider, newStore func() rag.VectorStore, options ...SemanticCacheOption) *SemanticCache {
+	if newStore == nil {
+		newStore = func() rag.VectorStore {
+			return rag.NewMemoryVectorStore(embeddings)
+		}
+	}
+
+	cache := &SemanticCache{
+		embeddings:  embeddings,
+		newStore:    newStore,
+		namespaces:  make(map[string]*semanticNamespace),
+		threshold:   0.95,
+		searchLimit: 10,
+		ttl:         time.Hour,
+		maxEntries:  1000,
+		hasher:      defaultHasher,
+		entries:     make(map[string]*semanticEntry),
+		order:       list.New(),
+	}
+
+	for _, option := range options {
+		option(cache)
+	}
+
+	return cache
+}
+
+// SemanticCacheOption is a function that configures a SemanticCache
+type SemanticCacheOption func(*SemanticCache)
+
+// WithSimilarityThreshold sets the minimum cosine similarity between the
+// embeddings of two questions for them to share a response (defaults to
+// 0.95). Lower thresholds match looser paraphrases, but risk serving the
+// answer of a different question.
+func WithSimilarityThreshold(threshold float32) SemanticCacheOption {
+	return func(c *SemanticCache) {
+		c.threshold = threshold
+	}
+}
+
+// WithSearchLimit sets the number of similar questions looked up in the
+// vector store of the guard for each request (defaults to 10)
+func WithSearchLimit(limit int) SemanticCacheOption {
+	return func(c *SemanticCache) {
+		c.searchLimit = limit
+	}
+}
+
+// WithSemanticTTL sets the time-to-live for cache entries. Entries never
+// expire with a TTL of 0.
+func WithSemanticTTL(ttl time.Duration) SemanticCacheOption {
+	return func(c *SemanticCache) {
+		c.ttl = ttl
+	}
+}
+
+// WithSemanticMaxEntries sets the maximum number of entries in the cache, or
+// no limit if 0. The oldest entries are evicted first.
+func WithSemanticMaxEntries(max int) SemanticCacheOption {
+	return func(c *SemanticCache) {
+		c.maxEntries = max
+	}
+}
+
+// WithSemanticHasher sets the hasher of the guards, which must be identical
+// for prompts to share a response, such as one excluding FieldTemperature
+func WithSemanticHasher(hasher *Hasher) SemanticCacheOption {
+	return func(c *SemanticCache) {
+		c.hasher = hasher
+	}
+}
+
+// Get retrieves the response of the most similar cached question above the
+// threshold, among the prompts with the same guard. Errors of the embeddings
+// provider or vector store are reported as misses.
+func (c *SemanticCache) Get(ctx context.Context, prompt *core.Prompt) (*core.Response, bool) {
+	question, guard, ok := c.split(ctx, prompt)
+	if !ok {
+		return nil, false
+	}
+
+	// Remove the expired entries first, so that they do not take the place
+	// of live ones in the search
+	c.mu.Lock()
+	err := c.evict(ctx, time.Now())
+	namespace, exists := c.namespaces[guard]
+	c.mu.Unlock()
+	if err != nil || !exists {
+		return nil, false
+	}
+
+	embedding, err := c.embeddings.EmbedQuery(ctx, question)
+	if err != nil {
+		return nil, false
+	}
+	chunks, err := namespace.store.SimilaritySearch(ctx, embedding, c.searchLimit)
+	if err != nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for _, chunk := range chunks {
+		entry, exists := c.entries[chunk.ID]
+		if !exists || entry.guard != guard {
+			continue
+		}
+		if c.expired(entry, now) {
+			c.remove(ctx, chunk.ID)
+			continue
+		}
+		if similarity(embedding, chunk) >= c.threshold {
+			return entry.response, true
+		}
+	}
+
+	return nil, false
+}
+
+// Set stores a response for a prompt. Prompts that do not end with a user
+// question are not cached.
+func (c *SemanticCache) Set(ctx context.Context, prompt *core.Prompt, response *core.Response) error {
+	question, guard, ok := c.split(ctx, prompt)
+	if !ok {
+		return nil
+	}
+
+	// Questions are embedded as queries, like the questions they are
+	// compared with
+	embedding, err := c.embeddings.EmbedQuery(ctx, question)
+	if err != nil {
+		return fmt.Errorf("failed to embed question: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := c.hasher.Hash(prompt, IdentityFromContext(ctx))
+	if err := c.remove(ctx, id); err != nil {
+		return err
+	}
+
+	namespace, exists := c.namespaces[guard]
+	if !exists {
+		namespace = &semanticNamespace{store: c.newStore()}
+	}
+	chunk := &rag.Chunk{
+		ID:        id,
+		Content:   question,
+		Embedding: embedding,
+	}
+	if err := namespace.store.AddChunks(ctx, []*rag.Chunk{chunk}); err != nil {
+		return fmt.Errorf("failed to add question to vector store: %w", err)
+	}
+	namespace.entries++
+	c.namespaces[guard] = namespace
+
+	entry := &semanticEntry{
+		response: response,
+		guard:    guard,
+		element:  c.order.PushBack(id),
+	}
+	if c.ttl > 0 {
+		entry.expires = time.Now().Add(c.ttl)
+	}
+	c.entries[id] = entry
+
+	return c.evict(ctx, time.Now())
+}
+
+// Invalidate removes the cached response of a prompt. Responses of similar
+// questions are kept.
+func (c *SemanticCache) Invalidate(ctx context.Context, prompt *core.Prompt) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.remove(ctx, c.hasher.Hash(prompt, IdentityFromContext(ctx)))
+}
+
+// Clear removes all cached responses and clears the vector stores
+func (c *SemanticCache) Clear(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for guard, namespace := range c.namespaces {
+		if err := namespace.store.Clear(ctx); err != nil {
+			return fmt.Errorf("failed to clear vector store: %w", err)
+		}
+		delete(c.namespaces, guard)
+	}
+	c.entries = make(map[string]*semanticEntry)
+	c.order.Init()
+
+	return nil
+}
+
+// evict removes the oldest entries while the cache is full or they have
+// expired. Entries expire in the order they were stored, as they share a TTL.
+// The caller must hold the lock.
+func (c *SemanticCache) evict(ctx context.Context, now time.Time) error {
+	for front := c.order.Front(); front != nil; front = c.order.Front() {
+		oldest := front.Value.(string)
+		if !(c.maxEntries > 0 && len(c.entries) > c.maxEntries) && !c.expired(c.entries[oldest], now) {
+			break
+		}
+		if err := c.remove(ctx, oldest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove removes an entry and its question, and the vector store of its
+// guard once empty. The caller must hold the lock.
+func (c *SemanticCache) remove(ctx context.Context, id string) error {
+	entry, exists := c.entries[id]
+	if !exists {
+		return nil
+	}
+
+	namespace := c.namespaces[entry.guard]
+	if err := namespace.store.Delete(ctx, []string{id}); err != nil {
+		return fmt.Errorf("failed to delete question from vector store: %w", err)
+	}
+	c.order.Remove(entry.element)
+	delete(c.entries, id)
+	namespace.entries--
+	if namespace.entries == 0 {
+		delete(c.namespaces, entry.guard)
+	}
+
+	return nil
+}
+
+// similarity returns the score of a chunk found by a similarity search, or
+// its cosine similarity to the query if the vector store reports no score
+func similarity(query []float32, chunk *rag.Chunk) float32 {
+	if chunk.Score != 0 || chunk.Embedding == nil {
+		return chunk.Score
+	}
+	return rag.CosineSimilarity(query, chunk.Embedding)
+}
+
+// expired returns whether an entry has expired
+func (c *SemanticCache) expired(entry *semanticEntry, now time.Time) bool {
+	return !entry.expires.IsZero() && now.After(entry.expires)
+}
+
+// split returns the question of a prompt, which is the text of its last user
+// message, and the guard hashing the rest of the prompt with the identity of
+// the context. It returns false if the prompt does not end with a question.
+func (c *SemanticCache) split(ctx context.Context, prompt *core.Prompt) (string, string, bool) {
+	conversation := prompt.Conversation()
+	if len(conversation) == 0 {
+		return "", "", false
+	}
+	last := conversation[len(conversation)-1]
+	if last.Role != core.RoleUser || last.Content == "" {
+		return "", "", false
+	}
+
+	// The guard covers the history and the media of the question, but not
+	// its text
+	rest := *prompt
+	rest.Text = ""
+	rest.Parts = last.Parts
+	rest.Messages = conversation[:len(conversation)-1]
+	return last.Content, c.hasher.Hash(&rest, IdentityFromContext(ctx)), true
+}
@@ -0,0 +1,327 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/tracing"
+)
+
+// ErrCircuitOpen is the underlying error of the requests rejected while the
+// circuit is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// State is the state of a circuit breaker
+type State int
+
+const (
+	// StateClosed lets requests through and records their outcome
+	StateClosed State = iota
+
+	// StateOpen rejects requests until the open timeout has elapsed
+	StateOpen
+
+	// StateHalfOpen lets a limited number of probe requests through to
+	// check whether the provider has recovered
+	StateHalfOpen
+)
+
+// String returns the name of the state
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half_open"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// CircuitBreaker is a middleware that stops sending requests to a provider
+// that keeps failing
+type CircuitBreaker struct {
+	provider         core.LLMProvider
+	tracer           tracing.Tracer
+	failureRatio     float64
+	window           time.Duration
+	minRequests      int
+	openTimeout      time.Duration
+	halfOpenRequests int
+	isFailure        func(err error) bool
+
+	mu         sync.Mutex
+	state      State
+	generation int
+	outcomes   []outcome
+	openedAt   time.Time
+	probes     int
+	successes  int
+}
+
+// outcome is the result of a request in the failure window
+type outcome struct {
+	time   time.Time
+	failed bool
+}
+
+// CircuitBreakerOption configures a CircuitBreaker
+type CircuitBreakerOption func(*CircuitBreaker)
+
+// WithFailureRatio sets the ratio of failed requests in the window that
+// opens the circuit
+func WithFailureRatio(ratio float64) CircuitBreakerOption {
+	return func(b *CircuitBreaker) {
+		b.failureRatio = ratio
+	}
+}
+
+// WithWindow sets the duration of the window in which failures are counted
+func WithWindow(window time.Duration) CircuitBreakerOption {
+	return func(b *CircuitBreaker) {
+		b.window = window
+	}
+}
+
+// WithMinRequests sets the minimum number of requests in the window before
+// the failure ratio is evaluated
+func WithMinRequests(requests int) CircuitBreakerOption {
+	return func(b *CircuitBreaker) {
+		b.minRequests = requests
+	}
+}
+
+// WithOpenTimeout sets how long the circuit stays open before it half-opens
+func WithOpenTimeout(timeout time.Duration) CircuitBreakerOption {
+	return func(b *CircuitBreaker) {
+		b.openTimeout = timeout
+	}
+}
+
+// WithHalfOpenRequests sets the number of probe requests let through while
+// half-open. The circuit closes once they all succeed.
+func WithHalfOpenRequests(requests int) CircuitBreakerOption {
+	return func(b *CircuitBreaker) {
+		b.halfOpenRequests = requests
+	}
+}
+
+// WithFailureIf sets the function that decides whether an error counts as a
+// failure. By default, errors count when core.IsRetryable returns true, so
+// that invalid requests do not open the circuit.
+func WithFailureIf(isFailure func(err error) bool) CircuitBreakerOption {
+	return func(b *CircuitBreaker) {
+		b.isFailure = isFailure
+	}
+}
+
+// WithTracer sets the tracer used to record state transitions
+func WithTracer(tracer tracing.Tracer) CircuitBreakerOption {
+	return func(b *CircuitBreaker) {
+		b.tracer = tracer
+	}
+}
+
+// NewCircuitBreaker creates a new circuit breaker
+func NewCircuitBreaker(provider core.LLMProvider, opts ...CircuitBreakerOption) *CircuitBreaker {
+	b := &CircuitBreaker{
+		provider:         provider,
+		failureRatio:     0.5,
+		window:           time.Minute,
+		minRequests:      10,
+		openTimeout:      30 * time.Second,
+		halfOpenRequests: 1,
+		isFailure:        core.IsRetryable,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// Middleware returns a core.Middleware that wraps each provider with its own
+// circuit breaker
+func Middleware(opts ...CircuitBreakerOption) core.Middleware {
+	return func(provider core.LLMProvider) core.LLMProvider {
+		return NewCircuitBreaker(provider, opts...)
+	}
+}
+
+// Name returns the name of the wrapped provider
+func (b *CircuitBreaker) Name() string {
+	return b.provider.Name()
+}
+
+// Capabilities returns the capabilities of the model of the wrapped provider
+func (b *CircuitBreaker) Capabilities() (core.ModelCapabilities, bool) {
+	return core.CapabilitiesOf(b.provider)
+}
+
+// Model returns the name of the model of the wrapped provider
+func (b *CircuitBreaker) Model() string {
+	return core.ModelOf(b.provider)
+}
+
+// State returns the current state of the circuit
+func (b *CircuitBreaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.checkTimeout(context.Background(), time.Now())
+	return b.state
+}
+
+// Generate generates a response for a prompt unless the circuit is open
+func (b *CircuitBreaker) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
+	generation, err := b.before(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := b.provider.Generate(ctx, prompt)
+	b.after(ctx, generation, err)
+	return response, err
+}
+
+// GenerateStream generates a streaming response for a prompt unless the
+// circuit is open. Only the outcome of opening the stream is recorded.
+func (b *CircuitBreaker) GenerateStream(ctx context.Context, prompt *core.Prompt) (core.ResponseStream, error) {
+	generation, err := b.before(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := b.provider.GenerateStream(ctx, prompt)
+	b.after(ctx, generation, err)
+	return stream, err
+}
+
+// before checks whether a request may be sent and returns the generation of
+// the state it was sent in
+func (b *CircuitBreaker) before(ctx context.Context) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.checkTimeout(ctx, now)
+
+	switch b.state {
+	case StateOpen:
+		return 0, b.openError(now)
+	case StateHalfOpen:
+		if b.probes >= b.halfOpenRequests {
+			return 0, b.openError(now)
+		}
+		b.probes++
+	}
+
+	return b.generation, nil
+}
+
+// after records the outcome of a request
+func (b *CircuitBreaker) after(ctx context.Context, generation int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Ignore requests sent before the last transition
+	if generation != b.generation {
+		return
+	}
+
+	now := time.Now()
+	failed := err != nil && b.isFailure(err)
+
+	switch b.state {
+	case StateClosed:
+		b.outcomes = append(b.outcomes, outcome{time: now, failed: failed})
+		b.pruneOutcomes(now)
+
+		failures := 0
+		for _, o := range b.outcomes {
+			if o.failed {
+				failures++
+			}
+		}
+		if len(b.outcomes) >= b.minRequests && float64(failures) >= b.failureRatio*float64(len(b.outcomes)) {
+			b.setState(ctx, StateOpen, now)
+		}
+	case StateHalfOpen:
+		if failed {
+			b.setState(ctx, StateOpen, now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.halfOpenRequests {
+			b.setState(ctx, StateClosed, now)
+		}
+	}
+}
+
+// pruneOutcomes removes the outcomes that are no longer in the window
+func (b *CircuitBreaker) pruneOutcomes(now time.Time) {
+	i := 0
+	for i < len(b.outcomes) && now.Sub(b.outcomes[i].time) > b.window {
+		i++
+	}
+	b.outcomes = b.outcomes[i:]
+}
+
+// checkTimeout half-opens the circuit once the open timeout has elapsed
+func (b *CircuitBreaker) checkTimeout(ctx context.Context, now time.Time) {
+	if b.state == StateOpen && now.Sub(b.openedAt) >= b.openTimeout {
+		b.setState(ctx, StateHalfOpen, now)
+	}
+}
+
+// setState transitions the circuit to a new state
+func (b *CircuitBreaker) setState(ctx context.Context, state State, now time.Time) {
+	from := b.state
+	b.state = state
+	b.generation++
+	b.outcomes = nil
+	b.probes = 0
+	b.successes = 0
+	if state == StateOpen {
+		b.openedAt = now
+	}
+
+	if b.tracer != nil {
+		attributes := map[string]interface{}{
+			"provider": b.provider.Name(),
+			"from":     from.String(),
+			"to":       state.String(),
+		}
+		ctx, _ = b.tracer.StartSpan(ctx, "circuit_breaker",
+			tracing.WithParent(tracing.SpanFromContext(ctx)),
+			tracing.WithAttributes(attributes),
+		)
+		b.tracer.AddEvent(ctx, "circuit_breaker_state_change", attributes)
+		b.tracer.EndSpan(ctx, tracing.SpanStatusOK)
+	}
+}
+
+// openError returns the error for a request rejected while the circuit is
+// open. It is a retryable provider error, so that fallbacks move on to the
+// next provider.
+func (b *CircuitBreaker) openError(now time.Time) error {
+	retryAfter := b.openTimeout - now.Sub(b.openedAt)
+	if retryAfter < 0 {
+		retryAfter = 0
+	}
+
+	return &core.ProviderError{
+		Provider:   b.provider.Name(),
+		Category:   core.ErrorCategoryServer,
+		Message:    ErrCircuitOpen.Error(),
+		RetryAfter: retryAfter,
+		Err:        ErrCircuitOpen,
+	}
+}
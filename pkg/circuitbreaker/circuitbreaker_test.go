@@ -0,0 +1,174 @@
+package circuitbreaker_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/circuitbreaker"
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/tracing"
+)
+
+// TestCircuitBreaker tests the transitions between the circuit states
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	prompt := core.NewPrompt("Hello")
+	provider := &MockProvider{err: &core.ProviderError{Provider: "mock", Category: core.ErrorCategoryServer, StatusCode: 500}}
+	tracer := &RecordingTracer{}
+	breaker := circuitbreaker.NewCircuitBreaker(provider,
+		circuitbreaker.WithMinRequests(4),
+		circuitbreaker.WithFailureRatio(0.5),
+		circuitbreaker.WithOpenTimeout(50*time.Millisecond),
+		circuitbreaker.WithTracer(tracer),
+	)
+
+	// Invalid requests do not count as failures
+	invalid := &core.ProviderError{Provider: "mock", Category: core.ErrorCategoryInvalidRequest, StatusCode: 400}
+	provider.setErr(invalid)
+	for i := 0; i < 4; i++ {
+		breaker.Generate(ctx, prompt)
+	}
+	if breaker.State() != circuitbreaker.StateClosed {
+		t.Fatalf("Expected the circuit to stay closed, got %s", breaker.State())
+	}
+
+	// Server errors open the circuit once the failure ratio is reached
+	provider.setErr(&core.ProviderError{Provider: "mock", Category: core.ErrorCategoryServer, StatusCode: 500})
+	for i := 0; i < 4; i++ {
+		breaker.Generate(ctx, prompt)
+	}
+	if breaker.State() != circuitbreaker.StateOpen {
+		t.Fatalf("Expected the circuit to be open, got %s", breaker.State())
+	}
+
+	// Requests fail fast while the circuit is open
+	calls := provider.callCount()
+	_, err := breaker.Generate(ctx, prompt)
+	if !errors.Is(err, circuitbreaker.ErrCircuitOpen) || !core.IsRetryable(err) {
+		t.Fatalf("Expected a retryable circuit open error, got %v", err)
+	}
+	if provider.callCount() != calls {
+		t.Fatal("Provider was called while the circuit was open")
+	}
+
+	// The circuit half-opens after the timeout, and a failed probe opens it
+	time.Sleep(60 * time.Millisecond)
+	if breaker.State() != circuitbreaker.StateHalfOpen {
+		t.Fatalf("Expected the circuit to be half-open, got %s", breaker.State())
+	}
+	breaker.Generate(ctx, prompt)
+	if breaker.State() != circuitbreaker.StateOpen {
+		t.Fatalf("Expected the circuit to open again, got %s", breaker.State())
+	}
+
+	// A successful probe closes the circuit
+	time.Sleep(60 * time.Millisecond)
+	provider.setErr(nil)
+	if _, err := breaker.Generate(ctx, prompt); err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	if breaker.State() != circuitbreaker.StateClosed {
+		t.Fatalf("Expected the circuit to be closed, got %s", breaker.State())
+	}
+
+	// Every transition was recorded
+	expected := []string{"closed>open", "open>half_open", "half_open>open", "open>half_open", "half_open>closed"}
+	if len(tracer.transitions) != len(expected) {
+		t.Fatalf("Expected transitions %v, got %v", expected, tracer.transitions)
+	}
+	for i, transition := range expected {
+		if tracer.transitions[i] != transition {
+			t.Fatalf("Expected transitions %v, got %v", expected, tracer.transitions)
+		}
+	}
+}
+
+// MockProvider is a mock provider that fails with the configured error
+type MockProvider struct {
+	mu    sync.Mutex
+	err   error
+	calls int
+}
+
+// setErr sets the error returned by the provider
+func (p *MockProvider) setErr(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+// callCount returns the number of calls to the provider
+func (p *MockProvider) callCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+// Name returns the name of the provider
+func (p *MockProvider) Name() string {
+	return "mock"
+}
+
+// Generate echoes the prompt or fails with the configured error
+func (p *MockProvider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &core.Response{Text: prompt.Text}, nil
+}
+
+// GenerateStream returns an empty stream or fails with the configured error
+func (p *MockProvider) GenerateStream(ctx context.Context, prompt *core.Prompt) (core.ResponseStream, error) {
+	if _, err := p.Generate(ctx, prompt); err != nil {
+		return nil, err
+	}
+	return &emptyStream{}, nil
+}
+
+// emptyStream is a stream without chunks
+type emptyStream struct{}
+
+// Next returns io.EOF
+func (s *emptyStream) Next() (*core.ResponseChunk, error) {
+	return nil, io.EOF
+}
+
+// Close closes the stream
+func (s *emptyStream) Close() error {
+	return nil
+}
+
+// RecordingTracer is a tracer that records circuit breaker transitions
+type RecordingTracer struct {
+	transitions []string
+}
+
+// StartSpan returns the context unchanged
+func (t *RecordingTracer) StartSpan(ctx context.Context, name string, opts ...tracing.SpanOption) (context.Context, *tracing.Span) {
+	return ctx, &tracing.Span{Name: name}
+}
+
+// EndSpan does nothing
+func (t *RecordingTracer) EndSpan(ctx context.Context, status tracing.SpanStatus) {}
+
+// AddEvent records state change events
+func (t *RecordingTracer) AddEvent(ctx context.Context, name string, attributes map[string]interface{}) {
+	if name == "circuit_breaker_state_change" {
+		t.transitions = append(t.transitions, attributes["from"].(string)+">"+attributes["to"].(string))
+	}
+}
+
+// SetAttribute does nothing
+func (t *RecordingTracer) SetAttribute(ctx context.Context, key string, value interface{}) {}
+
+// Flush does nothing
+func (t *RecordingTracer) Flush() error {
+	return nil
+}
@@ -9,6 +9,16 @@ import (
 	"strings"
 
 	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/providers/anthropic"
+	"github.com/GeoloeG-IsT/gollem/pkg/providers/azure"
+	"github.com/GeoloeG-IsT/gollem/pkg/providers/google"
+	"github.com/GeoloeG-IsT/gollem/pkg/providers/llama"
+	"github.com/GeoloeG-IsT/gollem/pkg/providers/mistral"
+	"github.com/GeoloeG-IsT/gollem/pkg/providers/ollama"
+	"github.com/GeoloeG-IsT/gollem/pkg/providers/openai"
+	"github.com/GeoloeG-IsT/gollem/pkg/providers/openaicompat"
+	"github.com/GeoloeG-IsT/gollem/pkg/ratelimit"
+	"github.com/GeoloeG-IsT/gollem/pkg/routing"
 )
 
 // Config represents the configuration for the gollem package
@@ -30,6 +40,24 @@ type Config struct {
 	
 	// CustomProviderPaths is a list of paths to search for custom providers
 	CustomProviderPaths []string `json:"custom_provider_paths"`
+	
+	// Fallbacks is a map of providers that try other providers in order
+	Fallbacks map[string]FallbackConfig `json:"fallbacks,omitempty"`
+	
+	// Routers is a map of providers that select another provider per prompt
+	Routers map[string]RouterConfig `json:"routers,omitempty"`
+	
+	// Middleware is the middleware applied to every configured provider,
+	// from the outermost to the innermost
+	Middleware []MiddlewareConfig `json:"middleware,omitempty"`
+	
+	// Usage configuration
+	Usage UsageConfig `json:"usage"`
+	
+	// Models overrides the capabilities of models in the model catalog. Keys
+	// are "provider/model", or "model" for any provider, and values are
+	// partial core.ModelCapabilities objects.
+	Models map[string]json.RawMessage `json:"models,omitempty"`
 }
 
 // ProviderConfig represents the configuration for an LLM provider
@@ -48,6 +76,22 @@ type ProviderConfig struct {
 	
 	// Parameters contains additional provider-specific parameters
 	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	
+	// RateLimit limits the requests sent to the provider (optional)
+	RateLimit *RateLimitConfig `json:"rate_limit,omitempty"`
+}
+
+// RateLimitConfig represents the client-side limits of a provider. Limits
+// set to 0 are not enforced.
+type RateLimitConfig struct {
+	// RequestsPerMinute is the maximum number of requests per minute
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+	
+	// TokensPerMinute is the maximum number of tokens per minute
+	TokensPerMinute int `json:"tokens_per_minute,omitempty"`
+	
+	// MaxConcurrency is the maximum number of requests in flight
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
 }
 
 // CacheConfig represents the configuration for caching
@@ -107,6 +151,105 @@ type TracingConfig struct {
 	Parameters map[string]interface{} `json:"parameters,omitempty"`
 }
 
+// FallbackConfig represents the configuration for a fallback provider
+type FallbackConfig struct {
+	// Providers are the names of the providers to try, in order
+	Providers []string `json:"providers"`
+}
+
+// RouterConfig represents the configuration for a router
+type RouterConfig struct {
+	// Default is the name of the provider used when no route matches
+	Default string `json:"default"`
+	
+	// Routes are evaluated in order, and the first matching route selects
+	// the provider
+	Routes []RouteConfig `json:"routes"`
+}
+
+// RouteConfig represents a route of a router. A route matches prompts that
+// satisfy all of its conditions.
+type RouteConfig struct {
+	// Provider is the name of the provider selected by the route
+	Provider string `json:"provider"`
+	
+	// Tags are tags the prompt must have in AdditionalParams["tags"]
+	Tags []string `json:"tags,omitempty"`
+	
+	// Capabilities are capabilities the prompt needs ("tools",
+	// "structured_output", "vision", "audio")
+	Capabilities []string `json:"capabilities,omitempty"`
+	
+	// MinPromptTokens is the minimum estimated prompt length in tokens
+	MinPromptTokens int `json:"min_prompt_tokens,omitempty"`
+	
+	// MaxPromptTokens is the maximum estimated prompt length in tokens
+	MaxPromptTokens int `json:"max_prompt_tokens,omitempty"`
+}
+
+// MiddlewareConfig represents a middleware applied to the providers
+type MiddlewareConfig struct {
+	// Type is the type of middleware ("cache", "tracing", "retry" or
+	// "circuit_breaker"). The cache and tracing middleware are configured by
+	// the Cache and Tracing sections of the configuration.
+	Type string `json:"type"`
+	
+	// Parameters contains additional middleware-specific parameters
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// UsageConfig represents the configuration for usage and cost accounting
+type UsageConfig struct {
+	// Prices maps "provider/model", "model" or "provider" to the price of
+	// the model
+	Prices map[string]PriceConfig `json:"prices,omitempty"`
+	
+	// Budgets are the usage limits enforced by the usage middleware
+	Budgets []BudgetConfig `json:"budgets,omitempty"`
+}
+
+// PriceConfig represents the price of a model per million tokens
+type PriceConfig struct {
+	// Prompt is the price of a million prompt tokens
+	Prompt float64 `json:"prompt"`
+	
+	// Completion is the price of a million completion tokens
+	Completion float64 `json:"completion"`
+	
+	// CacheRead is the price of a million prompt tokens read from the prompt
+	// cache (optional, defaults to Prompt)
+	CacheRead float64 `json:"cache_read,omitempty"`
+	
+	// CacheWrite is the price of a million prompt tokens written to the
+	// prompt cache (optional, defaults to Prompt)
+	CacheWrite float64 `json:"cache_write,omitempty"`
+}
+
+// BudgetConfig represents a usage budget
+type BudgetConfig struct {
+	// Provider restricts the budget to a provider (optional)
+	Provider string `json:"provider,omitempty"`
+	
+	// Model restricts the budget to a model (optional)
+	Model string `json:"model,omitempty"`
+	
+	// Tag restricts the budget to a tag (optional)
+	Tag string `json:"tag,omitempty"`
+	
+	// PerTag gives every tag its own budget
+	PerTag bool `json:"per_tag,omitempty"`
+	
+	// Period is the period after which the budget is renewed ("hour",
+	// "day", "month" or empty for no renewal)
+	Period string `json:"period,omitempty"`
+	
+	// MaxCost is the maximum cost within the period
+	MaxCost float64 `json:"max_cost,omitempty"`
+	
+	// MaxTokens is the maximum number of tokens within the period
+	MaxTokens int `json:"max_tokens,omitempty"`
+}
+
 // LoadConfig loads the configuration from a file
 func LoadConfig(path string) (*Config, error) {
 	data, err := ioutil.ReadFile(path)
@@ -136,19 +279,45 @@ func SaveConfig(config *Config, path string) error {
 	return nil
 }
 
-// CreateRegistry creates a provider registry from the configuration
-func (c *Config) CreateRegistry() (*core.Registry, error) {
+// CreateRegistry creates a provider registry from the configuration. The
+// configured middleware and then the given middleware are applied to every
+// configured provider, the first one being the outermost. The registry must be
+// closed with Close to release the caches of the providers.
+func (c *Config) CreateRegistry(middleware ...core.Middleware) (*core.Registry, error) {
+	// Models are overridden in a copy of the default catalog, used by the
+	// providers of this registry only
+	catalog := core.DefaultCatalog().Clone()
+	if err := c.overrideModels(catalog); err != nil {
+		return nil, err
+	}
+	
 	registry := core.NewRegistry()
 	
 	// Register built-in provider factories
-	registerBuiltInProviderFactories(registry)
+	registerBuiltInProviderFactories(registry, catalog)
 	
 	// Register custom provider factories
 	if err := c.registerCustomProviderFactories(registry); err != nil {
 		return nil, err
 	}
 	
-	// Create providers from the configuration
+	builder, err := c.newMiddlewareBuilder(registry)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Release the caches created so far if the registry cannot be created
+	created := false
+	defer func() {
+		if !created {
+			registry.Close()
+		}
+	}()
+	
+	// Create providers from the configuration. Providers are registered
+	// under their configured names, and under their own names when no other
+	// provider shares them.
+	byName := make(map[string][]core.LLMProvider)
 	for name, providerConfig := range c.Providers {
 		config := map[string]interface{}{
 			"api_key":  providerConfig.APIKey,
@@ -161,22 +330,249 @@ func (c *Config) CreateRegistry() (*core.Registry, error) {
 			config[k] = v
 		}
 		
-		_, err := registry.CreateProvider(providerConfig.Type, config)
+		// OpenAI-compatible providers are named after their configuration
+		// unless named explicitly
+		if _, exists := config["name"]; !exists && providerConfig.Type == "openaicompat" {
+			config["name"] = name
+		}
+		
+		provider, err := registry.NewProvider(providerConfig.Type, config)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create provider %s: %w", name, err)
 		}
+		
+		// Apply the rate limits
+		if limits := providerConfig.RateLimit; limits != nil {
+			provider = ratelimit.NewRateLimitMiddleware(provider,
+				ratelimit.WithRequestsPerMinute(limits.RequestsPerMinute),
+				ratelimit.WithTokensPerMinute(limits.TokensPerMinute),
+				ratelimit.WithMaxConcurrency(limits.MaxConcurrency),
+			)
+		}
+		
+		// Apply the middleware
+		configured, err := builder.build(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create middleware for provider %s: %w", name, err)
+		}
+		provider = core.Chain(provider, append(configured, middleware...)...)
+		registry.RegisterProviderAs(name, provider)
+		byName[provider.Name()] = append(byName[provider.Name()], provider)
+	}
+	for name, providers := range byName {
+		if _, configured := c.Providers[name]; !configured && len(providers) == 1 {
+			registry.RegisterProviderAs(name, providers[0])
+		}
+	}
+	
+	// Create fallback providers and routers, which refer to other providers
+	// by name
+	resolver := &providerResolver{config: c, registry: registry, resolving: make(map[string]bool)}
+	for name := range c.Fallbacks {
+		if _, err := resolver.resolve(name); err != nil {
+			return nil, err
+		}
+	}
+	for name := range c.Routers {
+		if _, err := resolver.resolve(name); err != nil {
+			return nil, err
+		}
 	}
 	
+	created = true
 	return registry, nil
 }
 
-// registerBuiltInProviderFactories registers the built-in provider factories
-func registerBuiltInProviderFactories(registry *core.Registry) {
-	// This would be implemented in a real package to register all the built-in providers
-	// For example:
-	// registry.RegisterFactory("openai", openai.NewProvider)
-	// registry.RegisterFactory("anthropic", anthropic.NewProvider)
-	// etc.
+// overrideModels applies the model overrides of the configuration to a
+// catalog
+func (c *Config) overrideModels(catalog *core.ModelCatalog) error {
+	for key, capabilities := range c.Models {
+		provider, model := "", key
+		if i := strings.Index(key, "/"); i >= 0 {
+			provider, model = key[:i], key[i+1:]
+		}
+		if err := catalog.Override(provider, model, capabilities); err != nil {
+			return fmt.Errorf("failed to override model %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// providerResolver creates fallback providers and routers, resolving the
+// providers they refer to in any order
+type providerResolver struct {
+	config    *Config
+	registry  *core.Registry
+	resolving map[string]bool
+}
+
+// resolve returns the provider with the given name, creating it if it is a
+// fallback provider or router
+func (r *providerResolver) resolve(name string) (core.LLMProvider, error) {
+	if provider, exists := r.registry.GetProvider(name); exists {
+		return provider, nil
+	}
+	
+	if r.resolving[name] {
+		return nil, fmt.Errorf("provider %s refers to itself", name)
+	}
+	r.resolving[name] = true
+	defer delete(r.resolving, name)
+	
+	var provider core.LLMProvider
+	var err error
+	if fallbackConfig, exists := r.config.Fallbacks[name]; exists {
+		provider, err = r.createFallback(name, fallbackConfig)
+	} else if routerConfig, exists := r.config.Routers[name]; exists {
+		provider, err = r.createRouter(name, routerConfig)
+	} else {
+		return nil, fmt.Errorf("provider %s does not exist", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	
+	r.registry.RegisterProvider(provider)
+	return provider, nil
+}
+
+// createFallback creates a fallback provider from its configuration
+func (r *providerResolver) createFallback(name string, config FallbackConfig) (core.LLMProvider, error) {
+	providers := make([]core.LLMProvider, 0, len(config.Providers))
+	for _, providerName := range config.Providers {
+		provider, err := r.resolve(providerName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create fallback %s: %w", name, err)
+		}
+		providers = append(providers, provider)
+	}
+	
+	fallback, err := routing.NewFallbackProvider(name, providers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create fallback %s: %w", name, err)
+	}
+	return fallback, nil
+}
+
+// createRouter creates a router from its configuration
+func (r *providerResolver) createRouter(name string, config RouterConfig) (core.LLMProvider, error) {
+	defaultProvider, err := r.resolve(config.Default)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create router %s: %w", name, err)
+	}
+	router, err := routing.NewRouter(name, defaultProvider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create router %s: %w", name, err)
+	}
+	
+	for _, route := range config.Routes {
+		provider, err := r.resolve(route.Provider)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create router %s: %w", name, err)
+		}
+		
+		var rules []routing.Rule
+		for _, tag := range route.Tags {
+			rules = append(rules, routing.HasTag(tag))
+		}
+		for _, capability := range route.Capabilities {
+			rules = append(rules, routing.Needs(routing.Capability(capability)))
+		}
+		if route.MinPromptTokens > 0 || route.MaxPromptTokens > 0 {
+			rules = append(rules, routing.PromptLength(route.MinPromptTokens, route.MaxPromptTokens, nil))
+		}
+		router.AddRoute(routing.All(rules...), provider)
+	}
+	
+	return router, nil
+}
+
+// registerBuiltInProviderFactories registers the built-in provider
+// factories, creating providers that look models up in the given catalog
+func registerBuiltInProviderFactories(registry *core.Registry, catalog *core.ModelCatalog) {
+	registry.RegisterFactory("openai", func(config map[string]interface{}) (core.LLMProvider, error) {
+		var providerConfig openai.Config
+		if err := decodeProviderConfig(config, &providerConfig); err != nil {
+			return nil, err
+		}
+		providerConfig.Catalog = catalog
+		return openai.NewProvider(providerConfig)
+	})
+	registry.RegisterFactory("anthropic", func(config map[string]interface{}) (core.LLMProvider, error) {
+		var providerConfig anthropic.Config
+		if err := decodeProviderConfig(config, &providerConfig); err != nil {
+			return nil, err
+		}
+		providerConfig.Catalog = catalog
+		return anthropic.NewProvider(providerConfig)
+	})
+	registry.RegisterFactory("google", func(config map[string]interface{}) (core.LLMProvider, error) {
+		var providerConfig google.Config
+		if err := decodeProviderConfig(config, &providerConfig); err != nil {
+			return nil, err
+		}
+		providerConfig.Catalog = catalog
+		return google.NewProvider(providerConfig)
+	})
+	registry.RegisterFactory("mistral", func(config map[string]interface{}) (core.LLMProvider, error) {
+		var providerConfig mistral.Config
+		if err := decodeProviderConfig(config, &providerConfig); err != nil {
+			return nil, err
+		}
+		providerConfig.Catalog = catalog
+		return mistral.NewProvider(providerConfig)
+	})
+	registry.RegisterFactory("llama", func(config map[string]interface{}) (core.LLMProvider, error) {
+		var providerConfig llama.Config
+		if err := decodeProviderConfig(config, &providerConfig); err != nil {
+			return nil, err
+		}
+		providerConfig.Catalog = catalog
+		return llama.NewProvider(providerConfig)
+	})
+	registry.RegisterFactory("ollama", func(config map[string]interface{}) (core.LLMProvider, error) {
+		var providerConfig ollama.Config
+		if err := decodeProviderConfig(config, &providerConfig); err != nil {
+			return nil, err
+		}
+		providerConfig.Catalog = catalog
+		return ollama.NewProvider(providerConfig)
+	})
+	registry.RegisterFactory("openaicompat", func(config map[string]interface{}) (core.LLMProvider, error) {
+		var providerConfig openaicompat.Config
+		if err := decodeProviderConfig(config, &providerConfig); err != nil {
+			return nil, err
+		}
+		providerConfig.Catalog = catalog
+		return openaicompat.NewProvider(providerConfig)
+	})
+	registry.RegisterFactory("azure", func(config map[string]interface{}) (core.LLMProvider, error) {
+		var providerConfig azure.Config
+		if err := decodeProviderConfig(config, &providerConfig); err != nil {
+			return nil, err
+		}
+		providerConfig.Catalog = catalog
+		// The model names the deployment unless it is set explicitly
+		if providerConfig.Deployment == "" {
+			providerConfig.Deployment, _ = config["model"].(string)
+		}
+		return azure.NewProvider(providerConfig)
+	})
+}
+
+// decodeProviderConfig decodes a factory configuration into the Config
+// struct of a provider, using its JSON field names
+func decodeProviderConfig(config map[string]interface{}, providerConfig interface{}) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal provider config: %w", err)
+	}
+	
+	if err := json.Unmarshal(data, providerConfig); err != nil {
+		return fmt.Errorf("failed to parse provider config: %w", err)
+	}
+	
+	return nil
 }
 
 // registerCustomProviderFactories registers custom provider factories
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -128,6 +129,7 @@ func CreateRegistryWithConfig(configPath string) (*core.Registry, error) {
 	if len(config.CustomProviderPaths) > 0 {
 		loader := custom.NewProviderLoader(config.CustomProviderPaths)
 		if err := loader.LoadProviders(registry); err != nil {
+			registry.Close()
 			return nil, fmt.Errorf("failed to load custom providers: %w", err)
 		}
 	}
@@ -191,8 +193,16 @@ func CreateDefaultConfigFile(path string) error {
 
 // ValidateConfig validates the configuration
 func ValidateConfig(config *Config) error {
+	// Providers can be configured providers, fallbacks or routers
+	exists := func(name string) bool {
+		_, isProvider := config.Providers[name]
+		_, isFallback := config.Fallbacks[name]
+		_, isRouter := config.Routers[name]
+		return isProvider || isFallback || isRouter
+	}
+	
 	// Check if the default provider exists
-	if _, exists := config.Providers[config.DefaultProvider]; !exists {
+	if !exists(config.DefaultProvider) {
 		return fmt.Errorf("default provider %s does not exist", config.DefaultProvider)
 	}
 	
@@ -203,14 +213,59 @@ func ValidateConfig(config *Config) error {
 		}
 		
 		// Validate API key for providers that require it
-		if provider.Type != "custom" && provider.APIKey == "" {
+		if requiresAPIKey(provider) && provider.APIKey == "" {
 			return fmt.Errorf("provider %s requires an API key", name)
 		}
 	}
 	
+	// Validate fallback configurations
+	for name, fallback := range config.Fallbacks {
+		if len(fallback.Providers) == 0 {
+			return fmt.Errorf("fallback %s has no providers", name)
+		}
+		for _, provider := range fallback.Providers {
+			if !exists(provider) {
+				return fmt.Errorf("fallback %s refers to unknown provider %s", name, provider)
+			}
+		}
+	}
+	
+	// Validate middleware configurations
+	for _, middleware := range config.Middleware {
+		if !isMiddlewareType(middleware.Type) {
+			return fmt.Errorf("unknown middleware type %s", middleware.Type)
+		}
+	}
+	
+	// Validate router configurations
+	for name, router := range config.Routers {
+		if !exists(router.Default) {
+			return fmt.Errorf("router %s refers to unknown default provider %s", name, router.Default)
+		}
+		for _, route := range router.Routes {
+			if !exists(route.Provider) {
+				return fmt.Errorf("router %s refers to unknown provider %s", name, route.Provider)
+			}
+		}
+	}
+	
 	return nil
 }
 
+// requiresAPIKey returns whether a provider requires an API key. Custom
+// providers, local Ollama and OpenAI-compatible servers may run without one,
+// and Azure and Google on Vertex AI accept a bearer token instead.
+func requiresAPIKey(provider ProviderConfig) bool {
+	switch provider.Type {
+	case "custom", "ollama", "openaicompat", "azure":
+		return false
+	case "google":
+		return provider.Parameters["project"] == nil
+	default:
+		return true
+	}
+}
+
 // MergeConfigs merges two configurations, with the second taking precedence
 func MergeConfigs(base, override *Config) *Config {
 	result := *base
@@ -225,6 +280,44 @@ func MergeConfigs(base, override *Config) *Config {
 		result.Providers[name] = provider
 	}
 	
+	// Merge fallbacks and routers
+	for name, fallback := range override.Fallbacks {
+		if result.Fallbacks == nil {
+			result.Fallbacks = make(map[string]FallbackConfig)
+		}
+		result.Fallbacks[name] = fallback
+	}
+	
+	for name, router := range override.Routers {
+		if result.Routers == nil {
+			result.Routers = make(map[string]RouterConfig)
+		}
+		result.Routers[name] = router
+	}
+	
+	// Merge middleware and usage configuration
+	if override.Middleware != nil {
+		result.Middleware = override.Middleware
+	}
+	
+	for name, price := range override.Usage.Prices {
+		if result.Usage.Prices == nil {
+			result.Usage.Prices = make(map[string]PriceConfig)
+		}
+		result.Usage.Prices[name] = price
+	}
+	
+	if override.Usage.Budgets != nil {
+		result.Usage.Budgets = override.Usage.Budgets
+	}
+	
+	for name, capabilities := range override.Models {
+		if result.Models == nil {
+			result.Models = make(map[string]json.RawMessage)
+		}
+		result.Models[name] = capabilities
+	}
+	
 	// Merge cache configuration
 	if override.Cache.Type != "" {
 		result.Cache.Type = override.Cache.Type
@@ -62,9 +62,10 @@ func (m *ConfigManager) SaveConfig() error {
 	return SaveConfig(m.config, m.configPath)
 }
 
-// CreateRegistry creates a provider registry from the configuration
-func (m *ConfigManager) CreateRegistry() (*core.Registry, error) {
-	return m.config.CreateRegistry()
+// CreateRegistry creates a provider registry from the configuration,
+// applying the given middleware to every configured provider
+func (m *ConfigManager) CreateRegistry(middleware ...core.Middleware) (*core.Registry, error) {
+	return m.config.CreateRegistry(middleware...)
 }
 
 // UpdateProvider updates a provider configuration
@@ -1,11 +1,17 @@
 package config_test
 
 import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/GeoloeG-IsT/gollem/pkg/config"
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
 )
 
 // TestConfigLoading tests the configuration loading functionality
@@ -263,4 +269,333 @@ func TestConfigValidation(t *testing.T) {
 	if err == nil {
 		t.Fatal("No error when validating config with provider with no API key")
 	}
+	
+	// Google on Vertex AI is authenticated with a bearer token instead
+	delete(invalidConfig3.Providers, "invalid")
+	invalidConfig3.Providers["vertex"] = config.ProviderConfig{
+		Type:       "google",
+		Parameters: map[string]interface{}{"project": "my-project", "bearer_token": "token"},
+	}
+	if err := config.ValidateConfig(invalidConfig3); err != nil {
+		t.Fatalf("Validation failed for Vertex AI provider without API key: %v", err)
+	}
+}
+
+// TestRoutingConfig tests creating fallback providers and routers from the
+// configuration
+func TestRoutingConfig(t *testing.T) {
+	// The anthropic server is down and the openai server responds
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"Paris"},"finish_reason":"stop"}]}`)
+	}))
+	defer up.Close()
+
+	data := `{
+		"default_provider": "smart",
+		"providers": {
+			"claude": {"type": "anthropic", "api_key": "test", "endpoint": "` + down.URL + `"},
+			"gpt": {"type": "openai", "api_key": "test", "endpoint": "` + up.URL + `"}
+		},
+		"fallbacks": {
+			"main": {"providers": ["claude", "gpt"]}
+		},
+		"routers": {
+			"smart": {
+				"default": "main",
+				"routes": [{"provider": "claude", "tags": ["premium"]}]
+			}
+		}
+	}`
+	var cfg config.Config
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if err := config.ValidateConfig(&cfg); err != nil {
+		t.Fatalf("Validation failed for valid config: %v", err)
+	}
+
+	registry, err := cfg.CreateRegistry()
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+
+	// The router uses the fallback by default, which falls back to openai
+	router, exists := registry.GetProvider("smart")
+	if !exists {
+		t.Fatal("Router is not registered")
+	}
+	response, err := router.Generate(context.Background(), core.NewPrompt("What is the capital of France?"))
+	if err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	if response.Text != "Paris" {
+		t.Fatalf("Response is incorrect: %s", response.Text)
+	}
+
+	// Tagged prompts are routed to anthropic directly
+	prompt := core.NewPrompt("What is the capital of France?")
+	prompt.AdditionalParams = map[string]interface{}{"tags": []string{"premium"}}
+	if _, err := router.Generate(context.Background(), prompt); err == nil {
+		t.Fatal("Expected an error from the anthropic provider")
+	}
+
+	// References to unknown providers are invalid
+	cfg.Fallbacks["broken"] = config.FallbackConfig{Providers: []string{"missing"}}
+	if err := config.ValidateConfig(&cfg); err == nil {
+		t.Fatal("No error when validating config with an unknown fallback provider")
+	}
+}
+
+// TestMiddlewareConfig tests that configured and programmatic middleware is
+// applied to every configured provider
+func TestMiddlewareConfig(t *testing.T) {
+	// The server fails the first request and then answers
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, `{"error":{"message":"overloaded"}}`)
+			return
+		}
+		io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"Paris"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	data := `{
+		"default_provider": "gpt",
+		"providers": {
+			"gpt": {"type": "openai", "api_key": "test", "endpoint": "` + server.URL + `"}
+		},
+		"cache": {"enabled": true, "type": "memory", "ttl": 60, "parameters": {"eviction": "lfu", "shards": 4, "cleanup_interval_seconds": 60}},
+		"middleware": [
+			{"type": "cache"},
+			{"type": "retry", "parameters": {"max_attempts": 2, "base_delay_ms": 1}}
+		]
+	}`
+	var cfg config.Config
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if err := config.ValidateConfig(&cfg); err != nil {
+		t.Fatalf("Validation failed for valid config: %v", err)
+	}
+
+	// Programmatic middleware is applied inside the configured middleware
+	var order []string
+	record := func(name string) core.Middleware {
+		return func(provider core.LLMProvider) core.LLMProvider {
+			order = append(order, name)
+			return provider
+		}
+	}
+	registry, err := cfg.CreateRegistry(record("outer"), record("inner"))
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	if len(order) != 2 || order[0] != "inner" || order[1] != "outer" {
+		t.Fatalf("Middleware was applied in the wrong order: %v", order)
+	}
+
+	// The failed request is retried, and the response is then cached
+	provider, exists := registry.GetProvider("gpt")
+	if !exists {
+		t.Fatal("Provider is not registered under its configured name")
+	}
+	if provider.Name() != "openai" {
+		t.Fatalf("Wrapped provider has the wrong name: %s", provider.Name())
+	}
+	for i := 0; i < 2; i++ {
+		response, err := provider.Generate(context.Background(), core.NewPrompt("What is the capital of France?"))
+		if err != nil {
+			t.Fatalf("Failed to generate response: %v", err)
+		}
+		if response.Text != "Paris" {
+			t.Fatalf("Response is incorrect: %s", response.Text)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("Expected 2 requests to the server, got %d", calls)
+	}
+
+	// Closing the registry stops the background cleanup of the caches
+	if err := registry.Close(); err != nil {
+		t.Fatalf("Failed to close registry: %v", err)
+	}
+
+	// Unknown middleware types are invalid
+	cfg.Middleware = append(cfg.Middleware, config.MiddlewareConfig{Type: "unknown"})
+	if err := config.ValidateConfig(&cfg); err == nil {
+		t.Fatal("No error when validating config with an unknown middleware type")
+	}
+}
+
+// TestUsageConfig tests creating a usage tracker from the configuration
+func TestUsageConfig(t *testing.T) {
+	data := `{
+		"usage": {
+			"prices": {"openai/gpt-4o": {"prompt": 2.5, "completion": 10}},
+			"budgets": [{"tag": "acme", "period": "month", "max_cost": 100}]
+		}
+	}`
+	var cfg config.Config
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	tracker, err := cfg.CreateUsageTracker()
+	if err != nil {
+		t.Fatalf("Failed to create usage tracker: %v", err)
+	}
+	cost := tracker.Record(context.Background(), "openai", "gpt-4o", &core.TokenUsage{Prompt: 1000000, Completion: 1000000})
+	if cost != 12.5 {
+		t.Fatalf("Cost is incorrect: %v", cost)
+	}
+
+	// Unknown periods are invalid
+	cfg.Usage.Budgets[0].Period = "week"
+	if _, err := cfg.CreateUsageTracker(); err == nil {
+		t.Fatal("No error when creating a usage tracker with an unknown budget period")
+	}
+}
+
+// TestOpenAICompatibleConfig tests that OpenAI-compatible providers are
+// named after their configuration and need no API key
+func TestOpenAICompatibleConfig(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/embed" {
+			io.WriteString(w, `{"data":[{"index":0,"embedding":[0.5,0.5]}]}`)
+			return
+		}
+		io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"Paris"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	data := `{
+		"default_provider": "vllm",
+		"providers": {
+			"vllm": {"type": "openaicompat", "model": "qwen", "endpoint": "` + server.URL + `"},
+			"studio": {"type": "openaicompat", "model": "phi", "endpoint": "` + server.URL + `",
+				"parameters": {"name": "lmstudio", "features": {"disable_tools": true},
+					"embeddings_path": "v2/embed", "embedding_model": "bge"}}
+		}
+	}`
+	var cfg config.Config
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if err := config.ValidateConfig(&cfg); err != nil {
+		t.Fatalf("Validation failed for valid config: %v", err)
+	}
+
+	registry, err := cfg.CreateRegistry()
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+
+	for _, name := range []string{"vllm", "studio", "lmstudio"} {
+		provider, exists := registry.GetProvider(name)
+		if !exists {
+			t.Fatalf("Provider %s is not registered", name)
+		}
+		if _, err := provider.Generate(context.Background(), core.NewPrompt("Hello")); err != nil {
+			t.Fatalf("Failed to generate response with %s: %v", name, err)
+		}
+	}
+	if provider, _ := registry.GetProvider("vllm"); provider.Name() != "vllm" {
+		t.Fatalf("Provider name is incorrect: %s", provider.Name())
+	}
+
+	// Embeddings are requested from the configured path
+	provider, _ := registry.GetProvider("studio")
+	embedder, ok := provider.(core.EmbeddingProvider)
+	if !ok {
+		t.Fatal("Provider does not generate embeddings")
+	}
+	response, err := embedder.Embed(context.Background(), &core.EmbeddingRequest{Texts: []string{"Hello"}})
+	if err != nil {
+		t.Fatalf("Failed to generate embeddings: %v", err)
+	}
+	if len(response.Embeddings) != 1 {
+		t.Fatalf("Expected 1 embedding, got %d", len(response.Embeddings))
+	}
+}
+
+// TestProviderNames tests that providers sharing a type are only registered
+// under their configured names
+func TestProviderNames(t *testing.T) {
+	data := `{
+		"providers": {
+			"gpt4": {"type": "openai", "api_key": "test", "model": "gpt-4o"},
+			"gpt35": {"type": "openai", "api_key": "test", "model": "gpt-3.5-turbo"},
+			"claude": {"type": "anthropic", "api_key": "test", "model": "claude-3-haiku-20240307"}
+		}
+	}`
+	var cfg config.Config
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	registry, err := cfg.CreateRegistry()
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	for name, model := range map[string]string{"gpt4": "gpt-4o", "gpt35": "gpt-3.5-turbo", "claude": "claude-3-haiku-20240307"} {
+		provider, exists := registry.GetProvider(name)
+		if !exists || core.ModelOf(provider) != model {
+			t.Fatalf("Provider %s is not registered with model %s", name, model)
+		}
+	}
+
+	// The name of a type is ambiguous when several providers share it
+	if _, exists := registry.GetProvider("openai"); exists {
+		t.Fatal("Provider registered under a name shared by several providers")
+	}
+	if provider, exists := registry.GetProvider("anthropic"); !exists || core.ModelOf(provider) != "claude-3-haiku-20240307" {
+		t.Fatal("Provider is not registered under its own name")
+	}
+}
+
+// TestModelsConfig tests overriding the capabilities of models
+func TestModelsConfig(t *testing.T) {
+	data := `{
+		"providers": {
+			"catalogtest": {"type": "openaicompat", "model": "acme-llm-v2", "endpoint": "http://localhost:8000/v1"}
+		},
+		"models": {
+			"catalogtest/acme-llm": {"context_window": 4096, "tools": true}
+		}
+	}`
+	var cfg config.Config
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	registry, err := cfg.CreateRegistry()
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	provider, _ := registry.GetProvider("catalogtest")
+	capabilities, ok := core.CapabilitiesOf(provider)
+	if !ok {
+		t.Fatal("Capabilities of the model are unknown")
+	}
+	if capabilities.ContextWindow != 4096 || !capabilities.Tools || capabilities.Vision || capabilities.Version != "v2" {
+		t.Fatalf("Capabilities are incorrect: %+v", capabilities)
+	}
+
+	// Overrides apply to the providers of the registry only
+	if _, ok := core.LookupModel("catalogtest", "acme-llm-v2"); ok {
+		t.Fatal("Override leaked into the default catalog")
+	}
+
+	// Overrides must be capabilities
+	cfg.Models["catalogtest/acme-llm"] = json.RawMessage(`{"tools": "yes"}`)
+	if _, err := cfg.CreateRegistry(); err == nil {
+		t.Fatal("No error when overriding a model with invalid capabilities")
+	}
 }
@@ -0,0 +1,265 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/cache"
+	"github.com/GeoloeG-IsT/gollem/pkg/circuitbreaker"
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/retry"
+	"github.com/GeoloeG-IsT/gollem/pkg/tracing"
+	"github.com/GeoloeG-IsT/gollem/pkg/usage"
+)
+
+// retryConfig represents the parameters of the retry middleware
+type retryConfig struct {
+	MaxAttempts int `json:"max_attempts"`
+	BaseDelayMs int `json:"base_delay_ms"`
+	MaxDelayMs  int `json:"max_delay_ms"`
+}
+
+// circuitBreakerConfig represents the parameters of the circuit breaker
+// middleware
+type circuitBreakerConfig struct {
+	FailureRatio       float64 `json:"failure_ratio"`
+	WindowSeconds      int     `json:"window_seconds"`
+	MinRequests        int     `json:"min_requests"`
+	OpenTimeoutSeconds int     `json:"open_timeout_seconds"`
+	HalfOpenRequests   int     `json:"half_open_requests"`
+}
+
+// memoryCacheConfig represents the parameters of the memory cache
+type memoryCacheConfig struct {
+	Eviction               string `json:"eviction"`
+	MaxBytes               int64  `json:"max_bytes"`
+	Shards                 int    `json:"shards"`
+	CleanupIntervalSeconds int    `json:"cleanup_interval_seconds"`
+}
+
+// isMiddlewareType returns whether a middleware type is supported
+func isMiddlewareType(middlewareType string) bool {
+	switch middlewareType {
+	case "cache", "tracing", "retry", "circuit_breaker":
+		return true
+	default:
+		return false
+	}
+}
+
+// middlewareBuilder builds the configured middleware of each provider.
+// Middleware that keeps state per provider, such as caches and circuit
+// breakers, is created for every provider, while the tracer is shared. Caches
+// that need closing are closed with the registry.
+type middlewareBuilder struct {
+	config   *Config
+	registry *core.Registry
+	tracer   tracing.Tracer
+}
+
+// newMiddlewareBuilder creates a middleware builder for the configuration
+// and the registry the providers are registered in
+func (c *Config) newMiddlewareBuilder(registry *core.Registry) (*middlewareBuilder, error) {
+	builder := &middlewareBuilder{config: c, registry: registry}
+
+	for _, middleware := range c.Middleware {
+		if middleware.Type != "tracing" || builder.tracer != nil {
+			continue
+		}
+
+		tracerConfig := map[string]interface{}{
+			"type":     c.Tracing.Type,
+			"endpoint": c.Tracing.Endpoint,
+		}
+		for k, v := range c.Tracing.Parameters {
+			tracerConfig[k] = v
+		}
+
+		tracer, err := tracing.NewTracerFactory().CreateTracer(tracerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create tracer: %w", err)
+		}
+		builder.tracer = tracer
+	}
+
+	return builder, nil
+}
+
+// build returns the middleware of the provider with the given configured
+// name
+func (b *middlewareBuilder) build(name string) ([]core.Middleware, error) {
+	middleware := make([]core.Middleware, 0, len(b.config.Middleware))
+
+	for _, config := range b.config.Middleware {
+		switch config.Type {
+		case "cache":
+			c, err := b.createCache(name)
+			if err != nil {
+				return nil, err
+			}
+			if closer, ok := c.(io.Closer); ok {
+				b.registry.AddCloser(closer)
+			}
+			middleware = append(middleware, cache.Middleware(c))
+		case "tracing":
+			middleware = append(middleware, tracing.Middleware(b.tracer))
+		case "retry":
+			var params retryConfig
+			if err := decodeProviderConfig(config.Parameters, &params); err != nil {
+				return nil, err
+			}
+
+			var opts []retry.RetryOption
+			if params.MaxAttempts > 0 {
+				opts = append(opts, retry.WithMaxAttempts(params.MaxAttempts))
+			}
+			if params.BaseDelayMs > 0 {
+				opts = append(opts, retry.WithBaseDelay(time.Duration(params.BaseDelayMs)*time.Millisecond))
+			}
+			if params.MaxDelayMs > 0 {
+				opts = append(opts, retry.WithMaxDelay(time.Duration(params.MaxDelayMs)*time.Millisecond))
+			}
+			middleware = append(middleware, retry.Middleware(opts...))
+		case "circuit_breaker":
+			var params circuitBreakerConfig
+			if err := decodeProviderConfig(config.Parameters, &params); err != nil {
+				return nil, err
+			}
+
+			var opts []circuitbreaker.CircuitBreakerOption
+			if params.FailureRatio > 0 {
+				opts = append(opts, circuitbreaker.WithFailureRatio(params.FailureRatio))
+			}
+			if params.WindowSeconds > 0 {
+				opts = append(opts, circuitbreaker.WithWindow(time.Duration(params.WindowSeconds)*time.Second))
+			}
+			if params.MinRequests > 0 {
+				opts = append(opts, circuitbreaker.WithMinRequests(params.MinRequests))
+			}
+			if params.OpenTimeoutSeconds > 0 {
+				opts = append(opts, circuitbreaker.WithOpenTimeout(time.Duration(params.OpenTimeoutSeconds)*time.Second))
+			}
+			if params.HalfOpenRequests > 0 {
+				opts = append(opts, circuitbreaker.WithHalfOpenRequests(params.HalfOpenRequests))
+			}
+			if b.tracer != nil {
+				opts = append(opts, circuitbreaker.WithTracer(b.tracer))
+			}
+			middleware = append(middleware, circuitbreaker.Middleware(opts...))
+		default:
+			return nil, fmt.Errorf("unknown middleware type %s", config.Type)
+		}
+	}
+
+	return middleware, nil
+}
+
+// createCache creates the cache of a provider from the cache configuration.
+// Persistent caches are stored in a subdirectory named after the provider.
+func (b *middlewareBuilder) createCache(name string) (cache.Cache, error) {
+	config := b.config.Cache
+	ttl := time.Duration(config.TTL) * time.Second
+
+	switch config.Type {
+	case "", "memory":
+		var params memoryCacheConfig
+		if err := decodeProviderConfig(config.Parameters, &params); err != nil {
+			return nil, err
+		}
+
+		var opts []cache.MemoryCacheOption
+		if ttl > 0 {
+			opts = append(opts, cache.WithTTL(ttl))
+		}
+		if config.MaxEntries > 0 {
+			opts = append(opts, cache.WithMaxEntries(config.MaxEntries))
+		}
+		if params.MaxBytes > 0 {
+			opts = append(opts, cache.WithMaxBytes(params.MaxBytes))
+		}
+		if params.Shards > 0 {
+			opts = append(opts, cache.WithShards(params.Shards))
+		}
+		if params.CleanupIntervalSeconds > 0 {
+			opts = append(opts, cache.WithCleanupInterval(time.Duration(params.CleanupIntervalSeconds)*time.Second))
+		}
+		switch params.Eviction {
+		case "", "lru":
+		case "lfu":
+			opts = append(opts, cache.WithEvictionPolicy(cache.LFU))
+		case "tinylfu":
+			opts = append(opts, cache.WithEvictionPolicy(cache.TinyLFU))
+		default:
+			return nil, fmt.Errorf("unknown cache eviction policy %s", params.Eviction)
+		}
+		return cache.NewMemoryCache(opts...), nil
+	case "persistent":
+		directory, _ := config.Parameters["directory"].(string)
+		if directory == "" {
+			directory = filepath.Join(os.TempDir(), "gollem-cache")
+		}
+
+		opts := []cache.PersistentCacheOption{cache.WithDirectory(filepath.Join(directory, name))}
+		if ttl > 0 {
+			opts = append(opts, cache.WithPersistentTTL(ttl))
+		}
+		if config.MaxEntries > 0 {
+			opts = append(opts, cache.WithPersistentMaxEntries(config.MaxEntries))
+		}
+
+		c, err := cache.NewPersistentCache(opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cache: %w", err)
+		}
+		return c, nil
+	default:
+		return nil, fmt.Errorf("unknown cache type %s", config.Type)
+	}
+}
+
+// CreateUsageTracker creates a usage tracker with the configured prices and
+// budgets. Pass usage.Middleware(tracker) to CreateRegistry to record the
+// usage of every configured provider.
+func (c *Config) CreateUsageTracker() (*usage.Tracker, error) {
+	prices := make(usage.PriceTable, len(c.Usage.Prices))
+	for name, price := range c.Usage.Prices {
+		prices[name] = usage.Price{
+			Prompt:     price.Prompt,
+			Completion: price.Completion,
+			CacheRead:  price.CacheRead,
+			CacheWrite: price.CacheWrite,
+		}
+	}
+	opts := []usage.TrackerOption{usage.WithPrices(prices)}
+
+	for _, budget := range c.Usage.Budgets {
+		var period usage.Period
+		switch budget.Period {
+		case "":
+			period = usage.PeriodTotal
+		case "hour":
+			period = usage.PeriodHour
+		case "day":
+			period = usage.PeriodDay
+		case "month":
+			period = usage.PeriodMonth
+		default:
+			return nil, fmt.Errorf("unknown budget period %s", budget.Period)
+		}
+
+		opts = append(opts, usage.WithBudget(usage.Budget{
+			Provider:  budget.Provider,
+			Model:     budget.Model,
+			Tag:       budget.Tag,
+			PerTag:    budget.PerTag,
+			Period:    period,
+			MaxCost:   budget.MaxCost,
+			MaxTokens: budget.MaxTokens,
+		}))
+	}
+
+	return usage.NewTracker(opts...), nil
+}
@@ -0,0 +1,362 @@
+package core
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+// ModelCapabilities describes what a model supports. Limits of 0 are unknown.
+type ModelCapabilities struct {
+	// ContextWindow is the maximum number of tokens of the prompt and the
+	// completion
+	ContextWindow int `json:"context_window,omitempty"`
+
+	// MaxOutputTokens is the maximum number of tokens of the completion
+	MaxOutputTokens int `json:"max_output_tokens,omitempty"`
+
+	// Tools indicates whether the model supports tool calling
+	Tools bool `json:"tools"`
+
+	// Vision indicates whether the model accepts images
+	Vision bool `json:"vision"`
+
+	// Audio indicates whether the model accepts audio
+	Audio bool `json:"audio"`
+
+	// Files indicates whether the model accepts documents such as PDFs
+	Files bool `json:"files"`
+
+	// JSONMode indicates whether the output can be constrained to JSON
+	JSONMode bool `json:"json_mode"`
+
+	// JSONSchema indicates whether the output can be constrained to a JSON
+	// schema
+	JSONSchema bool `json:"json_schema"`
+
+	// Streaming indicates whether responses can be streamed
+	Streaming bool `json:"streaming"`
+
+	// EmbeddingDimensions is the number of dimensions of the embeddings of
+	// embedding models
+	EmbeddingDimensions int `json:"embedding_dimensions,omitempty"`
+
+	// Version is the version of the model, if known
+	Version string `json:"version,omitempty"`
+}
+
+// Check returns an UnsupportedError if the prompt needs a capability the model
+// lacks. The length of the prompt is not checked, and schemas are not since
+// providers fall back to instructing the model.
+func (c ModelCapabilities) Check(provider string, prompt *Prompt) error {
+	if len(prompt.Tools) > 0 && !c.Tools {
+		return NewUnsupportedError(provider, "tools")
+	}
+
+	for _, msg := range prompt.Conversation() {
+		for _, part := range msg.Parts {
+			switch {
+			case part.Type == ContentImage && !c.Vision:
+				return NewUnsupportedError(provider, "image input")
+			case part.Type == ContentAudio && !c.Audio:
+				return NewUnsupportedError(provider, "audio input")
+			case part.Type == ContentFile && !c.Files:
+				return NewUnsupportedError(provider, "file input")
+			}
+		}
+	}
+	return nil
+}
+
+// CapabilityProvider is implemented by providers and middleware that know the
+// capabilities of their model
+type CapabilityProvider interface {
+	// Capabilities returns the capabilities of the model, and false if they
+	// are unknown
+	Capabilities() (ModelCapabilities, bool)
+}
+
+// CapabilitiesOf returns the capabilities of the model of a provider, and
+// false if the provider does not know them
+func CapabilitiesOf(provider LLMProvider) (ModelCapabilities, bool) {
+	if p, ok := provider.(CapabilityProvider); ok {
+		return p.Capabilities()
+	}
+	return ModelCapabilities{}, false
+}
+
+// ModelProvider is implemented by providers and middleware that know the name
+// of their model
+type ModelProvider interface {
+	// Model returns the name of the model
+	Model() string
+}
+
+// ModelOf returns the name of the model of a provider, or an empty string if
+// the provider does not know it
+func ModelOf(provider LLMProvider) string {
+	if p, ok := provider.(ModelProvider); ok {
+		return p.Model()
+	}
+	return ""
+}
+
+// ModelCatalog maps models to their capabilities
+type ModelCatalog struct {
+	models map[string]map[string]ModelCapabilities
+	mu     sync.RWMutex
+}
+
+// NewModelCatalog creates a new empty catalog
+func NewModelCatalog() *ModelCatalog {
+	return &ModelCatalog{
+		models: make(map[string]map[string]ModelCapabilities),
+	}
+}
+
+// Register sets the capabilities of a model of a provider. Models registered
+// without a provider are used for any provider.
+func (c *ModelCatalog) Register(provider, model string, capabilities ModelCapabilities) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.models[provider] == nil {
+		c.models[provider] = make(map[string]ModelCapabilities)
+	}
+	c.models[provider][model] = capabilities
+}
+
+// Override updates the capabilities of a model with the fields set in the
+// given JSON object, keeping the others. Models not in the catalog start
+// from the capabilities they would be looked up with.
+func (c *ModelCatalog) Override(provider, model string, data json.RawMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	capabilities, _ := c.lookup(provider, model)
+	if err := json.Unmarshal(data, &capabilities); err != nil {
+		return err
+	}
+	if c.models[provider] == nil {
+		c.models[provider] = make(map[string]ModelCapabilities)
+	}
+	c.models[provider][model] = capabilities
+	return nil
+}
+
+// Clone returns a copy of the catalog, which can be changed without
+// changing the catalog
+func (c *ModelCatalog) Clone() *ModelCatalog {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	clone := NewModelCatalog()
+	for provider, models := range c.models {
+		clone.models[provider] = make(map[string]ModelCapabilities, len(models))
+		for model, capabilities := range models {
+			clone.models[provider][model] = capabilities
+		}
+	}
+	return clone
+}
+
+// Lookup returns the capabilities of a model. Models are matched exactly or
+// by their longest registered prefix followed by '-', '@' or ':', so that
+// dated snapshots such as "gpt-4o-2024-08-06" match "gpt-4o". The suffix is
+// then the version of the model unless the entry has one. Models of the
+// provider are looked up before the models registered without a provider.
+func (c *ModelCatalog) Lookup(provider, model string) (ModelCapabilities, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.lookup(provider, model)
+}
+
+// Version returns the version of a model, or an empty string if it is
+// unknown
+func (c *ModelCatalog) Version(provider, model string) string {
+	capabilities, _ := c.Lookup(provider, model)
+	return capabilities.Version
+}
+
+// lookup looks up a model. The caller must hold the lock.
+func (c *ModelCatalog) lookup(provider, model string) (ModelCapabilities, bool) {
+	for _, p := range []string{provider, ""} {
+		if capabilities, ok := lookupModel(c.models[p], model); ok {
+			return capabilities, true
+		}
+		if provider == "" {
+			break
+		}
+	}
+	return ModelCapabilities{}, false
+}
+
+// lookupModel matches a model against the entries of a provider
+func lookupModel(models map[string]ModelCapabilities, model string) (ModelCapabilities, bool) {
+	if capabilities, ok := models[model]; ok {
+		return capabilities, true
+	}
+
+	best := ""
+	for name := range models {
+		if len(name) <= len(best) || len(name) >= len(model) || !strings.HasPrefix(model, name) {
+			continue
+		}
+		if strings.IndexByte("-@:", model[len(name)]) >= 0 {
+			best = name
+		}
+	}
+	if best == "" {
+		return ModelCapabilities{}, false
+	}
+
+	capabilities := models[best]
+	if capabilities.Version == "" {
+		capabilities.Version = model[len(best)+1:]
+	}
+	return capabilities, true
+}
+
+// defaultCatalog is the catalog of the models known to the library
+var defaultCatalog = newDefaultCatalog()
+
+// DefaultCatalog returns the catalog used by the built-in providers. It
+// contains the known OpenAI, Anthropic, Google and Mistral models, and can be
+// extended or overridden with Register and Override.
+func DefaultCatalog() *ModelCatalog {
+	return defaultCatalog
+}
+
+// LookupModel returns the capabilities of a model in the default catalog
+func LookupModel(provider, model string) (ModelCapabilities, bool) {
+	return defaultCatalog.Lookup(provider, model)
+}
+
+// newDefaultCatalog creates the catalog of the built-in models
+func newDefaultCatalog() *ModelCatalog {
+	catalog := NewModelCatalog()
+
+	// chat returns the capabilities of a chat model
+	chat := func(contextWindow, maxOutputTokens int, tools, vision, jsonMode, jsonSchema bool) ModelCapabilities {
+		return ModelCapabilities{
+			ContextWindow:   contextWindow,
+			MaxOutputTokens: maxOutputTokens,
+			Tools:           tools,
+			Vision:          vision,
+			JSONMode:        jsonMode,
+			JSONSchema:      jsonSchema,
+			Streaming:       true,
+		}
+	}
+
+	// embedding returns the capabilities of an embedding model
+	embedding := func(contextWindow, dimensions int) ModelCapabilities {
+		return ModelCapabilities{
+			ContextWindow:       contextWindow,
+			EmbeddingDimensions: dimensions,
+		}
+	}
+
+	// OpenAI
+	gpt4o := chat(128000, 16384, true, true, true, true)
+	gpt4o.Files = true
+	gpt41 := chat(1047576, 32768, true, true, true, true)
+	gpt41.Files = true
+	audio := chat(128000, 16384, true, false, true, false)
+	audio.Audio = true
+	reasoning := chat(200000, 100000, true, true, true, true)
+	reasoning.Files = true
+	for model, capabilities := range map[string]ModelCapabilities{
+		"gpt-4o":                 gpt4o,
+		"gpt-4o-mini":            gpt4o,
+		"gpt-4o-audio-preview":   audio,
+		"gpt-4.1":                gpt41,
+		"gpt-4.1-mini":           gpt41,
+		"gpt-4.1-nano":           gpt41,
+		"gpt-4-turbo":            chat(128000, 4096, true, true, true, false),
+		"gpt-4":                  chat(8192, 8192, true, false, false, false),
+		"gpt-4-32k":              chat(32768, 32768, true, false, false, false),
+		"gpt-3.5-turbo":          chat(16385, 4096, true, false, true, false),
+		"o1":                     reasoning,
+		"o1-mini":                chat(128000, 65536, false, false, false, false),
+		"o3":                     reasoning,
+		"o3-mini":                chat(200000, 100000, true, false, true, true),
+		"o4-mini":                reasoning,
+		"text-embedding-3-small": embedding(8191, 1536),
+		"text-embedding-3-large": embedding(8191, 3072),
+		"text-embedding-ada-002": embedding(8191, 1536),
+	} {
+		catalog.Register("openai", model, capabilities)
+	}
+
+	// Anthropic
+	claude := func(maxOutputTokens int) ModelCapabilities {
+		capabilities := chat(200000, maxOutputTokens, true, true, false, false)
+		capabilities.Files = true
+		return capabilities
+	}
+	for model, capabilities := range map[string]ModelCapabilities{
+		"claude-3-haiku":    claude(4096),
+		"claude-3-sonnet":   claude(4096),
+		"claude-3-opus":     claude(4096),
+		"claude-3-5-haiku":  claude(8192),
+		"claude-3-5-sonnet": claude(8192),
+		"claude-3-7-sonnet": claude(64000),
+		"claude-sonnet-4":   claude(64000),
+		"claude-sonnet-4-5": claude(64000),
+		"claude-haiku-4-5":  claude(64000),
+		"claude-opus-4":     claude(32000),
+		"claude-opus-4-1":   claude(32000),
+	} {
+		catalog.Register("anthropic", model, capabilities)
+	}
+
+	// Google
+	gemini := func(contextWindow, maxOutputTokens int) ModelCapabilities {
+		capabilities := chat(contextWindow, maxOutputTokens, true, true, true, true)
+		capabilities.Audio = true
+		capabilities.Files = true
+		return capabilities
+	}
+	for model, capabilities := range map[string]ModelCapabilities{
+		"gemini-pro":            chat(32760, 8192, true, false, false, false),
+		"gemini-1.0-pro":        chat(32760, 8192, true, false, false, false),
+		"gemini-1.5-pro":        gemini(2097152, 8192),
+		"gemini-1.5-flash":      gemini(1048576, 8192),
+		"gemini-1.5-flash-8b":   gemini(1048576, 8192),
+		"gemini-2.0-flash":      gemini(1048576, 8192),
+		"gemini-2.0-flash-lite": gemini(1048576, 8192),
+		"gemini-2.5-pro":        gemini(1048576, 65536),
+		"gemini-2.5-flash":      gemini(1048576, 65536),
+		"text-embedding-004":    embedding(2048, 768),
+	} {
+		catalog.Register("google", model, capabilities)
+	}
+
+	// Mistral
+	pixtral := chat(131072, 0, true, true, true, true)
+	for model, capabilities := range map[string]ModelCapabilities{
+		"mistral-large":     chat(131072, 0, true, false, true, true),
+		"mistral-medium":    chat(131072, 0, true, true, true, true),
+		"mistral-small":     chat(32768, 0, true, false, true, true),
+		"mistral-tiny":      chat(32768, 0, false, false, true, false),
+		"open-mistral-nemo": chat(131072, 0, true, false, true, true),
+		"ministral-3b":      chat(131072, 0, true, false, true, true),
+		"ministral-8b":      chat(131072, 0, true, false, true, true),
+		"codestral":         chat(262144, 0, true, false, true, true),
+		"pixtral-12b":       pixtral,
+		"pixtral-large":     pixtral,
+		"mistral-embed":     embedding(8192, 1024),
+	} {
+		catalog.Register("mistral", model, capabilities)
+	}
+
+	return catalog
+}
+
+// ModelVersion returns the version of a model in the default catalog, or an
+// empty string if it is unknown
+func ModelVersion(provider, model string) string {
+	return defaultCatalog.Version(provider, model)
+}
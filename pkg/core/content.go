@@ -0,0 +1,107 @@
+package core
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// ContentType identifies the kind of a content part
+type ContentType string
+
+const (
+	// ContentText is a text part
+	ContentText ContentType = "text"
+
+	// ContentImage is an image, such as a PNG or JPEG image
+	ContentImage ContentType = "image"
+
+	// ContentAudio is an audio clip, such as a WAV or MP3 file
+	ContentAudio ContentType = "audio"
+
+	// ContentFile is a document, such as a PDF file
+	ContentFile ContentType = "file"
+)
+
+// ContentPart is a part of a multimodal message: text, or media given
+// either inline or by URL
+type ContentPart struct {
+	// Type is the kind of content
+	Type ContentType
+
+	// Text is the text of a text part
+	Text string
+
+	// Data is the content of inline media
+	Data []byte
+
+	// URL is the location of remote media, such as an HTTPS URL or the URI
+	// of a file uploaded to the provider, used if Data is empty
+	URL string
+
+	// MIMEType is the media type, such as "image/png" or "application/pdf".
+	// It is required for inline media.
+	MIMEType string
+
+	// Filename is the name of a file, sent to the providers that use it
+	// (optional)
+	Filename string
+}
+
+// TextPart creates a text part
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: ContentText, Text: text}
+}
+
+// ImagePart creates an inline image part
+func ImagePart(data []byte, mimeType string) ContentPart {
+	return ContentPart{Type: ContentImage, Data: data, MIMEType: mimeType}
+}
+
+// ImageURLPart creates an image part from a URL
+func ImageURLPart(url string) ContentPart {
+	return ContentPart{Type: ContentImage, URL: url}
+}
+
+// AudioPart creates an inline audio part
+func AudioPart(data []byte, mimeType string) ContentPart {
+	return ContentPart{Type: ContentAudio, Data: data, MIMEType: mimeType}
+}
+
+// FilePart creates an inline file part
+func FilePart(data []byte, mimeType, filename string) ContentPart {
+	return ContentPart{Type: ContentFile, Data: data, MIMEType: mimeType, Filename: filename}
+}
+
+// FileURLPart creates a file part from a URL
+func FileURLPart(url, mimeType string) ContentPart {
+	return ContentPart{Type: ContentFile, URL: url, MIMEType: mimeType}
+}
+
+// IsInline reports whether the media of the part is given inline
+func (p ContentPart) IsInline() bool {
+	return len(p.Data) > 0
+}
+
+// Base64 returns the inline media encoded in base64
+func (p ContentPart) Base64() string {
+	return base64.StdEncoding.EncodeToString(p.Data)
+}
+
+// DataURL returns the URL of the media: the URL of remote media, or a data
+// URL with the inline media
+func (p ContentPart) DataURL() string {
+	if !p.IsInline() {
+		return p.URL
+	}
+	return fmt.Sprintf("data:%s;base64,%s", p.MIMEType, p.Base64())
+}
+
+// MessageParts returns the content of a message as parts: its text content,
+// if any, followed by its parts
+func MessageParts(msg Message) []ContentPart {
+	parts := make([]ContentPart, 0, len(msg.Parts)+1)
+	if msg.Content != "" {
+		parts = append(parts, TextPart(msg.Content))
+	}
+	return append(parts, msg.Parts...)
+}
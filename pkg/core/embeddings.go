@@ -0,0 +1,103 @@
+package core
+
+import (
+	"context"
+	"fmt"
+)
+
+// EmbeddingProvider is implemented by providers that can generate embeddings
+type EmbeddingProvider interface {
+	// Name returns the name of the provider
+	Name() string
+
+	// Embed generates the embeddings of the texts of the request
+	Embed(ctx context.Context, request *EmbeddingRequest) (*EmbeddingResponse, error)
+}
+
+// EmbeddingInputType describes what the embedded texts are used for, which
+// some models use to optimize the embeddings for retrieval
+type EmbeddingInputType string
+
+const (
+	// EmbeddingInputDefault leaves the input type unspecified
+	EmbeddingInputDefault EmbeddingInputType = ""
+
+	// EmbeddingInputQuery is used for search queries
+	EmbeddingInputQuery EmbeddingInputType = "query"
+
+	// EmbeddingInputDocument is used for the documents searched
+	EmbeddingInputDocument EmbeddingInputType = "document"
+)
+
+// EmbeddingRequest represents a request for embeddings
+type EmbeddingRequest struct {
+	// Texts are the texts to embed
+	Texts []string
+
+	// Model is the embedding model, overriding the configured one (optional)
+	Model string
+
+	// Dimensions is the number of dimensions of the embeddings, for models
+	// that support shortening them (optional, defaults to the model's)
+	Dimensions int
+
+	// InputType hints what the texts are used for. It is ignored by
+	// providers that do not support it.
+	InputType EmbeddingInputType
+}
+
+// EmbeddingResponse represents the embeddings generated for a request
+type EmbeddingResponse struct {
+	// Embeddings are the embeddings of the texts, in the same order
+	Embeddings [][]float32
+
+	// Model is the model that generated the embeddings
+	Model string
+
+	// TokensUsed contains the token usage, if reported by the provider
+	TokensUsed *TokenUsage
+}
+
+// EmbedBatches splits a request into batches of at most batchSize texts,
+// the limit of the provider, and embeds them in order with embed. The
+// embeddings and usage of the batches are combined into a single response.
+// A request without texts gets an empty response without calling embed.
+func EmbedBatches(ctx context.Context, request *EmbeddingRequest, batchSize int,
+	embed func(ctx context.Context, request *EmbeddingRequest) (*EmbeddingResponse, error)) (*EmbeddingResponse, error) {
+	if len(request.Texts) == 0 {
+		return &EmbeddingResponse{Model: request.Model}, nil
+	}
+	if len(request.Texts) <= batchSize || batchSize <= 0 {
+		return embed(ctx, request)
+	}
+
+	response := &EmbeddingResponse{
+		Embeddings: make([][]float32, 0, len(request.Texts)),
+	}
+	for start := 0; start < len(request.Texts); start += batchSize {
+		end := start + batchSize
+		if end > len(request.Texts) {
+			end = len(request.Texts)
+		}
+
+		batch := *request
+		batch.Texts = request.Texts[start:end]
+		batchResponse, err := embed(ctx, &batch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed texts %d to %d: %w", start, end-1, err)
+		}
+
+		response.Embeddings = append(response.Embeddings, batchResponse.Embeddings...)
+		response.Model = batchResponse.Model
+		if batchResponse.TokensUsed != nil {
+			if response.TokensUsed == nil {
+				response.TokensUsed = &TokenUsage{}
+			}
+			response.TokensUsed.Prompt += batchResponse.TokensUsed.Prompt
+			response.TokensUsed.Completion += batchResponse.TokensUsed.Completion
+			response.TokensUsed.Total += batchResponse.TokensUsed.Total
+		}
+	}
+
+	return response, nil
+}
@@ -0,0 +1,375 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorCategory classifies provider failures
+type ErrorCategory int
+
+const (
+	// ErrorCategoryUnknown is used for failures that could not be classified
+	ErrorCategoryUnknown ErrorCategory = iota
+
+	// ErrorCategoryRateLimit indicates that the request was rate limited
+	ErrorCategoryRateLimit
+
+	// ErrorCategoryQuotaExceeded indicates that the account has run out of
+	// quota or credits
+	ErrorCategoryQuotaExceeded
+
+	// ErrorCategoryAuthentication indicates an invalid or unauthorized API key
+	ErrorCategoryAuthentication
+
+	// ErrorCategoryInvalidRequest indicates a malformed request, such as an
+	// unknown model or an invalid parameter
+	ErrorCategoryInvalidRequest
+
+	// ErrorCategoryContextLength indicates that the prompt does not fit in
+	// the context window of the model
+	ErrorCategoryContextLength
+
+	// ErrorCategoryContentFilter indicates that the request or response was
+	// refused by a content filter
+	ErrorCategoryContentFilter
+
+	// ErrorCategoryServer indicates a server error or an overloaded service
+	ErrorCategoryServer
+
+	// ErrorCategoryTimeout indicates that the request timed out
+	ErrorCategoryTimeout
+
+	// ErrorCategoryNetwork indicates that the request could not be sent or
+	// the connection was lost
+	ErrorCategoryNetwork
+)
+
+// String returns the name of the category
+func (c ErrorCategory) String() string {
+	switch c {
+	case ErrorCategoryUnknown:
+		return "unknown"
+	case ErrorCategoryRateLimit:
+		return "rate_limit"
+	case ErrorCategoryQuotaExceeded:
+		return "quota_exceeded"
+	case ErrorCategoryAuthentication:
+		return "authentication"
+	case ErrorCategoryInvalidRequest:
+		return "invalid_request"
+	case ErrorCategoryContextLength:
+		return "context_length"
+	case ErrorCategoryContentFilter:
+		return "content_filter"
+	case ErrorCategoryServer:
+		return "server"
+	case ErrorCategoryTimeout:
+		return "timeout"
+	case ErrorCategoryNetwork:
+		return "network"
+	default:
+		return fmt.Sprintf("ErrorCategory(%d)", int(c))
+	}
+}
+
+// ProviderError is returned by providers when a request fails
+type ProviderError struct {
+	// Provider is the name of the provider that returned the error
+	Provider string
+
+	// Category is the kind of failure
+	Category ErrorCategory
+
+	// StatusCode is the HTTP status code, or 0 if no response was received
+	StatusCode int
+
+	// Message is the error message reported by the provider
+	Message string
+
+	// RetryAfter is the delay requested by the provider before retrying, or
+	// 0 if none was given
+	RetryAfter time.Duration
+
+	// Body is the raw response body
+	Body string
+
+	// Err is the underlying error, if any
+	Err error
+}
+
+// Error returns the error message
+func (e *ProviderError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Provider)
+	b.WriteString(": ")
+	b.WriteString(e.Category.String())
+	b.WriteString(" error")
+	if e.StatusCode != 0 {
+		fmt.Fprintf(&b, " (status %d)", e.StatusCode)
+	}
+	if e.Message != "" {
+		b.WriteString(": ")
+		b.WriteString(e.Message)
+	} else if e.Err != nil {
+		b.WriteString(": ")
+		b.WriteString(e.Err.Error())
+	}
+	return b.String()
+}
+
+// Unwrap returns the underlying error
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the request may succeed if retried
+func (e *ProviderError) Retryable() bool {
+	switch e.Category {
+	case ErrorCategoryRateLimit, ErrorCategoryServer, ErrorCategoryTimeout:
+		return true
+	case ErrorCategoryNetwork:
+		// A canceled request must not be retried
+		return !errors.Is(e.Err, context.Canceled)
+	default:
+		return false
+	}
+}
+
+// IsRetryable reports whether an error is a provider error that may succeed
+// if retried
+func IsRetryable(err error) bool {
+	var providerErr *ProviderError
+	return errors.As(err, &providerErr) && providerErr.Retryable()
+}
+
+// ErrUnsupported is matched by errors.Is for the errors of prompts that use
+// a capability the provider does not support
+var ErrUnsupported = errors.New("unsupported capability")
+
+// UnsupportedError is returned by providers, before sending a request, when
+// a prompt uses a capability they do not support, such as audio input
+type UnsupportedError struct {
+	// Provider is the name of the provider
+	Provider string
+
+	// Capability describes the unsupported capability, such as "audio
+	// input" or "image URLs"
+	Capability string
+}
+
+// Error returns the error message
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s: %s is not supported", e.Provider, e.Capability)
+}
+
+// Is reports whether the target is ErrUnsupported
+func (e *UnsupportedError) Is(target error) bool {
+	return target == ErrUnsupported
+}
+
+// NewUnsupportedError creates an error for an unsupported capability
+func NewUnsupportedError(provider, capability string) *UnsupportedError {
+	return &UnsupportedError{
+		Provider:   provider,
+		Capability: capability,
+	}
+}
+
+// NewProviderError creates an error from an unsuccessful HTTP response and
+// its body, classifying it from the status code and the error reported by
+// the provider
+func NewProviderError(provider string, resp *http.Response, body []byte) *ProviderError {
+	message, code := parseErrorBody(body)
+	if message == "" {
+		message = strings.TrimSpace(string(body))
+	}
+
+	return &ProviderError{
+		Provider:   provider,
+		Category:   classifyError(resp.StatusCode, code+" "+message),
+		StatusCode: resp.StatusCode,
+		Message:    message,
+		RetryAfter: parseRetryAfter(resp.Header, body),
+		Body:       string(body),
+	}
+}
+
+// NewNetworkError creates an error for a request that could not be completed
+func NewNetworkError(provider string, err error) *ProviderError {
+	category := ErrorCategoryNetwork
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		category = ErrorCategoryTimeout
+	}
+
+	return &ProviderError{
+		Provider: provider,
+		Category: category,
+		Err:      err,
+	}
+}
+
+// NewStreamError creates an error from an error event received in the middle
+// of a streaming response, after the HTTP status has been sent
+func NewStreamError(provider string, body []byte) *ProviderError {
+	message, code := parseErrorBody(body)
+	if message == "" {
+		message = strings.TrimSpace(string(body))
+	}
+
+	// Without a status code, server errors are only recognizable by their type
+	category := classifyError(0, code+" "+message)
+	if category == ErrorCategoryUnknown {
+		switch details := strings.ToLower(code); {
+		case strings.Contains(details, "api_error"), strings.Contains(details, "server_error"),
+			strings.Contains(details, "internal"), strings.Contains(details, "unavailable"):
+			category = ErrorCategoryServer
+		case strings.Contains(details, "invalid_request"), strings.Contains(details, "invalid_argument"):
+			category = ErrorCategoryInvalidRequest
+		}
+	}
+
+	return &ProviderError{
+		Provider: provider,
+		Category: category,
+		Message:  message,
+		Body:     string(body),
+	}
+}
+
+// parseErrorBody extracts the error message and code from the common error
+// body formats, such as {"error": {"message": ..., "type": ...}}
+func parseErrorBody(body []byte) (string, string) {
+	var parsed struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+		Type    string          `json:"type"`
+		Code    interface{}     `json:"code"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return "", ""
+	}
+
+	message := parsed.Message
+	code := strings.TrimSpace(fmt.Sprint(parsed.Type, " ", stringCode(parsed.Code)))
+
+	// The error is either a string or an object
+	var errString string
+	if err := json.Unmarshal(parsed.Error, &errString); err == nil {
+		return errString, code
+	}
+
+	var errObject struct {
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Code    interface{} `json:"code"`
+		Status  string      `json:"status"`
+	}
+	if err := json.Unmarshal(parsed.Error, &errObject); err == nil {
+		if errObject.Message != "" {
+			message = errObject.Message
+		}
+		code = strings.Join([]string{code, errObject.Type, stringCode(errObject.Code), errObject.Status}, " ")
+	}
+
+	return message, code
+}
+
+// stringCode converts an error code, which may be a string or a number, to
+// a string
+func stringCode(code interface{}) string {
+	if s, ok := code.(string); ok {
+		return s
+	}
+	return ""
+}
+
+// classifyError determines the category of an error from its status code
+// and the error codes and message reported by the provider
+func classifyError(status int, details string) ErrorCategory {
+	details = strings.ToLower(details)
+	containsAny := func(keywords ...string) bool {
+		for _, keyword := range keywords {
+			if strings.Contains(details, keyword) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// The details are more specific than the status code
+	switch {
+	case containsAny("context_length_exceeded", "maximum context length", "context window",
+		"prompt is too long", "too many tokens", "input token count"):
+		return ErrorCategoryContextLength
+	case containsAny("content_filter", "content_policy", "content management policy", "responsibleai"):
+		return ErrorCategoryContentFilter
+	case containsAny("insufficient_quota", "billing", "credit balance"):
+		return ErrorCategoryQuotaExceeded
+	case containsAny("rate_limit", "resource_exhausted"):
+		return ErrorCategoryRateLimit
+	case containsAny("overloaded"):
+		return ErrorCategoryServer
+	}
+
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrorCategoryAuthentication
+	case status == http.StatusTooManyRequests:
+		return ErrorCategoryRateLimit
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		return ErrorCategoryTimeout
+	case status >= 500:
+		return ErrorCategoryServer
+	case status >= 400:
+		return ErrorCategoryInvalidRequest
+	default:
+		return ErrorCategoryUnknown
+	}
+}
+
+// parseRetryAfter reads the delay requested by the provider from the
+// Retry-After headers or, for Google, the retry info of the error details
+func parseRetryAfter(header http.Header, body []byte) time.Duration {
+	if ms := header.Get("Retry-After-Ms"); ms != "" {
+		if v, err := strconv.ParseFloat(ms, 64); err == nil && v > 0 {
+			return time.Duration(v * float64(time.Millisecond))
+		}
+	}
+
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			if delay := time.Until(date); delay > 0 {
+				return delay
+			}
+		}
+	}
+
+	var parsed struct {
+		Error struct {
+			Details []struct {
+				RetryDelay string `json:"retryDelay"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &parsed); err == nil {
+		for _, detail := range parsed.Error.Details {
+			if delay, err := time.ParseDuration(detail.RetryDelay); err == nil && delay > 0 {
+				return delay
+			}
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,16 @@
+package core
+
+// Middleware wraps a provider to add functionality such as caching, tracing
+// or retries. Middleware should keep the name of the provider they wrap, so
+// that wrapped providers can be registered and looked up under their usual
+// name.
+type Middleware func(provider LLMProvider) LLMProvider
+
+// Chain wraps a provider with middleware. The first middleware is the
+// outermost one: it sees each request first and each response last.
+func Chain(provider LLMProvider, middleware ...Middleware) LLMProvider {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		provider = middleware[i](provider)
+	}
+	return provider
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	internalParamsMu sync.RWMutex
+	internalParams   = make(map[string]bool)
+)
+
+// RegisterInternalParam registers a key of Prompt.AdditionalParams that is
+// used by gollem itself, such as routing tags, so that it is not sent to
+// providers
+func RegisterInternalParam(key string) {
+	internalParamsMu.Lock()
+	defer internalParamsMu.Unlock()
+	internalParams[key] = true
+}
+
+// isInternalParam returns whether a key is used by gollem itself
+func isInternalParam(key string) bool {
+	internalParamsMu.RLock()
+	defer internalParamsMu.RUnlock()
+	return internalParams[key]
+}
+
+// ApplyParams merges additional parameters into the JSON request body of a
+// provider. Parameters are passed through under their name, with these
+// rules:
+//
+//   - A key prefixed with a provider name and a colon, such as
+//     "google:safetySettings", is only sent to that provider.
+//   - A dotted key, such as "generationConfig.topK", sets a nested field.
+//   - An object value is merged into an existing object field instead of
+//     replacing it.
+//   - Internal keys registered with RegisterInternalParam are skipped.
+//
+// Provider-specific keys are applied after the others, so they take
+// precedence.
+func ApplyParams(provider string, body []byte, params map[string]interface{}) ([]byte, error) {
+	if len(params) == 0 {
+		return body, nil
+	}
+
+	var request map[string]interface{}
+	if err := decodeJSON(body, &request); err != nil {
+		return nil, fmt.Errorf("failed to parse request body: %w", err)
+	}
+
+	// Sort the keys so that overlapping parameters are applied in a stable
+	// order, with provider-specific keys last
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		iScoped, jScoped := strings.Contains(keys[i], ":"), strings.Contains(keys[j], ":")
+		if iScoped != jScoped {
+			return jScoped
+		}
+		return keys[i] < keys[j]
+	})
+
+	for _, key := range keys {
+		name := key
+		if i := strings.Index(key, ":"); i >= 0 {
+			if key[:i] != provider {
+				continue
+			}
+			name = key[i+1:]
+		}
+		if isInternalParam(name) || name == "" {
+			continue
+		}
+
+		// Convert the value to its JSON representation, so that structs can
+		// be merged with objects
+		data, err := json.Marshal(params[key])
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal parameter %s: %w", key, err)
+		}
+		var value interface{}
+		if err := decodeJSON(data, &value); err != nil {
+			return nil, fmt.Errorf("failed to parse parameter %s: %w", key, err)
+		}
+
+		if err := setParam(request, strings.Split(name, "."), value); err != nil {
+			return nil, fmt.Errorf("failed to set parameter %s: %w", key, err)
+		}
+	}
+
+	return json.Marshal(request)
+}
+
+// setParam sets the field at the given path, creating intermediate objects
+func setParam(object map[string]interface{}, path []string, value interface{}) error {
+	for _, field := range path[:len(path)-1] {
+		child, exists := object[field]
+		if !exists || child == nil {
+			child = make(map[string]interface{})
+			object[field] = child
+		}
+		childObject, ok := child.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("field %s is not an object", field)
+		}
+		object = childObject
+	}
+
+	field := path[len(path)-1]
+	object[field] = mergeValues(object[field], value)
+	return nil
+}
+
+// mergeValues merges an object into an existing object recursively. Other
+// values replace the existing value.
+func mergeValues(existing, value interface{}) interface{} {
+	existingObject, ok := existing.(map[string]interface{})
+	if !ok {
+		return value
+	}
+	valueObject, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+
+	for field, v := range valueObject {
+		existingObject[field] = mergeValues(existingObject[field], v)
+	}
+	return existingObject
+}
+
+// decodeJSON decodes JSON keeping numbers as json.Number, so that large
+// integers such as seeds are not rounded
+func decodeJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
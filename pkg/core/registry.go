@@ -2,6 +2,7 @@ package core
 
 import (
 	"fmt"
+	"io"
 	"sync"
 )
 
@@ -9,6 +10,7 @@ import (
 type Registry struct {
 	providers map[string]LLMProvider
 	factories map[string]ProviderFactory
+	closers   []io.Closer
 	mu        sync.RWMutex
 }
 
@@ -30,6 +32,14 @@ func (r *Registry) RegisterProvider(provider LLMProvider) {
 	r.providers[provider.Name()] = provider
 }
 
+// RegisterProviderAs registers a provider with the registry under the given
+// name instead of the name of the provider
+func (r *Registry) RegisterProviderAs(name string, provider LLMProvider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[name] = provider
+}
+
 // GetProvider retrieves a provider from the registry
 func (r *Registry) GetProvider(name string) (LLMProvider, bool) {
 	r.mu.RLock()
@@ -45,8 +55,46 @@ func (r *Registry) RegisterFactory(name string, factory ProviderFactory) {
 	r.factories[name] = factory
 }
 
-// CreateProvider creates a provider using a registered factory
+// CreateProvider creates a provider using a registered factory and
+// registers it
 func (r *Registry) CreateProvider(name string, config map[string]interface{}) (LLMProvider, error) {
+	provider, err := r.NewProvider(name, config)
+	if err != nil {
+		return nil, err
+	}
+	
+	r.RegisterProvider(provider)
+	return provider, nil
+}
+
+// AddCloser adds a resource, such as the cache of a provider, that is closed
+// when the registry is closed
+func (r *Registry) AddCloser(closer io.Closer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closers = append(r.closers, closer)
+}
+
+// Close closes the resources added to the registry, in reverse order, and
+// returns the first error
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	closers := r.closers
+	r.closers = nil
+	r.mu.Unlock()
+	
+	var firstErr error
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// NewProvider creates a provider using a registered factory without
+// registering it
+func (r *Registry) NewProvider(name string, config map[string]interface{}) (LLMProvider, error) {
 	r.mu.RLock()
 	factory, exists := r.factories[name]
 	r.mu.RUnlock()
@@ -55,11 +103,5 @@ func (r *Registry) CreateProvider(name string, config map[string]interface{}) (L
 		return nil, fmt.Errorf("no factory registered for provider: %s", name)
 	}
 	
-	provider, err := factory(config)
-	if err != nil {
-		return nil, err
-	}
-	
-	r.RegisterProvider(provider)
-	return provider, nil
+	return factory(config)
 }
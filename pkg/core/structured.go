@@ -0,0 +1,115 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/validation"
+)
+
+// StructuredOutputError is returned when a response does not contain valid
+// structured output. Providers return it together with the response, so
+// that the raw text can still be inspected.
+type StructuredOutputError struct {
+	// Provider is the name of the provider
+	Provider string
+
+	// Text is the raw output of the model
+	Text string
+
+	// Errors are the schema violations of the output
+	Errors []validation.ValidationError
+
+	// Err is the parse error, if the output is not valid JSON
+	Err error
+}
+
+// Error returns a description of the error
+func (e *StructuredOutputError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: invalid structured output: %v", e.Provider, e.Err)
+	}
+
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%s: structured output does not match the schema: %s", e.Provider, strings.Join(messages, "; "))
+}
+
+// Unwrap returns the parse error
+func (e *StructuredOutputError) Unwrap() error {
+	return e.Err
+}
+
+// OutputSchema returns the schema for structured output of the prompt.
+// Prompt.Schema may be a validation.JSONSchema, a pointer to one, raw JSON
+// or any value that encodes to a JSON schema, such as a map.
+func (p *Prompt) OutputSchema() (validation.JSONSchema, error) {
+	switch schema := p.Schema.(type) {
+	case validation.JSONSchema:
+		return schema, nil
+	case *validation.JSONSchema:
+		return *schema, nil
+	}
+
+	data, err := p.OutputSchemaJSON()
+	if err != nil {
+		return validation.JSONSchema{}, err
+	}
+
+	var schema validation.JSONSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return validation.JSONSchema{}, fmt.Errorf("failed to parse schema: %w", err)
+	}
+	return schema, nil
+}
+
+// OutputSchemaJSON returns the JSON encoding of the schema for structured
+// output of the prompt. Raw JSON schemas are returned unchanged, so that
+// keywords unknown to validation.JSONSchema are kept.
+func (p *Prompt) OutputSchemaJSON() (json.RawMessage, error) {
+	switch schema := p.Schema.(type) {
+	case json.RawMessage:
+		return schema, nil
+	case []byte:
+		return json.RawMessage(schema), nil
+	case string:
+		return json.RawMessage(schema), nil
+	}
+
+	data, err := json.Marshal(p.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal schema: %w", err)
+	}
+	return data, nil
+}
+
+// ParseStructuredOutput parses the output of a model as JSON and validates
+// it against the schema of the prompt. Markdown code fences around the JSON
+// are ignored.
+func ParseStructuredOutput(provider string, prompt *Prompt, text string) (interface{}, error) {
+	schema, err := prompt.OutputSchema()
+	if err != nil {
+		return nil, &StructuredOutputError{Provider: provider, Text: text, Err: err}
+	}
+
+	trimmed := strings.TrimSpace(text)
+	if strings.HasPrefix(trimmed, "```") {
+		trimmed = strings.TrimPrefix(trimmed, "```json")
+		trimmed = strings.TrimPrefix(trimmed, "```")
+		trimmed = strings.TrimSuffix(trimmed, "```")
+	}
+
+	var output interface{}
+	if err := json.Unmarshal([]byte(trimmed), &output); err != nil {
+		return nil, &StructuredOutputError{Provider: provider, Text: text, Err: err}
+	}
+
+	if errors := validation.NewValidator(schema).Validate(output); len(errors) > 0 {
+		return nil, &StructuredOutputError{Provider: provider, Text: text, Errors: errors}
+	}
+
+	return output, nil
+}
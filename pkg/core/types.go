@@ -2,6 +2,9 @@ package core
 
 import (
 	"context"
+	"strings"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/validation"
 )
 
 // LLMProvider defines the interface for all LLM providers
@@ -25,14 +28,187 @@ type ResponseStream interface {
 	Close() error
 }
 
+// Role identifies the author of a message in a conversation
+type Role string
+
+const (
+	// RoleSystem is used for system instructions
+	RoleSystem Role = "system"
+
+	// RoleUser is used for messages written by the user
+	RoleUser Role = "user"
+
+	// RoleAssistant is used for messages written by the model
+	RoleAssistant Role = "assistant"
+
+	// RoleTool is used for the results of tool calls
+	RoleTool Role = "tool"
+)
+
+// Message represents a single message in a conversation
+type Message struct {
+	// Role is the author of the message
+	Role Role
+
+	// Content is the text content of the message
+	Content string
+
+	// Parts are additional content parts, such as images or documents,
+	// sent after the text content
+	Parts []ContentPart
+
+	// Name is an optional name for the author of the message. For tool
+	// messages, it is the name of the tool that was called.
+	Name string
+
+	// ToolCalls are the tool calls requested by an assistant message
+	ToolCalls []ToolCall
+
+	// ToolCallID is the ID of the tool call a tool message responds to
+	ToolCallID string
+	
+	// Thinking is the reasoning of an assistant message, which providers
+	// that require it get back with the tool calls of the message
+	Thinking []ThinkingBlock
+	
+	// Cache marks the message as the end of a prefix of the prompt to cache,
+	// for providers with explicit prompt caching (optional)
+	Cache bool
+}
+
+// Tool describes a function that the model may call
+type Tool struct {
+	// Name is the name of the tool
+	Name string
+
+	// Description explains to the model what the tool does
+	Description string
+
+	// Parameters is the JSON schema of the tool arguments
+	Parameters validation.JSONSchema
+}
+
+// ParameterSchema returns the schema of the tool arguments. Tools without
+// parameters get an empty object schema, as most providers require one.
+func (t Tool) ParameterSchema() validation.JSONSchema {
+	schema := t.Parameters
+	if schema.Type == "" {
+		schema.Type = "object"
+	}
+	return schema
+}
+
+// ThinkingBlock is a block of the reasoning a model does before answering,
+// with extended thinking
+type ThinkingBlock struct {
+	// Index is the position of the block in the response, used to
+	// correlate the fragments of a streamed block
+	Index int
+	
+	// Text is the reasoning text. In a streamed chunk, it only contains the
+	// newly generated fragment.
+	Text string
+	
+	// Signature verifies the block when it is sent back to the model
+	Signature string
+	
+	// RedactedData is the encrypted reasoning of a redacted block, which has
+	// no text
+	RedactedData string
+}
+
+// SafetyRating is the rating of a prompt or response for a category of harm
+type SafetyRating struct {
+	// Category is the category of harm, such as "HARM_CATEGORY_HARASSMENT"
+	Category string
+	
+	// Probability is the probability that the content is harmful, such as
+	// "NEGLIGIBLE" or "HIGH"
+	Probability string
+	
+	// Blocked indicates whether the content was blocked for this category
+	Blocked bool
+}
+
+// Candidate is one of several responses generated for the same prompt
+type Candidate struct {
+	// Index is the position of the candidate in the response
+	Index int
+	
+	// Text is the text of the candidate
+	Text string
+	
+	// ToolCalls contains the tool calls requested in the candidate
+	ToolCalls []ToolCall
+	
+	// FinishReason indicates why generation of the candidate stopped
+	FinishReason string
+	
+	// SafetyRatings contains the safety ratings of the candidate
+	SafetyRatings []SafetyRating
+}
+
+// ToolCall represents a request from the model to call a tool
+type ToolCall struct {
+	// Index is the position of the tool call in the response, used to
+	// correlate the fragments of a streamed tool call
+	Index int
+
+	// ID is the identifier of the tool call
+	ID string
+
+	// Name is the name of the tool to call
+	Name string
+
+	// Arguments contains the JSON-encoded tool arguments. In a streamed
+	// chunk, it only contains the newly generated fragment.
+	Arguments string
+}
+
+const (
+	// ToolChoiceAuto lets the model decide whether to call a tool
+	ToolChoiceAuto = "auto"
+
+	// ToolChoiceNone prevents the model from calling tools
+	ToolChoiceNone = "none"
+
+	// ToolChoiceRequired forces the model to call at least one tool
+	ToolChoiceRequired = "required"
+)
+
+// ToolResultMessage creates a tool message with the result of a tool call
+func ToolResultMessage(call ToolCall, result string) Message {
+	return Message{
+		Role:       RoleTool,
+		Content:    result,
+		Name:       call.Name,
+		ToolCallID: call.ID,
+	}
+}
+
 // Prompt represents a prompt to be sent to an LLM
 type Prompt struct {
-	// Text is the main prompt text
+	// Text is the main prompt text. When Messages is also set, Text is
+	// sent as a final user message after the conversation history.
 	Text string
 	
+	// Parts are content parts, such as images or documents, sent with Text
+	// in the final user message
+	Parts []ContentPart
+	
 	// SystemMessage is an optional system message
 	SystemMessage string
 	
+	// Messages is the conversation history
+	Messages []Message
+	
+	// Tools are the tools the model may call
+	Tools []Tool
+	
+	// ToolChoice controls tool use: ToolChoiceAuto, ToolChoiceNone,
+	// ToolChoiceRequired or the name of a tool to force (optional)
+	ToolChoice string
+	
 	// Temperature controls randomness (0.0-1.0)
 	Temperature float64
 	
@@ -71,8 +247,43 @@ func NewPrompt(text string) *Prompt {
 	}
 }
 
+// NewChatPrompt creates a new prompt from a conversation with default settings
+func NewChatPrompt(messages ...Message) *Prompt {
+	prompt := NewPrompt("")
+	prompt.Messages = messages
+	return prompt
+}
+
+// AddMessage appends a message to the conversation history
+func (p *Prompt) AddMessage(role Role, content string) *Prompt {
+	p.Messages = append(p.Messages, Message{
+		Role:    role,
+		Content: content,
+	})
+	return p
+}
+
+// Conversation returns the messages to send to the model: the Messages
+// history followed by Text and Parts as a final user message, if set.
+// SystemMessage is not included, since providers place it differently.
+func (p *Prompt) Conversation() []Message {
+	messages := make([]Message, 0, len(p.Messages)+1)
+	messages = append(messages, p.Messages...)
+	if p.Text != "" || len(p.Parts) > 0 {
+		messages = append(messages, Message{
+			Role:    RoleUser,
+			Content: p.Text,
+			Parts:   p.Parts,
+		})
+	}
+	return messages
+}
+
 // Response represents a response from an LLM
 type Response struct {
+	// ID is the identifier of the response assigned by the provider, if any
+	ID string
+	
 	// Text is the response text
 	Text string
 	
@@ -85,6 +296,20 @@ type Response struct {
 	// FinishReason indicates why generation stopped
 	FinishReason string
 	
+	// ToolCalls contains the tool calls requested by the model
+	ToolCalls []ToolCall
+	
+	// Thinking contains the reasoning of the model, separately from Text
+	Thinking []ThinkingBlock
+	
+	// SafetyRatings contains the safety ratings of the response, or of the
+	// prompt if it was blocked, for providers that rate content
+	SafetyRatings []SafetyRating
+	
+	// Candidates contains all the generated responses when several were
+	// requested. The response itself is the first one that was not blocked.
+	Candidates []Candidate
+	
 	// ModelInfo contains information about the model
 	ModelInfo *ModelInfo
 	
@@ -92,6 +317,15 @@ type Response struct {
 	ProviderInfo *ProviderInfo
 }
 
+// ThinkingText returns the text of the reasoning of the model
+func (r *Response) ThinkingText() string {
+	var text strings.Builder
+	for _, block := range r.Thinking {
+		text.WriteString(block.Text)
+	}
+	return text.String()
+}
+
 // ResponseChunk represents a chunk of a streaming response
 type ResponseChunk struct {
 	// Text is the chunk text
@@ -102,6 +336,25 @@ type ResponseChunk struct {
 	
 	// FinishReason indicates why generation stopped (only set if IsFinal is true)
 	FinishReason string
+	
+	// ToolCalls contains tool call fragments, correlated by their Index
+	ToolCalls []ToolCall
+	
+	// Thinking contains reasoning fragments, correlated by their Index
+	Thinking []ThinkingBlock
+	
+	// ID is the identifier of the response assigned by the provider, if any
+	ID string
+	
+	// TokensUsed contains the token usage reported so far, usually only on
+	// the last chunks. Later values supersede earlier ones.
+	TokensUsed *TokenUsage
+	
+	// ModelInfo contains information about the model, once known
+	ModelInfo *ModelInfo
+	
+	// ProviderInfo contains information about the provider
+	ProviderInfo *ProviderInfo
 }
 
 // TokenUsage contains information about token usage
@@ -114,6 +367,12 @@ type TokenUsage struct {
 	
 	// Total is the total number of tokens
 	Total int
+	
+	// CacheRead is the number of prompt tokens read from the prompt cache
+	CacheRead int
+	
+	// CacheWrite is the number of prompt tokens written to the prompt cache
+	CacheWrite int
 }
 
 // ModelInfo contains information about the model
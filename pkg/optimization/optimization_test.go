@@ -95,6 +95,43 @@ func TestTokenLimitStrategy(t *testing.T) {
 	}
 }
 
+// TestTokenLimitStrategyFor tests limiting prompts to the context window of
+// the model of a provider
+func TestTokenLimitStrategyFor(t *testing.T) {
+	estimator := &MockTokenEstimator{}
+	long := core.NewPrompt(strings.Repeat("a", 30))
+	short := core.NewPrompt(strings.Repeat("a", 16))
+
+	// The limit is the context window less the maximum output tokens
+	provider := &MockCapableProvider{capabilities: core.ModelCapabilities{ContextWindow: 20, MaxOutputTokens: 10}}
+	strategy := optimization.NewTokenLimitStrategyFor(provider, 1000, estimator)
+	if optimized, _ := strategy.Optimize(long); len(optimized.Text) >= len(long.Text) {
+		t.Fatalf("Expected the prompt to be truncated: %s", optimized.Text)
+	}
+	if optimized, _ := strategy.Optimize(short); optimized.Text != short.Text {
+		t.Fatalf("Expected the prompt to be unchanged: %s", optimized.Text)
+	}
+
+	// Providers without capabilities use the default limit
+	strategy = optimization.NewTokenLimitStrategyFor(&MockCapableProvider{unknown: true}, 1000, estimator)
+	if optimized, _ := strategy.Optimize(long); optimized.Text != long.Text {
+		t.Fatalf("Expected the prompt to be unchanged: %s", optimized.Text)
+	}
+}
+
+// MockCapableProvider is a mock provider that knows the capabilities of its
+// model
+type MockCapableProvider struct {
+	core.LLMProvider
+	capabilities core.ModelCapabilities
+	unknown      bool
+}
+
+// Capabilities returns the capabilities of the model
+func (p *MockCapableProvider) Capabilities() (core.ModelCapabilities, bool) {
+	return p.capabilities, !p.unknown
+}
+
 // MockTokenEstimator is a mock implementation of TokenEstimator for testing
 type MockTokenEstimator struct{}
 
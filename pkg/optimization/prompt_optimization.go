@@ -108,6 +108,21 @@ func NewTokenLimitStrategy(maxTokens int, estimator TokenEstimator) *TokenLimitS
 	}
 }
 
+// NewTokenLimitStrategyFor creates a token limit strategy for the model of a
+// provider. The limit is the context window of the model, less its maximum
+// output tokens, or defaultMaxTokens if the capabilities of the model are
+// unknown.
+func NewTokenLimitStrategyFor(provider core.LLMProvider, defaultMaxTokens int, estimator TokenEstimator) *TokenLimitStrategy {
+	maxTokens := defaultMaxTokens
+	if capabilities, ok := core.CapabilitiesOf(provider); ok && capabilities.ContextWindow > 0 {
+		maxTokens = capabilities.ContextWindow
+		if capabilities.MaxOutputTokens < capabilities.ContextWindow {
+			maxTokens -= capabilities.MaxOutputTokens
+		}
+	}
+	return NewTokenLimitStrategy(maxTokens, estimator)
+}
+
 // Name returns the name of the strategy
 func (s *TokenLimitStrategy) Name() string {
 	return "token_limit"
@@ -8,9 +8,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/streaming/sse"
 )
 
 // Provider implements the core.LLMProvider interface for Anthropic
@@ -35,6 +37,22 @@ type Config struct {
 	
 	// Version is the Anthropic API version
 	Version string `json:"version,omitempty"`
+	
+	// CacheSystem caches the tools and system message of every request, so
+	// that prompts sharing long instructions are cheaper (optional)
+	CacheSystem bool `json:"cache_system,omitempty"`
+	
+	// CacheTTL is the lifetime of cached prompt prefixes, "5m" or "1h"
+	// (optional, defaults to 5 minutes)
+	CacheTTL string `json:"cache_ttl,omitempty"`
+	
+	// ThinkingBudget enables extended thinking with the given maximum number
+	// of reasoning tokens, at least 1024 (optional)
+	ThinkingBudget int `json:"thinking_budget,omitempty"`
+	
+	// Catalog is the model catalog the capabilities of the models are looked
+	// up in (optional, defaults to core.DefaultCatalog())
+	Catalog *core.ModelCatalog `json:"-"`
 }
 
 // NewProvider creates a new Anthropic provider
@@ -59,6 +77,10 @@ func NewProvider(config Config) (*Provider, error) {
 		config.Version = "2023-06-01"
 	}
 	
+	if config.Catalog == nil {
+		config.Catalog = core.DefaultCatalog()
+	}
+	
 	client := &http.Client{
 		Timeout: time.Duration(config.Timeout) * time.Second,
 	}
@@ -74,6 +96,16 @@ func (p *Provider) Name() string {
 	return "anthropic"
 }
 
+// Capabilities returns the capabilities of the model from the model catalog
+func (p *Provider) Capabilities() (core.ModelCapabilities, bool) {
+	return p.config.Catalog.Lookup("anthropic", p.config.Model)
+}
+
+// Model returns the name of the model
+func (p *Provider) Model() string {
+	return p.config.Model
+}
+
 // Generate generates a response for the given prompt
 func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
 	// Prepare the request
@@ -101,14 +133,14 @@ func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Res
 	// Send the request
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, core.NewNetworkError("anthropic", err)
 	}
 	defer resp.Body.Close()
 	
 	// Check for errors
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, core.NewProviderError("anthropic", resp, body)
 	}
 	
 	// Parse the response
@@ -119,17 +151,13 @@ func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Res
 	
 	// Convert to core.Response
 	response := &core.Response{
-		Text: anthropicResp.Content[0].Text,
-		TokensUsed: &core.TokenUsage{
-			Prompt:     anthropicResp.Usage.InputTokens,
-			Completion: anthropicResp.Usage.OutputTokens,
-			Total:      anthropicResp.Usage.InputTokens + anthropicResp.Usage.OutputTokens,
-		},
+		ID:           anthropicResp.ID,
+		TokensUsed:   anthropicResp.Usage.tokenUsage(),
 		FinishReason: anthropicResp.StopReason,
 		ModelInfo: &core.ModelInfo{
 			Name:     p.config.Model,
 			Provider: "anthropic",
-			Version:  "1.0.0",
+			Version:  p.config.Catalog.Version("anthropic", p.config.Model),
 		},
 		ProviderInfo: &core.ProviderInfo{
 			Name:    "anthropic",
@@ -137,11 +165,46 @@ func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Res
 		},
 	}
 	
-	// Handle structured output if a schema was provided
-	if prompt.Schema != nil {
-		// In a real implementation, this would parse the JSON and validate it against the schema
-		// For simplicity, we're just setting it to nil
-		response.StructuredOutput = nil
+	// Collect the text, reasoning and tool calls from the content blocks
+	var text strings.Builder
+	for i, block := range anthropicResp.Content {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "thinking":
+			response.Thinking = append(response.Thinking, core.ThinkingBlock{
+				Index:     i,
+				Text:      block.Thinking,
+				Signature: block.Signature,
+			})
+		case "redacted_thinking":
+			response.Thinking = append(response.Thinking, core.ThinkingBlock{
+				Index:        i,
+				RedactedData: block.Data,
+			})
+		case "tool_use":
+			// The input of the structured output tool is the response
+			if block.Name == structuredOutputTool && prompt.Schema != nil {
+				text.Write(block.Input)
+				continue
+			}
+			response.ToolCalls = append(response.ToolCalls, core.ToolCall{
+				Index:     len(response.ToolCalls),
+				ID:        block.ID,
+				Name:      block.Name,
+				Arguments: string(block.Input),
+			})
+		}
+	}
+	response.Text = text.String()
+	
+	// Parse the structured output if a schema was provided
+	if prompt.Schema != nil && len(response.ToolCalls) == 0 {
+		output, err := core.ParseStructuredOutput("anthropic", prompt, response.Text)
+		if err != nil {
+			return response, err
+		}
+		response.StructuredOutput = output
 	}
 	
 	return response, nil
@@ -185,30 +248,121 @@ func (p *Provider) GenerateStream(ctx context.Context, prompt *core.Prompt) (cor
 	// Send the request
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, core.NewNetworkError("anthropic", err)
 	}
 	
 	// Check for errors
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, core.NewProviderError("anthropic", resp, body)
 	}
 	
 	// Create a stream
 	return &anthropicStream{
-		reader: resp.Body,
+		reader:      resp.Body,
+		decoder:     sse.NewDecoder(resp.Body),
+		outputBlock: -1,
+		model:       p.config.Model,
+		catalog:     p.config.Catalog,
 	}, nil
 }
 
 // prepareRequestBody prepares the request body for the Anthropic API
 func (p *Provider) prepareRequestBody(prompt *core.Prompt) ([]byte, error) {
-	// Create the messages
-	messages := []message{
-		{
-			Role:    "user",
-			Content: prompt.Text,
-		},
+	// Create the messages. Anthropic takes system instructions as a separate
+	// field, so system messages from the conversation are moved there.
+	var system []string
+	if prompt.SystemMessage != "" {
+		system = append(system, prompt.SystemMessage)
+	}
+	
+	conversation := prompt.Conversation()
+	messages := make([]message, 0, len(conversation))
+	for _, msg := range conversation {
+		role := "user"
+		var blocks []contentBlock
+		
+		switch msg.Role {
+		case core.RoleSystem:
+			system = append(system, msg.Content)
+			continue
+		case core.RoleTool:
+			// Tool results are sent back as user content
+			result := contentBlock{
+				Type:      "tool_result",
+				ToolUseID: msg.ToolCallID,
+			}
+			if len(msg.Parts) > 0 {
+				parts, err := contentBlocks(msg)
+				if err != nil {
+					return nil, err
+				}
+				result.Content = parts
+			} else if msg.Content != "" {
+				result.Content = msg.Content
+			}
+			blocks = append(blocks, result)
+		case core.RoleAssistant:
+			role = "assistant"
+			
+			// The reasoning of the model comes first, and must be sent back
+			// unchanged with tool calls
+			for _, thinking := range msg.Thinking {
+				if thinking.RedactedData != "" {
+					blocks = append(blocks, contentBlock{Type: "redacted_thinking", Data: thinking.RedactedData})
+					continue
+				}
+				blocks = append(blocks, contentBlock{
+					Type:      "thinking",
+					Thinking:  thinking.Text,
+					Signature: thinking.Signature,
+				})
+			}
+			
+			parts, err := contentBlocks(msg)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, parts...)
+			for _, call := range msg.ToolCalls {
+				input := json.RawMessage(call.Arguments)
+				if len(input) == 0 {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, contentBlock{
+					Type:  "tool_use",
+					ID:    call.ID,
+					Name:  call.Name,
+					Input: input,
+				})
+			}
+		default:
+			parts, err := contentBlocks(msg)
+			if err != nil {
+				return nil, err
+			}
+			if len(parts) == 0 {
+				parts = append(parts, contentBlock{Type: "text", Text: msg.Content})
+			}
+			blocks = append(blocks, parts...)
+		}
+		
+		// The prompt is cached up to the end of the message
+		if msg.Cache && len(blocks) > 0 {
+			blocks[len(blocks)-1].CacheControl = p.cacheControl()
+		}
+		
+		// Consecutive messages with the same role are merged, since the API
+		// expects user and assistant turns to alternate
+		if n := len(messages); n > 0 && messages[n-1].Role == role {
+			messages[n-1].Content = append(messages[n-1].Content, blocks...)
+			continue
+		}
+		messages = append(messages, message{
+			Role:    role,
+			Content: blocks,
+		})
 	}
 	
 	// Create the request body
@@ -221,157 +375,408 @@ func (p *Provider) prepareRequestBody(prompt *core.Prompt) ([]byte, error) {
 		StopSequences: prompt.StopSequences,
 	}
 	
-	// Add system message if provided
-	if prompt.SystemMessage != "" {
-		reqBody.System = prompt.SystemMessage
+	// Add system message if provided, as a cached block if the tools and
+	// system message are cached
+	if len(system) > 0 {
+		reqBody.System = strings.Join(system, "\n\n")
+		if p.config.CacheSystem {
+			reqBody.System = []contentBlock{{
+				Type:         "text",
+				Text:         strings.Join(system, "\n\n"),
+				CacheControl: p.cacheControl(),
+			}}
+		}
 	}
 	
-	// Add schema if provided
-	if prompt.Schema != nil {
-		// In a real implementation, this would set the response_format to JSON
-		// and include the schema
-		// For simplicity, we're not implementing this
+	// Add tools if provided
+	for _, tool := range prompt.Tools {
+		reqBody.Tools = append(reqBody.Tools, toolDefinition{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: tool.ParameterSchema(),
+		})
 	}
+	reqBody.ToolChoice = toolChoice(prompt.ToolChoice)
 	
-	// Add additional parameters
-	// For simplicity, we're not implementing this
-	
-	return json.Marshal(reqBody)
-}
-
-// anthropicStream implements core.ResponseStream for Anthropic
-type anthropicStream struct {
-	reader io.ReadCloser
-	buffer []byte
-}
-
-// Next returns the next chunk of the response
-func (s *anthropicStream) Next() (*core.ResponseChunk, error) {
-	// Read the next line
-	line, err := s.readLine()
-	if err != nil {
-		if err == io.EOF {
-			return nil, io.EOF
+	// Anthropic has no JSON mode, so structured output is requested as the
+	// input of a tool, which is forced unless the prompt has tools of its own
+	if prompt.Schema != nil {
+		schema, err := prompt.OutputSchemaJSON()
+		if err != nil {
+			return nil, err
+		}
+		reqBody.Tools = append(reqBody.Tools, toolDefinition{
+			Name:        structuredOutputTool,
+			Description: "Respond with structured output conforming to the input schema",
+			InputSchema: schema,
+		})
+		if len(prompt.Tools) == 0 {
+			reqBody.ToolChoice = toolChoice(structuredOutputTool)
 		}
-		return nil, fmt.Errorf("failed to read line: %w", err)
 	}
 	
-	// Skip empty lines
-	if len(line) == 0 {
-		return s.Next()
+	// Without a system message, the cache ends with the tools
+	if p.config.CacheSystem && len(system) == 0 && len(reqBody.Tools) > 0 {
+		reqBody.Tools[len(reqBody.Tools)-1].CacheControl = p.cacheControl()
 	}
 	
-	// Skip lines that don't start with "data: "
-	if len(line) < 6 || string(line[:6]) != "data: " {
-		return s.Next()
+	// Extended thinking counts the reasoning in the maximum number of tokens,
+	// and does not support sampling parameters or forced tool use
+	if p.config.ThinkingBudget > 0 {
+		reqBody.Thinking = &thinkingConfig{Type: "enabled", BudgetTokens: p.config.ThinkingBudget}
+		reqBody.MaxTokens += p.config.ThinkingBudget
+		reqBody.Temperature = 0
+		reqBody.TopP = 0
+		if choice, ok := reqBody.ToolChoice.(map[string]string); ok && choice["type"] != core.ToolChoiceNone {
+			reqBody.ToolChoice = toolChoice(core.ToolChoiceAuto)
+		}
 	}
 	
-	// Handle the "data: [DONE]" message
-	if string(line) == "data: [DONE]" {
-		return nil, io.EOF
+	data, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
 	}
 	
-	// Parse the JSON
-	var streamResp messageStreamResponse
-	if err := json.Unmarshal(line[6:], &streamResp); err != nil {
-		return nil, fmt.Errorf("failed to parse stream response: %w", err)
+	// Merge the additional parameters into the request
+	return core.ApplyParams("anthropic", data, prompt.AdditionalParams)
+}
+
+// cacheControl returns the cache breakpoint of a cached prompt prefix
+func (p *Provider) cacheControl() *cacheControl {
+	return &cacheControl{Type: "ephemeral", TTL: p.config.CacheTTL}
+}
+
+// structuredOutputTool is the name of the tool used for structured output
+const structuredOutputTool = "structured_output"
+
+// toolChoice converts a core tool choice to the Anthropic format
+func toolChoice(choice string) interface{} {
+	switch choice {
+	case "":
+		return nil
+	case core.ToolChoiceAuto, core.ToolChoiceNone:
+		return map[string]string{"type": choice}
+	case core.ToolChoiceRequired:
+		return map[string]string{"type": "any"}
+	default:
+		return map[string]string{"type": "tool", "name": choice}
 	}
+}
+
+// anthropicStream implements core.ResponseStream for Anthropic
+type anthropicStream struct {
+	reader  io.ReadCloser
+	decoder *sse.Decoder
 	
-	// Create a response chunk
-	chunk := &core.ResponseChunk{
-		Text:    streamResp.Delta.Text,
-		IsFinal: false,
-	}
+	// outputBlock is the index of the structured output tool block, whose
+	// input is streamed as text, or -1
+	outputBlock int
 	
-	// Check if this is the final chunk
-	if streamResp.Type == "message_stop" {
-		chunk.IsFinal = true
-		chunk.FinishReason = streamResp.StopReason
-	}
+	// stopReason is the stop reason sent by the message_delta event
+	stopReason string
 	
-	return chunk, nil
-}
-
-// Close closes the stream
-func (s *anthropicStream) Close() error {
-	return s.reader.Close()
+	// id, model and usage are sent by the message_start and message_delta
+	// events
+	id    string
+	model string
+	usage messageUsage
+	
+	// catalog is the model catalog of the provider
+	catalog *core.ModelCatalog
 }
 
-// readLine reads a line from the stream
-func (s *anthropicStream) readLine() ([]byte, error) {
-	var line []byte
-	
+// Next returns the next chunk of the response
+func (s *anthropicStream) Next() (*core.ResponseChunk, error) {
 	for {
-		// If we have data in the buffer, try to find a newline
-		if len(s.buffer) > 0 {
-			i := bytes.IndexByte(s.buffer, '\n')
-			if i >= 0 {
-				line = s.buffer[:i]
-				s.buffer = s.buffer[i+1:]
-				return line, nil
+		// Read the next event
+		event, err := s.decoder.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil, io.EOF
 			}
+			return nil, fmt.Errorf("failed to read stream: %w", err)
 		}
 		
-		// Read more data
-		buf := make([]byte, 1024)
-		n, err := s.reader.Read(buf)
-		if err != nil {
-			if err == io.EOF && len(s.buffer) > 0 {
-				// Return the remaining data
-				line = s.buffer
-				s.buffer = nil
-				return line, nil
+		// Parse the JSON
+		var streamResp messageStreamResponse
+		if err := json.Unmarshal([]byte(event.Data), &streamResp); err != nil {
+			return nil, fmt.Errorf("failed to parse stream response: %w", err)
+		}
+		if streamResp.Type == "" {
+			streamResp.Type = event.Type
+		}
+		
+		switch streamResp.Type {
+		case "error":
+			// Errors that occur after the response has started, such as an
+			// overloaded API, are sent as events
+			return nil, core.NewStreamError("anthropic", []byte(event.Data))
+		case "content_block_start":
+			// Redacted reasoning is sent whole
+			if streamResp.ContentBlock.Type == "redacted_thinking" {
+				chunk := s.newChunk()
+				chunk.Thinking = []core.ThinkingBlock{{
+					Index:        streamResp.Index,
+					RedactedData: streamResp.ContentBlock.Data,
+				}}
+				return chunk, nil
 			}
-			return nil, err
+			
+			// Tool calls start with a content block and stream their input as
+			// JSON fragments
+			if streamResp.ContentBlock.Type != "tool_use" {
+				continue
+			}
+			if streamResp.ContentBlock.Name == structuredOutputTool {
+				s.outputBlock = streamResp.Index
+				continue
+			}
+			chunk := s.newChunk()
+			chunk.ToolCalls = []core.ToolCall{{
+				Index: streamResp.Index,
+				ID:    streamResp.ContentBlock.ID,
+				Name:  streamResp.ContentBlock.Name,
+			}}
+			return chunk, nil
+		case "content_block_delta":
+			chunk := s.newChunk()
+			switch {
+			case streamResp.Delta.Type == "text_delta":
+				chunk.Text = streamResp.Delta.Text
+			case streamResp.Delta.Type == "thinking_delta":
+				chunk.Thinking = []core.ThinkingBlock{{Index: streamResp.Index, Text: streamResp.Delta.Thinking}}
+			case streamResp.Delta.Type == "signature_delta":
+				chunk.Thinking = []core.ThinkingBlock{{Index: streamResp.Index, Signature: streamResp.Delta.Signature}}
+			case streamResp.Delta.Type == "input_json_delta" && streamResp.Index == s.outputBlock:
+				chunk.Text = streamResp.Delta.PartialJSON
+			case streamResp.Delta.Type == "input_json_delta":
+				chunk.ToolCalls = []core.ToolCall{{
+					Index:     streamResp.Index,
+					Arguments: streamResp.Delta.PartialJSON,
+				}}
+			default:
+				continue
+			}
+			return chunk, nil
+		case "message_start":
+			s.id = streamResp.Message.ID
+			if streamResp.Message.Model != "" {
+				s.model = streamResp.Message.Model
+			}
+			s.usage = streamResp.Message.Usage
+		case "message_delta":
+			// The stop reason and the final output token count are sent
+			// before the end of the message
+			if streamResp.Delta.StopReason != "" {
+				s.stopReason = streamResp.Delta.StopReason
+			}
+			s.usage.update(streamResp.Usage)
+		case "message_stop":
+			chunk := s.newChunk()
+			chunk.IsFinal = true
+			chunk.FinishReason = s.stopReason
+			chunk.TokensUsed = s.usage.tokenUsage()
+			return chunk, nil
 		}
 		
-		// Append to buffer
-		s.buffer = append(s.buffer, buf[:n]...)
+		// Skip content_block_stop, ping and unknown events
+	}
+}
+
+// newChunk creates a chunk with the metadata of the message
+func (s *anthropicStream) newChunk() *core.ResponseChunk {
+	return &core.ResponseChunk{
+		ID: s.id,
+		ModelInfo: &core.ModelInfo{
+			Name:     s.model,
+			Provider: "anthropic",
+			Version:  s.catalog.Version("anthropic", s.model),
+		},
+		ProviderInfo: &core.ProviderInfo{
+			Name:    "anthropic",
+			Version: "1.0.0",
+		},
+	}
+}
+
+// Close closes the stream
+func (s *anthropicStream) Close() error {
+	return s.reader.Close()
+}
+
+// contentBlocks converts the content of a message to content blocks
+func contentBlocks(msg core.Message) ([]contentBlock, error) {
+	var blocks []contentBlock
+	for _, part := range core.MessageParts(msg) {
+		switch part.Type {
+		case core.ContentText:
+			blocks = append(blocks, contentBlock{Type: "text", Text: part.Text})
+		case core.ContentImage:
+			blocks = append(blocks, contentBlock{Type: "image", Source: mediaSourceOf(part)})
+		case core.ContentFile:
+			block := contentBlock{Type: "document", Source: mediaSourceOf(part), Title: part.Filename}
+			if part.IsInline() {
+				// Plain text documents are sent as text, and other documents
+				// must be PDF files
+				switch {
+				case strings.HasPrefix(part.MIMEType, "text/"):
+					block.Source = &mediaSource{Type: "text", MediaType: "text/plain", Data: string(part.Data)}
+				case part.MIMEType != "application/pdf":
+					return nil, core.NewUnsupportedError("anthropic", fmt.Sprintf("files of type %q", part.MIMEType))
+				}
+			}
+			blocks = append(blocks, block)
+		case core.ContentAudio:
+			return nil, core.NewUnsupportedError("anthropic", "audio input")
+		default:
+			return nil, fmt.Errorf("unknown content type %q", part.Type)
+		}
+	}
+	return blocks, nil
+}
+
+// mediaSourceOf returns the source of the media of a content part
+func mediaSourceOf(part core.ContentPart) *mediaSource {
+	if !part.IsInline() {
+		return &mediaSource{Type: "url", URL: part.URL}
 	}
+	return &mediaSource{Type: "base64", MediaType: part.MIMEType, Data: part.Base64()}
 }
 
 // message represents a message in a message request
 type message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string         `json:"role"`
+	Content []contentBlock `json:"content"`
+}
+
+// contentBlock represents a block of message content
+type contentBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   interface{}     `json:"content,omitempty"`
+	Source    *mediaSource    `json:"source,omitempty"`
+	Title     string          `json:"title,omitempty"`
+	Thinking  string          `json:"thinking,omitempty"`
+	Signature string          `json:"signature,omitempty"`
+	Data      string          `json:"data,omitempty"`
+	
+	CacheControl *cacheControl `json:"cache_control,omitempty"`
+}
+
+// cacheControl marks the end of a prompt prefix to cache
+type cacheControl struct {
+	Type string `json:"type"`
+	TTL  string `json:"ttl,omitempty"`
+}
+
+// thinkingConfig represents the extended thinking configuration of a request
+type thinkingConfig struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+// mediaSource represents the source of an image or a document
+type mediaSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+// toolDefinition represents a tool in a message request
+type toolDefinition struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	InputSchema interface{} `json:"input_schema"`
+	
+	CacheControl *cacheControl `json:"cache_control,omitempty"`
 }
 
 // messageRequest represents a request to the messages API
 type messageRequest struct {
-	Model         string    `json:"model"`
-	Messages      []message `json:"messages"`
-	System        string    `json:"system,omitempty"`
-	MaxTokens     int       `json:"max_tokens,omitempty"`
-	Temperature   float64   `json:"temperature,omitempty"`
-	TopP          float64   `json:"top_p,omitempty"`
-	StopSequences []string  `json:"stop_sequences,omitempty"`
+	Model         string           `json:"model"`
+	Messages      []message        `json:"messages"`
+	System        interface{}      `json:"system,omitempty"`
+	MaxTokens     int              `json:"max_tokens,omitempty"`
+	Temperature   float64          `json:"temperature,omitempty"`
+	TopP          float64          `json:"top_p,omitempty"`
+	StopSequences []string         `json:"stop_sequences,omitempty"`
+	Tools         []toolDefinition `json:"tools,omitempty"`
+	ToolChoice    interface{}      `json:"tool_choice,omitempty"`
+	Thinking      *thinkingConfig  `json:"thinking,omitempty"`
 }
 
 // messageResponse represents a response from the messages API
 type messageResponse struct {
-	ID        string `json:"id"`
-	Type      string `json:"type"`
-	Role      string `json:"role"`
-	Content   []struct {
-		Type string `json:"type"`
-		Text string `json:"text"`
-	} `json:"content"`
-	Model      string `json:"model"`
-	StopReason string `json:"stop_reason"`
-	Usage      struct {
-		InputTokens  int `json:"input_tokens"`
-		OutputTokens int `json:"output_tokens"`
-	} `json:"usage"`
+	ID         string         `json:"id"`
+	Type       string         `json:"type"`
+	Role       string         `json:"role"`
+	Content    []contentBlock `json:"content"`
+	Model      string         `json:"model"`
+	StopReason string         `json:"stop_reason"`
+	Usage      messageUsage   `json:"usage"`
+}
+
+// messageUsage represents the token usage of a message. Input tokens do not
+// include the tokens read from or written to the prompt cache.
+type messageUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// update updates the usage with the counts reported by a message_delta
+// event, which are cumulative
+func (u *messageUsage) update(delta messageUsage) {
+	if delta.InputTokens > 0 {
+		u.InputTokens = delta.InputTokens
+	}
+	if delta.OutputTokens > 0 {
+		u.OutputTokens = delta.OutputTokens
+	}
+	if delta.CacheCreationInputTokens > 0 {
+		u.CacheCreationInputTokens = delta.CacheCreationInputTokens
+	}
+	if delta.CacheReadInputTokens > 0 {
+		u.CacheReadInputTokens = delta.CacheReadInputTokens
+	}
+}
+
+// tokenUsage converts the usage to the core format, in which the prompt
+// tokens include the cached tokens
+func (u messageUsage) tokenUsage() *core.TokenUsage {
+	prompt := u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+	return &core.TokenUsage{
+		Prompt:     prompt,
+		Completion: u.OutputTokens,
+		Total:      prompt + u.OutputTokens,
+		CacheRead:  u.CacheReadInputTokens,
+		CacheWrite: u.CacheCreationInputTokens,
+	}
 }
 
 // messageStreamResponse represents a streaming response from the messages API
 type messageStreamResponse struct {
-	Type       string `json:"type"`
-	ID         string `json:"id"`
-	Model      string `json:"model"`
-	StopReason string `json:"stop_reason,omitempty"`
-	Delta      struct {
-		Type    string `json:"type,omitempty"`
-		Text    string `json:"text,omitempty"`
+	Type         string       `json:"type"`
+	Index        int          `json:"index"`
+	ContentBlock contentBlock `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type,omitempty"`
+		Text        string `json:"text,omitempty"`
+		PartialJSON string `json:"partial_json,omitempty"`
+		Thinking    string `json:"thinking,omitempty"`
+		Signature   string `json:"signature,omitempty"`
+		StopReason  string `json:"stop_reason,omitempty"`
 	} `json:"delta"`
+	Message struct {
+		ID    string       `json:"id"`
+		Model string       `json:"model"`
+		Usage messageUsage `json:"usage"`
+	} `json:"message"`
+	Usage messageUsage `json:"usage"`
 }
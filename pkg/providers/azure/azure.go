@@ -0,0 +1,279 @@
+// Package azure implements a provider for the Azure OpenAI Service
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/providers/openai"
+)
+
+// DefaultAPIVersion is the API version used unless configured otherwise
+const DefaultAPIVersion = "2024-10-21"
+
+// streamUsageVersion is the first API version accepting the stream_options
+// field that requests the usage of streaming responses
+const streamUsageVersion = "2024-09-01"
+
+// ErrContentFiltered is the underlying error of the requests and responses
+// blocked by the Azure content filter
+var ErrContentFiltered = errors.New("content blocked by the Azure content filter")
+
+// Provider implements the core.LLMProvider interface for Azure OpenAI. It
+// uses the OpenAI wire format with deployment URLs.
+type Provider struct {
+	provider *openai.Provider
+	config   Config
+}
+
+// Config contains the configuration for the Azure OpenAI provider
+type Config struct {
+	// Endpoint is the endpoint of the Azure OpenAI resource, such as
+	// "https://my-resource.openai.azure.com"
+	Endpoint string `json:"endpoint"`
+
+	// Deployment is the name of the chat model deployment
+	Deployment string `json:"deployment"`
+
+	// EmbeddingDeployment is the name of the embedding model deployment
+	// (optional)
+	EmbeddingDeployment string `json:"embedding_deployment,omitempty"`
+
+	// APIVersion is the API version (optional, defaults to DefaultAPIVersion).
+	// Versions older than 2024-09-01 do not report the usage of streaming
+	// responses.
+	APIVersion string `json:"api_version,omitempty"`
+
+	// APIKey is the API key, sent in the api-key header
+	APIKey string `json:"api_key,omitempty"`
+
+	// BearerToken is a Microsoft Entra ID token, used instead of the API key
+	BearerToken string `json:"bearer_token,omitempty"`
+
+	// Timeout is the request timeout in seconds
+	Timeout int `json:"timeout,omitempty"`
+
+	// Catalog is the model catalog the capabilities of the models are looked
+	// up in (optional, defaults to core.DefaultCatalog())
+	Catalog *core.ModelCatalog `json:"-"`
+}
+
+// NewProvider creates a new Azure OpenAI provider
+func NewProvider(config Config) (*Provider, error) {
+	if config.Endpoint == "" {
+		return nil, errors.New("endpoint is required")
+	}
+
+	if config.Deployment == "" {
+		return nil, errors.New("deployment is required")
+	}
+
+	if config.APIVersion == "" {
+		config.APIVersion = DefaultAPIVersion
+	}
+
+	opts := []openai.ProviderOption{
+		openai.WithName("azure"),
+		openai.WithChatPath(deploymentPath(config.Deployment, "chat/completions")),
+		openai.WithQueryParam("api-version", config.APIVersion),
+	}
+	// Older versions reject requests with stream_options. Versions are
+	// dates, optionally followed by "-preview", so they compare as strings.
+	if config.APIVersion < streamUsageVersion {
+		opts = append(opts, openai.WithFeatures(openai.Features{DisableStreamUsage: true}))
+	}
+	if config.EmbeddingDeployment != "" {
+		opts = append(opts, openai.WithEmbeddingsPath(deploymentPath(config.EmbeddingDeployment, "embeddings")))
+	}
+
+	key := config.APIKey
+	switch {
+	case config.BearerToken != "":
+		key = config.BearerToken
+		opts = append(opts, openai.WithAuth("Authorization", "Bearer "))
+	case config.APIKey != "":
+		opts = append(opts, openai.WithAuth("api-key", ""))
+	default:
+		return nil, errors.New("API key or bearer token is required")
+	}
+
+	provider, err := openai.NewProvider(openai.Config{
+		APIKey:         key,
+		Model:          config.Deployment,
+		Endpoint:       strings.TrimSuffix(config.Endpoint, "/"),
+		Timeout:        config.Timeout,
+		EmbeddingModel: config.EmbeddingDeployment,
+		Catalog:        config.Catalog,
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Provider{
+		provider: provider,
+		config:   config,
+	}, nil
+}
+
+// Name returns the name of the provider
+func (p *Provider) Name() string {
+	return "azure"
+}
+
+// Capabilities returns the capabilities of the model of the deployment, which
+// is looked up by the name of the deployment
+func (p *Provider) Capabilities() (core.ModelCapabilities, bool) {
+	return p.provider.Capabilities()
+}
+
+// Model returns the name of the chat model deployment
+func (p *Provider) Model() string {
+	return p.config.Deployment
+}
+
+// Generate generates a response for the given prompt. The results of the
+// content filter are returned as the safety ratings of the response.
+// Responses blocked by the content filter are returned with an error
+// wrapping ErrContentFiltered, and so are prompts, with a response holding
+// only the ratings of the prompt.
+func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
+	response, err := p.provider.Generate(ctx, prompt)
+	if err != nil {
+		err = contentFilterError(err)
+		if response == nil {
+			response = blockedPromptResponse(err)
+		}
+		return response, err
+	}
+
+	if response.FinishReason == "content_filter" {
+		return response, newContentFilterError("the response was blocked by the content filter" + filteredCategories(response.SafetyRatings))
+	}
+
+	return response, nil
+}
+
+// GenerateStream generates a streaming response for the given prompt. The
+// stream fails with an error wrapping ErrContentFiltered if the content
+// filter blocks the rest of the response.
+func (p *Provider) GenerateStream(ctx context.Context, prompt *core.Prompt) (core.ResponseStream, error) {
+	stream, err := p.provider.GenerateStream(ctx, prompt)
+	if err != nil {
+		return nil, contentFilterError(err)
+	}
+
+	return &azureStream{stream: stream}, nil
+}
+
+// Embed generates the embeddings of the texts of the request with the
+// embedding deployment. The model of the request is ignored, as the
+// deployment determines it.
+func (p *Provider) Embed(ctx context.Context, request *core.EmbeddingRequest) (*core.EmbeddingResponse, error) {
+	if p.config.EmbeddingDeployment == "" {
+		return nil, errors.New("embedding deployment is not configured")
+	}
+
+	return p.provider.Embed(ctx, request)
+}
+
+// azureStream implements core.ResponseStream for Azure OpenAI
+type azureStream struct {
+	stream core.ResponseStream
+}
+
+// Next returns the next chunk of the response
+func (s *azureStream) Next() (*core.ResponseChunk, error) {
+	chunk, err := s.stream.Next()
+	if err != nil {
+		return nil, contentFilterError(err)
+	}
+
+	if chunk.IsFinal && chunk.FinishReason == "content_filter" {
+		return nil, newContentFilterError("the response was blocked by the content filter")
+	}
+
+	return chunk, nil
+}
+
+// Close closes the stream
+func (s *azureStream) Close() error {
+	return s.stream.Close()
+}
+
+// deploymentPath returns the path of an operation of a deployment
+func deploymentPath(deployment, operation string) string {
+	return fmt.Sprintf("/openai/deployments/%s/%s", url.PathEscape(deployment), operation)
+}
+
+// newContentFilterError creates an error for a response blocked by the
+// content filter
+func newContentFilterError(message string) *core.ProviderError {
+	return &core.ProviderError{
+		Provider: "azure",
+		Category: core.ErrorCategoryContentFilter,
+		Message:  message,
+		Err:      ErrContentFiltered,
+	}
+}
+
+// blockedPromptResponse returns a response with the results of the content
+// filter for a prompt it blocked, or nil if the error has none. The blocked
+// categories are added to the message of the error.
+func blockedPromptResponse(err error) *core.Response {
+	var providerErr *core.ProviderError
+	if !errors.As(err, &providerErr) || providerErr.Category != core.ErrorCategoryContentFilter {
+		return nil
+	}
+
+	var body struct {
+		Error struct {
+			InnerError struct {
+				ContentFilterResult openai.ContentFilterResults `json:"content_filter_result"`
+			} `json:"innererror"`
+		} `json:"error"`
+	}
+	if json.Unmarshal([]byte(providerErr.Body), &body) != nil || len(body.Error.InnerError.ContentFilterResult) == 0 {
+		return nil
+	}
+
+	ratings := body.Error.InnerError.ContentFilterResult.SafetyRatings()
+	providerErr.Message += filteredCategories(ratings)
+	return &core.Response{
+		FinishReason:  "content_filter",
+		SafetyRatings: ratings,
+		ProviderInfo: &core.ProviderInfo{
+			Name:    "azure",
+			Version: "1.0.0",
+		},
+	}
+}
+
+// filteredCategories returns the categories blocked by the content filter,
+// formatted to be appended to an error message
+func filteredCategories(ratings []core.SafetyRating) string {
+	var categories []string
+	for _, rating := range ratings {
+		if rating.Blocked {
+			categories = append(categories, rating.Category)
+		}
+	}
+	if len(categories) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(categories, ", ") + ")"
+}
+
+// contentFilterError makes the errors of prompts blocked by the content
+// filter wrap ErrContentFiltered
+func contentFilterError(err error) error {
+	var providerErr *core.ProviderError
+	if errors.As(err, &providerErr) && providerErr.Category == core.ErrorCategoryContentFilter && providerErr.Err == nil {
+		providerErr.Err = ErrContentFiltered
+	}
+	return err
+}
@@ -0,0 +1,214 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+)
+
+// maxEmbeddingInputs is the maximum number of requests per batch
+const maxEmbeddingInputs = 100
+
+// Embed generates the embeddings of the texts of the request, in batches
+// if needed. The input type selects the retrieval task type.
+func (p *Provider) Embed(ctx context.Context, request *core.EmbeddingRequest) (*core.EmbeddingResponse, error) {
+	return core.EmbedBatches(ctx, request, maxEmbeddingInputs, p.embed)
+}
+
+// embed generates the embeddings of a single batch of texts, using
+// embedContent for a single text and batchEmbedContents otherwise
+func (p *Provider) embed(ctx context.Context, request *core.EmbeddingRequest) (*core.EmbeddingResponse, error) {
+	model := request.Model
+	if model == "" {
+		model = p.config.EmbeddingModel
+	}
+	model = strings.TrimPrefix(model, "models/")
+
+	// Vertex AI serves the embedding models with the predict method
+	if p.config.Project != "" {
+		return p.predictEmbeddings(ctx, model, request)
+	}
+
+	requests := make([]embedContentRequest, len(request.Texts))
+	for i, text := range request.Texts {
+		requests[i] = embedContentRequest{
+			Model:                "models/" + model,
+			Content:              contentType{Parts: []part{{Text: text}}},
+			TaskType:             taskType(request.InputType),
+			OutputDimensionality: request.Dimensions,
+		}
+	}
+
+	method := "batchEmbedContents"
+	var body interface{} = map[string]interface{}{"requests": requests}
+	if len(requests) == 1 {
+		method = "embedContent"
+		body = requests[0]
+	}
+
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	// Send the request
+	var embedResp embedContentResponse
+	if err := p.post(ctx, p.modelURL(model, method), reqBody, &embedResp); err != nil {
+		return nil, err
+	}
+	if embedResp.Embedding != nil {
+		embedResp.Embeddings = append(embedResp.Embeddings, *embedResp.Embedding)
+	}
+
+	if len(embedResp.Embeddings) != len(request.Texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(request.Texts), len(embedResp.Embeddings))
+	}
+
+	embeddings := make([][]float32, len(embedResp.Embeddings))
+	for i, embedding := range embedResp.Embeddings {
+		embeddings[i] = embedding.Values
+	}
+
+	return &core.EmbeddingResponse{
+		Embeddings: embeddings,
+		Model:      model,
+	}, nil
+}
+
+// predictEmbeddings generates the embeddings of a batch of texts with the
+// predict method of Vertex AI
+func (p *Provider) predictEmbeddings(ctx context.Context, model string, request *core.EmbeddingRequest) (*core.EmbeddingResponse, error) {
+	instances := make([]predictInstance, len(request.Texts))
+	for i, text := range request.Texts {
+		instances[i] = predictInstance{
+			Content:  text,
+			TaskType: taskType(request.InputType),
+		}
+	}
+
+	body := predictRequest{Instances: instances}
+	if request.Dimensions > 0 {
+		body.Parameters = &predictParameters{OutputDimensionality: request.Dimensions}
+	}
+
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	// Send the request
+	var predictResp predictResponse
+	if err := p.post(ctx, p.modelURL(model, "predict"), reqBody, &predictResp); err != nil {
+		return nil, err
+	}
+
+	if len(predictResp.Predictions) != len(request.Texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(request.Texts), len(predictResp.Predictions))
+	}
+
+	embeddings := make([][]float32, len(predictResp.Predictions))
+	usage := &core.TokenUsage{}
+	for i, prediction := range predictResp.Predictions {
+		embeddings[i] = prediction.Embeddings.Values
+		usage.Prompt += prediction.Embeddings.Statistics.TokenCount
+	}
+	usage.Total = usage.Prompt
+
+	return &core.EmbeddingResponse{
+		Embeddings: embeddings,
+		Model:      model,
+		TokensUsed: usage,
+	}, nil
+}
+
+// post sends a request and decodes the response into result
+func (p *Provider) post(ctx context.Context, u string, body []byte, result interface{}) error {
+	req, err := p.newRequest(ctx, u, body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return core.NewNetworkError("google", err)
+	}
+	defer resp.Body.Close()
+
+	// Check for errors
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return core.NewProviderError("google", resp, body)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// taskType converts an input type to a task type of the embeddings API
+func taskType(inputType core.EmbeddingInputType) string {
+	switch inputType {
+	case core.EmbeddingInputQuery:
+		return "RETRIEVAL_QUERY"
+	case core.EmbeddingInputDocument:
+		return "RETRIEVAL_DOCUMENT"
+	default:
+		return ""
+	}
+}
+
+// embedContentRequest represents a request to embed a single content
+type embedContentRequest struct {
+	Model                string      `json:"model"`
+	Content              contentType `json:"content"`
+	TaskType             string      `json:"taskType,omitempty"`
+	OutputDimensionality int         `json:"outputDimensionality,omitempty"`
+}
+
+// contentEmbedding represents the embedding of a content
+type contentEmbedding struct {
+	Values []float32 `json:"values"`
+}
+
+// embedContentResponse represents a response from the embedContent or
+// batchEmbedContents API
+type embedContentResponse struct {
+	Embedding  *contentEmbedding  `json:"embedding,omitempty"`
+	Embeddings []contentEmbedding `json:"embeddings,omitempty"`
+}
+
+// predictInstance represents a text to embed with Vertex AI
+type predictInstance struct {
+	Content  string `json:"content"`
+	TaskType string `json:"task_type,omitempty"`
+}
+
+// predictParameters represents the parameters of a Vertex AI embedding
+// request
+type predictParameters struct {
+	OutputDimensionality int `json:"outputDimensionality,omitempty"`
+}
+
+// predictRequest represents a request to the Vertex AI predict API
+type predictRequest struct {
+	Instances  []predictInstance  `json:"instances"`
+	Parameters *predictParameters `json:"parameters,omitempty"`
+}
+
+// predictResponse represents a response from the Vertex AI predict API
+type predictResponse struct {
+	Predictions []struct {
+		Embeddings struct {
+			Values     []float32 `json:"values"`
+			Statistics struct {
+				TokenCount int `json:"token_count"`
+			} `json:"statistics"`
+		} `json:"embeddings"`
+	} `json:"predictions"`
+}
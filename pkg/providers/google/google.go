@@ -8,12 +8,21 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/streaming/sse"
+	"github.com/GeoloeG-IsT/gollem/pkg/validation"
 )
 
-// Provider implements the core.LLMProvider interface for Google AI
+// ErrBlocked is the underlying error of the prompts and responses blocked by
+// the Google safety filters
+var ErrBlocked = errors.New("content blocked by the Google safety filters")
+
+// Provider implements the core.LLMProvider interface for Google AI. With a
+// project, it uses Vertex AI instead of the Gemini API.
 type Provider struct {
 	config Config
 	client *http.Client
@@ -21,36 +30,78 @@ type Provider struct {
 
 // Config contains the configuration for the Google provider
 type Config struct {
-	// APIKey is the Google API key
+	// APIKey is the Google API key (optional with a bearer token)
 	APIKey string `json:"api_key"`
 	
 	// Model is the model to use (e.g., "gemini-pro", "gemini-ultra")
 	Model string `json:"model"`
 	
+	// EmbeddingModel is the model used for embeddings (optional, defaults
+	// to "text-embedding-004")
+	EmbeddingModel string `json:"embedding_model,omitempty"`
+	
 	// Endpoint is the API endpoint (optional, defaults to Google's API)
 	Endpoint string `json:"endpoint,omitempty"`
 	
 	// Timeout is the request timeout in seconds
 	Timeout int `json:"timeout,omitempty"`
 	
-	// Project is the Google Cloud project ID (optional)
+	// Project is the Google Cloud project ID. When set, requests are sent to
+	// Vertex AI in this project instead of the Gemini API.
 	Project string `json:"project,omitempty"`
 	
-	// Location is the Google Cloud location (optional)
+	// Location is the Google Cloud location of Vertex AI (optional, defaults
+	// to "us-central1")
 	Location string `json:"location,omitempty"`
+	
+	// BearerToken is an OAuth 2.0 access token sent in the Authorization
+	// header, as required by Vertex AI
+	BearerToken string `json:"bearer_token,omitempty"`
+	
+	// TokenSource returns the bearer token of each request, for tokens that
+	// expire (optional, takes precedence over BearerToken)
+	TokenSource func(ctx context.Context) (string, error) `json:"-"`
+	
+	// SafetySettings sets the thresholds at which content is blocked for
+	// each category of harm (optional)
+	SafetySettings []SafetySetting `json:"safety_settings,omitempty"`
+	
+	// CandidateCount is the number of responses to generate (optional)
+	CandidateCount int `json:"candidate_count,omitempty"`
+	
+	// Catalog is the model catalog the capabilities of the models are looked
+	// up in (optional, defaults to core.DefaultCatalog())
+	Catalog *core.ModelCatalog `json:"-"`
+}
+
+// SafetySetting sets the threshold at which content of a category of harm is
+// blocked, such as "BLOCK_ONLY_HIGH" for "HARM_CATEGORY_DANGEROUS_CONTENT"
+type SafetySetting struct {
+	Category  string `json:"category"`
+	Threshold string `json:"threshold"`
 }
 
 // NewProvider creates a new Google provider
 func NewProvider(config Config) (*Provider, error) {
-	if config.APIKey == "" {
-		return nil, errors.New("API key is required")
+	if config.APIKey == "" && config.BearerToken == "" && config.TokenSource == nil {
+		return nil, errors.New("API key or bearer token is required")
+	}
+	
+	if config.Project != "" && config.Location == "" {
+		config.Location = "us-central1"
 	}
 	
 	if config.Model == "" {
 		config.Model = "gemini-pro"
 	}
 	
-	if config.Endpoint == "" {
+	if config.EmbeddingModel == "" {
+		config.EmbeddingModel = "text-embedding-004"
+	}
+	
+	if config.Endpoint == "" && config.Project != "" {
+		config.Endpoint = vertexEndpoint(config.Project, config.Location)
+	} else if config.Endpoint == "" {
 		config.Endpoint = "https://generativelanguage.googleapis.com/v1beta"
 	}
 	
@@ -58,6 +109,10 @@ func NewProvider(config Config) (*Provider, error) {
 		config.Timeout = 30
 	}
 	
+	if config.Catalog == nil {
+		config.Catalog = core.DefaultCatalog()
+	}
+	
 	client := &http.Client{
 		Timeout: time.Duration(config.Timeout) * time.Second,
 	}
@@ -73,6 +128,16 @@ func (p *Provider) Name() string {
 	return "google"
 }
 
+// Capabilities returns the capabilities of the model from the model catalog
+func (p *Provider) Capabilities() (core.ModelCapabilities, bool) {
+	return p.config.Catalog.Lookup("google", p.config.Model)
+}
+
+// Model returns the name of the model
+func (p *Provider) Model() string {
+	return p.config.Model
+}
+
 // Generate generates a response for the given prompt
 func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
 	// Prepare the request
@@ -81,34 +146,23 @@ func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Res
 		return nil, fmt.Errorf("failed to prepare request body: %w", err)
 	}
 	
-	// Create the request URL
-	url := fmt.Sprintf("%s/models/%s:generateContent?key=%s", p.config.Endpoint, p.config.Model, p.config.APIKey)
-	
 	// Create the request
-	req, err := http.NewRequestWithContext(
-		ctx,
-		"POST",
-		url,
-		bytes.NewBuffer(reqBody),
-	)
+	req, err := p.newRequest(ctx, p.modelURL(p.config.Model, "generateContent"), reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
 	
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	
 	// Send the request
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, core.NewNetworkError("google", err)
 	}
 	defer resp.Body.Close()
 	
 	// Check for errors
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, core.NewProviderError("google", resp, body)
 	}
 	
 	// Parse the response
@@ -117,24 +171,19 @@ func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Res
 		return nil, fmt.Errorf("failed to parse response: %w", err)
 	}
 	
-	// Check if candidates array is empty
-	if len(googleResp.Candidates) == 0 {
-		return nil, fmt.Errorf("no candidates returned in response")
-	}
-	
 	// Convert to core.Response
 	response := &core.Response{
-		Text: googleResp.Candidates[0].Content.Parts[0].Text,
+		ID: googleResp.ResponseID,
 		TokensUsed: &core.TokenUsage{
 			Prompt:     googleResp.UsageMetadata.PromptTokenCount,
 			Completion: googleResp.UsageMetadata.CandidatesTokenCount,
 			Total:      googleResp.UsageMetadata.TotalTokenCount,
+			CacheRead:  googleResp.UsageMetadata.CachedContentTokenCount,
 		},
-		FinishReason: googleResp.Candidates[0].FinishReason,
 		ModelInfo: &core.ModelInfo{
 			Name:     p.config.Model,
 			Provider: "google",
-			Version:  "1.0.0",
+			Version:  p.config.Catalog.Version("google", p.config.Model),
 		},
 		ProviderInfo: &core.ProviderInfo{
 			Name:    "google",
@@ -142,11 +191,57 @@ func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Res
 		},
 	}
 	
-	// Handle structured output if a schema was provided
-	if prompt.Schema != nil {
-		// In a real implementation, this would parse the JSON and validate it against the schema
-		// For simplicity, we're just setting it to nil
-		response.StructuredOutput = nil
+	// Blocked prompts have no candidates, only the reason of the block
+	if reason := googleResp.PromptFeedback.BlockReason; reason != "" {
+		response.FinishReason = reason
+		response.SafetyRatings = safetyRatings(googleResp.PromptFeedback.SafetyRatings)
+		return response, newBlockedError("the prompt was blocked: " + reason)
+	}
+	
+	// Check if candidates array is empty
+	if len(googleResp.Candidates) == 0 {
+		return nil, fmt.Errorf("no candidates returned in response")
+	}
+	
+	// Use the first candidate that was not blocked
+	candidates := make([]core.Candidate, len(googleResp.Candidates))
+	selected := -1
+	for i, c := range googleResp.Candidates {
+		text, toolCalls := fromParts(c.Content.Parts, 0)
+		candidates[i] = core.Candidate{
+			Index:         c.Index,
+			Text:          text,
+			ToolCalls:     toolCalls,
+			FinishReason:  c.FinishReason,
+			SafetyRatings: safetyRatings(c.SafetyRatings),
+		}
+		if selected < 0 && !isBlocked(c.FinishReason) {
+			selected = i
+		}
+	}
+	if len(candidates) > 1 {
+		response.Candidates = candidates
+	}
+	
+	candidate := candidates[0]
+	if selected >= 0 {
+		candidate = candidates[selected]
+	}
+	response.Text = candidate.Text
+	response.ToolCalls = candidate.ToolCalls
+	response.FinishReason = candidate.FinishReason
+	response.SafetyRatings = candidate.SafetyRatings
+	if selected < 0 {
+		return response, newBlockedError("the response was blocked: " + candidate.FinishReason)
+	}
+
+	// Parse the structured output if a schema was provided
+	if prompt.Schema != nil && len(response.ToolCalls) == 0 {
+		output, err := core.ParseStructuredOutput("google", prompt, response.Text)
+		if err != nil {
+			return response, err
+		}
+		response.StructuredOutput = output
 	}
 	
 	return response, nil
@@ -160,142 +255,434 @@ func (p *Provider) GenerateStream(ctx context.Context, prompt *core.Prompt) (cor
 		return nil, fmt.Errorf("failed to prepare request body: %w", err)
 	}
 	
-	// Set streaming to true
-	var reqMap map[string]interface{}
-	if err := json.Unmarshal(reqBody, &reqMap); err != nil {
-		return nil, fmt.Errorf("failed to parse request body: %w", err)
-	}
-	reqMap["stream"] = true
-	reqBody, err = json.Marshal(reqMap)
+	// Create the request. Streaming is selected by the method, and alt=sse
+	// returns the chunks as server-sent events instead of a JSON array.
+	req, err := p.newRequest(ctx, p.modelURL(p.config.Model, "streamGenerateContent?alt=sse"), reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+		return nil, err
 	}
 	
-	// Create the request URL
-	url := fmt.Sprintf("%s/models/%s:streamGenerateContent?key=%s", p.config.Endpoint, p.config.Model, p.config.APIKey)
-	
-	// Create the request
-	req, err := http.NewRequestWithContext(
-		ctx,
-		"POST",
-		url,
-		bytes.NewBuffer(reqBody),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	
 	// Send the request
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, core.NewNetworkError("google", err)
 	}
 	
 	// Check for errors
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, core.NewProviderError("google", resp, body)
 	}
 	
 	// Create a stream
 	return &googleStream{
-		reader: resp.Body,
+		reader:  resp.Body,
+		decoder: sse.NewDecoder(resp.Body),
+		model:   p.config.Model,
+		catalog: p.config.Catalog,
 	}, nil
 }
 
 // prepareRequestBody prepares the request body for the Google API
 func (p *Provider) prepareRequestBody(prompt *core.Prompt) ([]byte, error) {
-	// Create the content
-	contentObj := contentType{
-		Parts: []part{
-			{
-				Text: prompt.Text,
-			},
-		},
+	// Create the contents. Google names the assistant role "model" and takes
+	// system instructions separately from the conversation.
+	var systemParts []part
+	if prompt.SystemMessage != "" {
+		systemParts = append(systemParts, part{Text: prompt.SystemMessage})
+	}
+	
+	// Function responses are matched by name, so remember the names of the
+	// calls made by the model
+	callNames := make(map[string]string)
+	
+	conversation := prompt.Conversation()
+	contents := make([]contentType, 0, len(conversation))
+	for _, msg := range conversation {
+		switch msg.Role {
+		case core.RoleSystem:
+			systemParts = append(systemParts, part{Text: msg.Content})
+		case core.RoleAssistant:
+			var parts []part
+			if msg.Content != "" || len(msg.ToolCalls) == 0 {
+				parts = append(parts, part{Text: msg.Content})
+			}
+			media, err := mediaParts(msg.Parts)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, media...)
+			for _, call := range msg.ToolCalls {
+				callNames[call.ID] = call.Name
+				args := json.RawMessage(call.Arguments)
+				if len(args) == 0 {
+					args = json.RawMessage("{}")
+				}
+				parts = append(parts, part{
+					FunctionCall: &functionCall{Name: call.Name, Args: args},
+				})
+			}
+			contents = append(contents, contentType{
+				Role:  "model",
+				Parts: parts,
+			})
+		case core.RoleTool:
+			name := msg.Name
+			if name == "" {
+				name = callNames[msg.ToolCallID]
+			}
+			contents = append(contents, contentType{
+				Role: "user",
+				Parts: []part{{
+					FunctionResponse: &functionResponse{
+						Name:     name,
+						Response: functionResult(msg.Content),
+					},
+				}},
+			})
+		default:
+			var parts []part
+			if msg.Content != "" || len(msg.Parts) == 0 {
+				parts = append(parts, part{Text: msg.Content})
+			}
+			media, err := mediaParts(msg.Parts)
+			if err != nil {
+				return nil, err
+			}
+			contents = append(contents, contentType{
+				Role:  "user",
+				Parts: append(parts, media...),
+			})
+		}
 	}
 	
 	// Create the request body
 	reqBody := generateContentRequest{
-		Contents: []contentType{contentObj},
+		Contents: contents,
 		GenerationConfig: generationConfig{
 			Temperature:     prompt.Temperature,
 			MaxOutputTokens: prompt.MaxTokens,
 			TopP:            prompt.TopP,
 			StopSequences:   prompt.StopSequences,
+			CandidateCount:  p.config.CandidateCount,
 		},
+		SafetySettings: p.config.SafetySettings,
 	}
 	
-	// Add system message if provided
-	if prompt.SystemMessage != "" {
+	// Add system instructions if provided
+	if len(systemParts) > 0 {
 		reqBody.SystemInstruction = &contentType{
-			Parts: []part{
-				{
-					Text: prompt.SystemMessage,
-				},
-			},
+			Parts: systemParts,
+		}
+	}
+	
+	// Add tools if provided
+	if len(prompt.Tools) > 0 {
+		declarations := make([]functionDeclaration, 0, len(prompt.Tools))
+		for _, tool := range prompt.Tools {
+			declarations = append(declarations, functionDeclaration{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  convertSchema(tool.ParameterSchema()),
+			})
 		}
+		reqBody.Tools = []toolDefinition{{FunctionDeclarations: declarations}}
 	}
+	reqBody.ToolConfig = toolConfiguration(prompt.ToolChoice)
 	
-	// Add schema if provided
+	// Constrain the output to the schema if provided
 	if prompt.Schema != nil {
-		// In a real implementation, this would set the response_format to JSON
-		// and include the schema
-		// For simplicity, we're not implementing this
+		outputSchema, err := prompt.OutputSchema()
+		if err != nil {
+			return nil, err
+		}
+		reqBody.GenerationConfig.ResponseMimeType = "application/json"
+		reqBody.GenerationConfig.ResponseSchema = convertSchema(outputSchema)
 	}
 	
-	// Add additional parameters
-	// For simplicity, we're not implementing this
+	data, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
 	
-	return json.Marshal(reqBody)
+	// Merge the additional parameters into the request
+	return core.ApplyParams("google", data, prompt.AdditionalParams)
 }
 
-// googleStream implements core.ResponseStream for Google
-type googleStream struct {
-	reader io.ReadCloser
-	buffer []byte
+// modelURL returns the URL of a method of a model. The API key, if any, is
+// sent as a query parameter.
+func (p *Provider) modelURL(model, method string) string {
+	u := fmt.Sprintf("%s/models/%s:%s", p.config.Endpoint, model, method)
+	if p.config.APIKey == "" || p.config.BearerToken != "" || p.config.TokenSource != nil {
+		return u
+	}
+	if strings.Contains(method, "?") {
+		return u + "&key=" + url.QueryEscape(p.config.APIKey)
+	}
+	return u + "?key=" + url.QueryEscape(p.config.APIKey)
 }
 
-// Next returns the next chunk of the response
-func (s *googleStream) Next() (*core.ResponseChunk, error) {
-	// Read the next line
-	line, err := s.readLine()
+// newRequest creates a POST request with the given body, authenticated with
+// the bearer token if there is one
+func (p *Provider) newRequest(ctx context.Context, u string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewBuffer(body))
 	if err != nil {
-		if err == io.EOF {
-			return nil, io.EOF
-		}
-		return nil, fmt.Errorf("failed to read line: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 	
-	// Skip empty lines
-	if len(line) == 0 {
-		return s.Next()
+	token := p.config.BearerToken
+	if p.config.TokenSource != nil {
+		if token, err = p.config.TokenSource(ctx); err != nil {
+			return nil, fmt.Errorf("failed to get bearer token: %w", err)
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// vertexEndpoint returns the endpoint of the Google models on Vertex AI in a
+// project and location
+func vertexEndpoint(project, location string) string {
+	host := location + "-aiplatform.googleapis.com"
+	if location == "global" {
+		host = "aiplatform.googleapis.com"
+	}
+	return fmt.Sprintf("https://%s/v1/projects/%s/locations/%s/publishers/google", host, url.PathEscape(project), url.PathEscape(location))
+}
+
+// isBlocked returns whether a finish reason means that the candidate was
+// blocked rather than completed
+func isBlocked(finishReason string) bool {
+	switch finishReason {
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		return true
+	default:
+		return false
+	}
+}
+
+// newBlockedError creates an error for a prompt or response blocked by the
+// safety filters
+func newBlockedError(message string) *core.ProviderError {
+	return &core.ProviderError{
+		Provider: "google",
+		Category: core.ErrorCategoryContentFilter,
+		Message:  message,
+		Err:      ErrBlocked,
+	}
+}
+
+// safetyRatings converts safety ratings to the core format
+func safetyRatings(ratings []safetyRating) []core.SafetyRating {
+	if len(ratings) == 0 {
+		return nil
+	}
+	result := make([]core.SafetyRating, len(ratings))
+	for i, rating := range ratings {
+		result[i] = core.SafetyRating{
+			Category:    rating.Category,
+			Probability: rating.Probability,
+			Blocked:     rating.Blocked,
+		}
+	}
+	return result
+}
+
+// fromParts collects the text and function calls from the parts of a
+// candidate, indexing the function calls from first
+func fromParts(parts []part, first int) (string, []core.ToolCall) {
+	var text string
+	var toolCalls []core.ToolCall
+	for _, p := range parts {
+		text += p.Text
+		if p.FunctionCall != nil {
+			// Function calls only carry an ID in some API versions, so the
+			// others get one from their name and index, as the same function
+			// may be called several times
+			index := first + len(toolCalls)
+			id := p.FunctionCall.ID
+			if id == "" {
+				id = fmt.Sprintf("%s_%d", p.FunctionCall.Name, index)
+			}
+			toolCalls = append(toolCalls, core.ToolCall{
+				Index:     index,
+				ID:        id,
+				Name:      p.FunctionCall.Name,
+				Arguments: string(p.FunctionCall.Args),
+			})
+		}
+	}
+	return text, toolCalls
+}
+
+// functionResult converts a tool result to a function response object. The
+// API requires an object, so other results are wrapped.
+func functionResult(content string) map[string]interface{} {
+	var result map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &result); err == nil && result != nil {
+		return result
 	}
 	
-	// Parse the JSON
-	var streamResp generateContentResponse
-	if err := json.Unmarshal(line, &streamResp); err != nil {
-		return nil, fmt.Errorf("failed to parse stream response: %w", err)
+	var value interface{} = content
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(content), &decoded); err == nil {
+		value = decoded
+	}
+	return map[string]interface{}{"result": value}
+}
+
+// toolConfiguration converts a core tool choice to the Google format
+func toolConfiguration(choice string) *toolConfig {
+	config := &toolConfig{}
+	switch choice {
+	case "":
+		return nil
+	case core.ToolChoiceAuto:
+		config.FunctionCallingConfig.Mode = "AUTO"
+	case core.ToolChoiceNone:
+		config.FunctionCallingConfig.Mode = "NONE"
+	case core.ToolChoiceRequired:
+		config.FunctionCallingConfig.Mode = "ANY"
+	default:
+		config.FunctionCallingConfig.Mode = "ANY"
+		config.FunctionCallingConfig.AllowedFunctionNames = []string{choice}
+	}
+	return config
+}
+
+// convertSchema converts a JSON schema to the OpenAPI subset supported by
+// the Google API
+func convertSchema(s validation.JSONSchema) *schema {
+	result := &schema{
+		Type:        strings.ToUpper(s.Type),
+		Format:      s.Format,
+		Description: s.Description,
+		Required:    s.Required,
+	}
+	for _, value := range s.Enum {
+		result.Enum = append(result.Enum, fmt.Sprint(value))
+	}
+	if len(s.Properties) > 0 {
+		result.Properties = make(map[string]*schema, len(s.Properties))
+		for name, property := range s.Properties {
+			result.Properties[name] = convertSchema(property)
+		}
+	}
+	if s.Items != nil {
+		result.Items = convertSchema(*s.Items)
 	}
+	return result
+}
+
+// googleStream implements core.ResponseStream for Google
+type googleStream struct {
+	reader    io.ReadCloser
+	decoder   *sse.Decoder
+	model     string
+	catalog   *core.ModelCatalog
+	toolCalls int
+}
+
+// Next returns the next chunk of the response. Only the first candidate is
+// streamed, and the stream fails with an error wrapping ErrBlocked if the
+// safety filters block the prompt or the rest of the response.
+func (s *googleStream) Next() (*core.ResponseChunk, error) {
+	var streamResp generateContentResponse
+	var candidate *candidateType
+	for candidate == nil {
+		// Read the next event
+		event, err := s.decoder.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("failed to read stream: %w", err)
+		}
 	
-	// Check if candidates array is empty
-	if len(streamResp.Candidates) == 0 {
-		return nil, fmt.Errorf("no candidates returned in stream response")
+		// Parse the JSON
+		streamResp = generateContentResponse{}
+		if err := json.Unmarshal([]byte(event.Data), &streamResp); err != nil {
+			return nil, fmt.Errorf("failed to parse stream response: %w", err)
+		}
+	
+		// Errors that occur after the response has started are sent as events
+		if len(streamResp.Error) > 0 && string(streamResp.Error) != "null" {
+			return nil, core.NewStreamError("google", []byte(event.Data))
+		}
+	
+		// Blocked prompts have no candidates, only the reason of the block
+		if reason := streamResp.PromptFeedback.BlockReason; reason != "" {
+			return nil, newBlockedError("the prompt was blocked: " + reason)
+		}
+	
+		// The last event may only carry the usage of the response
+		if len(streamResp.Candidates) == 0 {
+			if streamResp.UsageMetadata.TotalTokenCount > 0 {
+				break
+			}
+			return nil, fmt.Errorf("no candidates or usage returned in stream response")
+		}
+	
+		// Skip the events of the other candidates
+		for i := range streamResp.Candidates {
+			if streamResp.Candidates[i].Index == 0 {
+				candidate = &streamResp.Candidates[i]
+				break
+			}
+		}
 	}
 	
 	// Create a response chunk
+	var text string
+	var toolCalls []core.ToolCall
+	if candidate != nil {
+		// Streamed function calls arrive whole, so give each one its own index
+		text, toolCalls = fromParts(candidate.Content.Parts, s.toolCalls)
+		s.toolCalls += len(toolCalls)
+	}
+	model := streamResp.ModelVersion
+	if model == "" {
+		model = s.model
+	}
 	chunk := &core.ResponseChunk{
-		Text:    streamResp.Candidates[0].Content.Parts[0].Text,
-		IsFinal: false,
+		ID:        streamResp.ResponseID,
+		Text:      text,
+		ToolCalls: toolCalls,
+		IsFinal:   false,
+		ModelInfo: &core.ModelInfo{
+			Name:     model,
+			Provider: "google",
+			Version:  s.catalog.Version("google", model),
+		},
+		ProviderInfo: &core.ProviderInfo{
+			Name:    "google",
+			Version: "1.0.0",
+		},
+	}
+	
+	// The usage metadata is cumulative, so the last chunk has the totals
+	if streamResp.UsageMetadata.TotalTokenCount > 0 {
+		chunk.TokensUsed = &core.TokenUsage{
+			Prompt:     streamResp.UsageMetadata.PromptTokenCount,
+			Completion: streamResp.UsageMetadata.CandidatesTokenCount,
+			Total:      streamResp.UsageMetadata.TotalTokenCount,
+			CacheRead:  streamResp.UsageMetadata.CachedContentTokenCount,
+		}
 	}
 	
-	// Check if this is the final chunk
-	if streamResp.Candidates[0].FinishReason != "" {
+	// Check if this is the final chunk, or the usage that follows it
+	if candidate == nil {
+		chunk.IsFinal = true
+	} else if candidate.FinishReason != "" {
+		if isBlocked(candidate.FinishReason) {
+			return nil, newBlockedError("the response was blocked: " + candidate.FinishReason)
+		}
 		chunk.IsFinal = true
-		chunk.FinishReason = streamResp.Candidates[0].FinishReason
+		chunk.FinishReason = candidate.FinishReason
 	}
 	
 	return chunk, nil
@@ -306,39 +693,6 @@ func (s *googleStream) Close() error {
 	return s.reader.Close()
 }
 
-// readLine reads a line from the stream
-func (s *googleStream) readLine() ([]byte, error) {
-	var line []byte
-	
-	for {
-		// If we have data in the buffer, try to find a newline
-		if len(s.buffer) > 0 {
-			i := bytes.IndexByte(s.buffer, '\n')
-			if i >= 0 {
-				line = s.buffer[:i]
-				s.buffer = s.buffer[i+1:]
-				return line, nil
-			}
-		}
-		
-		// Read more data
-		buf := make([]byte, 1024)
-		n, err := s.reader.Read(buf)
-		if err != nil {
-			if err == io.EOF && len(s.buffer) > 0 {
-				// Return the remaining data
-				line = s.buffer
-				s.buffer = nil
-				return line, nil
-			}
-			return nil, err
-		}
-		
-		// Append to buffer
-		s.buffer = append(s.buffer, buf[:n]...)
-	}
-}
-
 // contentType represents the content in a request or response
 type contentType struct {
 	Role  string `json:"role,omitempty"`
@@ -347,16 +701,98 @@ type contentType struct {
 
 // part represents a part of content
 type part struct {
-	Text string `json:"text"`
+	Text             string            `json:"text,omitempty"`
+	InlineData       *blob             `json:"inlineData,omitempty"`
+	FileData         *fileData         `json:"fileData,omitempty"`
+	FunctionCall     *functionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *functionResponse `json:"functionResponse,omitempty"`
+}
+
+// blob represents inline media
+type blob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+// fileData represents media referenced by URI
+type fileData struct {
+	MimeType string `json:"mimeType"`
+	FileURI  string `json:"fileUri"`
+}
+
+// mediaParts converts content parts to parts. Images, audio and files are
+// all sent as inline data or file data.
+func mediaParts(contentParts []core.ContentPart) ([]part, error) {
+	parts := make([]part, 0, len(contentParts))
+	for _, contentPart := range contentParts {
+		switch {
+		case contentPart.Type == core.ContentText:
+			parts = append(parts, part{Text: contentPart.Text})
+		case contentPart.IsInline():
+			parts = append(parts, part{InlineData: &blob{MimeType: contentPart.MIMEType, Data: contentPart.Base64()}})
+		case contentPart.MIMEType == "":
+			return nil, fmt.Errorf("MIME type is required for media URL %s", contentPart.URL)
+		default:
+			parts = append(parts, part{FileData: &fileData{MimeType: contentPart.MIMEType, FileURI: contentPart.URL}})
+		}
+	}
+	return parts, nil
+}
+
+// functionCall represents a function call made by the model
+type functionCall struct {
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// functionResponse represents the result of a function call
+type functionResponse struct {
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+// toolDefinition represents a set of tools in a request
+type toolDefinition struct {
+	FunctionDeclarations []functionDeclaration `json:"functionDeclarations"`
+}
+
+// functionDeclaration represents a function the model may call
+type functionDeclaration struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Parameters  *schema `json:"parameters,omitempty"`
+}
+
+// toolConfig represents the tool configuration of a request
+type toolConfig struct {
+	FunctionCallingConfig struct {
+		Mode                 string   `json:"mode"`
+		AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+	} `json:"functionCallingConfig"`
+}
+
+// schema represents the OpenAPI schema subset supported by the API
+type schema struct {
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Properties  map[string]*schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *schema            `json:"items,omitempty"`
 }
 
 // generationConfig represents the generation configuration
 type generationConfig struct {
-	Temperature     float64  `json:"temperature,omitempty"`
-	MaxOutputTokens int      `json:"maxOutputTokens,omitempty"`
-	TopP            float64  `json:"topP,omitempty"`
-	TopK            int      `json:"topK,omitempty"`
-	StopSequences   []string `json:"stopSequences,omitempty"`
+	Temperature      float64  `json:"temperature,omitempty"`
+	MaxOutputTokens  int      `json:"maxOutputTokens,omitempty"`
+	TopP             float64  `json:"topP,omitempty"`
+	TopK             int      `json:"topK,omitempty"`
+	StopSequences    []string `json:"stopSequences,omitempty"`
+	CandidateCount   int      `json:"candidateCount,omitempty"`
+	ResponseMimeType string   `json:"responseMimeType,omitempty"`
+	ResponseSchema   *schema  `json:"responseSchema,omitempty"`
 }
 
 // generateContentRequest represents a request to the generateContent API
@@ -364,17 +800,40 @@ type generateContentRequest struct {
 	Contents          []contentType      `json:"contents"`
 	SystemInstruction *contentType       `json:"systemInstruction,omitempty"`
 	GenerationConfig  generationConfig   `json:"generationConfig,omitempty"`
+	Tools             []toolDefinition   `json:"tools,omitempty"`
+	ToolConfig        *toolConfig        `json:"toolConfig,omitempty"`
+	SafetySettings    []SafetySetting    `json:"safetySettings,omitempty"`
+}
+
+// safetyRating represents the rating of content for a category of harm
+type safetyRating struct {
+	Category    string `json:"category"`
+	Probability string `json:"probability"`
+	Blocked     bool   `json:"blocked,omitempty"`
+}
+
+// candidateType represents a candidate of a response
+type candidateType struct {
+	Index         int            `json:"index"`
+	Content       contentType    `json:"content"`
+	FinishReason  string         `json:"finishReason"`
+	SafetyRatings []safetyRating `json:"safetyRatings,omitempty"`
 }
 
 // generateContentResponse represents a response from the generateContent API
 type generateContentResponse struct {
-	Candidates []struct {
-		Content      contentType `json:"content"`
-		FinishReason string      `json:"finishReason"`
-	} `json:"candidates"`
+	Candidates     []candidateType `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason   string         `json:"blockReason,omitempty"`
+		SafetyRatings []safetyRating `json:"safetyRatings,omitempty"`
+	} `json:"promptFeedback"`
 	UsageMetadata struct {
-		PromptTokenCount      int `json:"promptTokenCount"`
-		CandidatesTokenCount  int `json:"candidatesTokenCount"`
-		TotalTokenCount       int `json:"totalTokenCount"`
+		PromptTokenCount        int `json:"promptTokenCount"`
+		CandidatesTokenCount    int `json:"candidatesTokenCount"`
+		TotalTokenCount         int `json:"totalTokenCount"`
+		CachedContentTokenCount int `json:"cachedContentTokenCount"`
 	} `json:"usageMetadata"`
+	ResponseID   string          `json:"responseId,omitempty"`
+	ModelVersion string          `json:"modelVersion,omitempty"`
+	Error        json.RawMessage `json:"error,omitempty"`
 }
@@ -8,9 +8,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/streaming/sse"
 )
 
 // Provider implements the core.LLMProvider interface for Llama
@@ -27,11 +29,19 @@ type Config struct {
 	// Model is the model to use
 	Model string `json:"model"`
 	
+	// EmbeddingModel is the model used for embeddings (optional, defaults
+	// to Model)
+	EmbeddingModel string `json:"embedding_model,omitempty"`
+	
 	// Endpoint is the API endpoint
 	Endpoint string `json:"endpoint"`
 	
 	// Timeout is the request timeout in seconds
 	Timeout int `json:"timeout,omitempty"`
+	
+	// Catalog is the model catalog the capabilities of the models are looked
+	// up in (optional, defaults to core.DefaultCatalog())
+	Catalog *core.ModelCatalog `json:"-"`
 }
 
 // NewProvider creates a new Llama provider
@@ -44,10 +54,18 @@ func NewProvider(config Config) (*Provider, error) {
 		return nil, errors.New("model is required")
 	}
 	
+	if config.EmbeddingModel == "" {
+		config.EmbeddingModel = config.Model
+	}
+	
 	if config.Timeout == 0 {
 		config.Timeout = 30
 	}
 	
+	if config.Catalog == nil {
+		config.Catalog = core.DefaultCatalog()
+	}
+	
 	client := &http.Client{
 		Timeout: time.Duration(config.Timeout) * time.Second,
 	}
@@ -63,6 +81,16 @@ func (p *Provider) Name() string {
 	return "llama"
 }
 
+// Capabilities returns the capabilities of the model from the model catalog
+func (p *Provider) Capabilities() (core.ModelCapabilities, bool) {
+	return p.config.Catalog.Lookup("llama", p.config.Model)
+}
+
+// Model returns the name of the model
+func (p *Provider) Model() string {
+	return p.config.Model
+}
+
 // Generate generates a response for the given prompt
 func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
 	// Prepare the request
@@ -91,14 +119,14 @@ func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Res
 	// Send the request
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, core.NewNetworkError("llama", err)
 	}
 	defer resp.Body.Close()
 	
 	// Check for errors
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, core.NewProviderError("llama", resp, body)
 	}
 	
 	// Parse the response
@@ -119,7 +147,7 @@ func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Res
 		ModelInfo: &core.ModelInfo{
 			Name:     p.config.Model,
 			Provider: "llama",
-			Version:  "1.0.0",
+			Version:  p.config.Catalog.Version("llama", p.config.Model),
 		},
 		ProviderInfo: &core.ProviderInfo{
 			Name:    "llama",
@@ -127,11 +155,13 @@ func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Res
 		},
 	}
 	
-	// Handle structured output if a schema was provided
+	// Parse the structured output if a schema was provided
 	if prompt.Schema != nil {
-		// In a real implementation, this would parse the JSON and validate it against the schema
-		// For simplicity, we're just setting it to nil
-		response.StructuredOutput = nil
+		output, err := core.ParseStructuredOutput("llama", prompt, response.Text)
+		if err != nil {
+			return response, err
+		}
+		response.StructuredOutput = output
 	}
 	
 	return response, nil
@@ -176,28 +206,68 @@ func (p *Provider) GenerateStream(ctx context.Context, prompt *core.Prompt) (cor
 	// Send the request
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, core.NewNetworkError("llama", err)
 	}
 	
 	// Check for errors
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, core.NewProviderError("llama", resp, body)
 	}
 	
 	// Create a stream
 	return &llamaStream{
-		reader: resp.Body,
+		reader:  resp.Body,
+		decoder: sse.NewDecoder(resp.Body),
+		model:   p.config.Model,
+		catalog: p.config.Catalog,
 	}, nil
 }
 
 // prepareRequestBody prepares the request body for the Llama API
 func (p *Provider) prepareRequestBody(prompt *core.Prompt) ([]byte, error) {
+	// The completion endpoint has no notion of tools
+	if len(prompt.Tools) > 0 {
+		return nil, errors.New("tool calling is not supported by the llama provider")
+	}
+	
+	// The completion endpoint takes a single prompt string, so a conversation
+	// history is rendered as a transcript
+	var system []string
+	if prompt.SystemMessage != "" {
+		system = append(system, prompt.SystemMessage)
+	}
+	
+	// The completion endpoint only takes text
+	conversation := prompt.Conversation()
+	for i, msg := range conversation {
+		text, err := messageText(msg)
+		if err != nil {
+			return nil, err
+		}
+		conversation[i].Content = text
+	}
+	
+	text := prompt.Text
+	if len(prompt.Messages) > 0 {
+		var messages []core.Message
+		for _, msg := range conversation {
+			if msg.Role == core.RoleSystem {
+				system = append(system, msg.Content)
+				continue
+			}
+			messages = append(messages, msg)
+		}
+		text = formatConversation(messages)
+	} else if len(conversation) > 0 {
+		text = conversation[0].Content
+	}
+	
 	// Create the request body
 	reqBody := completionRequest{
 		Model:       p.config.Model,
-		Prompt:      prompt.Text,
+		Prompt:      text,
 		MaxTokens:   prompt.MaxTokens,
 		Temperature: prompt.Temperature,
 		TopP:        prompt.TopP,
@@ -205,71 +275,141 @@ func (p *Provider) prepareRequestBody(prompt *core.Prompt) ([]byte, error) {
 	}
 	
 	// Add system message if provided
-	if prompt.SystemMessage != "" {
-		reqBody.SystemPrompt = prompt.SystemMessage
+	if len(system) > 0 {
+		reqBody.SystemPrompt = strings.Join(system, "\n\n")
 	}
 	
-	// Add schema if provided
-	if prompt.Schema != nil {
-		// In a real implementation, this would set the response_format to JSON
-		// and include the schema
-		// For simplicity, we're not implementing this
+	// Constrain the output with a GBNF grammar or the schema if provided.
+	// The server converts JSON schemas to grammars itself.
+	if grammar, ok := prompt.AdditionalParams[GrammarParam].(string); ok && grammar != "" {
+		reqBody.Grammar = grammar
+	} else if prompt.Schema != nil {
+		schema, err := prompt.OutputSchemaJSON()
+		if err != nil {
+			return nil, err
+		}
+		reqBody.JSONSchema = schema
 	}
 	
-	// Add additional parameters
-	// For simplicity, we're not implementing this
+	data, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
 	
-	return json.Marshal(reqBody)
+	// Merge the additional parameters into the request
+	return core.ApplyParams("llama", data, prompt.AdditionalParams)
+}
+
+// GrammarParam is the key of a GBNF grammar in Prompt.AdditionalParams,
+// which constrains the output instead of the schema
+const GrammarParam = "grammar"
+
+// messageText returns the text of a message, including its text parts. Other
+// parts are not supported.
+func messageText(msg core.Message) (string, error) {
+	var texts []string
+	for _, part := range core.MessageParts(msg) {
+		if part.Type != core.ContentText {
+			return "", core.NewUnsupportedError("llama", fmt.Sprintf("%s input", part.Type))
+		}
+		texts = append(texts, part.Text)
+	}
+	return strings.Join(texts, "\n\n"), nil
+}
+
+// formatConversation renders a conversation as a plain-text transcript that
+// ends with an open assistant turn
+func formatConversation(messages []core.Message) string {
+	var sb strings.Builder
+	for _, msg := range messages {
+		switch msg.Role {
+		case core.RoleAssistant:
+			sb.WriteString("Assistant: ")
+		case core.RoleTool:
+			sb.WriteString("Tool: ")
+		default:
+			sb.WriteString("User: ")
+		}
+		sb.WriteString(msg.Content)
+		sb.WriteString("\n\n")
+	}
+	sb.WriteString("Assistant:")
+	return sb.String()
 }
 
 // llamaStream implements core.ResponseStream for Llama
 type llamaStream struct {
-	reader io.ReadCloser
-	buffer []byte
+	reader  io.ReadCloser
+	decoder *sse.Decoder
+	model   string
+	catalog *core.ModelCatalog
 }
 
 // Next returns the next chunk of the response
 func (s *llamaStream) Next() (*core.ResponseChunk, error) {
-	// Read the next line
-	line, err := s.readLine()
+	// Read the next event
+	event, err := s.decoder.Next()
 	if err != nil {
 		if err == io.EOF {
 			return nil, io.EOF
 		}
-		return nil, fmt.Errorf("failed to read line: %w", err)
-	}
-	
-	// Skip empty lines
-	if len(line) == 0 {
-		return s.Next()
+		return nil, fmt.Errorf("failed to read stream: %w", err)
 	}
 	
-	// Skip lines that don't start with "data: "
-	if len(line) < 6 || string(line[:6]) != "data: " {
-		return s.Next()
-	}
-	
-	// Handle the "data: [DONE]" message
-	if string(line) == "data: [DONE]" {
+	// Handle the "[DONE]" message
+	if event.Data == "[DONE]" {
 		return nil, io.EOF
 	}
 	
 	// Parse the JSON
 	var streamResp completionStreamResponse
-	if err := json.Unmarshal(line[6:], &streamResp); err != nil {
+	if err := json.Unmarshal([]byte(event.Data), &streamResp); err != nil {
 		return nil, fmt.Errorf("failed to parse stream response: %w", err)
 	}
 	
+	// Errors that occur after the response has started are sent as events
+	if len(streamResp.Error) > 0 && string(streamResp.Error) != "null" {
+		return nil, core.NewStreamError("llama", []byte(event.Data))
+	}
+	
 	// Create a response chunk
+	model := streamResp.Model
+	if model == "" {
+		model = s.model
+	}
 	chunk := &core.ResponseChunk{
 		Text:    streamResp.Content,
 		IsFinal: false,
+		ModelInfo: &core.ModelInfo{
+			Name:     model,
+			Provider: "llama",
+			Version:  s.catalog.Version("llama", model),
+		},
+		ProviderInfo: &core.ProviderInfo{
+			Name:    "llama",
+			Version: "1.0.0",
+		},
 	}
 	
-	// Check if this is the final chunk
-	if streamResp.StopReason != "" {
+	// The usage is sent with the last chunk
+	if streamResp.TotalTokens > 0 || streamResp.PromptTokens > 0 || streamResp.CompletionTokens > 0 {
+		chunk.TokensUsed = &core.TokenUsage{
+			Prompt:     streamResp.PromptTokens,
+			Completion: streamResp.CompletionTokens,
+			Total:      streamResp.TotalTokens,
+		}
+		if chunk.TokensUsed.Total == 0 {
+			chunk.TokensUsed.Total = streamResp.PromptTokens + streamResp.CompletionTokens
+		}
+	}
+	
+	// Check if this is the final chunk. llama.cpp only sets the stop flag.
+	if streamResp.StopReason != "" || streamResp.Stop {
 		chunk.IsFinal = true
 		chunk.FinishReason = streamResp.StopReason
+		if chunk.FinishReason == "" {
+			chunk.FinishReason = "stop"
+		}
 	}
 	
 	return chunk, nil
@@ -280,48 +420,17 @@ func (s *llamaStream) Close() error {
 	return s.reader.Close()
 }
 
-// readLine reads a line from the stream
-func (s *llamaStream) readLine() ([]byte, error) {
-	var line []byte
-	
-	for {
-		// If we have data in the buffer, try to find a newline
-		if len(s.buffer) > 0 {
-			i := bytes.IndexByte(s.buffer, '\n')
-			if i >= 0 {
-				line = s.buffer[:i]
-				s.buffer = s.buffer[i+1:]
-				return line, nil
-			}
-		}
-		
-		// Read more data
-		buf := make([]byte, 1024)
-		n, err := s.reader.Read(buf)
-		if err != nil {
-			if err == io.EOF && len(s.buffer) > 0 {
-				// Return the remaining data
-				line = s.buffer
-				s.buffer = nil
-				return line, nil
-			}
-			return nil, err
-		}
-		
-		// Append to buffer
-		s.buffer = append(s.buffer, buf[:n]...)
-	}
-}
-
 // completionRequest represents a request to the completion API
 type completionRequest struct {
-	Model        string    `json:"model"`
-	Prompt       string    `json:"prompt"`
-	SystemPrompt string    `json:"system_prompt,omitempty"`
-	MaxTokens    int       `json:"max_tokens,omitempty"`
-	Temperature  float64   `json:"temperature,omitempty"`
-	TopP         float64   `json:"top_p,omitempty"`
-	Stop         []string  `json:"stop,omitempty"`
+	Model        string          `json:"model"`
+	Prompt       string          `json:"prompt"`
+	SystemPrompt string          `json:"system_prompt,omitempty"`
+	MaxTokens    int             `json:"max_tokens,omitempty"`
+	Temperature  float64         `json:"temperature,omitempty"`
+	TopP         float64         `json:"top_p,omitempty"`
+	Stop         []string        `json:"stop,omitempty"`
+	JSONSchema   json.RawMessage `json:"json_schema,omitempty"`
+	Grammar      string          `json:"grammar,omitempty"`
 }
 
 // completionResponse represents a response from the completion API
@@ -336,7 +445,12 @@ type completionResponse struct {
 
 // completionStreamResponse represents a streaming response from the completion API
 type completionStreamResponse struct {
-	Model      string `json:"model"`
-	Content    string `json:"content"`
-	StopReason string `json:"stop_reason,omitempty"`
+	Model            string          `json:"model"`
+	Content          string          `json:"content"`
+	StopReason       string          `json:"stop_reason,omitempty"`
+	Stop             bool            `json:"stop,omitempty"`
+	PromptTokens     int             `json:"prompt_tokens,omitempty"`
+	CompletionTokens int             `json:"completion_tokens,omitempty"`
+	TotalTokens      int             `json:"total_tokens,omitempty"`
+	Error            json.RawMessage `json:"error,omitempty"`
 }
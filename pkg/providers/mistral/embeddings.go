@@ -0,0 +1,115 @@
+package mistral
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+)
+
+// maxEmbeddingInputs is the number of texts per embeddings request, which
+// keeps requests under the token limit of the API
+const maxEmbeddingInputs = 128
+
+// Embed generates the embeddings of the texts of the request, in batches
+// if needed. The input type is ignored, as Mistral models do not use it.
+func (p *Provider) Embed(ctx context.Context, request *core.EmbeddingRequest) (*core.EmbeddingResponse, error) {
+	return core.EmbedBatches(ctx, request, maxEmbeddingInputs, p.embed)
+}
+
+// embed generates the embeddings of a single batch of texts
+func (p *Provider) embed(ctx context.Context, request *core.EmbeddingRequest) (*core.EmbeddingResponse, error) {
+	model := request.Model
+	if model == "" {
+		model = p.config.EmbeddingModel
+	}
+
+	reqBody, err := json.Marshal(embeddingRequest{
+		Model:           model,
+		Input:           request.Texts,
+		OutputDimension: request.Dimensions,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	// Create the request
+	req, err := http.NewRequestWithContext(
+		ctx,
+		"POST",
+		fmt.Sprintf("%s/embeddings", p.config.Endpoint),
+		bytes.NewBuffer(reqBody),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set headers
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.config.APIKey))
+
+	// Send the request
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, core.NewNetworkError("mistral", err)
+	}
+	defer resp.Body.Close()
+
+	// Check for errors
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, core.NewProviderError("mistral", resp, body)
+	}
+
+	// Parse the response
+	var embeddingResp embeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embeddingResp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	// The embeddings are identified by the index of their input
+	embeddings := make([][]float32, len(request.Texts))
+	for _, data := range embeddingResp.Data {
+		if data.Index < 0 || data.Index >= len(embeddings) {
+			return nil, fmt.Errorf("embedding index %d out of range", data.Index)
+		}
+		embeddings[data.Index] = data.Embedding
+	}
+	for i, embedding := range embeddings {
+		if embedding == nil {
+			return nil, fmt.Errorf("no embedding returned for input %d", i)
+		}
+	}
+
+	return &core.EmbeddingResponse{
+		Embeddings: embeddings,
+		Model:      model,
+		TokensUsed: &core.TokenUsage{
+			Prompt: embeddingResp.Usage.PromptTokens,
+			Total:  embeddingResp.Usage.TotalTokens,
+		},
+	}, nil
+}
+
+// embeddingRequest represents a request to the embeddings API
+type embeddingRequest struct {
+	Model           string   `json:"model"`
+	Input           []string `json:"input"`
+	OutputDimension int      `json:"output_dimension,omitempty"`
+}
+
+// embeddingResponse represents a response from the embeddings API
+type embeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Usage struct {
+		PromptTokens int `json:"prompt_tokens"`
+		TotalTokens  int `json:"total_tokens"`
+	} `json:"usage"`
+}
@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/streaming/sse"
+	"github.com/GeoloeG-IsT/gollem/pkg/validation"
 )
 
 // Provider implements the core.LLMProvider interface for Mistral AI
@@ -27,11 +29,19 @@ type Config struct {
 	// Model is the model to use (e.g., "mistral-medium", "mistral-large")
 	Model string `json:"model"`
 	
+	// EmbeddingModel is the model used for embeddings (optional, defaults
+	// to "mistral-embed")
+	EmbeddingModel string `json:"embedding_model,omitempty"`
+	
 	// Endpoint is the API endpoint (optional, defaults to Mistral's API)
 	Endpoint string `json:"endpoint,omitempty"`
 	
 	// Timeout is the request timeout in seconds
 	Timeout int `json:"timeout,omitempty"`
+	
+	// Catalog is the model catalog the capabilities of the models are looked
+	// up in (optional, defaults to core.DefaultCatalog())
+	Catalog *core.ModelCatalog `json:"-"`
 }
 
 // NewProvider creates a new Mistral provider
@@ -44,6 +54,10 @@ func NewProvider(config Config) (*Provider, error) {
 		config.Model = "mistral-medium"
 	}
 	
+	if config.EmbeddingModel == "" {
+		config.EmbeddingModel = "mistral-embed"
+	}
+	
 	if config.Endpoint == "" {
 		config.Endpoint = "https://api.mistral.ai/v1"
 	}
@@ -52,6 +66,10 @@ func NewProvider(config Config) (*Provider, error) {
 		config.Timeout = 30
 	}
 	
+	if config.Catalog == nil {
+		config.Catalog = core.DefaultCatalog()
+	}
+	
 	client := &http.Client{
 		Timeout: time.Duration(config.Timeout) * time.Second,
 	}
@@ -67,6 +85,16 @@ func (p *Provider) Name() string {
 	return "mistral"
 }
 
+// Capabilities returns the capabilities of the model from the model catalog
+func (p *Provider) Capabilities() (core.ModelCapabilities, bool) {
+	return p.config.Catalog.Lookup("mistral", p.config.Model)
+}
+
+// Model returns the name of the model
+func (p *Provider) Model() string {
+	return p.config.Model
+}
+
 // Generate generates a response for the given prompt
 func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
 	// Prepare the request
@@ -93,14 +121,14 @@ func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Res
 	// Send the request
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, core.NewNetworkError("mistral", err)
 	}
 	defer resp.Body.Close()
 	
 	// Check for errors
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, core.NewProviderError("mistral", resp, body)
 	}
 	
 	// Parse the response
@@ -109,8 +137,14 @@ func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Res
 		return nil, fmt.Errorf("failed to parse response: %w", err)
 	}
 	
+	// Check if choices array is empty
+	if len(mistralResp.Choices) == 0 {
+		return nil, fmt.Errorf("no choices returned in response")
+	}
+	
 	// Convert to core.Response
 	response := &core.Response{
+		ID:   mistralResp.ID,
 		Text: mistralResp.Choices[0].Message.Content,
 		TokensUsed: &core.TokenUsage{
 			Prompt:     mistralResp.Usage.PromptTokens,
@@ -121,7 +155,7 @@ func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Res
 		ModelInfo: &core.ModelInfo{
 			Name:     p.config.Model,
 			Provider: "mistral",
-			Version:  "1.0.0",
+			Version:  p.config.Catalog.Version("mistral", p.config.Model),
 		},
 		ProviderInfo: &core.ProviderInfo{
 			Name:    "mistral",
@@ -129,11 +163,23 @@ func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Res
 		},
 	}
 	
-	// Handle structured output if a schema was provided
-	if prompt.Schema != nil {
-		// In a real implementation, this would parse the JSON and validate it against the schema
-		// For simplicity, we're just setting it to nil
-		response.StructuredOutput = nil
+	// Convert the tool calls
+	for i, call := range mistralResp.Choices[0].Message.ToolCalls {
+		response.ToolCalls = append(response.ToolCalls, core.ToolCall{
+			Index:     i,
+			ID:        call.ID,
+			Name:      call.Function.Name,
+			Arguments: call.Function.Arguments,
+		})
+	}
+	
+	// Parse the structured output if a schema was provided
+	if prompt.Schema != nil && len(response.ToolCalls) == 0 {
+		output, err := core.ParseStructuredOutput("mistral", prompt, response.Text)
+		if err != nil {
+			return response, err
+		}
+		response.StructuredOutput = output
 	}
 	
 	return response, nil
@@ -176,38 +222,85 @@ func (p *Provider) GenerateStream(ctx context.Context, prompt *core.Prompt) (cor
 	// Send the request
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, core.NewNetworkError("mistral", err)
 	}
 	
 	// Check for errors
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, core.NewProviderError("mistral", resp, body)
 	}
 	
 	// Create a stream
 	return &mistralStream{
-		reader: resp.Body,
+		reader:  resp.Body,
+		decoder: sse.NewDecoder(resp.Body),
+		model:   p.config.Model,
+		catalog: p.config.Catalog,
 	}, nil
 }
 
 // prepareRequestBody prepares the request body for the Mistral API
 func (p *Provider) prepareRequestBody(prompt *core.Prompt) ([]byte, error) {
-	messages := []chatMessage{
-		{
-			Role:    "user",
-			Content: prompt.Text,
-		},
+	conversation := prompt.Conversation()
+	messages := make([]chatMessage, 0, len(conversation)+1)
+	
+	// JSON mode does not take a schema, so the model is given the schema in
+	// the system message
+	system := prompt.SystemMessage
+	if prompt.Schema != nil {
+		schema, err := prompt.OutputSchemaJSON()
+		if err != nil {
+			return nil, err
+		}
+		instructions := fmt.Sprintf("Respond with a JSON object that conforms to the following JSON schema:\n%s", schema)
+		if system != "" {
+			system += "\n\n"
+		}
+		system += instructions
 	}
 	
-	if prompt.SystemMessage != "" {
-		messages = append([]chatMessage{
-			{
-				Role:    "system",
-				Content: prompt.SystemMessage,
-			},
-		}, messages...)
+	if system != "" {
+		messages = append(messages, chatMessage{
+			Role:    "system",
+			Content: system,
+		})
+	}
+	
+	for _, msg := range conversation {
+		chatMsg := chatMessage{
+			Role:    string(msg.Role),
+			Content: msg.Content,
+			Name:    msg.Name,
+		}
+		
+		// Tool results are identified by the ID of the call they answer
+		if msg.Role == core.RoleTool {
+			chatMsg.ToolCallID = msg.ToolCallID
+		}
+		
+		// Multimodal messages are sent as a list of content parts
+		if len(msg.Parts) > 0 {
+			parts, err := contentParts(msg)
+			if err != nil {
+				return nil, err
+			}
+			chatMsg.Content = parts
+		}
+		
+		for _, call := range msg.ToolCalls {
+			chatMsg.ToolCalls = append(chatMsg.ToolCalls, toolCall{
+				ID:   call.ID,
+				Type: "function",
+				Function: functionCall{
+					Name:      call.Name,
+					Arguments: call.Arguments,
+				},
+			})
+		}
+		
+		messages = append(messages, chatMsg)
 	}
 	
 	reqBody := chatCompletionRequest{
@@ -219,70 +312,146 @@ func (p *Provider) prepareRequestBody(prompt *core.Prompt) ([]byte, error) {
 		Stop:             prompt.StopSequences,
 	}
 	
-	// Add schema if provided
+	// Add tools if provided
+	for _, tool := range prompt.Tools {
+		reqBody.Tools = append(reqBody.Tools, toolDefinition{
+			Type: "function",
+			Function: functionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.ParameterSchema(),
+			},
+		})
+	}
+	reqBody.ToolChoice = toolChoice(prompt.ToolChoice)
+	
+	// Enable JSON mode if a schema was provided
 	if prompt.Schema != nil {
-		// In a real implementation, this would set the response_format to JSON
-		// and include the schema
-		// For simplicity, we're not implementing this
+		reqBody.ResponseFormat = &responseFormat{Type: "json_object"}
 	}
 	
-	// Add additional parameters
-	// For simplicity, we're not implementing this
+	data, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
 	
-	return json.Marshal(reqBody)
+	// Merge the additional parameters into the request
+	return core.ApplyParams("mistral", data, prompt.AdditionalParams)
+}
+
+// toolChoice converts a core tool choice to the Mistral format, which names
+// the required mode "any"
+func toolChoice(choice string) interface{} {
+	switch choice {
+	case "":
+		return nil
+	case core.ToolChoiceRequired:
+		return "any"
+	case core.ToolChoiceAuto, core.ToolChoiceNone:
+		return choice
+	default:
+		return map[string]interface{}{
+			"type": "function",
+			"function": map[string]string{
+				"name": choice,
+			},
+		}
+	}
 }
 
 // mistralStream implements core.ResponseStream for Mistral
 type mistralStream struct {
-	reader io.ReadCloser
-	buffer []byte
+	reader  io.ReadCloser
+	decoder *sse.Decoder
+	model   string
+	catalog *core.ModelCatalog
 }
 
 // Next returns the next chunk of the response
 func (s *mistralStream) Next() (*core.ResponseChunk, error) {
-	// Read the next line
-	line, err := s.readLine()
-	if err != nil {
-		if err == io.EOF {
+	for {
+		// Read the next event
+		event, err := s.decoder.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("failed to read stream: %w", err)
+		}
+		
+		// Handle the "[DONE]" message
+		if event.Data == "[DONE]" {
 			return nil, io.EOF
 		}
-		return nil, fmt.Errorf("failed to read line: %w", err)
-	}
-	
-	// Skip empty lines
-	if len(line) == 0 {
-		return s.Next()
-	}
-	
-	// Skip lines that don't start with "data: "
-	if len(line) < 6 || string(line[:6]) != "data: " {
-		return s.Next()
-	}
-	
-	// Handle the "data: [DONE]" message
-	if string(line) == "data: [DONE]" {
-		return nil, io.EOF
-	}
-	
-	// Parse the JSON
-	var streamResp chatCompletionStreamResponse
-	if err := json.Unmarshal(line[6:], &streamResp); err != nil {
-		return nil, fmt.Errorf("failed to parse stream response: %w", err)
-	}
-	
-	// Create a response chunk
-	chunk := &core.ResponseChunk{
-		Text:    streamResp.Choices[0].Delta.Content,
-		IsFinal: false,
-	}
-	
-	// Check if this is the final chunk
-	if streamResp.Choices[0].FinishReason != "" {
-		chunk.IsFinal = true
-		chunk.FinishReason = streamResp.Choices[0].FinishReason
+		
+		// Parse the JSON
+		var streamResp chatCompletionStreamResponse
+		if err := json.Unmarshal([]byte(event.Data), &streamResp); err != nil {
+			return nil, fmt.Errorf("failed to parse stream response: %w", err)
+		}
+		
+		// Errors that occur after the response has started are sent as events
+		if streamResp.Object == "error" || (len(streamResp.Error) > 0 && string(streamResp.Error) != "null") {
+			return nil, core.NewStreamError("mistral", []byte(event.Data))
+		}
+		
+		// Skip chunks without choices
+		if len(streamResp.Choices) == 0 {
+			continue
+		}
+		
+		model := streamResp.Model
+		if model == "" {
+			model = s.model
+		}
+		
+		// Create a response chunk
+		chunk := &core.ResponseChunk{
+			ID:      streamResp.ID,
+			Text:    streamResp.Choices[0].Delta.Content,
+			IsFinal: false,
+			ModelInfo: &core.ModelInfo{
+				Name:     model,
+				Provider: "mistral",
+				Version:  s.catalog.Version("mistral", model),
+			},
+			ProviderInfo: &core.ProviderInfo{
+				Name:    "mistral",
+				Version: "1.0.0",
+			},
+		}
+		
+		// The usage is sent with the last chunk
+		if streamResp.Usage != nil {
+			chunk.TokensUsed = &core.TokenUsage{
+				Prompt:     streamResp.Usage.PromptTokens,
+				Completion: streamResp.Usage.CompletionTokens,
+				Total:      streamResp.Usage.TotalTokens,
+			}
+		}
+		
+		// Add tool calls, which Mistral streams whole
+		for i, call := range streamResp.Choices[0].Delta.ToolCalls {
+			index := i
+			if call.Index != nil {
+				index = *call.Index
+			}
+			chunk.ToolCalls = append(chunk.ToolCalls, core.ToolCall{
+				Index:     index,
+				ID:        call.ID,
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+			})
+		}
+		
+		// Check if this is the final chunk
+		if streamResp.Choices[0].FinishReason != "" {
+			chunk.IsFinal = true
+			chunk.FinishReason = streamResp.Choices[0].FinishReason
+		}
+		
+		return chunk, nil
 	}
-	
-	return chunk, nil
 }
 
 // Close closes the stream
@@ -290,55 +459,94 @@ func (s *mistralStream) Close() error {
 	return s.reader.Close()
 }
 
-// readLine reads a line from the stream
-func (s *mistralStream) readLine() ([]byte, error) {
-	var line []byte
-	
-	for {
-		// If we have data in the buffer, try to find a newline
-		if len(s.buffer) > 0 {
-			i := bytes.IndexByte(s.buffer, '\n')
-			if i >= 0 {
-				line = s.buffer[:i]
-				s.buffer = s.buffer[i+1:]
-				return line, nil
-			}
-		}
-		
-		// Read more data
-		buf := make([]byte, 1024)
-		n, err := s.reader.Read(buf)
-		if err != nil {
-			if err == io.EOF && len(s.buffer) > 0 {
-				// Return the remaining data
-				line = s.buffer
-				s.buffer = nil
-				return line, nil
+// contentParts converts the content of a multimodal message to content
+// parts. Mistral accepts images, and documents by URL.
+func contentParts(msg core.Message) ([]contentPart, error) {
+	var parts []contentPart
+	for _, part := range core.MessageParts(msg) {
+		switch part.Type {
+		case core.ContentText:
+			parts = append(parts, contentPart{Type: "text", Text: part.Text})
+		case core.ContentImage:
+			parts = append(parts, contentPart{Type: "image_url", ImageURL: part.DataURL()})
+		case core.ContentFile:
+			if part.IsInline() {
+				return nil, core.NewUnsupportedError("mistral", "inline files")
 			}
-			return nil, err
+			parts = append(parts, contentPart{Type: "document_url", DocumentURL: part.URL, DocumentName: part.Filename})
+		case core.ContentAudio:
+			return nil, core.NewUnsupportedError("mistral", "audio input")
+		default:
+			return nil, fmt.Errorf("unknown content type %q", part.Type)
 		}
-		
-		// Append to buffer
-		s.buffer = append(s.buffer, buf[:n]...)
 	}
+	return parts, nil
 }
 
-// chatMessage represents a message in a chat completion request
+// chatMessage represents a message in a chat completion request. The
+// content is either a string or a list of content parts.
 type chatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string      `json:"role"`
+	Content    interface{} `json:"content"`
+	Name       string      `json:"name,omitempty"`
+	ToolCalls  []toolCall  `json:"tool_calls,omitempty"`
+	ToolCallID string      `json:"tool_call_id,omitempty"`
+}
+
+// contentPart represents a part of the content of a multimodal message
+type contentPart struct {
+	Type         string `json:"type"`
+	Text         string `json:"text,omitempty"`
+	ImageURL     string `json:"image_url,omitempty"`
+	DocumentURL  string `json:"document_url,omitempty"`
+	DocumentName string `json:"document_name,omitempty"`
+}
+
+// toolCall represents a tool call in a chat message
+type toolCall struct {
+	Index    *int         `json:"index,omitempty"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function functionCall `json:"function"`
+}
+
+// functionCall represents the function invoked by a tool call
+type functionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// toolDefinition represents a tool in a chat completion request
+type toolDefinition struct {
+	Type     string             `json:"type"`
+	Function functionDefinition `json:"function"`
+}
+
+// functionDefinition describes a function the model may call
+type functionDefinition struct {
+	Name        string                `json:"name"`
+	Description string                `json:"description,omitempty"`
+	Parameters  validation.JSONSchema `json:"parameters"`
 }
 
 // chatCompletionRequest represents a request to the chat completions API
 type chatCompletionRequest struct {
-	Model            string        `json:"model"`
-	Messages         []chatMessage `json:"messages"`
-	Temperature      float64       `json:"temperature,omitempty"`
-	MaxTokens        int           `json:"max_tokens,omitempty"`
-	TopP             float64       `json:"top_p,omitempty"`
-	FrequencyPenalty float64       `json:"frequency_penalty,omitempty"`
-	PresencePenalty  float64       `json:"presence_penalty,omitempty"`
-	Stop             []string      `json:"stop,omitempty"`
+	Model            string           `json:"model"`
+	Messages         []chatMessage    `json:"messages"`
+	Temperature      float64          `json:"temperature,omitempty"`
+	MaxTokens        int              `json:"max_tokens,omitempty"`
+	TopP             float64          `json:"top_p,omitempty"`
+	FrequencyPenalty float64          `json:"frequency_penalty,omitempty"`
+	PresencePenalty  float64          `json:"presence_penalty,omitempty"`
+	Stop             []string         `json:"stop,omitempty"`
+	Tools            []toolDefinition `json:"tools,omitempty"`
+	ToolChoice       interface{}      `json:"tool_choice,omitempty"`
+	ResponseFormat   *responseFormat  `json:"response_format,omitempty"`
+}
+
+// responseFormat represents the format of the model output
+type responseFormat struct {
+	Type string `json:"type"`
 }
 
 // chatCompletionResponse represents a response from the chat completions API
@@ -350,8 +558,9 @@ type chatCompletionResponse struct {
 	Choices []struct {
 		Index        int `json:"index"`
 		Message      struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
+			Role      string     `json:"role"`
+			Content   string     `json:"content"`
+			ToolCalls []toolCall `json:"tool_calls,omitempty"`
 		} `json:"message"`
 		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
@@ -371,9 +580,16 @@ type chatCompletionStreamResponse struct {
 	Choices []struct {
 		Index        int `json:"index"`
 		Delta        struct {
-			Role    string `json:"role,omitempty"`
-			Content string `json:"content,omitempty"`
+			Role      string     `json:"role,omitempty"`
+			Content   string     `json:"content,omitempty"`
+			ToolCalls []toolCall `json:"tool_calls,omitempty"`
 		} `json:"delta"`
 		FinishReason string `json:"finish_reason,omitempty"`
 	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+		TotalTokens      int `json:"total_tokens"`
+	} `json:"usage,omitempty"`
+	Error json.RawMessage `json:"error,omitempty"`
 }
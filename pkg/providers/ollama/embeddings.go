@@ -0,0 +1,77 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+)
+
+// maxEmbeddingInputs is the number of texts per embed request, which keeps
+// requests to local servers reasonably small
+const maxEmbeddingInputs = 512
+
+// Embed generates the embeddings of the texts of the request, in batches
+// if needed. The input type is ignored, as Ollama does not support it.
+func (p *Provider) Embed(ctx context.Context, request *core.EmbeddingRequest) (*core.EmbeddingResponse, error) {
+	return core.EmbedBatches(ctx, request, maxEmbeddingInputs, p.embed)
+}
+
+// embed generates the embeddings of a single batch of texts
+func (p *Provider) embed(ctx context.Context, request *core.EmbeddingRequest) (*core.EmbeddingResponse, error) {
+	model := request.Model
+	if model == "" {
+		model = p.config.EmbeddingModel
+	}
+
+	reqBody, err := json.Marshal(embedRequest{
+		Model:      model,
+		Input:      request.Texts,
+		Dimensions: request.Dimensions,
+		KeepAlive:  p.config.KeepAlive,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	resp, err := p.post(ctx, "/api/embed", reqBody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Parse the response
+	var embedResp embedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embedResp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	if len(embedResp.Embeddings) != len(request.Texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(request.Texts), len(embedResp.Embeddings))
+	}
+
+	return &core.EmbeddingResponse{
+		Embeddings: embedResp.Embeddings,
+		Model:      model,
+		TokensUsed: &core.TokenUsage{
+			Prompt: embedResp.PromptEvalCount,
+			Total:  embedResp.PromptEvalCount,
+		},
+	}, nil
+}
+
+// embedRequest represents a request to the embed API
+type embedRequest struct {
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int      `json:"dimensions,omitempty"`
+	KeepAlive  string   `json:"keep_alive,omitempty"`
+}
+
+// embedResponse represents a response from the embed API
+type embedResponse struct {
+	Model           string      `json:"model"`
+	Embeddings      [][]float32 `json:"embeddings"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
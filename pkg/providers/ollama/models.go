@@ -0,0 +1,161 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/streaming/sse"
+)
+
+// Model describes a model available on the Ollama server
+type Model struct {
+	// Name is the name of the model, including its tag
+	Name string `json:"name"`
+
+	// ModifiedAt is when the model was last modified
+	ModifiedAt time.Time `json:"modified_at"`
+
+	// Size is the size of the model in bytes
+	Size int64 `json:"size"`
+
+	// Digest is the digest of the model
+	Digest string `json:"digest"`
+
+	// Details describes the format and architecture of the model
+	Details ModelDetails `json:"details"`
+}
+
+// ModelDetails describes the format and architecture of a model
+type ModelDetails struct {
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+// PullStatus reports the progress of a model download
+type PullStatus struct {
+	// Status describes the current step, such as "pulling manifest" or
+	// "success"
+	Status string `json:"status"`
+
+	// Digest is the digest of the layer being downloaded
+	Digest string `json:"digest,omitempty"`
+
+	// Total is the size of the layer in bytes
+	Total int64 `json:"total,omitempty"`
+
+	// Completed is the number of bytes of the layer downloaded so far
+	Completed int64 `json:"completed,omitempty"`
+}
+
+// ListModels returns the models available on the server
+func (p *Provider) ListModels(ctx context.Context) ([]Model, error) {
+	req, err := p.newRequest(ctx, "GET", "/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.do(p.client, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tagsResp struct {
+		Models []Model `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tagsResp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return tagsResp.Models, nil
+}
+
+// HasModel returns whether a model has been pulled to the server. A name
+// without a tag refers to the "latest" tag.
+func (p *Provider) HasModel(ctx context.Context, name string) (bool, error) {
+	models, err := p.ListModels(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	name = withTag(name)
+	for _, model := range models {
+		if withTag(model.Name) == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Pull downloads a model to the server, calling progress, if not nil, with
+// each status update. It returns once the download has completed.
+func (p *Provider) Pull(ctx context.Context, name string, progress func(PullStatus)) error {
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"model":  name,
+		"stream": true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	req, err := p.newRequest(ctx, "POST", "/api/pull", reqBody)
+	if err != nil {
+		return err
+	}
+
+	// Downloads usually outlast the request timeout, so they are only
+	// limited by the context
+	client := &http.Client{Transport: p.client.Transport}
+	resp, err := p.do(client, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := sse.NewNDJSONDecoder(resp.Body)
+	for {
+		line, err := decoder.Next()
+		if err == io.EOF {
+			return errors.New("pull ended before completing")
+		}
+		if err != nil {
+			return core.NewNetworkError("ollama", err)
+		}
+
+		var status struct {
+			PullStatus
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(line, &status); err != nil {
+			return fmt.Errorf("failed to parse pull status: %w", err)
+		}
+		if status.Error != "" {
+			return core.NewStreamError("ollama", line)
+		}
+
+		if progress != nil {
+			progress(status.PullStatus)
+		}
+		if status.Status == "success" {
+			return nil
+		}
+	}
+}
+
+// withTag adds the default tag to a model name without one
+func withTag(name string) string {
+	if strings.Contains(name[strings.LastIndex(name, "/")+1:], ":") {
+		return name
+	}
+	return name + ":latest"
+}
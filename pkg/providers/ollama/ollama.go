@@ -0,0 +1,662 @@
+// Package ollama implements a provider for the native API of Ollama
+package ollama
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/streaming/sse"
+	"github.com/GeoloeG-IsT/gollem/pkg/validation"
+)
+
+// Provider implements the core.LLMProvider interface for Ollama
+type Provider struct {
+	config Config
+	client *http.Client
+}
+
+// Config contains the configuration for the Ollama provider
+type Config struct {
+	// Endpoint is the URL of the Ollama server (optional, defaults to
+	// "http://localhost:11434")
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Model is the model to use, such as "llama3.1" or "qwen2.5:7b"
+	Model string `json:"model"`
+
+	// EmbeddingModel is the model used for embeddings (optional, defaults
+	// to Model)
+	EmbeddingModel string `json:"embedding_model,omitempty"`
+
+	// APIKey is sent as a bearer token, for servers behind an authenticating
+	// proxy (optional)
+	APIKey string `json:"api_key,omitempty"`
+
+	// KeepAlive is how long the model stays loaded after a request, such as
+	// "10m", "0" to unload it immediately or "-1m" to keep it loaded
+	// (optional, defaults to the server setting)
+	KeepAlive string `json:"keep_alive,omitempty"`
+
+	// Options are the default model options, such as num_ctx or seed. The
+	// sampling parameters of the prompt take precedence.
+	Options map[string]interface{} `json:"options,omitempty"`
+
+	// UseGenerate sends prompts to /api/generate instead of /api/chat. The
+	// generate endpoint does not support tools.
+	UseGenerate bool `json:"use_generate,omitempty"`
+
+	// Timeout is the request timeout in seconds
+	Timeout int `json:"timeout,omitempty"`
+
+	// Catalog is the model catalog the capabilities of the models are looked
+	// up in (optional, defaults to core.DefaultCatalog())
+	Catalog *core.ModelCatalog `json:"-"`
+}
+
+// NewProvider creates a new Ollama provider
+func NewProvider(config Config) (*Provider, error) {
+	if config.Model == "" {
+		return nil, errors.New("model is required")
+	}
+
+	if config.Endpoint == "" {
+		config.Endpoint = "http://localhost:11434"
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+
+	if config.EmbeddingModel == "" {
+		config.EmbeddingModel = config.Model
+	}
+
+	// Loading a local model into memory may take a while
+	if config.Timeout == 0 {
+		config.Timeout = 120
+	}
+
+	if config.Catalog == nil {
+		config.Catalog = core.DefaultCatalog()
+	}
+
+	client := &http.Client{
+		Timeout: time.Duration(config.Timeout) * time.Second,
+	}
+
+	return &Provider{
+		config: config,
+		client: client,
+	}, nil
+}
+
+// Name returns the name of the provider
+func (p *Provider) Name() string {
+	return "ollama"
+}
+
+// Capabilities returns the capabilities of the model from the model catalog
+func (p *Provider) Capabilities() (core.ModelCapabilities, bool) {
+	return p.config.Catalog.Lookup("ollama", p.config.Model)
+}
+
+// Model returns the name of the model
+func (p *Provider) Model() string {
+	return p.config.Model
+}
+
+// Generate generates a response for the given prompt
+func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
+	path, reqBody, err := p.prepareRequestBody(prompt, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare request body: %w", err)
+	}
+
+	resp, err := p.post(ctx, path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Parse the response
+	var ollamaResp chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	// Convert to core.Response
+	response := &core.Response{
+		Text:         ollamaResp.text(),
+		TokensUsed:   ollamaResp.usage(),
+		FinishReason: ollamaResp.DoneReason,
+		ModelInfo:    p.modelInfo(ollamaResp.Model),
+		ProviderInfo: &core.ProviderInfo{
+			Name:    "ollama",
+			Version: "1.0.0",
+		},
+	}
+	response.ToolCalls = ollamaResp.Message.toolCalls()
+
+	// Parse the structured output if a schema was provided
+	if prompt.Schema != nil && len(response.ToolCalls) == 0 {
+		output, err := core.ParseStructuredOutput("ollama", prompt, response.Text)
+		if err != nil {
+			return response, err
+		}
+		response.StructuredOutput = output
+	}
+
+	return response, nil
+}
+
+// GenerateStream generates a streaming response for the given prompt
+func (p *Provider) GenerateStream(ctx context.Context, prompt *core.Prompt) (core.ResponseStream, error) {
+	path, reqBody, err := p.prepareRequestBody(prompt, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare request body: %w", err)
+	}
+
+	resp, err := p.post(ctx, path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a stream
+	return &ollamaStream{
+		reader:   resp.Body,
+		decoder:  sse.NewNDJSONDecoder(resp.Body),
+		provider: p,
+	}, nil
+}
+
+// post sends a request to the API and returns the response if it succeeded
+func (p *Provider) post(ctx context.Context, path string, body []byte) (*http.Response, error) {
+	req, err := p.newRequest(ctx, "POST", path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.do(p.client, req)
+}
+
+// newRequest creates a request to the API
+func (p *Provider) newRequest(ctx context.Context, method, path string, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.config.Endpoint+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set headers
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if p.config.APIKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.config.APIKey))
+	}
+
+	return req, nil
+}
+
+// do sends a request and returns the response if it succeeded
+func (p *Provider) do(client *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, core.NewNetworkError("ollama", err)
+	}
+
+	// Check for errors
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, core.NewProviderError("ollama", resp, body)
+	}
+
+	return resp, nil
+}
+
+// prepareRequestBody prepares the request body for the chat or generate API
+// and returns it with the path of the endpoint
+func (p *Provider) prepareRequestBody(prompt *core.Prompt, stream bool) (string, []byte, error) {
+	var (
+		path string
+		body interface{}
+		err  error
+	)
+	if p.config.UseGenerate {
+		path = "/api/generate"
+		body, err = p.generateRequest(prompt, stream)
+	} else {
+		path = "/api/chat"
+		body, err = p.chatRequest(prompt, stream)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Merge the additional parameters into the request, so that options
+	// such as "options.num_ctx" can be set per prompt
+	data, err = core.ApplyParams("ollama", data, prompt.AdditionalParams)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return path, data, nil
+}
+
+// chatRequest creates a request to the chat API
+func (p *Provider) chatRequest(prompt *core.Prompt, stream bool) (*chatRequest, error) {
+	conversation := prompt.Conversation()
+	messages := make([]chatMessage, 0, len(conversation)+1)
+
+	if prompt.SystemMessage != "" {
+		messages = append(messages, chatMessage{
+			Role:    "system",
+			Content: prompt.SystemMessage,
+		})
+	}
+
+	for _, msg := range conversation {
+		chatMsg := chatMessage{
+			Role:    string(msg.Role),
+			Content: msg.Content,
+		}
+
+		// Images are sent inline with the text of the message
+		for _, part := range msg.Parts {
+			switch {
+			case part.Type == core.ContentText:
+				chatMsg.Content = joinText(chatMsg.Content, part.Text)
+			case part.Type != core.ContentImage:
+				return nil, core.NewUnsupportedError("ollama", fmt.Sprintf("%s input", part.Type))
+			case !part.IsInline():
+				return nil, core.NewUnsupportedError("ollama", "image URLs")
+			default:
+				chatMsg.Images = append(chatMsg.Images, part.Base64())
+			}
+		}
+
+		// Tool results are identified by the name of the tool, as Ollama
+		// does not assign IDs to tool calls
+		if msg.Role == core.RoleTool {
+			chatMsg.ToolName = msg.Name
+		}
+
+		for _, call := range msg.ToolCalls {
+			arguments := json.RawMessage(call.Arguments)
+			if !json.Valid(arguments) {
+				return nil, fmt.Errorf("invalid arguments for tool call %s", call.Name)
+			}
+			chatMsg.ToolCalls = append(chatMsg.ToolCalls, toolCall{
+				Function: functionCall{
+					Name:      call.Name,
+					Arguments: arguments,
+				},
+			})
+		}
+
+		messages = append(messages, chatMsg)
+	}
+
+	format, err := outputFormat(prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	reqBody := &chatRequest{
+		Model:     p.config.Model,
+		Messages:  messages,
+		Stream:    stream,
+		Format:    format,
+		Options:   p.options(prompt),
+		KeepAlive: p.config.KeepAlive,
+	}
+
+	// Ollama has no tool choice, so tools are only left out when the model
+	// must not call them
+	if prompt.ToolChoice != core.ToolChoiceNone {
+		for _, tool := range prompt.Tools {
+			reqBody.Tools = append(reqBody.Tools, toolDefinition{
+				Type: "function",
+				Function: functionDefinition{
+					Name:        tool.Name,
+					Description: tool.Description,
+					Parameters:  tool.ParameterSchema(),
+				},
+			})
+		}
+	}
+
+	return reqBody, nil
+}
+
+// generateRequest creates a request to the generate API
+func (p *Provider) generateRequest(prompt *core.Prompt, stream bool) (*generateRequest, error) {
+	// The generate endpoint has no notion of tools
+	if len(prompt.Tools) > 0 {
+		return nil, errors.New("tool calling is not supported by the generate API")
+	}
+
+	// The images of all messages are sent with the prompt, and text parts
+	// are joined to the text of their message
+	var images []string
+	conversation := prompt.Conversation()
+	for i, msg := range conversation {
+		for _, part := range msg.Parts {
+			switch {
+			case part.Type == core.ContentText:
+				conversation[i].Content = joinText(conversation[i].Content, part.Text)
+			case part.Type != core.ContentImage:
+				return nil, core.NewUnsupportedError("ollama", fmt.Sprintf("%s input", part.Type))
+			case !part.IsInline():
+				return nil, core.NewUnsupportedError("ollama", "image URLs")
+			default:
+				images = append(images, part.Base64())
+			}
+		}
+	}
+
+	// The generate endpoint takes a single prompt string, so a conversation
+	// history is rendered as a transcript
+	var system []string
+	if prompt.SystemMessage != "" {
+		system = append(system, prompt.SystemMessage)
+	}
+
+	var text string
+	if len(prompt.Messages) > 0 {
+		var messages []core.Message
+		for _, msg := range conversation {
+			if msg.Role == core.RoleSystem {
+				system = append(system, msg.Content)
+				continue
+			}
+			messages = append(messages, msg)
+		}
+		text = formatConversation(messages)
+	} else if len(conversation) > 0 {
+		text = conversation[0].Content
+	}
+
+	format, err := outputFormat(prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &generateRequest{
+		Model:     p.config.Model,
+		Prompt:    text,
+		Images:    images,
+		System:    strings.Join(system, "\n\n"),
+		Stream:    stream,
+		Format:    format,
+		Options:   p.options(prompt),
+		KeepAlive: p.config.KeepAlive,
+	}, nil
+}
+
+// options returns the model options for a prompt: the configured options
+// overridden by the sampling parameters of the prompt
+func (p *Provider) options(prompt *core.Prompt) map[string]interface{} {
+	options := make(map[string]interface{}, len(p.config.Options)+6)
+	for key, value := range p.config.Options {
+		options[key] = value
+	}
+
+	if prompt.Temperature != 0 {
+		options["temperature"] = prompt.Temperature
+	}
+	if prompt.TopP != 0 {
+		options["top_p"] = prompt.TopP
+	}
+	if prompt.MaxTokens != 0 {
+		options["num_predict"] = prompt.MaxTokens
+	}
+	if prompt.FrequencyPenalty != 0 {
+		options["frequency_penalty"] = prompt.FrequencyPenalty
+	}
+	if prompt.PresencePenalty != 0 {
+		options["presence_penalty"] = prompt.PresencePenalty
+	}
+	if len(prompt.StopSequences) > 0 {
+		options["stop"] = prompt.StopSequences
+	}
+
+	if len(options) == 0 {
+		return nil
+	}
+	return options
+}
+
+// modelInfo returns the information about the model that generated a
+// response
+func (p *Provider) modelInfo(model string) *core.ModelInfo {
+	if model == "" {
+		model = p.config.Model
+	}
+	return &core.ModelInfo{
+		Name:     model,
+		Provider: "ollama",
+		Version:  p.config.Catalog.Version("ollama", model),
+	}
+}
+
+// outputFormat returns the format of the output: the schema of the prompt,
+// which Ollama enforces natively, or nil
+func outputFormat(prompt *core.Prompt) (json.RawMessage, error) {
+	if prompt.Schema == nil {
+		return nil, nil
+	}
+	return prompt.OutputSchemaJSON()
+}
+
+// joinText appends a text to another, separated by a blank line
+func joinText(text, more string) string {
+	if text == "" {
+		return more
+	}
+	return text + "\n\n" + more
+}
+
+// formatConversation renders a conversation as a plain-text transcript that
+// ends with an open assistant turn
+func formatConversation(messages []core.Message) string {
+	var sb strings.Builder
+	for _, msg := range messages {
+		switch msg.Role {
+		case core.RoleAssistant:
+			sb.WriteString("Assistant: ")
+		case core.RoleTool:
+			sb.WriteString("Tool: ")
+		default:
+			sb.WriteString("User: ")
+		}
+		sb.WriteString(msg.Content)
+		sb.WriteString("\n\n")
+	}
+	sb.WriteString("Assistant:")
+	return sb.String()
+}
+
+// ollamaStream implements core.ResponseStream for Ollama
+type ollamaStream struct {
+	reader   io.ReadCloser
+	decoder  *sse.NDJSONDecoder
+	provider *Provider
+	calls    int
+}
+
+// Next returns the next chunk of the response
+func (s *ollamaStream) Next() (*core.ResponseChunk, error) {
+	// Read the next line
+	line, err := s.decoder.Next()
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	// Parse the JSON
+	var streamResp chatResponse
+	if err := json.Unmarshal(line, &streamResp); err != nil {
+		return nil, fmt.Errorf("failed to parse stream response: %w", err)
+	}
+
+	// Errors that occur after the response has started are sent as lines
+	if streamResp.Error != "" {
+		return nil, core.NewStreamError("ollama", line)
+	}
+
+	// Create a response chunk
+	chunk := &core.ResponseChunk{
+		Text:      streamResp.text(),
+		IsFinal:   streamResp.Done,
+		ModelInfo: s.provider.modelInfo(streamResp.Model),
+		ProviderInfo: &core.ProviderInfo{
+			Name:    "ollama",
+			Version: "1.0.0",
+		},
+	}
+
+	// Tool calls are streamed whole, so they are numbered across chunks
+	for _, call := range streamResp.Message.toolCalls() {
+		call.Index = s.calls
+		call.ID = fmt.Sprintf("call_%d", s.calls)
+		s.calls++
+		chunk.ToolCalls = append(chunk.ToolCalls, call)
+	}
+
+	// The usage is sent with the last chunk
+	if streamResp.Done {
+		chunk.FinishReason = streamResp.DoneReason
+		if chunk.FinishReason == "" {
+			chunk.FinishReason = "stop"
+		}
+		chunk.TokensUsed = streamResp.usage()
+	}
+
+	return chunk, nil
+}
+
+// Close closes the stream
+func (s *ollamaStream) Close() error {
+	return s.reader.Close()
+}
+
+// chatMessage represents a message in a chat request
+type chatMessage struct {
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	Images    []string   `json:"images,omitempty"`
+	ToolCalls []toolCall `json:"tool_calls,omitempty"`
+	ToolName  string     `json:"tool_name,omitempty"`
+}
+
+// toolCalls converts the tool calls of a message, which Ollama does not
+// identify, giving them IDs from their position
+func (m chatMessage) toolCalls() []core.ToolCall {
+	var calls []core.ToolCall
+	for i, call := range m.ToolCalls {
+		calls = append(calls, core.ToolCall{
+			Index:     i,
+			ID:        fmt.Sprintf("call_%d", i),
+			Name:      call.Function.Name,
+			Arguments: string(call.Function.Arguments),
+		})
+	}
+	return calls
+}
+
+// toolCall represents a tool call in a chat message
+type toolCall struct {
+	Function functionCall `json:"function"`
+}
+
+// functionCall represents the function invoked by a tool call. Ollama sends
+// the arguments as an object rather than a string.
+type functionCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// toolDefinition represents a tool in a chat request
+type toolDefinition struct {
+	Type     string             `json:"type"`
+	Function functionDefinition `json:"function"`
+}
+
+// functionDefinition describes a function the model may call
+type functionDefinition struct {
+	Name        string                `json:"name"`
+	Description string                `json:"description,omitempty"`
+	Parameters  validation.JSONSchema `json:"parameters"`
+}
+
+// chatRequest represents a request to the chat API
+type chatRequest struct {
+	Model     string                 `json:"model"`
+	Messages  []chatMessage          `json:"messages"`
+	Tools     []toolDefinition       `json:"tools,omitempty"`
+	Stream    bool                   `json:"stream"`
+	Format    json.RawMessage        `json:"format,omitempty"`
+	Options   map[string]interface{} `json:"options,omitempty"`
+	KeepAlive string                 `json:"keep_alive,omitempty"`
+}
+
+// generateRequest represents a request to the generate API
+type generateRequest struct {
+	Model     string                 `json:"model"`
+	Prompt    string                 `json:"prompt"`
+	Images    []string               `json:"images,omitempty"`
+	System    string                 `json:"system,omitempty"`
+	Stream    bool                   `json:"stream"`
+	Format    json.RawMessage        `json:"format,omitempty"`
+	Options   map[string]interface{} `json:"options,omitempty"`
+	KeepAlive string                 `json:"keep_alive,omitempty"`
+}
+
+// chatResponse represents a response, or a line of a streaming response,
+// from the chat or generate API
+type chatResponse struct {
+	Model           string      `json:"model"`
+	Message         chatMessage `json:"message"`
+	Response        string      `json:"response"`
+	Done            bool        `json:"done"`
+	DoneReason      string      `json:"done_reason,omitempty"`
+	PromptEvalCount int         `json:"prompt_eval_count,omitempty"`
+	EvalCount       int         `json:"eval_count,omitempty"`
+	Error           string      `json:"error,omitempty"`
+}
+
+// text returns the generated text, which the chat API sends in the message
+// and the generate API in the response field
+func (r *chatResponse) text() string {
+	if r.Response != "" {
+		return r.Response
+	}
+	return r.Message.Content
+}
+
+// usage returns the token usage reported with the last response
+func (r *chatResponse) usage() *core.TokenUsage {
+	return &core.TokenUsage{
+		Prompt:     r.PromptEvalCount,
+		Completion: r.EvalCount,
+		Total:      r.PromptEvalCount + r.EvalCount,
+	}
+}
@@ -0,0 +1,118 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+)
+
+// maxEmbeddingInputs is the maximum number of texts per embeddings request
+const maxEmbeddingInputs = 2048
+
+// Embed generates the embeddings of the texts of the request, in batches
+// if needed. The input type is ignored, as OpenAI models do not use it.
+func (p *Provider) Embed(ctx context.Context, request *core.EmbeddingRequest) (*core.EmbeddingResponse, error) {
+	return core.EmbedBatches(ctx, request, maxEmbeddingInputs, p.embed)
+}
+
+// embed generates the embeddings of a single batch of texts
+func (p *Provider) embed(ctx context.Context, request *core.EmbeddingRequest) (*core.EmbeddingResponse, error) {
+	model := request.Model
+	if model == "" {
+		model = p.config.EmbeddingModel
+	}
+
+	reqBody, err := json.Marshal(embeddingRequest{
+		Model:      model,
+		Input:      request.Texts,
+		Dimensions: request.Dimensions,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	// Create the request
+	req, err := p.newRequest(ctx, p.embedPath, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Send the request
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, core.NewNetworkError(p.name, err)
+	}
+	defer resp.Body.Close()
+
+	// Check for errors
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, core.NewProviderError(p.name, resp, body)
+	}
+
+	// Parse the response
+	var embeddingResp embeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embeddingResp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	embeddings, err := embeddingResp.embeddings(len(request.Texts))
+	if err != nil {
+		return nil, err
+	}
+
+	if embeddingResp.Model != "" {
+		model = embeddingResp.Model
+	}
+
+	return &core.EmbeddingResponse{
+		Embeddings: embeddings,
+		Model:      model,
+		TokensUsed: &core.TokenUsage{
+			Prompt: embeddingResp.Usage.PromptTokens,
+			Total:  embeddingResp.Usage.TotalTokens,
+		},
+	}, nil
+}
+
+// embeddingRequest represents a request to the embeddings API
+type embeddingRequest struct {
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int      `json:"dimensions,omitempty"`
+}
+
+// embeddingResponse represents a response from the embeddings API
+type embeddingResponse struct {
+	Model string `json:"model"`
+	Data  []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Usage struct {
+		PromptTokens int `json:"prompt_tokens"`
+		TotalTokens  int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+// embeddings returns the embeddings of the response in the order of the
+// inputs, which identify them by index
+func (r *embeddingResponse) embeddings(count int) ([][]float32, error) {
+	embeddings := make([][]float32, count)
+	for _, data := range r.Data {
+		if data.Index < 0 || data.Index >= count {
+			return nil, fmt.Errorf("embedding index %d out of range", data.Index)
+		}
+		embeddings[data.Index] = data.Embedding
+	}
+	for i, embedding := range embeddings {
+		if embedding == nil {
+			return nil, fmt.Errorf("no embedding returned for input %d", i)
+		}
+	}
+	return embeddings, nil
+}
@@ -8,15 +8,28 @@ import (
         "fmt"
         "io"
         "net/http"
+        "net/url"
+        "sort"
+        "strings"
         "time"
 
         "github.com/GeoloeG-IsT/gollem/pkg/core"
+        "github.com/GeoloeG-IsT/gollem/pkg/streaming/sse"
+        "github.com/GeoloeG-IsT/gollem/pkg/validation"
 )
 
 // Provider implements the core.LLMProvider interface for OpenAI
 type Provider struct {
-        config Config
-        client *http.Client
+        config     Config
+        client     *http.Client
+        name       string
+        headers    map[string]string
+        authHeader string
+        authPrefix string
+        chatPath   string
+        embedPath  string
+        query      url.Values
+        features   Features
 }
 
 // Config contains the configuration for the OpenAI provider
@@ -35,11 +48,32 @@ type Config struct {
         
         // Organization is the OpenAI organization ID (optional)
         Organization string `json:"organization,omitempty"`
+        
+        // EmbeddingModel is the model used for embeddings (optional, defaults to
+        // "text-embedding-3-small")
+        EmbeddingModel string `json:"embedding_model,omitempty"`
+        
+        // Catalog is the model catalog the capabilities of the models are looked
+        // up in (optional, defaults to core.DefaultCatalog())
+        Catalog *core.ModelCatalog `json:"-"`
 }
 
 // NewProvider creates a new OpenAI provider
-func NewProvider(config Config) (*Provider, error) {
-        if config.APIKey == "" {
+func NewProvider(config Config, opts ...ProviderOption) (*Provider, error) {
+        p := &Provider{
+                name:       "openai",
+                headers:    make(map[string]string),
+                authHeader: "Authorization",
+                authPrefix: "Bearer ",
+                chatPath:   "/chat/completions",
+                embedPath:  "/embeddings",
+                query:      url.Values{},
+        }
+        for _, opt := range opts {
+                opt(p)
+        }
+        
+        if config.APIKey == "" && p.authHeader != "" {
                 return nil, errors.New("API key is required")
         }
         
@@ -55,58 +89,63 @@ func NewProvider(config Config) (*Provider, error) {
                 config.Timeout = 30
         }
         
-        client := &http.Client{
+        if config.EmbeddingModel == "" {
+                config.EmbeddingModel = "text-embedding-3-small"
+        }
+        
+        if config.Catalog == nil {
+                config.Catalog = core.DefaultCatalog()
+        }
+        
+        p.config = config
+        p.client = &http.Client{
                 Timeout: time.Duration(config.Timeout) * time.Second,
         }
         
-        return &Provider{
-                config: config,
-                client: client,
-        }, nil
+        return p, nil
 }
 
 // Name returns the name of the provider
 func (p *Provider) Name() string {
-        return "openai"
+        return p.name
+}
+
+// Capabilities returns the capabilities of the model from the model catalog.
+// Providers named otherwise, such as Azure, fall back to the OpenAI models.
+func (p *Provider) Capabilities() (core.ModelCapabilities, bool) {
+        return lookupModel(p.config.Catalog, p.name, p.config.Model)
+}
+
+// Model returns the name of the model
+func (p *Provider) Model() string {
+        return p.config.Model
 }
 
 // Generate generates a response for the given prompt
 func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
         // Prepare the request
-        reqBody, err := p.prepareRequestBody(prompt)
+        reqBody, err := p.requestBody(prompt, false)
         if err != nil {
                 return nil, fmt.Errorf("failed to prepare request body: %w", err)
         }
         
         // Create the request
-        req, err := http.NewRequestWithContext(
-                ctx,
-                "POST",
-                fmt.Sprintf("%s/chat/completions", p.config.Endpoint),
-                bytes.NewBuffer(reqBody),
-        )
+        req, err := p.newRequest(ctx, p.chatPath, reqBody)
         if err != nil {
                 return nil, fmt.Errorf("failed to create request: %w", err)
         }
         
-        // Set headers
-        req.Header.Set("Content-Type", "application/json")
-        req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.config.APIKey))
-        if p.config.Organization != "" {
-                req.Header.Set("OpenAI-Organization", p.config.Organization)
-        }
-        
         // Send the request
         resp, err := p.client.Do(req)
         if err != nil {
-                return nil, fmt.Errorf("failed to send request: %w", err)
+                return nil, core.NewNetworkError(p.name, err)
         }
         defer resp.Body.Close()
         
         // Check for errors
         if resp.StatusCode != http.StatusOK {
                 body, _ := io.ReadAll(resp.Body)
-                return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+                return nil, core.NewProviderError(p.name, resp, body)
         }
         
         // Parse the response
@@ -115,35 +154,46 @@ func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Res
                 return nil, fmt.Errorf("failed to parse response: %w", err)
         }
         
+        // Check if choices array is empty
+        if len(openAIResp.Choices) == 0 {
+                return nil, fmt.Errorf("no choices returned in response")
+        }
+        
         // Convert to core.Response
         response := &core.Response{
-                Text: openAIResp.Choices[0].Message.Content,
-                TokensUsed: &core.TokenUsage{
-                        Prompt:     openAIResp.Usage.PromptTokens,
-                        Completion: openAIResp.Usage.CompletionTokens,
-                        Total:      openAIResp.Usage.TotalTokens,
-                },
-                FinishReason: openAIResp.Choices[0].FinishReason,
+                ID:            openAIResp.ID,
+                Text:          openAIResp.Choices[0].Message.Content,
+                TokensUsed:    openAIResp.Usage.tokenUsage(),
+                FinishReason:  openAIResp.Choices[0].FinishReason,
+                SafetyRatings: openAIResp.Choices[0].ContentFilterResults.SafetyRatings(),
                 ModelInfo: &core.ModelInfo{
-                        Name:      p.config.Model,
-                        Provider:  "openai",
-                        Version:   "1.0.0",
+                        Name:     p.config.Model,
+                        Provider: p.name,
+                        Version:  modelVersion(p.config.Catalog, p.name, p.config.Model),
                 },
                 ProviderInfo: &core.ProviderInfo{
-                        Name:    "openai",
+                        Name:    p.name,
                         Version: "1.0.0",
                 },
-                // Additional information stored in ProviderInfo
-                // ID: openAIResp.ID,
-                // Created: openAIResp.Created,
-                // Model: openAIResp.Model,
         }
         
-        // Handle structured output if a schema was provided
-        if prompt.Schema != nil {
-                // In a real implementation, this would parse the JSON and validate it against the schema
-                // For simplicity, we're just setting it to nil
-                response.StructuredOutput = nil
+        // Convert the tool calls
+        for i, call := range openAIResp.Choices[0].Message.ToolCalls {
+                response.ToolCalls = append(response.ToolCalls, core.ToolCall{
+                        Index:     i,
+                        ID:        call.ID,
+                        Name:      call.Function.Name,
+                        Arguments: call.Function.Arguments,
+                })
+        }
+        
+        // Parse the structured output if a schema was provided
+        if prompt.Schema != nil && len(response.ToolCalls) == 0 {
+                output, err := core.ParseStructuredOutput(p.name, prompt, response.Text)
+                if err != nil {
+                        return response, err
+                }
+                response.StructuredOutput = output
         }
         
         return response, nil
@@ -152,75 +202,164 @@ func (p *Provider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Res
 // GenerateStream generates a streaming response for the given prompt
 func (p *Provider) GenerateStream(ctx context.Context, prompt *core.Prompt) (core.ResponseStream, error) {
         // Prepare the request
-        reqBody, err := p.prepareRequestBody(prompt)
+        reqBody, err := p.requestBody(prompt, true)
         if err != nil {
                 return nil, fmt.Errorf("failed to prepare request body: %w", err)
         }
         
-        // Set streaming to true
-        var reqMap map[string]interface{}
-        if err := json.Unmarshal(reqBody, &reqMap); err != nil {
-                return nil, fmt.Errorf("failed to parse request body: %w", err)
-        }
-        reqMap["stream"] = true
-        reqBody, err = json.Marshal(reqMap)
-        if err != nil {
-                return nil, fmt.Errorf("failed to marshal request body: %w", err)
-        }
-        
         // Create the request
-        req, err := http.NewRequestWithContext(
-                ctx,
-                "POST",
-                fmt.Sprintf("%s/chat/completions", p.config.Endpoint),
-                bytes.NewBuffer(reqBody),
-        )
+        req, err := p.newRequest(ctx, p.chatPath, reqBody)
         if err != nil {
                 return nil, fmt.Errorf("failed to create request: %w", err)
         }
         
-        // Set headers
-        req.Header.Set("Content-Type", "application/json")
-        req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.config.APIKey))
-        if p.config.Organization != "" {
-                req.Header.Set("OpenAI-Organization", p.config.Organization)
-        }
-        
         // Send the request
         resp, err := p.client.Do(req)
         if err != nil {
-                return nil, fmt.Errorf("failed to send request: %w", err)
+                return nil, core.NewNetworkError(p.name, err)
         }
         
         // Check for errors
         if resp.StatusCode != http.StatusOK {
                 body, _ := io.ReadAll(resp.Body)
                 resp.Body.Close()
-                return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+                return nil, core.NewProviderError(p.name, resp, body)
         }
         
         // Create a stream
         return &openAIStream{
-                reader: resp.Body,
+                reader:  resp.Body,
+                decoder: sse.NewDecoder(resp.Body),
+                name:    p.name,
+                model:   p.config.Model,
+                catalog: p.config.Catalog,
         }, nil
 }
 
+// newRequest creates a POST request to the given path of the endpoint
+func (p *Provider) newRequest(ctx context.Context, path string, body []byte) (*http.Request, error) {
+        requestURL := p.config.Endpoint + path
+        if len(p.query) > 0 {
+                separator := "?"
+                if strings.Contains(requestURL, "?") {
+                        separator = "&"
+                }
+                requestURL += separator + p.query.Encode()
+        }
+        
+        req, err := http.NewRequestWithContext(ctx, "POST", requestURL, bytes.NewBuffer(body))
+        if err != nil {
+                return nil, err
+        }
+        
+        // Set headers
+        req.Header.Set("Content-Type", "application/json")
+        if p.authHeader != "" && p.config.APIKey != "" {
+                req.Header.Set(p.authHeader, p.authPrefix+p.config.APIKey)
+        }
+        if p.config.Organization != "" {
+                req.Header.Set("OpenAI-Organization", p.config.Organization)
+        }
+        for key, value := range p.headers {
+                req.Header.Set(key, value)
+        }
+        
+        return req, nil
+}
+
+// requestBody prepares the request body for a prompt, enabling streaming if
+// requested and removing the parameters that the server does not support
+func (p *Provider) requestBody(prompt *core.Prompt, stream bool) ([]byte, error) {
+        reqBody, err := p.prepareRequestBody(prompt)
+        if err != nil {
+                return nil, err
+        }
+        if !stream && len(p.features.UnsupportedParams) == 0 {
+                return reqBody, nil
+        }
+        
+        var reqMap map[string]interface{}
+        if err := json.Unmarshal(reqBody, &reqMap); err != nil {
+                return nil, fmt.Errorf("failed to parse request body: %w", err)
+        }
+        
+        // Set streaming to true, and request the usage of the stream
+        if stream {
+                reqMap["stream"] = true
+                if _, exists := reqMap["stream_options"]; !exists && !p.features.DisableStreamUsage {
+                        reqMap["stream_options"] = map[string]interface{}{"include_usage": true}
+                }
+        }
+        
+        for _, param := range p.features.UnsupportedParams {
+                delete(reqMap, param)
+        }
+        
+        return json.Marshal(reqMap)
+}
+
 // prepareRequestBody prepares the request body for the OpenAI API
 func (p *Provider) prepareRequestBody(prompt *core.Prompt) ([]byte, error) {
-        messages := []chatMessage{
-                {
-                        Role:    "user",
-                        Content: prompt.Text,
-                },
+        conversation := prompt.Conversation()
+        messages := make([]chatMessage, 0, len(conversation)+1)
+        
+        // Without structured output, the model is given the schema in the system
+        // message
+        system := prompt.SystemMessage
+        format := p.outputFormat()
+        if prompt.Schema != nil && format != "json_schema" {
+                schema, err := prompt.OutputSchemaJSON()
+                if err != nil {
+                        return nil, err
+                }
+                instructions := fmt.Sprintf("Respond with a JSON object that conforms to the following JSON schema:\n%s", schema)
+                if system != "" {
+                        system += "\n\n"
+                }
+                system += instructions
         }
         
-        if prompt.SystemMessage != "" {
-                messages = append([]chatMessage{
-                        {
-                                Role:    "system",
-                                Content: prompt.SystemMessage,
-                        },
-                }, messages...)
+        if system != "" {
+                messages = append(messages, chatMessage{
+                        Role:    "system",
+                        Content: system,
+                })
+        }
+        
+        for _, msg := range conversation {
+                chatMsg := chatMessage{
+                        Role:    string(msg.Role),
+                        Content: msg.Content,
+                        Name:    msg.Name,
+                }
+                
+                // Multimodal messages are sent as a list of content parts
+                if len(msg.Parts) > 0 {
+                        parts, err := p.contentParts(msg)
+                        if err != nil {
+                                return nil, err
+                        }
+                        chatMsg.Content = parts
+                }
+                
+                // Tool results are identified by the ID of the call they answer
+                if msg.Role == core.RoleTool {
+                        chatMsg.Name = ""
+                        chatMsg.ToolCallID = msg.ToolCallID
+                }
+                
+                for _, call := range msg.ToolCalls {
+                        chatMsg.ToolCalls = append(chatMsg.ToolCalls, toolCall{
+                                ID:   call.ID,
+                                Type: "function",
+                                Function: functionCall{
+                                        Name:      call.Name,
+                                        Arguments: call.Arguments,
+                                },
+                        })
+                }
+                
+                messages = append(messages, chatMsg)
         }
         
         reqBody := chatCompletionRequest{
@@ -234,78 +373,237 @@ func (p *Provider) prepareRequestBody(prompt *core.Prompt) ([]byte, error) {
                 Stop:             prompt.StopSequences,
         }
         
-        // Add schema if provided
-        if prompt.Schema != nil {
-                // In a real implementation, this would set the response_format to JSON
-                // and include the schema
-                // For simplicity, we're not implementing this
+        // Add tools if provided and supported
+        if !p.features.DisableTools {
+                for _, tool := range prompt.Tools {
+                        reqBody.Tools = append(reqBody.Tools, toolDefinition{
+                                Type: "function",
+                                Function: functionDefinition{
+                                        Name:        tool.Name,
+                                        Description: tool.Description,
+                                        Parameters:  tool.ParameterSchema(),
+                                },
+                        })
+                }
+                if !p.features.DisableToolChoice {
+                        reqBody.ToolChoice = toolChoice(prompt.ToolChoice)
+                }
+        }
+        
+        // Constrain the output to the schema if provided
+        switch {
+        case prompt.Schema == nil || format == "":
+        case format == "json_object":
+                reqBody.ResponseFormat = &responseFormat{Type: "json_object"}
+        default:
+                schema, err := prompt.OutputSchemaJSON()
+                if err != nil {
+                        return nil, err
+                }
+                reqBody.ResponseFormat = &responseFormat{
+                        Type: "json_schema",
+                        JSONSchema: &jsonSchemaFormat{
+                                Name:   schemaName(prompt),
+                                Schema: schema,
+                        },
+                }
         }
         
-        // Add additional parameters
-        // In a real implementation, this would add the parameters to the request
-        // For simplicity, we're not implementing this
-        // for k, v := range prompt.AdditionalParams {
-        //         // Add parameters to request
-        // }
+        data, err := json.Marshal(reqBody)
+        if err != nil {
+                return nil, err
+        }
         
-        return json.Marshal(reqBody)
+        // Merge the additional parameters into the request
+        return core.ApplyParams(p.name, data, prompt.AdditionalParams)
+}
+
+// lookupModel returns the capabilities of a model from a model catalog,
+// falling back to the OpenAI models for providers named otherwise
+func lookupModel(catalog *core.ModelCatalog, name, model string) (core.ModelCapabilities, bool) {
+        if capabilities, ok := catalog.Lookup(name, model); ok || name == "openai" {
+                return capabilities, ok
+        }
+        return catalog.Lookup("openai", model)
+}
+
+// modelVersion returns the version of a model from a model catalog
+func modelVersion(catalog *core.ModelCatalog, name, model string) string {
+        capabilities, _ := lookupModel(catalog, name, model)
+        return capabilities.Version
+}
+
+// outputFormat returns how the output of the model is constrained to a
+// schema: "json_schema", "json_object", or "" to only instruct the model. The
+// model catalog restricts the quirks of the provider.
+func (p *Provider) outputFormat() string {
+        jsonSchema := !p.features.DisableJSONSchema
+        jsonMode := !p.features.DisableResponseFormat
+        if capabilities, ok := p.Capabilities(); ok {
+                jsonSchema = jsonSchema && capabilities.JSONSchema
+                jsonMode = jsonMode && capabilities.JSONMode
+        }
+
+        switch {
+        case jsonMode && jsonSchema:
+                return "json_schema"
+        case jsonMode:
+                return "json_object"
+        default:
+                return ""
+        }
+}
+
+// schemaName returns the name of the output schema of a prompt, which must
+// only contain letters, digits, underscores and dashes
+func schemaName(prompt *core.Prompt) string {
+        schema, err := prompt.OutputSchema()
+        if err != nil || schema.Title == "" || len(schema.Title) > 64 {
+                return "response"
+        }
+        for _, r := range schema.Title {
+                if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+                        return "response"
+                }
+        }
+        return schema.Title
+}
+
+// toolChoice converts a core tool choice to the OpenAI format
+func toolChoice(choice string) interface{} {
+        switch choice {
+        case "":
+                return nil
+        case core.ToolChoiceAuto, core.ToolChoiceNone, core.ToolChoiceRequired:
+                return choice
+        default:
+                return map[string]interface{}{
+                        "type": "function",
+                        "function": map[string]string{
+                                "name": choice,
+                        },
+                }
+        }
 }
 
 // openAIStream implements core.ResponseStream for OpenAI
 type openAIStream struct {
-        reader io.ReadCloser
-        buffer []byte
+        reader  io.ReadCloser
+        decoder *sse.Decoder
+        name    string
+        model   string
+        catalog *core.ModelCatalog
+        
+        // final is the chunk with the finish reason, which is held back until
+        // the usage that follows it arrives
+        final *core.ResponseChunk
+        done  bool
 }
 
 // Next returns the next chunk of the response
 func (s *openAIStream) Next() (*core.ResponseChunk, error) {
-        // Read the next line
-        line, err := s.readLine()
-        if err != nil {
-                if err == io.EOF {
-                        return nil, io.EOF
+        for !s.done {
+                // Read the next event
+                event, err := s.decoder.Next()
+                if err != nil && err != io.EOF {
+                        return nil, fmt.Errorf("failed to read stream: %w", err)
                 }
-                return nil, fmt.Errorf("failed to read line: %w", err)
-        }
-        
-        // Skip empty lines
-        if len(line) == 0 {
-                return s.Next()
-        }
-        
-        // Skip lines that don't start with "data: "
-        if len(line) < 6 || string(line[:6]) != "data: " {
-                return s.Next()
-        }
-        
-        // Handle the "data: [DONE]" message
-        if string(line) == "data: [DONE]" {
-                return nil, io.EOF
+                
+                // Handle the end of the stream and the "[DONE]" message
+                if err == io.EOF || event.Data == "[DONE]" {
+                        s.done = true
+                        break
+                }
+                
+                // Parse the JSON
+                var streamResp chatCompletionStreamResponse
+                if err := json.Unmarshal([]byte(event.Data), &streamResp); err != nil {
+                        return nil, fmt.Errorf("failed to parse stream response: %w", err)
+                }
+                
+                // Errors that occur after the response has started are sent as events
+                if len(streamResp.Error) > 0 && string(streamResp.Error) != "null" {
+                        return nil, core.NewStreamError(s.name, []byte(event.Data))
+                }
+                
+                // The usage is sent in a chunk without choices after the last one,
+                // or by some servers with the finish reason or any other chunk
+                var usage *core.TokenUsage
+                if streamResp.Usage != nil {
+                        usage = streamResp.Usage.tokenUsage()
+                        if s.final != nil {
+                                s.final.TokensUsed = usage
+                                if len(streamResp.Choices) == 0 {
+                                        chunk := s.final
+                                        s.final = nil
+                                        return chunk, nil
+                                }
+                        } else if len(streamResp.Choices) == 0 {
+                                chunk := s.newChunk(&streamResp)
+                                chunk.TokensUsed = usage
+                                return chunk, nil
+                        }
+                }
+                
+                // Skip chunks without choices
+                if len(streamResp.Choices) == 0 {
+                        continue
+                }
+                
+                // Create a response chunk
+                chunk := s.newChunk(&streamResp)
+                chunk.Text = streamResp.Choices[0].Delta.Content
+                chunk.TokensUsed = usage
+                
+                // Add tool call fragments
+                for _, call := range streamResp.Choices[0].Delta.ToolCalls {
+                        chunk.ToolCalls = append(chunk.ToolCalls, core.ToolCall{
+                                Index:     call.Index,
+                                ID:        call.ID,
+                                Name:      call.Function.Name,
+                                Arguments: call.Function.Arguments,
+                        })
+                }
+                
+                // Check if this is the final chunk, and wait for the usage
+                if streamResp.Choices[0].FinishReason != "" {
+                        chunk.IsFinal = true
+                        chunk.FinishReason = streamResp.Choices[0].FinishReason
+                        s.final = chunk
+                        continue
+                }
+                
+                return chunk, nil
         }
         
-        // Parse the JSON
-        var streamResp chatCompletionStreamResponse
-        if err := json.Unmarshal(line[6:], &streamResp); err != nil {
-                return nil, fmt.Errorf("failed to parse stream response: %w", err)
+        // Return the final chunk if the server sent no usage
+        if s.final != nil {
+                chunk := s.final
+                s.final = nil
+                return chunk, nil
         }
-        
-        // Create a response chunk
-        chunk := &core.ResponseChunk{
-                Text:    streamResp.Choices[0].Delta.Content,
-                IsFinal: false,
-                // Additional information stored in ProviderInfo
-                // ID: streamResp.ID,
-                // Created: streamResp.Created,
-                // Model: streamResp.Model,
+        return nil, io.EOF
+}
+
+// newChunk creates a chunk with the metadata of a streaming response
+func (s *openAIStream) newChunk(streamResp *chatCompletionStreamResponse) *core.ResponseChunk {
+        model := streamResp.Model
+        if model == "" {
+                model = s.model
         }
         
-        // Check if this is the final chunk
-        if streamResp.Choices[0].FinishReason != "" {
-                chunk.IsFinal = true
-                chunk.FinishReason = streamResp.Choices[0].FinishReason
+        return &core.ResponseChunk{
+                ID: streamResp.ID,
+                ModelInfo: &core.ModelInfo{
+                        Name:     model,
+                        Provider: s.name,
+                        Version:  modelVersion(s.catalog, s.name, model),
+                },
+                ProviderInfo: &core.ProviderInfo{
+                        Name:    s.name,
+                        Version: "1.0.0",
+                },
         }
-        
-        return chunk, nil
 }
 
 // Close closes the stream
@@ -313,60 +611,148 @@ func (s *openAIStream) Close() error {
         return s.reader.Close()
 }
 
-// readLine reads a line from the stream
-func (s *openAIStream) readLine() ([]byte, error) {
-        var line []byte
-        // var isPrefix bool - removed unused variable
-        
-        for {
-                // If we have data in the buffer, try to find a newline
-                if len(s.buffer) > 0 {
-                        if i := bytes.IndexByte(s.buffer, '\n'); i >= 0 {
-                                line = s.buffer[:i]
-                                s.buffer = s.buffer[i+1:]
-                                return line, nil
+// contentParts converts the content of a multimodal message to content
+// parts
+func (p *Provider) contentParts(msg core.Message) ([]contentPart, error) {
+        var parts []contentPart
+        for _, part := range core.MessageParts(msg) {
+                switch part.Type {
+                case core.ContentText:
+                        parts = append(parts, contentPart{Type: "text", Text: part.Text})
+                case core.ContentImage:
+                        parts = append(parts, contentPart{Type: "image_url", ImageURL: &imageURL{URL: part.DataURL()}})
+                case core.ContentAudio:
+                        // Audio is only accepted inline, in WAV or MP3 format
+                        if !part.IsInline() {
+                                return nil, core.NewUnsupportedError(p.name, "audio URLs")
                         }
-                }
-                
-                // Read more data
-                buf := make([]byte, 1024)
-                n, err := s.reader.Read(buf)
-                if err != nil {
-                        if err == io.EOF && len(s.buffer) > 0 {
-                                // Return the remaining data
-                                line = s.buffer
-                                s.buffer = nil
-                                return line, nil
+                        format := audioFormats[part.MIMEType]
+                        if format == "" {
+                                return nil, core.NewUnsupportedError(p.name, fmt.Sprintf("audio of type %q", part.MIMEType))
                         }
-                        return nil, err
-                }
-                
-                // Append to the buffer
-                s.buffer = append(s.buffer, buf[:n]...)
-                
-                // If the buffer is too large, return an error
-                if len(s.buffer) > 1024*1024 {
-                        return nil, errors.New("buffer overflow")
+                        parts = append(parts, contentPart{Type: "input_audio", InputAudio: &inputAudio{Data: part.Base64(), Format: format}})
+                case core.ContentFile:
+                        // Files are only accepted inline, or once uploaded
+                        if !part.IsInline() {
+                                return nil, core.NewUnsupportedError(p.name, "file URLs")
+                        }
+                        filename := part.Filename
+                        if filename == "" {
+                                filename = "file"
+                        }
+                        parts = append(parts, contentPart{Type: "file", File: &fileData{Filename: filename, FileData: part.DataURL()}})
+                default:
+                        return nil, fmt.Errorf("unknown content type %q", part.Type)
                 }
         }
+        return parts, nil
+}
+
+// audioFormats maps the audio MIME types to the formats of the API
+var audioFormats = map[string]string{
+        "audio/wav":   "wav",
+        "audio/x-wav": "wav",
+        "audio/wave":  "wav",
+        "audio/mpeg":  "mp3",
+        "audio/mp3":   "mp3",
 }
 
-// chatMessage represents a message in a chat completion request
+// chatMessage represents a message in a chat completion request. The
+// content is either a string or a list of content parts.
 type chatMessage struct {
-        Role    string `json:"role"`
-        Content string `json:"content"`
+        Role       string      `json:"role"`
+        Content    interface{} `json:"content"`
+        Name       string      `json:"name,omitempty"`
+        ToolCalls  []toolCall  `json:"tool_calls,omitempty"`
+        ToolCallID string      `json:"tool_call_id,omitempty"`
+}
+
+// contentPart represents a part of the content of a multimodal message
+type contentPart struct {
+        Type       string      `json:"type"`
+        Text       string      `json:"text,omitempty"`
+        ImageURL   *imageURL   `json:"image_url,omitempty"`
+        InputAudio *inputAudio `json:"input_audio,omitempty"`
+        File       *fileData   `json:"file,omitempty"`
+}
+
+// imageURL represents the URL of an image, which may be a data URL
+type imageURL struct {
+        URL string `json:"url"`
+}
+
+// inputAudio represents inline audio
+type inputAudio struct {
+        Data   string `json:"data"`
+        Format string `json:"format"`
+}
+
+// fileData represents an inline file
+type fileData struct {
+        Filename string `json:"filename"`
+        FileData string `json:"file_data"`
+}
+
+// toolCall represents a tool call in a chat message
+type toolCall struct {
+        ID       string       `json:"id,omitempty"`
+        Type     string       `json:"type,omitempty"`
+        Function functionCall `json:"function"`
+}
+
+// streamToolCall represents a tool call fragment in a streaming response
+type streamToolCall struct {
+        Index    int          `json:"index"`
+        ID       string       `json:"id,omitempty"`
+        Type     string       `json:"type,omitempty"`
+        Function functionCall `json:"function"`
+}
+
+// functionCall represents the function invoked by a tool call
+type functionCall struct {
+        Name      string `json:"name,omitempty"`
+        Arguments string `json:"arguments"`
+}
+
+// toolDefinition represents a tool in a chat completion request
+type toolDefinition struct {
+        Type     string             `json:"type"`
+        Function functionDefinition `json:"function"`
+}
+
+// functionDefinition describes a function the model may call
+type functionDefinition struct {
+        Name        string                `json:"name"`
+        Description string                `json:"description,omitempty"`
+        Parameters  validation.JSONSchema `json:"parameters"`
 }
 
 // chatCompletionRequest represents a request to the chat completions API
 type chatCompletionRequest struct {
-        Model            string        `json:"model"`
-        Messages         []chatMessage `json:"messages"`
-        Temperature      float64       `json:"temperature,omitempty"`
-        MaxTokens        int           `json:"max_tokens,omitempty"`
-        TopP             float64       `json:"top_p,omitempty"`
-        FrequencyPenalty float64       `json:"frequency_penalty,omitempty"`
-        PresencePenalty  float64       `json:"presence_penalty,omitempty"`
-        Stop             []string      `json:"stop,omitempty"`
+        Model            string           `json:"model"`
+        Messages         []chatMessage    `json:"messages"`
+        Temperature      float64          `json:"temperature,omitempty"`
+        MaxTokens        int              `json:"max_tokens,omitempty"`
+        TopP             float64          `json:"top_p,omitempty"`
+        FrequencyPenalty float64          `json:"frequency_penalty,omitempty"`
+        PresencePenalty  float64          `json:"presence_penalty,omitempty"`
+        Stop             []string         `json:"stop,omitempty"`
+        Tools            []toolDefinition `json:"tools,omitempty"`
+        ToolChoice       interface{}      `json:"tool_choice,omitempty"`
+        ResponseFormat   *responseFormat  `json:"response_format,omitempty"`
+}
+
+// responseFormat represents the format of the model output
+type responseFormat struct {
+        Type       string            `json:"type"`
+        JSONSchema *jsonSchemaFormat `json:"json_schema,omitempty"`
+}
+
+// jsonSchemaFormat represents the schema the model output must conform to
+type jsonSchemaFormat struct {
+        Name   string          `json:"name"`
+        Schema json.RawMessage `json:"schema"`
+        Strict bool            `json:"strict,omitempty"`
 }
 
 // chatCompletionResponse represents a response from the chat completions API
@@ -378,16 +764,82 @@ type chatCompletionResponse struct {
         Choices []struct {
                 Index        int `json:"index"`
                 Message      struct {
-                        Role    string `json:"role"`
-                        Content string `json:"content"`
+                        Role      string     `json:"role"`
+                        Content   string     `json:"content"`
+                        ToolCalls []toolCall `json:"tool_calls,omitempty"`
                 } `json:"message"`
-                FinishReason string `json:"finish_reason"`
+                FinishReason         string               `json:"finish_reason"`
+                ContentFilterResults ContentFilterResults `json:"content_filter_results,omitempty"`
         } `json:"choices"`
-        Usage struct {
-                PromptTokens     int `json:"prompt_tokens"`
-                CompletionTokens int `json:"completion_tokens"`
-                TotalTokens      int `json:"total_tokens"`
-        } `json:"usage"`
+        Usage usage `json:"usage"`
+}
+
+// ContentFilterResults are the results of the content filter of servers such
+// as Azure OpenAI, by category of harm such as "hate" or "jailbreak"
+type ContentFilterResults map[string]ContentFilterResult
+
+// ContentFilterResult is the result of the content filter for a category
+type ContentFilterResult struct {
+        // Filtered indicates whether the content was blocked
+        Filtered bool `json:"filtered"`
+        
+        // Severity is the severity of the content, such as "safe" or "high"
+        Severity string `json:"severity,omitempty"`
+        
+        // Detected indicates whether the content was detected, for categories
+        // without severity such as "jailbreak"
+        Detected *bool `json:"detected,omitempty"`
+}
+
+// SafetyRatings converts the results to safety ratings, sorted by category.
+// The probability of a rating is the severity, or "detected" for detected
+// content without severity.
+func (r ContentFilterResults) SafetyRatings() []core.SafetyRating {
+        if len(r) == 0 {
+                return nil
+        }
+        
+        categories := make([]string, 0, len(r))
+        for category := range r {
+                categories = append(categories, category)
+        }
+        sort.Strings(categories)
+        
+        ratings := make([]core.SafetyRating, len(categories))
+        for i, category := range categories {
+                result := r[category]
+                probability := result.Severity
+                if probability == "" && result.Detected != nil && *result.Detected {
+                        probability = "detected"
+                }
+                ratings[i] = core.SafetyRating{
+                        Category:    category,
+                        Probability: probability,
+                        Blocked:     result.Filtered,
+                }
+        }
+        return ratings
+}
+
+// usage represents the token usage of a completion
+type usage struct {
+        PromptTokens        int `json:"prompt_tokens"`
+        CompletionTokens    int `json:"completion_tokens"`
+        TotalTokens         int `json:"total_tokens"`
+        PromptTokensDetails struct {
+                CachedTokens int `json:"cached_tokens"`
+        } `json:"prompt_tokens_details"`
+}
+
+// tokenUsage converts the usage to the core format. Prompts are cached
+// automatically, so no tokens are reported as written to the cache.
+func (u usage) tokenUsage() *core.TokenUsage {
+        return &core.TokenUsage{
+                Prompt:     u.PromptTokens,
+                Completion: u.CompletionTokens,
+                Total:      u.TotalTokens,
+                CacheRead:  u.PromptTokensDetails.CachedTokens,
+        }
 }
 
 // chatCompletionStreamResponse represents a streaming response from the chat completions API
@@ -399,9 +851,12 @@ type chatCompletionStreamResponse struct {
         Choices []struct {
                 Index        int `json:"index"`
                 Delta        struct {
-                        Role    string `json:"role,omitempty"`
-                        Content string `json:"content,omitempty"`
+                        Role      string           `json:"role,omitempty"`
+                        Content   string           `json:"content,omitempty"`
+                        ToolCalls []streamToolCall `json:"tool_calls,omitempty"`
                 } `json:"delta"`
                 FinishReason string `json:"finish_reason"`
         } `json:"choices"`
+        Usage *usage          `json:"usage,omitempty"`
+        Error json.RawMessage `json:"error,omitempty"`
 }
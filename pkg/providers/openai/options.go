@@ -0,0 +1,85 @@
+package openai
+
+// ProviderOption configures a Provider. The options adapt the provider to
+// other servers implementing the OpenAI API.
+type ProviderOption func(*Provider)
+
+// Features describes the parts of the OpenAI API that a server does not
+// support. The zero value supports the whole API.
+type Features struct {
+	// DisableTools drops the tools and the tool choice from requests
+	DisableTools bool `json:"disable_tools,omitempty"`
+
+	// DisableToolChoice drops the tool choice from requests
+	DisableToolChoice bool `json:"disable_tool_choice,omitempty"`
+
+	// DisableJSONSchema requests JSON mode instead of structured output and
+	// gives the schema to the model in the system message
+	DisableJSONSchema bool `json:"disable_json_schema,omitempty"`
+
+	// DisableResponseFormat drops the response format from requests and
+	// gives the schema to the model in the system message
+	DisableResponseFormat bool `json:"disable_response_format,omitempty"`
+
+	// DisableStreamUsage does not request the usage of streaming responses
+	DisableStreamUsage bool `json:"disable_stream_usage,omitempty"`
+
+	// UnsupportedParams are request fields removed before sending, such as
+	// "frequency_penalty"
+	UnsupportedParams []string `json:"unsupported_params,omitempty"`
+}
+
+// WithName sets the name of the provider, which is used in errors, model
+// information and to scope additional parameters
+func WithName(name string) ProviderOption {
+	return func(p *Provider) {
+		p.name = name
+	}
+}
+
+// WithHeader sets a header sent with every request
+func WithHeader(key, value string) ProviderOption {
+	return func(p *Provider) {
+		p.headers[key] = value
+	}
+}
+
+// WithAuth sets the header carrying the API key and the prefix of its value,
+// such as "Authorization" and "Bearer ". An empty header disables
+// authentication.
+func WithAuth(header, prefix string) ProviderOption {
+	return func(p *Provider) {
+		p.authHeader = header
+		p.authPrefix = prefix
+	}
+}
+
+// WithChatPath sets the path of the chat completions endpoint, relative to
+// the endpoint of the configuration
+func WithChatPath(path string) ProviderOption {
+	return func(p *Provider) {
+		p.chatPath = path
+	}
+}
+
+// WithEmbeddingsPath sets the path of the embeddings endpoint, relative to
+// the endpoint of the configuration
+func WithEmbeddingsPath(path string) ProviderOption {
+	return func(p *Provider) {
+		p.embedPath = path
+	}
+}
+
+// WithQueryParam sets a query parameter sent with every request
+func WithQueryParam(key, value string) ProviderOption {
+	return func(p *Provider) {
+		p.query.Set(key, value)
+	}
+}
+
+// WithFeatures sets the parts of the API that the server does not support
+func WithFeatures(features Features) ProviderOption {
+	return func(p *Provider) {
+		p.features = features
+	}
+}
@@ -0,0 +1,139 @@
+// Package openaicompat implements a provider for servers that speak the
+// OpenAI chat completions protocol, such as vLLM, LM Studio, the llama.cpp
+// server, Together, Groq and OpenRouter.
+package openaicompat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/providers/openai"
+)
+
+// Authentication schemes
+const (
+	// AuthBearer sends the API key as a bearer token
+	AuthBearer = "bearer"
+
+	// AuthHeader sends the API key as the value of AuthHeader
+	AuthHeader = "header"
+
+	// AuthNone sends no API key
+	AuthNone = "none"
+)
+
+// Config contains the configuration for an OpenAI-compatible provider
+type Config struct {
+	// Name is the name of the provider, such as "groq". Every registered
+	// provider needs its own name.
+	Name string `json:"name"`
+
+	// Endpoint is the base URL of the API, such as
+	// "https://api.groq.com/openai/v1"
+	Endpoint string `json:"endpoint"`
+
+	// APIKey is the API key (optional for local servers)
+	APIKey string `json:"api_key,omitempty"`
+
+	// Model is the model to use
+	Model string `json:"model"`
+
+	// Timeout is the request timeout in seconds
+	Timeout int `json:"timeout,omitempty"`
+
+	// Headers are sent with every request, such as the HTTP-Referer header
+	// of OpenRouter
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// AuthScheme is AuthBearer (the default), AuthHeader or AuthNone
+	AuthScheme string `json:"auth_scheme,omitempty"`
+
+	// AuthHeader is the header carrying the API key (defaults to
+	// "Authorization" for AuthBearer and "api-key" for AuthHeader)
+	AuthHeader string `json:"auth_header,omitempty"`
+
+	// ChatPath is the path of the chat completions endpoint (defaults to
+	// "/chat/completions")
+	ChatPath string `json:"chat_path,omitempty"`
+
+	// EmbeddingsPath is the path of the embeddings endpoint (defaults to
+	// "/embeddings")
+	EmbeddingsPath string `json:"embeddings_path,omitempty"`
+
+	// EmbeddingModel is the model used for embeddings (optional)
+	EmbeddingModel string `json:"embedding_model,omitempty"`
+
+	// Features describes the parameters that the server does not support
+	Features openai.Features `json:"features,omitempty"`
+
+	// Catalog is the model catalog the capabilities of the models are looked
+	// up in (optional, defaults to core.DefaultCatalog())
+	Catalog *core.ModelCatalog `json:"-"`
+}
+
+// NewProvider creates a new OpenAI-compatible provider
+func NewProvider(config Config) (*openai.Provider, error) {
+	if config.Name == "" {
+		return nil, errors.New("name is required")
+	}
+
+	if config.Endpoint == "" {
+		return nil, errors.New("endpoint is required")
+	}
+
+	if config.Model == "" {
+		return nil, errors.New("model is required")
+	}
+
+	opts := []openai.ProviderOption{
+		openai.WithName(config.Name),
+		openai.WithFeatures(config.Features),
+	}
+
+	switch strings.ToLower(config.AuthScheme) {
+	case "", AuthBearer:
+		header := config.AuthHeader
+		if header == "" {
+			header = "Authorization"
+		}
+		opts = append(opts, openai.WithAuth(header, "Bearer "))
+	case AuthHeader:
+		header := config.AuthHeader
+		if header == "" {
+			header = "api-key"
+		}
+		opts = append(opts, openai.WithAuth(header, ""))
+	case AuthNone:
+		opts = append(opts, openai.WithAuth("", ""))
+	default:
+		return nil, fmt.Errorf("unknown auth scheme %s", config.AuthScheme)
+	}
+
+	// Local servers usually need no API key
+	if config.APIKey == "" {
+		opts = append(opts, openai.WithAuth("", ""))
+	}
+
+	if config.ChatPath != "" {
+		opts = append(opts, openai.WithChatPath("/"+strings.TrimPrefix(config.ChatPath, "/")))
+	}
+
+	if config.EmbeddingsPath != "" {
+		opts = append(opts, openai.WithEmbeddingsPath("/"+strings.TrimPrefix(config.EmbeddingsPath, "/")))
+	}
+
+	for key, value := range config.Headers {
+		opts = append(opts, openai.WithHeader(key, value))
+	}
+
+	return openai.NewProvider(openai.Config{
+		APIKey:         config.APIKey,
+		Model:          config.Model,
+		Endpoint:       strings.TrimSuffix(config.Endpoint, "/"),
+		Timeout:        config.Timeout,
+		EmbeddingModel: config.EmbeddingModel,
+		Catalog:        config.Catalog,
+	}, opts...)
+}
@@ -2,11 +2,29 @@ package providers_test
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/providers/anthropic"
+	"github.com/GeoloeG-IsT/gollem/pkg/providers/azure"
+	"github.com/GeoloeG-IsT/gollem/pkg/providers/google"
+	"github.com/GeoloeG-IsT/gollem/pkg/providers/llama"
+	"github.com/GeoloeG-IsT/gollem/pkg/providers/mistral"
+	"github.com/GeoloeG-IsT/gollem/pkg/providers/ollama"
 	"github.com/GeoloeG-IsT/gollem/pkg/providers/openai"
+	"github.com/GeoloeG-IsT/gollem/pkg/providers/openaicompat"
+	"github.com/GeoloeG-IsT/gollem/pkg/routing"
+	"github.com/GeoloeG-IsT/gollem/pkg/streaming"
+	"github.com/GeoloeG-IsT/gollem/pkg/validation"
 )
 
 // TestOpenAIProvider tests the OpenAI provider implementation
@@ -123,6 +141,1482 @@ func TestMockProvider(t *testing.T) {
 	}
 }
 
+// TestConversationRequests tests that providers translate a conversation
+// history into their native request formats
+func TestConversationRequests(t *testing.T) {
+	prompt := core.NewChatPrompt(
+		core.Message{Role: core.RoleUser, Content: "Hi, I'm Bob."},
+		core.Message{Role: core.RoleAssistant, Content: "Hello Bob!"},
+	)
+	prompt.SystemMessage = "Be brief."
+	prompt.Text = "What is my name?"
+
+	ctx := context.Background()
+
+	// OpenAI and Mistral keep the system message at the head of the messages
+	server, body := newCaptureServer(t, `{"choices":[{"message":{"role":"assistant","content":"Bob"},"finish_reason":"stop"}]}`)
+	openaiProvider, err := openai.NewProvider(openai.Config{APIKey: "test", Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	if _, err := openaiProvider.Generate(ctx, prompt); err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	checkRoles(t, "openai", (*body)["messages"], []string{"system", "user", "assistant", "user"})
+
+	mistralProvider, err := mistral.NewProvider(mistral.Config{APIKey: "test", Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	if _, err := mistralProvider.Generate(ctx, prompt); err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	checkRoles(t, "mistral", (*body)["messages"], []string{"system", "user", "assistant", "user"})
+
+	// Anthropic moves the system message into its own field
+	server, body = newCaptureServer(t, `{"content":[{"type":"text","text":"Bob"}],"stop_reason":"end_turn"}`)
+	anthropicProvider, err := anthropic.NewProvider(anthropic.Config{APIKey: "test", Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	if _, err := anthropicProvider.Generate(ctx, prompt); err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	checkRoles(t, "anthropic", (*body)["messages"], []string{"user", "assistant", "user"})
+	if (*body)["system"] != "Be brief." {
+		t.Fatalf("Anthropic system message is incorrect: %v", (*body)["system"])
+	}
+
+	// Google names the assistant role "model"
+	server, body = newCaptureServer(t, `{"candidates":[{"content":{"parts":[{"text":"Bob"}]},"finishReason":"STOP"}]}`)
+	googleProvider, err := google.NewProvider(google.Config{APIKey: "test", Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	if _, err := googleProvider.Generate(ctx, prompt); err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	checkRoles(t, "google", (*body)["contents"], []string{"user", "model", "user"})
+	if (*body)["systemInstruction"] == nil {
+		t.Fatal("Google system instruction is missing")
+	}
+
+	// Llama renders the conversation as a transcript
+	server, body = newCaptureServer(t, `{"content":"Bob","stop_reason":"stop"}`)
+	llamaProvider, err := llama.NewProvider(llama.Config{Model: "llama", Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	if _, err := llamaProvider.Generate(ctx, prompt); err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	expected := "User: Hi, I'm Bob.\n\nAssistant: Hello Bob!\n\nUser: What is my name?\n\nAssistant:"
+	if (*body)["prompt"] != expected {
+		t.Fatalf("Llama prompt is incorrect: %q", (*body)["prompt"])
+	}
+}
+
+// TestToolCalling tests the mapping of tools, tool calls and tool results
+func TestToolCalling(t *testing.T) {
+	call := core.ToolCall{ID: "call_1", Name: "get_weather", Arguments: `{"city":"Paris"}`}
+	prompt := core.NewChatPrompt(
+		core.Message{Role: core.RoleUser, Content: "What's the weather in Paris?"},
+		core.Message{Role: core.RoleAssistant, ToolCalls: []core.ToolCall{call}},
+		core.ToolResultMessage(call, `{"temperature":21}`),
+	)
+	prompt.Tools = []core.Tool{{
+		Name:        "get_weather",
+		Description: "Get the current weather",
+		Parameters: validation.JSONSchema{
+			Type:       "object",
+			Properties: map[string]validation.JSONSchema{"city": {Type: "string"}},
+			Required:   []string{"city"},
+		},
+	}}
+
+	ctx := context.Background()
+
+	// checkToolCall checks the tool call parsed from a response
+	checkToolCall := func(provider string, response *core.Response) {
+		t.Helper()
+		if len(response.ToolCalls) != 1 || response.ToolCalls[0].Name != "get_weather" {
+			t.Fatalf("%s tool calls are incorrect: %+v", provider, response.ToolCalls)
+		}
+		var args map[string]interface{}
+		if err := json.Unmarshal([]byte(response.ToolCalls[0].Arguments), &args); err != nil || args["city"] != "Paris" {
+			t.Fatalf("%s tool call arguments are incorrect: %s", provider, response.ToolCalls[0].Arguments)
+		}
+	}
+
+	// OpenAI sends tool results as tool messages
+	server, body := newCaptureServer(t, `{"choices":[{"message":{"role":"assistant","tool_calls":[{"id":"call_2","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},"finish_reason":"tool_calls"}]}`)
+	openaiProvider, err := openai.NewProvider(openai.Config{APIKey: "test", Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	response, err := openaiProvider.Generate(ctx, prompt)
+	if err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	checkRoles(t, "openai", (*body)["messages"], []string{"user", "assistant", "tool"})
+	if tools, ok := (*body)["tools"].([]interface{}); !ok || len(tools) != 1 {
+		t.Fatalf("OpenAI tools are incorrect: %v", (*body)["tools"])
+	}
+	checkToolCall("openai", response)
+
+	// Anthropic sends tool results as user content blocks
+	server, body = newCaptureServer(t, `{"content":[{"type":"text","text":"Let me check."},{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{"city":"Paris"}}],"stop_reason":"tool_use"}`)
+	anthropicProvider, err := anthropic.NewProvider(anthropic.Config{APIKey: "test", Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	response, err = anthropicProvider.Generate(ctx, prompt)
+	if err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	checkRoles(t, "anthropic", (*body)["messages"], []string{"user", "assistant", "user"})
+	if tools, ok := (*body)["tools"].([]interface{}); !ok || len(tools) != 1 {
+		t.Fatalf("Anthropic tools are incorrect: %v", (*body)["tools"])
+	}
+	if response.Text != "Let me check." {
+		t.Fatalf("Anthropic text is incorrect: %q", response.Text)
+	}
+	checkToolCall("anthropic", response)
+
+	// Google sends tool results as function responses
+	server, body = newCaptureServer(t, `{"candidates":[{"content":{"parts":[{"functionCall":{"name":"get_weather","args":{"city":"Paris"}}}]},"finishReason":"STOP"}]}`)
+	googleProvider, err := google.NewProvider(google.Config{APIKey: "test", Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	response, err = googleProvider.Generate(ctx, prompt)
+	if err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	checkRoles(t, "google", (*body)["contents"], []string{"user", "model", "user"})
+	if tools, ok := (*body)["tools"].([]interface{}); !ok || len(tools) != 1 {
+		t.Fatalf("Google tools are incorrect: %v", (*body)["tools"])
+	}
+	checkToolCall("google", response)
+
+	// Llama has no tool support
+	llamaProvider, err := llama.NewProvider(llama.Config{Model: "llama", Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	if _, err := llamaProvider.Generate(ctx, prompt); err == nil {
+		t.Fatal("Expected an error for tools with llama")
+	}
+}
+
+// TestStructuredOutput tests that each provider requests structured output
+// natively and validates the result against the schema
+func TestStructuredOutput(t *testing.T) {
+	prompt := core.NewPrompt("What is the capital of France?")
+	prompt.Schema = validation.JSONSchema{
+		Type:       "object",
+		Properties: map[string]validation.JSONSchema{"city": {Type: "string"}},
+		Required:   []string{"city"},
+	}
+
+	ctx := context.Background()
+
+	// checkOutput checks the structured output parsed from a response
+	checkOutput := func(provider string, response *core.Response, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("Failed to generate %s response: %v", provider, err)
+		}
+		output, ok := response.StructuredOutput.(map[string]interface{})
+		if !ok || output["city"] != "Paris" {
+			t.Fatalf("%s structured output is incorrect: %v", provider, response.StructuredOutput)
+		}
+	}
+
+	// OpenAI uses a JSON schema response format
+	server, body := newCaptureServer(t, `{"choices":[{"message":{"role":"assistant","content":"{\"city\":\"Paris\"}"},"finish_reason":"stop"}]}`)
+	openaiProvider, _ := openai.NewProvider(openai.Config{APIKey: "test", Model: "gpt-4o", Endpoint: server.URL})
+	response, err := openaiProvider.Generate(ctx, prompt)
+	checkOutput("openai", response, err)
+	format, _ := (*body)["response_format"].(map[string]interface{})
+	if format["type"] != "json_schema" || format["json_schema"] == nil {
+		t.Fatalf("OpenAI response format is incorrect: %v", (*body)["response_format"])
+	}
+
+	// Anthropic forces a tool whose input is the output
+	server, body = newCaptureServer(t, `{"content":[{"type":"tool_use","id":"toolu_1","name":"structured_output","input":{"city":"Paris"}}],"stop_reason":"tool_use"}`)
+	anthropicProvider, _ := anthropic.NewProvider(anthropic.Config{APIKey: "test", Endpoint: server.URL})
+	response, err = anthropicProvider.Generate(ctx, prompt)
+	checkOutput("anthropic", response, err)
+	choice, _ := (*body)["tool_choice"].(map[string]interface{})
+	if choice["type"] != "tool" || choice["name"] != "structured_output" {
+		t.Fatalf("Anthropic tool choice is incorrect: %v", (*body)["tool_choice"])
+	}
+	if len(response.ToolCalls) != 0 {
+		t.Fatalf("Anthropic structured output was returned as a tool call: %+v", response.ToolCalls)
+	}
+
+	// Google uses a response schema
+	server, body = newCaptureServer(t, `{"candidates":[{"content":{"parts":[{"text":"{\"city\":\"Paris\"}"}]},"finishReason":"STOP"}]}`)
+	googleProvider, _ := google.NewProvider(google.Config{APIKey: "test", Endpoint: server.URL})
+	response, err = googleProvider.Generate(ctx, prompt)
+	checkOutput("google", response, err)
+	config, _ := (*body)["generationConfig"].(map[string]interface{})
+	if config["responseMimeType"] != "application/json" || config["responseSchema"] == nil {
+		t.Fatalf("Google generation config is incorrect: %v", config)
+	}
+
+	// Mistral uses JSON mode
+	server, body = newCaptureServer(t, `{"choices":[{"message":{"role":"assistant","content":"{\"city\":\"Paris\"}"},"finish_reason":"stop"}]}`)
+	mistralProvider, _ := mistral.NewProvider(mistral.Config{APIKey: "test", Endpoint: server.URL})
+	response, err = mistralProvider.Generate(ctx, prompt)
+	checkOutput("mistral", response, err)
+	format, _ = (*body)["response_format"].(map[string]interface{})
+	if format["type"] != "json_object" {
+		t.Fatalf("Mistral response format is incorrect: %v", (*body)["response_format"])
+	}
+
+	// Llama constrains the output with the JSON schema
+	server, body = newCaptureServer(t, `{"content":"{\"city\":\"Paris\"}","stop_reason":"stop"}`)
+	llamaProvider, _ := llama.NewProvider(llama.Config{Model: "llama", Endpoint: server.URL})
+	response, err = llamaProvider.Generate(ctx, prompt)
+	checkOutput("llama", response, err)
+	if (*body)["json_schema"] == nil {
+		t.Fatalf("Llama JSON schema is missing: %v", *body)
+	}
+
+	// Output that does not match the schema is an error, and the response
+	// is returned for inspection
+	server, _ = newCaptureServer(t, `{"content":"{\"country\":\"France\"}","stop_reason":"stop"}`)
+	llamaProvider, _ = llama.NewProvider(llama.Config{Model: "llama", Endpoint: server.URL})
+	response, err = llamaProvider.Generate(ctx, prompt)
+	var outputErr *core.StructuredOutputError
+	if !errors.As(err, &outputErr) || len(outputErr.Errors) == 0 {
+		t.Fatalf("Expected a structured output error, got %v", err)
+	}
+	if response == nil || response.Text != `{"country":"France"}` {
+		t.Fatalf("Response was not returned with the error: %+v", response)
+	}
+}
+
+// TestAdditionalParams tests that additional parameters are merged into the
+// request bodies
+func TestAdditionalParams(t *testing.T) {
+	prompt := core.NewPrompt("Hello")
+	prompt.Temperature = 0.5
+	prompt.AdditionalParams = map[string]interface{}{
+		"seed":                      int64(1234567890123),
+		"logit_bias":                map[string]int{"50256": -100},
+		"openai:n":                  2,
+		"google:safetySettings":     []map[string]string{{"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_NONE"}},
+		"google:generationConfig":   map[string]interface{}{"topK": 40},
+		"google:generationConfig.n": 1,
+		routing.TagsParam:           []string{"premium"},
+	}
+	ctx := context.Background()
+
+	// OpenAI gets the shared and its own parameters
+	server, body := newCaptureServer(t, `{"choices":[{"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}]}`)
+	openaiProvider, _ := openai.NewProvider(openai.Config{APIKey: "test", Endpoint: server.URL})
+	if _, err := openaiProvider.Generate(ctx, prompt); err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	if (*body)["seed"] != float64(1234567890123) || (*body)["n"] != float64(2) || (*body)["logit_bias"] == nil {
+		t.Fatalf("OpenAI parameters are incorrect: %v", *body)
+	}
+	if _, exists := (*body)["safetySettings"]; exists {
+		t.Fatal("Google parameters were sent to OpenAI")
+	}
+	if _, exists := (*body)["tags"]; exists {
+		t.Fatal("Routing tags were sent to OpenAI")
+	}
+
+	// Google merges nested parameters into the generation config
+	server, body = newCaptureServer(t, `{"candidates":[{"content":{"parts":[{"text":"Hi"}]},"finishReason":"STOP"}]}`)
+	googleProvider, _ := google.NewProvider(google.Config{APIKey: "test", Endpoint: server.URL})
+	if _, err := googleProvider.Generate(ctx, prompt); err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	config, _ := (*body)["generationConfig"].(map[string]interface{})
+	if config["temperature"] != 0.5 || config["topK"] != float64(40) || config["n"] != float64(1) {
+		t.Fatalf("Google generation config is incorrect: %v", config)
+	}
+	if settings, ok := (*body)["safetySettings"].([]interface{}); !ok || len(settings) != 1 {
+		t.Fatalf("Google safety settings are incorrect: %v", (*body)["safetySettings"])
+	}
+}
+
+// TestProviderErrors tests that failed requests are reported as classified
+// provider errors
+func TestProviderErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		header     http.Header
+		body       string
+		create     func(endpoint string) (core.LLMProvider, error)
+		category   core.ErrorCategory
+		retryAfter time.Duration
+		retryable  bool
+	}{
+		{
+			name:   "openai rate limit",
+			status: http.StatusTooManyRequests,
+			header: http.Header{"Retry-After": []string{"2"}},
+			body:   `{"error":{"message":"Rate limit reached","type":"requests","code":"rate_limit_exceeded"}}`,
+			create: func(endpoint string) (core.LLMProvider, error) {
+				return openai.NewProvider(openai.Config{APIKey: "test", Endpoint: endpoint})
+			},
+			category:   core.ErrorCategoryRateLimit,
+			retryAfter: 2 * time.Second,
+			retryable:  true,
+		},
+		{
+			name:   "openai quota",
+			status: http.StatusTooManyRequests,
+			body:   `{"error":{"message":"You exceeded your current quota","type":"insufficient_quota","code":"insufficient_quota"}}`,
+			create: func(endpoint string) (core.LLMProvider, error) {
+				return openai.NewProvider(openai.Config{APIKey: "test", Endpoint: endpoint})
+			},
+			category: core.ErrorCategoryQuotaExceeded,
+		},
+		{
+			name:   "mistral context length",
+			status: http.StatusBadRequest,
+			body:   `{"object":"error","message":"Prompt contains 40000 tokens, too many tokens for model","type":"invalid_request_error"}`,
+			create: func(endpoint string) (core.LLMProvider, error) {
+				return mistral.NewProvider(mistral.Config{APIKey: "test", Endpoint: endpoint})
+			},
+			category: core.ErrorCategoryContextLength,
+		},
+		{
+			name:   "anthropic overloaded",
+			status: 529,
+			body:   `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+			create: func(endpoint string) (core.LLMProvider, error) {
+				return anthropic.NewProvider(anthropic.Config{APIKey: "test", Endpoint: endpoint})
+			},
+			category:  core.ErrorCategoryServer,
+			retryable: true,
+		},
+		{
+			name:   "anthropic authentication",
+			status: http.StatusUnauthorized,
+			body:   `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`,
+			create: func(endpoint string) (core.LLMProvider, error) {
+				return anthropic.NewProvider(anthropic.Config{APIKey: "test", Endpoint: endpoint})
+			},
+			category: core.ErrorCategoryAuthentication,
+		},
+		{
+			name:   "google resource exhausted",
+			status: http.StatusTooManyRequests,
+			body:   `{"error":{"code":429,"message":"Quota exceeded","status":"RESOURCE_EXHAUSTED","details":[{"@type":"type.googleapis.com/google.rpc.RetryInfo","retryDelay":"30s"}]}}`,
+			create: func(endpoint string) (core.LLMProvider, error) {
+				return google.NewProvider(google.Config{APIKey: "test", Endpoint: endpoint})
+			},
+			category:   core.ErrorCategoryRateLimit,
+			retryAfter: 30 * time.Second,
+			retryable:  true,
+		},
+		{
+			name:   "llama server error",
+			status: http.StatusInternalServerError,
+			body:   `{"error":"model crashed"}`,
+			create: func(endpoint string) (core.LLMProvider, error) {
+				return llama.NewProvider(llama.Config{Model: "llama", Endpoint: endpoint})
+			},
+			category:  core.ErrorCategoryServer,
+			retryable: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for key, values := range tt.header {
+					w.Header()[key] = values
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+
+			provider, err := tt.create(server.URL)
+			if err != nil {
+				t.Fatalf("Failed to create provider: %v", err)
+			}
+
+			_, err = provider.Generate(context.Background(), core.NewPrompt("Hello"))
+			var providerErr *core.ProviderError
+			if !errors.As(err, &providerErr) {
+				t.Fatalf("Expected a provider error, got %v", err)
+			}
+			if providerErr.Category != tt.category {
+				t.Fatalf("Expected category %s, got %s", tt.category, providerErr.Category)
+			}
+			if providerErr.StatusCode != tt.status || providerErr.Body != tt.body {
+				t.Fatalf("Status or body is incorrect: %d %q", providerErr.StatusCode, providerErr.Body)
+			}
+			if providerErr.Provider != provider.Name() {
+				t.Fatalf("Expected provider %s, got %s", provider.Name(), providerErr.Provider)
+			}
+			if providerErr.RetryAfter != tt.retryAfter {
+				t.Fatalf("Expected retry after %v, got %v", tt.retryAfter, providerErr.RetryAfter)
+			}
+			if core.IsRetryable(err) != tt.retryable {
+				t.Fatalf("Expected retryable %v", tt.retryable)
+			}
+		})
+	}
+
+	// Transport failures are network errors
+	provider, err := openai.NewProvider(openai.Config{APIKey: "test", Endpoint: "http://127.0.0.1:1"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	_, err = provider.Generate(context.Background(), core.NewPrompt("Hello"))
+	var providerErr *core.ProviderError
+	if !errors.As(err, &providerErr) || providerErr.Category != core.ErrorCategoryNetwork {
+		t.Fatalf("Expected a network error, got %v", err)
+	}
+}
+
+// TestStreaming tests that every provider decodes its streaming format,
+// including events split over several lines, errors sent mid-stream and the
+// reported usage
+func TestStreaming(t *testing.T) {
+	large := strings.Repeat("a", 2<<20)
+
+	tests := []struct {
+		name         string
+		body         string
+		create       func(endpoint string) (core.LLMProvider, error)
+		text         string
+		finishReason string
+		arguments    string
+		tokens       int
+		id           string
+		category     core.ErrorCategory
+	}{
+		{
+			name: "openai",
+			body: ": keep-alive\r\n\r\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"" + large + "\"}}]}\r\n\r\n" +
+				"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"call_1\",\"function\":{\"name\":\"get_weather\",\"arguments\":\"{\\\"city\\\"\"}}]}}]}\r\n\r\n" +
+				"data: {\"id\":\"chatcmpl-1\",\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\":\\\"Paris\\\"}\"}}]},\"finish_reason\":\"tool_calls\"}]}\r\n\r\n" +
+				"data: {\"id\":\"chatcmpl-1\",\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":7,\"total_tokens\":12}}\r\n\r\n" +
+				"data: [DONE]\r\n\r\n",
+			create: func(endpoint string) (core.LLMProvider, error) {
+				return openai.NewProvider(openai.Config{APIKey: "test", Endpoint: endpoint})
+			},
+			text:         large,
+			finishReason: "tool_calls",
+			arguments:    `{"city":"Paris"}`,
+			tokens:       12,
+			id:           "chatcmpl-1",
+		},
+		{
+			name: "openai usage with finish reason",
+			body: "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n" +
+				"data: {\"id\":\"chatcmpl-2\",\"choices\":[{\"delta\":{\"content\":\"lo\"},\"finish_reason\":\"stop\"}],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":2,\"total_tokens\":7}}\n\n" +
+				"data: [DONE]\n\n",
+			create: func(endpoint string) (core.LLMProvider, error) {
+				return openai.NewProvider(openai.Config{APIKey: "test", Endpoint: endpoint})
+			},
+			text:         "Hello",
+			finishReason: "stop",
+			tokens:       7,
+			id:           "chatcmpl-2",
+		},
+		{
+			name: "openai error",
+			body: "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n" +
+				"data: {\"error\":{\"message\":\"The server had an error\",\"type\":\"server_error\"}}\n\n",
+			create: func(endpoint string) (core.LLMProvider, error) {
+				return openai.NewProvider(openai.Config{APIKey: "test", Endpoint: endpoint})
+			},
+			text:     "Hel",
+			category: core.ErrorCategoryServer,
+		},
+		{
+			name: "anthropic",
+			body: "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"usage\":{\"input_tokens\":10}}}\n\n" +
+				"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n" +
+				"event: ping\ndata: {\"type\":\"ping\"}\n\n" +
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hello\"}}\n\n" +
+				"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n" +
+				"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_1\",\"name\":\"get_weather\",\"input\":{}}}\n\n" +
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{\\\"city\\\":\"}}\n\n" +
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"\\\"Paris\\\"}\"}}\n\n" +
+				"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"tool_use\"},\"usage\":{\"output_tokens\":20}}\n\n" +
+				"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+			create: func(endpoint string) (core.LLMProvider, error) {
+				return anthropic.NewProvider(anthropic.Config{APIKey: "test", Endpoint: endpoint})
+			},
+			text:         "Hello",
+			finishReason: "tool_use",
+			arguments:    `{"city":"Paris"}`,
+			tokens:       30,
+			id:           "msg_1",
+		},
+		{
+			name: "anthropic error",
+			body: "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hel\"}}\n\n" +
+				"event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n",
+			create: func(endpoint string) (core.LLMProvider, error) {
+				return anthropic.NewProvider(anthropic.Config{APIKey: "test", Endpoint: endpoint})
+			},
+			text:     "Hel",
+			category: core.ErrorCategoryServer,
+		},
+		{
+			name: "google",
+			body: "data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"Hel\"}]}}]}\r\n\r\n" +
+				"data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"lo\"}]},\"finishReason\":\"STOP\"}],\"responseId\":\"resp-1\"}\r\n\r\n" +
+				"data: {\"usageMetadata\":{\"promptTokenCount\":3,\"candidatesTokenCount\":2,\"totalTokenCount\":5},\"responseId\":\"resp-1\"}\r\n\r\n",
+			create: func(endpoint string) (core.LLMProvider, error) {
+				return google.NewProvider(google.Config{APIKey: "test", Endpoint: endpoint})
+			},
+			text:         "Hello",
+			finishReason: "STOP",
+			tokens:       5,
+			id:           "resp-1",
+		},
+		{
+			name: "mistral",
+			body: "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"lo\"},\n" +
+				"data: \"finish_reason\":\"stop\"}],\"usage\":{\"prompt_tokens\":4,\"completion_tokens\":2,\"total_tokens\":6}}\n\n" +
+				"data: [DONE]\n\n",
+			create: func(endpoint string) (core.LLMProvider, error) {
+				return mistral.NewProvider(mistral.Config{APIKey: "test", Endpoint: endpoint})
+			},
+			text:         "Hello",
+			finishReason: "stop",
+			tokens:       6,
+		},
+		{
+			name: "llama",
+			body: "data: {\"content\":\"Hel\",\"stop\":false}\n\n" +
+				"data: {\"content\":\"lo\",\"stop\":true}\n\n",
+			create: func(endpoint string) (core.LLMProvider, error) {
+				return llama.NewProvider(llama.Config{Model: "llama", Endpoint: endpoint})
+			},
+			text:         "Hello",
+			finishReason: "stop",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, body := newCaptureServer(t, tt.body)
+
+			provider, err := tt.create(server.URL)
+			if err != nil {
+				t.Fatalf("Failed to create provider: %v", err)
+			}
+
+			stream, err := provider.GenerateStream(context.Background(), core.NewPrompt("Hello"))
+			if err != nil {
+				t.Fatalf("Failed to start stream: %v", err)
+			}
+			defer stream.Close()
+
+			handler := &streaming.TextStreamHandler{}
+			response, err := streaming.NewStreamProcessor(handler).Process(context.Background(), stream)
+			if handler.Text != tt.text {
+				t.Fatalf("Stream text is incorrect: %.50q", handler.Text)
+			}
+
+			if tt.category != core.ErrorCategoryUnknown {
+				var providerErr *core.ProviderError
+				if !errors.As(err, &providerErr) {
+					t.Fatalf("Expected a provider error, got %v", err)
+				}
+				if providerErr.Category != tt.category {
+					t.Fatalf("Expected category %s, got %s", tt.category, providerErr.Category)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected stream error: %v", err)
+			}
+
+			if response.FinishReason != tt.finishReason {
+				t.Fatalf("Expected finish reason %q, got %q", tt.finishReason, response.FinishReason)
+			}
+			var arguments string
+			for _, call := range response.ToolCalls {
+				arguments += call.Arguments
+			}
+			if arguments != tt.arguments {
+				t.Fatalf("Expected arguments %s, got %s", tt.arguments, arguments)
+			}
+
+			// The processor assembles the metadata of the chunks
+			if tt.tokens > 0 && (response.TokensUsed == nil || response.TokensUsed.Total != tt.tokens) {
+				t.Fatalf("Expected %d tokens, got %+v", tt.tokens, response.TokensUsed)
+			}
+			if response.ID != tt.id {
+				t.Fatalf("Expected ID %q, got %q", tt.id, response.ID)
+			}
+			if response.ModelInfo == nil || response.ProviderInfo == nil || response.ProviderInfo.Name != provider.Name() {
+				t.Fatalf("Model or provider info is missing: %+v %+v", response.ModelInfo, response.ProviderInfo)
+			}
+
+			// OpenAI only reports the usage of streams on request
+			if tt.name == "openai" {
+				if options, ok := (*body)["stream_options"].(map[string]interface{}); !ok || options["include_usage"] != true {
+					t.Fatalf("Usage was not requested: %v", (*body)["stream_options"])
+				}
+			}
+		})
+	}
+}
+
+// TestOpenAICompatible tests that OpenAI-compatible providers can be
+// registered under their own names with their own quirks
+func TestOpenAICompatible(t *testing.T) {
+	var request *http.Request
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = r
+		body = map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Failed to decode request body: %v", err)
+		}
+		io.WriteString(w, `{"id":"cmpl-1","choices":[{"message":{"role":"assistant","content":"{\"city\":\"Paris\"}"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	groq, err := openaicompat.NewProvider(openaicompat.Config{
+		Name:     "groq",
+		Endpoint: server.URL + "/openai/v1/",
+		APIKey:   "groq-key",
+		Model:    "llama3-70b",
+		Headers:  map[string]string{"X-Title": "gollem"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	local, err := openaicompat.NewProvider(openaicompat.Config{
+		Name:       "local",
+		Endpoint:   server.URL,
+		APIKey:     "local-key",
+		Model:      "qwen",
+		AuthScheme: openaicompat.AuthHeader,
+		AuthHeader: "X-API-Key",
+		ChatPath:   "v1/chat",
+		Features: openai.Features{
+			DisableTools:      true,
+			DisableJSONSchema: true,
+			UnsupportedParams: []string{"frequency_penalty"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	registry := core.NewRegistry()
+	registry.RegisterProvider(groq)
+	registry.RegisterProvider(local)
+
+	// The provider sends its headers to its base URL
+	provider, exists := registry.GetProvider("groq")
+	if !exists {
+		t.Fatal("Provider is not registered under its name")
+	}
+	response, err := provider.Generate(context.Background(), core.NewPrompt("Hello"))
+	if err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	if request.URL.Path != "/openai/v1/chat/completions" {
+		t.Fatalf("Path is incorrect: %s", request.URL.Path)
+	}
+	if request.Header.Get("Authorization") != "Bearer groq-key" || request.Header.Get("X-Title") != "gollem" {
+		t.Fatalf("Headers are incorrect: %v", request.Header)
+	}
+	if response.ModelInfo.Provider != "groq" || response.ProviderInfo.Name != "groq" || response.ID != "cmpl-1" {
+		t.Fatalf("Response metadata is incorrect: %+v %+v", response.ModelInfo, response.ProviderInfo)
+	}
+
+	// Unsupported parameters are not sent, and structured output falls back
+	// to JSON mode
+	provider, _ = registry.GetProvider("local")
+	prompt := core.NewPrompt("Where is the Eiffel Tower?")
+	prompt.FrequencyPenalty = 0.5
+	prompt.Tools = []core.Tool{{Name: "search", Parameters: validation.JSONSchema{Type: "object"}}}
+	prompt.Schema = validation.JSONSchema{
+		Type:       "object",
+		Properties: map[string]validation.JSONSchema{"city": {Type: "string"}},
+	}
+	response, err = provider.Generate(context.Background(), prompt)
+	if err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	if request.URL.Path != "/v1/chat" || request.Header.Get("X-API-Key") != "local-key" || request.Header.Get("Authorization") != "" {
+		t.Fatalf("Request is incorrect: %s %v", request.URL.Path, request.Header)
+	}
+	for _, field := range []string{"frequency_penalty", "tools", "tool_choice"} {
+		if _, exists := body[field]; exists {
+			t.Fatalf("Unsupported field %s was sent", field)
+		}
+	}
+	if format, _ := body["response_format"].(map[string]interface{}); format["type"] != "json_object" {
+		t.Fatalf("Response format is incorrect: %v", body["response_format"])
+	}
+	if response.StructuredOutput == nil {
+		t.Fatal("Structured output was not parsed")
+	}
+
+	// Errors are reported under the name of the provider
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer failing.Close()
+	provider, err = openaicompat.NewProvider(openaicompat.Config{Name: "together", Endpoint: failing.URL, Model: "mixtral"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	_, err = provider.Generate(context.Background(), core.NewPrompt("Hello"))
+	var providerErr *core.ProviderError
+	if !errors.As(err, &providerErr) || providerErr.Provider != "together" || providerErr.Category != core.ErrorCategoryRateLimit {
+		t.Fatalf("Expected a rate limit error from together, got %v", err)
+	}
+}
+
+// TestAzure tests the Azure OpenAI provider against a local server
+func TestAzure(t *testing.T) {
+	var request *http.Request
+	var body map[string]interface{}
+	responses := map[string]string{
+		"/openai/deployments/gpt-4o/chat/completions": `{"id":"cmpl-1","choices":[{"message":{"role":"assistant","content":"Hello"},"finish_reason":"stop","content_filter_results":{"violence":{"filtered":false,"severity":"low"},"hate":{"filtered":false,"severity":"safe"}}}]}`,
+		"/openai/deployments/ada/embeddings":          `{"data":[{"index":1,"embedding":[0.3,0.4]},{"index":0,"embedding":[0.1,0.2]}]}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = r
+		body = map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Failed to decode request body: %v", err)
+		}
+		switch {
+		case body["stream"] == true:
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Once\"}}]}\n\n"+
+				"data: {\"choices\":[{\"delta\":{},\"finish_reason\":\"content_filter\"}]}\n\n"+
+				"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":1,\"completion_tokens\":1,\"total_tokens\":2}}\n\n"+
+				"data: [DONE]\n\n")
+		case strings.Contains(fmt.Sprint(body["messages"]), "blocked"):
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error":{"code":"content_filter","message":"The prompt was filtered","innererror":{"code":"ResponsibleAIPolicyViolation","content_filter_result":{"hate":{"filtered":false,"severity":"safe"},"jailbreak":{"filtered":true,"detected":true}}}}}`)
+		case responses[r.URL.Path] != "":
+			io.WriteString(w, responses[r.URL.Path])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := azure.NewProvider(azure.Config{
+		Endpoint:            server.URL + "/",
+		Deployment:          "gpt-4o",
+		EmbeddingDeployment: "ada",
+		APIKey:              "azure-key",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	// Requests are sent to the deployment with the API version and key
+	response, err := provider.Generate(context.Background(), core.NewPrompt("Hello"))
+	if err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	if request.URL.Query().Get("api-version") != azure.DefaultAPIVersion || request.Header.Get("api-key") != "azure-key" ||
+		request.Header.Get("Authorization") != "" {
+		t.Fatalf("Request is incorrect: %s %v", request.URL, request.Header)
+	}
+	if response.Text != "Hello" || response.ProviderInfo.Name != "azure" {
+		t.Fatalf("Response is incorrect: %+v", response)
+	}
+
+	// The results of the content filter are returned as safety ratings
+	ratings := response.SafetyRatings
+	if len(ratings) != 2 || ratings[0] != (core.SafetyRating{Category: "hate", Probability: "safe"}) ||
+		ratings[1] != (core.SafetyRating{Category: "violence", Probability: "low"}) {
+		t.Fatalf("Safety ratings are incorrect: %+v", ratings)
+	}
+
+	// Blocked prompts and responses are reported as content filter errors,
+	// with the results of the filter
+	response, err = provider.Generate(context.Background(), core.NewPrompt("blocked"))
+	var providerErr *core.ProviderError
+	if !errors.Is(err, azure.ErrContentFiltered) || !errors.As(err, &providerErr) ||
+		providerErr.Category != core.ErrorCategoryContentFilter || providerErr.Provider != "azure" {
+		t.Fatalf("Expected a content filter error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "(jailbreak)") || response == nil || len(response.SafetyRatings) != 2 ||
+		response.SafetyRatings[1] != (core.SafetyRating{Category: "jailbreak", Probability: "detected", Blocked: true}) {
+		t.Fatalf("Blocked prompt is missing the filter results: %+v %v", response, err)
+	}
+
+	stream, err := provider.GenerateStream(context.Background(), core.NewPrompt("Tell me a story"))
+	if err != nil {
+		t.Fatalf("Failed to generate stream: %v", err)
+	}
+	defer stream.Close()
+	chunk, err := stream.Next()
+	if err != nil || chunk.Text != "Once" {
+		t.Fatalf("First chunk is incorrect: %+v %v", chunk, err)
+	}
+	if _, err := stream.Next(); !errors.Is(err, azure.ErrContentFiltered) {
+		t.Fatalf("Expected a content filter error, got %v", err)
+	}
+	if _, ok := body["stream_options"]; !ok {
+		t.Fatal("Usage of the stream was not requested")
+	}
+
+	// Older API versions do not accept stream options
+	older, err := azure.NewProvider(azure.Config{Endpoint: server.URL, Deployment: "gpt-4o", APIKey: "azure-key", APIVersion: "2024-06-01"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	stream, err = older.GenerateStream(context.Background(), core.NewPrompt("Tell me a story"))
+	if err != nil {
+		t.Fatalf("Failed to generate stream: %v", err)
+	}
+	stream.Close()
+	if _, ok := body["stream_options"]; ok {
+		t.Fatalf("Stream options were sent to an older API version: %v", body["stream_options"])
+	}
+
+	// Embeddings use their own deployment and keep the order of the inputs
+	embedded, err := provider.Embed(context.Background(), &core.EmbeddingRequest{Texts: []string{"a", "b"}})
+	if err != nil {
+		t.Fatalf("Failed to embed texts: %v", err)
+	}
+	embeddings := embedded.Embeddings
+	if len(embeddings) != 2 || embeddings[0][0] != 0.1 || embeddings[1][0] != 0.3 {
+		t.Fatalf("Embeddings are incorrect: %v", embeddings)
+	}
+	if body["model"] != "ada" {
+		t.Fatalf("Embedding model is incorrect: %v", body["model"])
+	}
+
+	// A bearer token replaces the API key
+	provider, err = azure.NewProvider(azure.Config{Endpoint: server.URL, Deployment: "gpt-4o", BearerToken: "token", APIVersion: "2024-10-21"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	if _, err := provider.Generate(context.Background(), core.NewPrompt("Hello")); err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	if request.Header.Get("Authorization") != "Bearer token" || request.Header.Get("api-key") != "" ||
+		request.URL.Query().Get("api-version") != "2024-10-21" {
+		t.Fatalf("Request is incorrect: %s %v", request.URL, request.Header)
+	}
+	if _, err := provider.Embed(context.Background(), &core.EmbeddingRequest{Texts: []string{"a"}}); err == nil {
+		t.Fatal("Expected an error without an embedding deployment")
+	}
+}
+
+// TestGoogleVertex tests the Vertex AI mode, safety settings and candidates
+// of the Google provider
+func TestGoogleVertex(t *testing.T) {
+	var request *http.Request
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = r
+		body = map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Failed to decode request body: %v", err)
+		}
+		prompt := fmt.Sprint(body["contents"])
+		switch {
+		case strings.HasSuffix(r.URL.Path, ":predict"):
+			io.WriteString(w, `{"predictions":[{"embeddings":{"values":[0.1,0.2],"statistics":{"token_count":3}}}]}`)
+		case strings.HasSuffix(r.URL.Path, ":streamGenerateContent"):
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, "data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"Once\"}]}}]}\n\n"+
+				"data: {\"candidates\":[{\"content\":{\"parts\":[]},\"finishReason\":\"SAFETY\"}]}\n\n")
+		case strings.Contains(prompt, "weather"):
+			io.WriteString(w, `{"candidates":[{"content":{"role":"model","parts":[`+
+				`{"functionCall":{"name":"get_weather","args":{"city":"Paris"}}},`+
+				`{"functionCall":{"name":"get_weather","args":{"city":"Rome"}}}]},"finishReason":"STOP"}],`+
+				`"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":5,"totalTokenCount":15,"cachedContentTokenCount":8}}`)
+		case strings.Contains(prompt, "forbidden"):
+			io.WriteString(w, `{"promptFeedback":{"blockReason":"SAFETY","safetyRatings":[{"category":"HARM_CATEGORY_DANGEROUS_CONTENT","probability":"HIGH","blocked":true}]}}`)
+		default:
+			io.WriteString(w, `{"candidates":[`+
+				`{"content":{"parts":[]},"finishReason":"SAFETY","safetyRatings":[{"category":"HARM_CATEGORY_HARASSMENT","probability":"MEDIUM","blocked":true}]},`+
+				`{"index":1,"content":{"role":"model","parts":[{"text":"Hello"}]},"finishReason":"STOP","safetyRatings":[{"category":"HARM_CATEGORY_HARASSMENT","probability":"NEGLIGIBLE"}]}]}`)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := google.NewProvider(google.Config{
+		Project:        "my-project",
+		Endpoint:       server.URL + "/v1/projects/my-project/locations/us-central1/publishers/google",
+		Model:          "gemini-1.5-pro",
+		TokenSource:    func(ctx context.Context) (string, error) { return "vertex-token", nil },
+		SafetySettings: []google.SafetySetting{{Category: "HARM_CATEGORY_HARASSMENT", Threshold: "BLOCK_ONLY_HIGH"}},
+		CandidateCount: 2,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	// Requests are authenticated with the bearer token and carry the safety
+	// settings and candidate count
+	response, err := provider.Generate(context.Background(), core.NewPrompt("Hello"))
+	if err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	if request.URL.Path != "/v1/projects/my-project/locations/us-central1/publishers/google/models/gemini-1.5-pro:generateContent" ||
+		request.URL.Query().Get("key") != "" || request.Header.Get("Authorization") != "Bearer vertex-token" {
+		t.Fatalf("Request is incorrect: %s %v", request.URL, request.Header)
+	}
+	if fmt.Sprint(body["safetySettings"]) != "[map[category:HARM_CATEGORY_HARASSMENT threshold:BLOCK_ONLY_HIGH]]" {
+		t.Fatalf("Safety settings are incorrect: %v", body["safetySettings"])
+	}
+	if config, _ := body["generationConfig"].(map[string]interface{}); config["candidateCount"] != float64(2) {
+		t.Fatalf("Generation config is incorrect: %v", body["generationConfig"])
+	}
+
+	// The first candidate that was not blocked is the response
+	if response.Text != "Hello" || response.FinishReason != "STOP" || len(response.Candidates) != 2 {
+		t.Fatalf("Response is incorrect: %+v", response)
+	}
+	if response.Candidates[0].FinishReason != "SAFETY" || !response.Candidates[0].SafetyRatings[0].Blocked ||
+		response.Candidates[1].Index != 1 || response.SafetyRatings[0].Probability != "NEGLIGIBLE" {
+		t.Fatalf("Candidates are incorrect: %+v", response.Candidates)
+	}
+
+	// Calls to the same function get their own IDs, and cached tokens are
+	// reported
+	response, err = provider.Generate(context.Background(), core.NewPrompt("What is the weather in Paris and Rome?"))
+	if err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	if len(response.ToolCalls) != 2 || response.ToolCalls[0].ID == response.ToolCalls[1].ID {
+		t.Fatalf("Tool calls are incorrect: %+v", response.ToolCalls)
+	}
+	if response.TokensUsed.CacheRead != 8 {
+		t.Fatalf("Token usage is incorrect: %+v", response.TokensUsed)
+	}
+
+	// Blocked prompts are reported as content filter errors with the ratings
+	response, err = provider.Generate(context.Background(), core.NewPrompt("forbidden"))
+	var providerErr *core.ProviderError
+	if !errors.Is(err, google.ErrBlocked) || !errors.As(err, &providerErr) ||
+		providerErr.Category != core.ErrorCategoryContentFilter || !strings.Contains(providerErr.Message, "SAFETY") {
+		t.Fatalf("Expected a blocked prompt error, got %v", err)
+	}
+	if response == nil || response.FinishReason != "SAFETY" || len(response.SafetyRatings) != 1 ||
+		response.SafetyRatings[0].Category != "HARM_CATEGORY_DANGEROUS_CONTENT" {
+		t.Fatalf("Blocked response is incorrect: %+v", response)
+	}
+
+	// Streams fail when the rest of the response is blocked
+	stream, err := provider.GenerateStream(context.Background(), core.NewPrompt("Tell me a story"))
+	if err != nil {
+		t.Fatalf("Failed to generate stream: %v", err)
+	}
+	defer stream.Close()
+	chunk, err := stream.Next()
+	if err != nil || chunk.Text != "Once" {
+		t.Fatalf("First chunk is incorrect: %+v %v", chunk, err)
+	}
+	if _, err := stream.Next(); !errors.Is(err, google.ErrBlocked) {
+		t.Fatalf("Expected a blocked response error, got %v", err)
+	}
+
+	// Embeddings use the predict method of Vertex AI
+	embedded, err := provider.Embed(context.Background(), &core.EmbeddingRequest{
+		Texts:     []string{"a"},
+		InputType: core.EmbeddingInputQuery,
+	})
+	if err != nil {
+		t.Fatalf("Failed to embed texts: %v", err)
+	}
+	if len(embedded.Embeddings) != 1 || embedded.Embeddings[0][1] != 0.2 || embedded.TokensUsed.Prompt != 3 {
+		t.Fatalf("Embeddings are incorrect: %+v", embedded)
+	}
+	if !strings.HasSuffix(request.URL.Path, "/models/text-embedding-004:predict") ||
+		fmt.Sprint(body["instances"]) != "[map[content:a task_type:RETRIEVAL_QUERY]]" {
+		t.Fatalf("Embedding request is incorrect: %s %v", request.URL, body)
+	}
+
+	// Vertex AI does not require an API key, but needs some credentials
+	if _, err := google.NewProvider(google.Config{Project: "my-project"}); err == nil {
+		t.Fatal("Expected an error without credentials")
+	}
+}
+
+// TestOllama tests the Ollama provider against a local server
+func TestOllama(t *testing.T) {
+	var paths []string
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		body = map[string]interface{}{}
+		if r.Method == "POST" {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("Failed to decode request body: %v", err)
+			}
+		}
+		switch {
+		case r.URL.Path == "/api/chat" && body["stream"] == true:
+			io.WriteString(w, `{"model":"llama3.1","message":{"role":"assistant","content":"Hel"},"done":false}`+"\n"+
+				`{"model":"llama3.1","message":{"role":"assistant","content":"lo"},"done":false}`+"\n"+
+				`{"model":"llama3.1","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":4,"eval_count":2}`+"\n")
+		case r.URL.Path == "/api/chat":
+			io.WriteString(w, `{"model":"llama3.1","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Paris"}}}]},"done":true,"done_reason":"stop","prompt_eval_count":10,"eval_count":5}`)
+		case r.URL.Path == "/api/generate":
+			io.WriteString(w, `{"model":"llama3.1","response":"{\"city\":\"Paris\"}","done":true,"done_reason":"stop"}`)
+		case r.URL.Path == "/api/embed":
+			io.WriteString(w, `{"model":"nomic-embed-text","embeddings":[[0.1,0.2],[0.3,0.4]]}`)
+		case r.URL.Path == "/api/tags":
+			io.WriteString(w, `{"models":[{"name":"llama3.1:latest","size":4661224676,"details":{"family":"llama","parameter_size":"8.0B"}}]}`)
+		case r.URL.Path == "/api/pull" && body["model"] == "missing":
+			io.WriteString(w, `{"status":"pulling manifest"}`+"\n"+`{"error":"pull model manifest: file does not exist"}`+"\n")
+		case r.URL.Path == "/api/pull":
+			io.WriteString(w, `{"status":"pulling manifest"}`+"\n"+
+				`{"status":"pulling 6a0746a1ec1a","digest":"sha256:6a0746a1ec1a","total":100,"completed":50}`+"\n"+
+				`{"status":"success"}`+"\n")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"error":"model \"unknown\" not found, try pulling it first"}`)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := ollama.NewProvider(ollama.Config{
+		Endpoint:       server.URL,
+		Model:          "llama3.1",
+		EmbeddingModel: "nomic-embed-text",
+		KeepAlive:      "10m",
+		Options:        map[string]interface{}{"num_ctx": 8192, "seed": 42, "temperature": 0.1},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	// Tool calls are sent to the chat API with the model options
+	prompt := core.NewPrompt("What is the weather in Paris?")
+	prompt.Tools = []core.Tool{{Name: "get_weather", Parameters: validation.JSONSchema{Type: "object"}}}
+	prompt.AdditionalParams["options.num_ctx"] = 4096
+	response, err := provider.Generate(context.Background(), prompt)
+	if err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	if len(response.ToolCalls) != 1 || response.ToolCalls[0].Name != "get_weather" || response.ToolCalls[0].Arguments != `{"city":"Paris"}` {
+		t.Fatalf("Tool calls are incorrect: %+v", response.ToolCalls)
+	}
+	if response.TokensUsed.Total != 15 {
+		t.Fatalf("Token usage is incorrect: %+v", response.TokensUsed)
+	}
+	options, _ := body["options"].(map[string]interface{})
+	if options["num_ctx"] != 4096.0 || options["seed"] != 42.0 || options["temperature"] != 0.7 || options["num_predict"] != 1024.0 {
+		t.Fatalf("Options are incorrect: %v", options)
+	}
+	if body["keep_alive"] != "10m" || body["stream"] != false || len(body["tools"].([]interface{})) != 1 {
+		t.Fatalf("Request is incorrect: %v", body)
+	}
+
+	// Tool results are sent back with the arguments as an object
+	prompt = core.NewChatPrompt(
+		core.Message{Role: core.RoleUser, Content: "What is the weather in Paris?"},
+		core.Message{Role: core.RoleAssistant, ToolCalls: response.ToolCalls},
+		core.ToolResultMessage(response.ToolCalls[0], "Sunny"),
+	)
+	if _, err := provider.Generate(context.Background(), prompt); err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	messages := body["messages"].([]interface{})
+	call := messages[1].(map[string]interface{})["tool_calls"].([]interface{})[0].(map[string]interface{})
+	if call["function"].(map[string]interface{})["arguments"].(map[string]interface{})["city"] != "Paris" ||
+		messages[2].(map[string]interface{})["tool_name"] != "get_weather" {
+		t.Fatalf("Messages are incorrect: %v", messages)
+	}
+
+	// Streams are decoded from NDJSON
+	stream, err := provider.GenerateStream(context.Background(), core.NewPrompt("Hello"))
+	if err != nil {
+		t.Fatalf("Failed to generate stream: %v", err)
+	}
+	response, err = streaming.NewStreamProcessor(&streaming.DefaultStreamHandler{}).Process(context.Background(), stream)
+	if err != nil {
+		t.Fatalf("Failed to process stream: %v", err)
+	}
+	if response.Text != "Hello" || response.FinishReason != "stop" || response.TokensUsed.Total != 6 {
+		t.Fatalf("Streamed response is incorrect: %+v", response)
+	}
+
+	// The generate API enforces the schema with the format
+	generator, err := ollama.NewProvider(ollama.Config{Endpoint: server.URL, Model: "llama3.1", UseGenerate: true})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	prompt = core.NewPrompt("Where is the Eiffel Tower?")
+	prompt.SystemMessage = "Be concise."
+	prompt.Schema = validation.JSONSchema{
+		Type:       "object",
+		Properties: map[string]validation.JSONSchema{"city": {Type: "string"}},
+	}
+	response, err = generator.Generate(context.Background(), prompt)
+	if err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	if format, _ := body["format"].(map[string]interface{}); format["type"] != "object" || body["system"] != "Be concise." {
+		t.Fatalf("Request is incorrect: %v", body)
+	}
+	if response.StructuredOutput == nil {
+		t.Fatal("Structured output was not parsed")
+	}
+
+	// Embeddings use the embedding model
+	embedded, err := provider.Embed(context.Background(), &core.EmbeddingRequest{Texts: []string{"a", "b"}})
+	if err != nil {
+		t.Fatalf("Failed to embed texts: %v", err)
+	}
+	embeddings := embedded.Embeddings
+	if len(embeddings) != 2 || embeddings[1][0] != 0.3 || body["model"] != "nomic-embed-text" {
+		t.Fatalf("Embeddings are incorrect: %v %v", embeddings, body)
+	}
+
+	// Models are listed and pulled
+	models, err := provider.ListModels(context.Background())
+	if err != nil || len(models) != 1 || models[0].Details.ParameterSize != "8.0B" {
+		t.Fatalf("Models are incorrect: %+v %v", models, err)
+	}
+	if exists, err := provider.HasModel(context.Background(), "llama3.1"); err != nil || !exists {
+		t.Fatalf("Expected the model to exist: %v", err)
+	}
+	if exists, _ := provider.HasModel(context.Background(), "mistral"); exists {
+		t.Fatal("Expected the model not to exist")
+	}
+	var statuses []ollama.PullStatus
+	if err := provider.Pull(context.Background(), "mistral", func(status ollama.PullStatus) {
+		statuses = append(statuses, status)
+	}); err != nil {
+		t.Fatalf("Failed to pull model: %v", err)
+	}
+	if len(statuses) != 3 || statuses[1].Completed != 50 {
+		t.Fatalf("Pull statuses are incorrect: %+v", statuses)
+	}
+	if err := provider.Pull(context.Background(), "missing", nil); err == nil {
+		t.Fatal("Expected an error when pulling a missing model")
+	}
+
+	// Unknown models are reported as invalid requests
+	unknown, _ := ollama.NewProvider(ollama.Config{Endpoint: server.URL + "/v2", Model: "unknown"})
+	_, err = unknown.Generate(context.Background(), core.NewPrompt("Hello"))
+	var providerErr *core.ProviderError
+	if !errors.As(err, &providerErr) || providerErr.Category != core.ErrorCategoryInvalidRequest || !strings.Contains(providerErr.Message, "not found") {
+		t.Fatalf("Expected an invalid request error, got %v", err)
+	}
+}
+
+// TestEmbeddings tests the embeddings APIs of the providers
+func TestEmbeddings(t *testing.T) {
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Failed to decode request body: %v", err)
+		}
+		body["path"] = r.URL.Path
+		requests = append(requests, body)
+
+		// Each text is a number, which is returned as its embedding so that
+		// the order can be checked
+		var texts []interface{}
+		if input, ok := body["input"].([]interface{}); ok {
+			texts = input
+		} else if batch, ok := body["requests"].([]interface{}); ok {
+			for _, request := range batch {
+				texts = append(texts, request.(map[string]interface{})["content"])
+			}
+		} else {
+			texts = append(texts, body["content"])
+		}
+		values := make([]string, len(texts))
+		for i, text := range texts {
+			if content, ok := text.(map[string]interface{}); ok {
+				text = content["parts"].([]interface{})[0].(map[string]interface{})["text"]
+			}
+			values[i] = text.(string)
+		}
+
+		switch {
+		case strings.HasSuffix(r.URL.Path, ":embedContent"):
+			fmt.Fprintf(w, `{"embedding":{"values":[%s]}}`, values[0])
+		case strings.HasSuffix(r.URL.Path, ":batchEmbedContents"):
+			fmt.Fprint(w, `{"embeddings":[`)
+			for i, value := range values {
+				if i > 0 {
+					fmt.Fprint(w, ",")
+				}
+				fmt.Fprintf(w, `{"values":[%s]}`, value)
+			}
+			fmt.Fprint(w, `]}`)
+		case r.URL.Path == "/api/embed":
+			fmt.Fprintf(w, `{"embeddings":[[%s]],"prompt_eval_count":%d}`, strings.Join(values, "],["), len(values))
+		default:
+			// The data is returned in reverse order, identified by index
+			fmt.Fprint(w, `{"model":"embed-v1","data":[`)
+			for i := len(values) - 1; i >= 0; i-- {
+				fmt.Fprintf(w, `{"index":%d,"embedding":[%s]}`, i, values[i])
+				if i > 0 {
+					fmt.Fprint(w, ",")
+				}
+			}
+			fmt.Fprintf(w, `],"usage":{"prompt_tokens":%d,"total_tokens":%d}}`, len(values), len(values))
+		}
+	}))
+	defer server.Close()
+
+	openaiProvider, _ := openai.NewProvider(openai.Config{APIKey: "test", Endpoint: server.URL})
+	googleProvider, _ := google.NewProvider(google.Config{APIKey: "test", Endpoint: server.URL})
+	mistralProvider, _ := mistral.NewProvider(mistral.Config{APIKey: "test", Endpoint: server.URL})
+	llamaProvider, _ := llama.NewProvider(llama.Config{Endpoint: server.URL, Model: "nomic"})
+	ollamaProvider, _ := ollama.NewProvider(ollama.Config{Endpoint: server.URL, Model: "nomic-embed-text"})
+
+	tests := []struct {
+		provider   core.EmbeddingProvider
+		texts      int
+		requests   int
+		path       string
+		model      string
+		dimensions string
+		taskType   string
+	}{
+		{provider: openaiProvider, texts: 2100, requests: 2, path: "/embeddings", model: "text-embedding-3-small", dimensions: "dimensions"},
+		{provider: googleProvider, texts: 150, requests: 2, path: "/models/text-embedding-004:batchEmbedContents", taskType: "RETRIEVAL_QUERY"},
+		{provider: googleProvider, texts: 1, requests: 1, path: "/models/text-embedding-004:embedContent", dimensions: "outputDimensionality", taskType: "RETRIEVAL_QUERY"},
+		{provider: mistralProvider, texts: 130, requests: 2, path: "/embeddings", model: "mistral-embed", dimensions: "output_dimension"},
+		{provider: llamaProvider, texts: 3, requests: 1, path: "/v1/embeddings", model: "nomic"},
+		{provider: ollamaProvider, texts: 600, requests: 2, path: "/api/embed", model: "nomic-embed-text", dimensions: "dimensions"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %d", tt.provider.Name(), tt.texts), func(t *testing.T) {
+			requests = nil
+			texts := make([]string, tt.texts)
+			for i := range texts {
+				texts[i] = fmt.Sprint(i)
+			}
+
+			response, err := tt.provider.Embed(context.Background(), &core.EmbeddingRequest{
+				Texts:      texts,
+				Dimensions: 8,
+				InputType:  core.EmbeddingInputQuery,
+			})
+			if err != nil {
+				t.Fatalf("Failed to embed texts: %v", err)
+			}
+
+			// The embeddings of all batches are returned in order
+			if len(response.Embeddings) != tt.texts {
+				t.Fatalf("Expected %d embeddings, got %d", tt.texts, len(response.Embeddings))
+			}
+			for i, embedding := range response.Embeddings {
+				if embedding[0] != float32(i) {
+					t.Fatalf("Embedding %d is incorrect: %v", i, embedding)
+				}
+			}
+			if len(requests) != tt.requests || requests[0]["path"] != tt.path {
+				t.Fatalf("Expected %d requests to %s, got %d to %v", tt.requests, tt.path, len(requests), requests[0]["path"])
+			}
+
+			// The options are sent in the format of the provider
+			request := requests[0]
+			if batch, ok := request["requests"].([]interface{}); ok {
+				request = batch[0].(map[string]interface{})
+			}
+			if tt.model != "" && request["model"] != tt.model {
+				t.Fatalf("Model is incorrect: %v", request["model"])
+			}
+			if tt.dimensions != "" && request[tt.dimensions] != 8.0 {
+				t.Fatalf("Dimensions are incorrect: %v", request)
+			}
+			if tt.taskType != "" && request["taskType"] != tt.taskType {
+				t.Fatalf("Task type is incorrect: %v", request["taskType"])
+			}
+		})
+	}
+
+	// The usage of the batches is summed
+	texts := make([]string, 2100)
+	for i := range texts {
+		texts[i] = "1"
+	}
+	response, err := openaiProvider.Embed(context.Background(), &core.EmbeddingRequest{Texts: texts})
+	if err != nil {
+		t.Fatalf("Failed to embed texts: %v", err)
+	}
+	if response.TokensUsed.Prompt != 2100 || response.Model != "embed-v1" {
+		t.Fatalf("Usage is incorrect: %+v %s", response.TokensUsed, response.Model)
+	}
+}
+
+// TestMultimodal tests sending images, audio and files to the providers
+func TestMultimodal(t *testing.T) {
+	ctx := context.Background()
+	image := []byte("png")
+	prompt := core.NewPrompt("Compare these")
+	prompt.Parts = []core.ContentPart{
+		core.ImagePart(image, "image/png"),
+		{Type: core.ContentImage, URL: "https://example.com/cat.jpg", MIMEType: "image/jpeg"},
+		core.FilePart([]byte("%PDF"), "application/pdf", "report.pdf"),
+	}
+	encoded := base64.StdEncoding.EncodeToString(image)
+
+	// content returns the content of the last message of a request
+	content := func(body map[string]interface{}, field string) []interface{} {
+		t.Helper()
+		messages, _ := body[field].([]interface{})
+		if len(messages) == 0 {
+			t.Fatalf("Request has no messages: %v", body)
+		}
+		message := messages[len(messages)-1].(map[string]interface{})
+		if field == "contents" {
+			return message["parts"].([]interface{})
+		}
+		parts, ok := message["content"].([]interface{})
+		if !ok {
+			t.Fatalf("Message content is not a list of parts: %v", message)
+		}
+		return parts
+	}
+	// field returns a nested field of a JSON value
+	field := func(value interface{}, path ...string) interface{} {
+		for _, key := range path {
+			object, _ := value.(map[string]interface{})
+			value = object[key]
+		}
+		return value
+	}
+
+	// OpenAI takes image URLs, which may be data URLs, and inline files
+	server, body := newCaptureServer(t, `{"choices":[{"message":{"role":"assistant","content":"Two cats"},"finish_reason":"stop"}]}`)
+	openaiProvider, _ := openai.NewProvider(openai.Config{APIKey: "test", Endpoint: server.URL})
+	if _, err := openaiProvider.Generate(ctx, prompt); err != nil {
+		t.Fatalf("Failed to generate OpenAI response: %v", err)
+	}
+	parts := content(*body, "messages")
+	if len(parts) != 4 || field(parts[0], "text") != "Compare these" ||
+		field(parts[1], "image_url", "url") != "data:image/png;base64,"+encoded ||
+		field(parts[2], "image_url", "url") != "https://example.com/cat.jpg" ||
+		field(parts[3], "file", "filename") != "report.pdf" {
+		t.Fatalf("OpenAI content is incorrect: %v", parts)
+	}
+	audio := core.NewPrompt("Transcribe this")
+	audio.Parts = []core.ContentPart{core.AudioPart([]byte("wav"), "audio/wav")}
+	if _, err := openaiProvider.Generate(ctx, audio); err != nil {
+		t.Fatalf("Failed to generate OpenAI response: %v", err)
+	}
+	if parts := content(*body, "messages"); field(parts[1], "input_audio", "format") != "wav" {
+		t.Fatalf("OpenAI audio is incorrect: %v", parts)
+	}
+
+	// Anthropic takes image and document blocks
+	server, body = newCaptureServer(t, `{"content":[{"type":"text","text":"Two cats"}],"stop_reason":"end_turn"}`)
+	anthropicProvider, _ := anthropic.NewProvider(anthropic.Config{APIKey: "test", Endpoint: server.URL})
+	if _, err := anthropicProvider.Generate(ctx, prompt); err != nil {
+		t.Fatalf("Failed to generate Anthropic response: %v", err)
+	}
+	parts = content(*body, "messages")
+	if len(parts) != 4 || field(parts[1], "source", "type") != "base64" || field(parts[1], "source", "data") != encoded ||
+		field(parts[2], "source", "url") != "https://example.com/cat.jpg" ||
+		field(parts[3], "type") != "document" || field(parts[3], "source", "media_type") != "application/pdf" {
+		t.Fatalf("Anthropic content is incorrect: %v", parts)
+	}
+
+	// Google takes inline data and file data
+	server, body = newCaptureServer(t, `{"candidates":[{"content":{"parts":[{"text":"Two cats"}]},"finishReason":"STOP"}]}`)
+	googleProvider, _ := google.NewProvider(google.Config{APIKey: "test", Endpoint: server.URL})
+	if _, err := googleProvider.Generate(ctx, prompt); err != nil {
+		t.Fatalf("Failed to generate Google response: %v", err)
+	}
+	parts = content(*body, "contents")
+	if len(parts) != 4 || field(parts[1], "inlineData", "data") != encoded ||
+		field(parts[2], "fileData", "fileUri") != "https://example.com/cat.jpg" ||
+		field(parts[3], "inlineData", "mimeType") != "application/pdf" {
+		t.Fatalf("Google content is incorrect: %v", parts)
+	}
+
+	// Ollama takes inline images with the message
+	server, body = newCaptureServer(t, `{"message":{"role":"assistant","content":"A cat"},"done":true}`)
+	ollamaProvider, _ := ollama.NewProvider(ollama.Config{Endpoint: server.URL, Model: "llava"})
+	vision := core.NewPrompt("Describe this")
+	vision.Parts = []core.ContentPart{core.ImagePart(image, "image/png")}
+	if _, err := ollamaProvider.Generate(ctx, vision); err != nil {
+		t.Fatalf("Failed to generate Ollama response: %v", err)
+	}
+	messages := (*body)["messages"].([]interface{})
+	if images := field(messages[0], "images").([]interface{}); len(images) != 1 || images[0] != encoded {
+		t.Fatalf("Ollama images are incorrect: %v", messages)
+	}
+
+	// Unsupported modalities are rejected before sending the request
+	llamaProvider, _ := llama.NewProvider(llama.Config{Endpoint: server.URL, Model: "llama"})
+	mistralProvider, _ := mistral.NewProvider(mistral.Config{APIKey: "test", Endpoint: server.URL})
+	tests := []struct {
+		provider core.LLMProvider
+		prompt   *core.Prompt
+	}{
+		{provider: anthropicProvider, prompt: audio},
+		{provider: mistralProvider, prompt: audio},
+		{provider: llamaProvider, prompt: vision},
+		{provider: ollamaProvider, prompt: prompt},
+		{provider: openaiProvider, prompt: core.NewChatPrompt(core.Message{
+			Role:  core.RoleUser,
+			Parts: []core.ContentPart{core.FileURLPart("https://example.com/report.pdf", "application/pdf")},
+		})},
+	}
+	for _, tt := range tests {
+		_, err := tt.provider.Generate(ctx, tt.prompt)
+		var unsupported *core.UnsupportedError
+		if !errors.Is(err, core.ErrUnsupported) || !errors.As(err, &unsupported) || unsupported.Provider != tt.provider.Name() {
+			t.Fatalf("Expected an unsupported capability error from %s, got %v", tt.provider.Name(), err)
+		}
+	}
+}
+
+// newCaptureServer starts a server that records the last request body and
+// replies with the given response
+func newCaptureServer(t *testing.T, response string) (*httptest.Server, *map[string]interface{}) {
+	body := &map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*body = map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+			t.Errorf("Failed to decode request body: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(server.Close)
+	return server, body
+}
+
+// checkRoles checks the roles of a list of request messages
+func checkRoles(t *testing.T, provider string, messages interface{}, roles []string) {
+	t.Helper()
+	list, ok := messages.([]interface{})
+	if !ok || len(list) != len(roles) {
+		t.Fatalf("%s messages are incorrect: %v", provider, messages)
+	}
+	for i, msg := range list {
+		role := msg.(map[string]interface{})["role"]
+		if role != roles[i] {
+			t.Fatalf("%s message %d has role %v, expected %s", provider, i, role, roles[i])
+		}
+	}
+}
+
 // MockProvider is a mock implementation of the LLMProvider interface
 type MockProvider struct {
 	name string
@@ -195,3 +1689,222 @@ func (s *MockResponseStream) Next() (*core.ResponseChunk, error) {
 func (s *MockResponseStream) Close() error {
 	return nil
 }
+
+// TestModelCatalog tests looking up the capabilities of models
+func TestModelCatalog(t *testing.T) {
+	catalog := core.NewModelCatalog()
+	catalog.Register("acme", "acme-large", core.ModelCapabilities{ContextWindow: 8000, Tools: true})
+	catalog.Register("acme", "acme-large-vision", core.ModelCapabilities{ContextWindow: 8000, Vision: true, Version: "1"})
+	catalog.Register("", "shared", core.ModelCapabilities{ContextWindow: 1000})
+
+	tests := []struct {
+		provider, model string
+		contextWindow   int
+		version         string
+	}{
+		{"acme", "acme-large", 8000, ""},
+		{"acme", "acme-large-2024-08-06", 8000, "2024-08-06"},
+		{"acme", "acme-large@001", 8000, "001"},
+		{"acme", "acme-large-vision", 8000, "1"},
+		{"acme", "acme-large-vision-preview", 8000, "1"},
+		{"other", "shared:latest", 1000, "latest"},
+		{"acme", "acme-largest", 0, ""},
+		{"other", "acme-large", 0, ""},
+	}
+	for _, tt := range tests {
+		capabilities, ok := catalog.Lookup(tt.provider, tt.model)
+		if ok != (tt.contextWindow > 0) || capabilities.ContextWindow != tt.contextWindow || capabilities.Version != tt.version {
+			t.Fatalf("Capabilities of %s/%s are incorrect: %+v, %v", tt.provider, tt.model, capabilities, ok)
+		}
+	}
+
+	// Overrides keep the fields they do not set
+	if err := catalog.Override("acme", "acme-large", json.RawMessage(`{"context_window": 16000}`)); err != nil {
+		t.Fatalf("Failed to override model: %v", err)
+	}
+	if capabilities, _ := catalog.Lookup("acme", "acme-large"); capabilities.ContextWindow != 16000 || !capabilities.Tools {
+		t.Fatalf("Overridden capabilities are incorrect: %+v", capabilities)
+	}
+
+	// The built-in providers look up their model in the default catalog
+	openaiProvider, _ := openai.NewProvider(openai.Config{APIKey: "test", Model: "gpt-4o-2024-08-06"})
+	anthropicProvider, _ := anthropic.NewProvider(anthropic.Config{APIKey: "test", Model: "claude-3-5-sonnet-20241022"})
+	googleProvider, _ := google.NewProvider(google.Config{APIKey: "test", Model: "gemini-1.5-pro-002"})
+	mistralProvider, _ := mistral.NewProvider(mistral.Config{APIKey: "test", Model: "mistral-large-latest"})
+	azureProvider, _ := azure.NewProvider(azure.Config{Endpoint: "https://acme.openai.azure.com", Deployment: "gpt-4o-mini", APIKey: "test"})
+	ollamaProvider, _ := ollama.NewProvider(ollama.Config{Model: "llama3"})
+	for _, tt := range []struct {
+		provider      core.LLMProvider
+		contextWindow int
+		vision        bool
+	}{
+		{openaiProvider, 128000, true},
+		{anthropicProvider, 200000, true},
+		{googleProvider, 2097152, true},
+		{mistralProvider, 131072, false},
+		{azureProvider, 128000, true},
+	} {
+		capabilities, ok := core.CapabilitiesOf(tt.provider)
+		if !ok || capabilities.ContextWindow != tt.contextWindow || capabilities.Vision != tt.vision || !capabilities.Streaming {
+			t.Fatalf("%s capabilities are incorrect: %+v", tt.provider.Name(), capabilities)
+		}
+	}
+	if _, ok := core.CapabilitiesOf(ollamaProvider); ok {
+		t.Fatal("Expected the capabilities of unknown models to be unknown")
+	}
+
+	// The version of the model is taken from the catalog
+	server, body := newCaptureServer(t, `{"choices":[{"message":{"role":"assistant","content":"{\"city\":\"Paris\"}"},"finish_reason":"stop"}]}`)
+	openaiProvider, _ = openai.NewProvider(openai.Config{APIKey: "test", Model: "gpt-4o-2024-08-06", Endpoint: server.URL})
+	response, err := openaiProvider.Generate(context.Background(), core.NewPrompt("Hello"))
+	if err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	if response.ModelInfo.Version != "2024-08-06" {
+		t.Fatalf("Model version is incorrect: %s", response.ModelInfo.Version)
+	}
+
+	// Models without structured output are given the schema instead
+	schema := validation.JSONSchema{Type: "object", Properties: map[string]validation.JSONSchema{"city": {Type: "string"}}}
+	openaiProvider, _ = openai.NewProvider(openai.Config{APIKey: "test", Model: "gpt-4", Endpoint: server.URL})
+	if _, err := openaiProvider.Generate(context.Background(), &core.Prompt{Text: "Capital of France?", Schema: schema}); err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	if (*body)["response_format"] != nil {
+		t.Fatalf("Unexpected response format: %v", (*body)["response_format"])
+	}
+	messages, _ := (*body)["messages"].([]interface{})
+	if system, _ := messages[0].(map[string]interface{}); system["role"] != "system" || !strings.Contains(system["content"].(string), "JSON schema") {
+		t.Fatalf("System message is incorrect: %v", messages[0])
+	}
+
+	// Models with JSON mode but no schemas use JSON mode
+	openaiProvider, _ = openai.NewProvider(openai.Config{APIKey: "test", Model: "gpt-4-turbo", Endpoint: server.URL})
+	if _, err := openaiProvider.Generate(context.Background(), &core.Prompt{Text: "Capital of France?", Schema: schema}); err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	if format, _ := (*body)["response_format"].(map[string]interface{}); format["type"] != "json_object" {
+		t.Fatalf("Response format is incorrect: %v", (*body)["response_format"])
+	}
+}
+
+// TestAnthropicExtended tests prompt caching and extended thinking with the
+// Anthropic provider
+func TestAnthropicExtended(t *testing.T) {
+	ctx := context.Background()
+	server, body := newCaptureServer(t, `{"id":"msg_1","content":[`+
+		`{"type":"thinking","thinking":"Paris is the capital.","signature":"sig"},`+
+		`{"type":"redacted_thinking","data":"secret"},`+
+		`{"type":"text","text":"Paris"}],"stop_reason":"end_turn",`+
+		`"usage":{"input_tokens":10,"output_tokens":20,"cache_creation_input_tokens":100,"cache_read_input_tokens":1000}}`)
+	provider, _ := anthropic.NewProvider(anthropic.Config{
+		APIKey:         "test",
+		Endpoint:       server.URL,
+		CacheSystem:    true,
+		CacheTTL:       "1h",
+		ThinkingBudget: 2048,
+	})
+
+	prompt := core.NewChatPrompt(
+		core.Message{Role: core.RoleUser, Content: "Here is a long document.", Cache: true},
+		core.Message{Role: core.RoleAssistant, Content: "Noted.", Thinking: []core.ThinkingBlock{{Text: "A document.", Signature: "sig0"}}},
+		core.Message{Role: core.RoleUser, Content: "What is the capital of France?"},
+	)
+	prompt.SystemMessage = "You answer questions about documents."
+	prompt.Tools = []core.Tool{{Name: "search"}}
+	prompt.ToolChoice = core.ToolChoiceRequired
+	response, err := provider.Generate(ctx, prompt)
+	if err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+
+	// The reasoning is kept apart from the answer
+	if response.Text != "Paris" || len(response.Thinking) != 2 || response.ThinkingText() != "Paris is the capital." {
+		t.Fatalf("Response is incorrect: %q %+v", response.Text, response.Thinking)
+	}
+	if response.Thinking[0].Signature != "sig" || response.Thinking[1].RedactedData != "secret" {
+		t.Fatalf("Thinking blocks are incorrect: %+v", response.Thinking)
+	}
+
+	// Cached tokens are part of the prompt tokens
+	usage := response.TokensUsed
+	if usage.Prompt != 1110 || usage.Total != 1130 || usage.CacheRead != 1000 || usage.CacheWrite != 100 {
+		t.Fatalf("Token usage is incorrect: %+v", usage)
+	}
+
+	// The system message and the first message end cached prefixes
+	system, _ := (*body)["system"].([]interface{})
+	if len(system) != 1 {
+		t.Fatalf("System is incorrect: %v", (*body)["system"])
+	}
+	if cache, _ := system[0].(map[string]interface{})["cache_control"].(map[string]interface{}); cache["type"] != "ephemeral" || cache["ttl"] != "1h" {
+		t.Fatalf("System cache control is incorrect: %v", system[0])
+	}
+	messages, _ := (*body)["messages"].([]interface{})
+	first := messages[0].(map[string]interface{})["content"].([]interface{})
+	if first[0].(map[string]interface{})["cache_control"] == nil {
+		t.Fatalf("Message cache control is missing: %v", first)
+	}
+
+	// The reasoning of assistant messages is sent back first
+	assistant := messages[1].(map[string]interface{})["content"].([]interface{})
+	if block := assistant[0].(map[string]interface{}); block["type"] != "thinking" || block["signature"] != "sig0" {
+		t.Fatalf("Assistant content is incorrect: %v", assistant)
+	}
+
+	// Thinking adds its budget to the maximum tokens, and does not support
+	// sampling parameters or forced tool use
+	thinking, _ := (*body)["thinking"].(map[string]interface{})
+	if thinking["type"] != "enabled" || thinking["budget_tokens"] != float64(2048) {
+		t.Fatalf("Thinking configuration is incorrect: %v", (*body)["thinking"])
+	}
+	if (*body)["max_tokens"] != float64(1024+2048) || (*body)["temperature"] != nil || (*body)["top_p"] != nil {
+		t.Fatalf("Sampling parameters are incorrect: %v", *body)
+	}
+	if choice, _ := (*body)["tool_choice"].(map[string]interface{}); choice["type"] != "auto" {
+		t.Fatalf("Tool choice is incorrect: %v", (*body)["tool_choice"])
+	}
+
+	// Without a system message, the cache ends with the tools
+	prompt.SystemMessage = ""
+	if _, err := provider.Generate(ctx, prompt); err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	tools, _ := (*body)["tools"].([]interface{})
+	if (*body)["system"] != nil || len(tools) != 1 || tools[0].(map[string]interface{})["cache_control"] == nil {
+		t.Fatalf("Tools are incorrect: %v", (*body)["tools"])
+	}
+
+	// Streamed reasoning is assembled by block
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_2\",\"usage\":{\"input_tokens\":10,\"cache_read_input_tokens\":1000}}}\n\n"+
+			"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"thinking\",\"thinking\":\"\"}}\n\n"+
+			"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"thinking_delta\",\"thinking\":\"Paris is \"}}\n\n"+
+			"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"thinking_delta\",\"thinking\":\"the capital.\"}}\n\n"+
+			"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"signature_delta\",\"signature\":\"sig\"}}\n\n"+
+			"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n"+
+			"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n"+
+			"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"text_delta\",\"text\":\"Paris\"}}\n\n"+
+			"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":20}}\n\n"+
+			"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+	}))
+	defer server.Close()
+	provider, _ = anthropic.NewProvider(anthropic.Config{APIKey: "test", Endpoint: server.URL, ThinkingBudget: 2048})
+	stream, err := provider.GenerateStream(ctx, core.NewPrompt("What is the capital of France?"))
+	if err != nil {
+		t.Fatalf("Failed to start stream: %v", err)
+	}
+	defer stream.Close()
+
+	handler := &streaming.TextStreamHandler{}
+	response, err = streaming.NewStreamProcessor(handler).Process(ctx, stream)
+	if err != nil {
+		t.Fatalf("Failed to process stream: %v", err)
+	}
+	if handler.Text != "Paris" || response.ThinkingText() != "Paris is the capital." || response.Thinking[0].Signature != "sig" {
+		t.Fatalf("Streamed response is incorrect: %q %+v", handler.Text, response.Thinking)
+	}
+	if response.TokensUsed.Prompt != 1010 || response.TokensUsed.CacheRead != 1000 || response.TokensUsed.Completion != 20 {
+		t.Fatalf("Streamed token usage is incorrect: %+v", response.TokensUsed)
+	}
+}
@@ -3,7 +3,6 @@ package rag
 import (
 	"context"
 	"fmt"
-	"math"
 	"os"
 	"path/filepath"
 	"strings"
@@ -11,15 +10,18 @@ import (
 	"github.com/GeoloeG-IsT/gollem/pkg/core"
 )
 
-// Embeddings implements the EmbeddingProvider interface for various embedding models
+// Embeddings implements the EmbeddingsProvider interface with the
+// embeddings API of a provider
 type Embeddings struct {
-	Provider  core.LLMProvider
+	Provider  core.EmbeddingProvider
 	model     string
 	dimension int
 }
 
-// NewEmbeddings creates a new embeddings provider
-func NewEmbeddings(provider core.LLMProvider, model string, dimension int) *Embeddings {
+// NewEmbeddings creates a new embeddings provider. The model overrides the
+// embedding model of the provider if not empty, and the dimension shortens
+// the embeddings for models that support it if not 0.
+func NewEmbeddings(provider core.EmbeddingProvider, model string, dimension int) *Embeddings {
 	return &Embeddings{
 		Provider:  provider,
 		model:     model,
@@ -29,37 +31,48 @@ func NewEmbeddings(provider core.LLMProvider, model string, dimension int) *Embe
 
 // EmbedQuery generates an embedding for a query
 func (e *Embeddings) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
-	// In a real implementation, this would call the provider's API to generate an embedding
-	// For simplicity, we're just returning a random embedding
-	return e.generateRandomEmbedding(), nil
+	embeddings, err := e.embed(ctx, []string{text}, core.EmbeddingInputQuery)
+	if err != nil {
+		return nil, err
+	}
+	return embeddings[0], nil
 }
 
 // EmbedDocuments generates embeddings for documents
 func (e *Embeddings) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
-	// In a real implementation, this would call the provider's API to generate embeddings
-	// For simplicity, we're just returning random embeddings
-	embeddings := make([][]float32, len(texts))
-	for i := range embeddings {
-		embeddings[i] = e.generateRandomEmbedding()
-	}
-	return embeddings, nil
+	return e.embed(ctx, texts, core.EmbeddingInputDocument)
 }
 
 // EmbedDocument generates an embedding for a document
 func (e *Embeddings) EmbedDocument(ctx context.Context, text string) ([]float32, error) {
-	// In a real implementation, this would call the provider's API to generate an embedding
-	// For simplicity, we're just returning a random embedding
-	return e.generateRandomEmbedding(), nil
+	embeddings, err := e.embed(ctx, []string{text}, core.EmbeddingInputDocument)
+	if err != nil {
+		return nil, err
+	}
+	return embeddings[0], nil
 }
 
-// generateRandomEmbedding generates a random embedding for testing
-func (e *Embeddings) generateRandomEmbedding() []float32 {
-	// Create a deterministic embedding based on the text
-	embedding := make([]float32, e.dimension)
-	for i := range embedding {
-		embedding[i] = float32(math.Sin(float64(i) * 0.1))
+// embed generates the embeddings of texts with the provider
+func (e *Embeddings) embed(ctx context.Context, texts []string, inputType core.EmbeddingInputType) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	response, err := e.Provider.Embed(ctx, &core.EmbeddingRequest{
+		Texts:      texts,
+		Model:      e.model,
+		Dimensions: e.dimension,
+		InputType:  inputType,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate embeddings: %w", err)
+	}
+
+	if len(response.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(response.Embeddings))
 	}
-	return embedding
+
+	return response.Embeddings, nil
 }
 
 // QueryEngine performs RAG queries with different strategies
@@ -136,15 +149,17 @@ func (e *QueryEngine) Query(ctx context.Context, query string) (*core.Response,
 	// Generate a response using the LLM provider
 	// Check if the embeddings provider is of type *Embeddings
 	if embeddings, ok := e.rag.Embeddings.(*Embeddings); ok {
-		// If it is, use its Provider field
-		return embeddings.Provider.Generate(ctx, prompt)
+		// If it is, use its Provider field if it can also generate responses
+		if llmProvider, ok := embeddings.Provider.(core.LLMProvider); ok {
+			return llmProvider.Generate(ctx, prompt)
+		}
 	}
 
 	// If we're in a test environment with a mock provider, we need to handle it differently
 	// For tests, we'll use a mock provider that's passed in the context
 	llmProvider, ok := ctx.Value("llm_provider").(core.LLMProvider)
 	if !ok {
-		return nil, fmt.Errorf("no LLM provider available: embeddings provider is not *Embeddings and no provider in context")
+		return nil, fmt.Errorf("no LLM provider available: embeddings provider cannot generate responses and no provider in context")
 	}
 
 	return llmProvider.Generate(ctx, prompt)
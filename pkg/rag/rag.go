@@ -46,6 +46,10 @@ type Chunk struct {
 
 	// Embedding is the vector representation of the chunk
 	Embedding []float32
+
+	// Score is the similarity of the chunk to the query of a similarity
+	// search, set by the vector stores that report it
+	Score float32
 }
 
 // RAG represents a Retrieval-Augmented Generation system
@@ -129,25 +133,30 @@ func NewRAG(options ...RAGOption) (*RAG, error) {
 
 // AddDocument adds a document to the RAG system
 func (r *RAG) AddDocument(ctx context.Context, document *Document) error {
-	// Generate embeddings for the document if not already present
+	// Chunk the document
+	chunks := r.chunkDocument(document)
+
+	// Generate the embeddings of the document, if not already present, and
+	// of its chunks together
+	texts := make([]string, 0, len(chunks)+1)
 	if document.Embedding == nil {
-		embedding, err := r.Embeddings.EmbedDocument(ctx, document.Content)
-		if err != nil {
-			return fmt.Errorf("failed to embed document: %w", err)
-		}
-		document.Embedding = embedding
+		texts = append(texts, document.Content)
+	}
+	for _, chunk := range chunks {
+		texts = append(texts, chunk.Content)
 	}
 
-	// Chunk the document
-	chunks := r.chunkDocument(document)
+	embeddings, err := embedDocuments(ctx, r.Embeddings, texts)
+	if err != nil {
+		return fmt.Errorf("failed to embed document: %w", err)
+	}
 
-	// Generate embeddings for each chunk
+	if document.Embedding == nil {
+		document.Embedding = embeddings[0]
+		embeddings = embeddings[1:]
+	}
 	for i := range chunks {
-		embedding, err := r.Embeddings.EmbedDocument(ctx, chunks[i].Content)
-		if err != nil {
-			return fmt.Errorf("failed to embed chunk: %w", err)
-		}
-		chunks[i].Embedding = embedding
+		chunks[i].Embedding = embeddings[i]
 	}
 
 	// Add the chunks to the vector store
@@ -372,6 +381,38 @@ type EmbeddingsProvider interface {
 	EmbedQuery(ctx context.Context, text string) ([]float32, error)
 }
 
+// DocumentsEmbedder is implemented by embeddings providers that can embed
+// several documents in a single request
+type DocumentsEmbedder interface {
+	// EmbedDocuments generates embeddings for documents
+	EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// embedDocuments generates the embeddings of documents, in a single request
+// if the provider supports it
+func embedDocuments(ctx context.Context, embeddings EmbeddingsProvider, texts []string) ([][]float32, error) {
+	if embedder, ok := embeddings.(DocumentsEmbedder); ok {
+		result, err := embedder.EmbedDocuments(ctx, texts)
+		if err != nil {
+			return nil, err
+		}
+		if len(result) != len(texts) {
+			return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(result))
+		}
+		return result, nil
+	}
+
+	result := make([][]float32, len(texts))
+	for i, text := range texts {
+		embedding, err := embeddings.EmbedDocument(ctx, text)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = embedding
+	}
+	return result, nil
+}
+
 // MemoryVectorStore is an in-memory implementation of VectorStore
 type MemoryVectorStore struct {
 	chunks     []*Chunk
@@ -387,8 +428,30 @@ func NewMemoryVectorStore(embeddings EmbeddingsProvider) *MemoryVectorStore {
 	}
 }
 
-// AddChunks adds chunks to the vector store
+// AddChunks adds chunks to the vector store. Chunks without an embedding
+// are embedded with the embeddings provider of the store.
 func (s *MemoryVectorStore) AddChunks(ctx context.Context, chunks []*Chunk) error {
+	var missing []*Chunk
+	var texts []string
+	for _, chunk := range chunks {
+		if chunk.Embedding == nil {
+			missing = append(missing, chunk)
+			texts = append(texts, chunk.Content)
+		}
+	}
+	if len(missing) > 0 {
+		if s.embeddings == nil {
+			return errors.New("chunks without embeddings require an embeddings provider")
+		}
+		embeddings, err := embedDocuments(ctx, s.embeddings, texts)
+		if err != nil {
+			return fmt.Errorf("failed to embed chunks: %w", err)
+		}
+		for i, chunk := range missing {
+			chunk.Embedding = embeddings[i]
+		}
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -398,7 +461,8 @@ func (s *MemoryVectorStore) AddChunks(ctx context.Context, chunks []*Chunk) erro
 	return nil
 }
 
-// SimilaritySearch searches for chunks similar to the query embedding
+// SimilaritySearch searches for chunks similar to the query embedding,
+// with their cosine similarity as score
 func (s *MemoryVectorStore) SimilaritySearch(ctx context.Context, embedding []float32, limit int) ([]*Chunk, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -411,7 +475,7 @@ func (s *MemoryVectorStore) SimilaritySearch(ctx context.Context, embedding []fl
 
 	scores := make([]chunkScore, 0, len(s.chunks))
 	for _, chunk := range s.chunks {
-		score := cosineSimilarity(embedding, chunk.Embedding)
+		score := CosineSimilarity(embedding, chunk.Embedding)
 		scores = append(scores, chunkScore{chunk, score})
 	}
 
@@ -420,10 +484,12 @@ func (s *MemoryVectorStore) SimilaritySearch(ctx context.Context, embedding []fl
 		return scores[i].score > scores[j].score
 	})
 
-	// Return the top chunks
+	// Return copies of the top chunks with their scores
 	result := make([]*Chunk, 0, limit)
 	for i := 0; i < limit && i < len(scores); i++ {
-		result = append(result, scores[i].chunk)
+		chunk := *scores[i].chunk
+		chunk.Score = scores[i].score
+		result = append(result, &chunk)
 	}
 
 	return result, nil
@@ -463,8 +529,9 @@ func (s *MemoryVectorStore) Clear(ctx context.Context) error {
 	return nil
 }
 
-// cosineSimilarity calculates the cosine similarity between two vectors
-func cosineSimilarity(a, b []float32) float32 {
+// CosineSimilarity calculates the cosine similarity between two vectors, or
+// returns 0 if their dimensions differ
+func CosineSimilarity(a, b []float32) float32 {
 	if len(a) != len(b) {
 		return 0
 	}
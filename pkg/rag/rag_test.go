@@ -379,6 +379,54 @@ func TestRAGSystem(t *testing.T) {
 	}
 }
 
+// TestEmbeddings tests generating embeddings with an embedding provider
+func TestEmbeddings(t *testing.T) {
+	provider := &MockCoreEmbeddingProvider{}
+	embeddings := rag.NewEmbeddings(provider, "embed-small", 4)
+	ctx := context.Background()
+
+	// Queries and documents are embedded with their input type
+	query, err := embeddings.EmbedQuery(ctx, "capital of France")
+	if err != nil {
+		t.Fatalf("Failed to embed query: %v", err)
+	}
+	if len(query) != 4 || provider.requests[0].InputType != core.EmbeddingInputQuery || provider.requests[0].Model != "embed-small" {
+		t.Fatalf("Query request is incorrect: %+v", provider.requests[0])
+	}
+
+	// A document and its chunks are embedded in a single request
+	store := rag.NewMemoryVectorStore(embeddings)
+	ragSystem, err := rag.NewRAG(rag.WithVectorStore(store), rag.WithEmbeddings(embeddings), rag.WithChunkSize(20), rag.WithChunkOverlap(0))
+	if err != nil {
+		t.Fatalf("Failed to create RAG: %v", err)
+	}
+	provider.requests = nil
+	document := &rag.Document{ID: "doc1", Content: "Paris is the capital of France. Berlin is the capital of Germany."}
+	if err := ragSystem.AddDocument(ctx, document); err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+	if len(provider.requests) != 1 || len(provider.requests[0].Texts) < 3 || provider.requests[0].InputType != core.EmbeddingInputDocument {
+		t.Fatalf("Document requests are incorrect: %+v", provider.requests)
+	}
+	if document.Embedding == nil {
+		t.Fatal("Document embedding was not set")
+	}
+
+	// The vector store embeds the chunks added without embeddings
+	if err := store.AddChunks(ctx, []*rag.Chunk{{ID: "chunk1", Content: "Rome is the capital of Italy."}}); err != nil {
+		t.Fatalf("Failed to add chunks: %v", err)
+	}
+	chunks, err := store.SimilaritySearch(ctx, query, 10)
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	for _, chunk := range chunks {
+		if len(chunk.Embedding) != 4 {
+			t.Fatalf("Chunk %s has no embedding", chunk.ID)
+		}
+	}
+}
+
 // MockEmbeddingProvider is a mock implementation of the EmbeddingsProvider interface
 type MockEmbeddingProvider struct{}
 
@@ -402,6 +450,31 @@ func (p *MockEmbeddingProvider) EmbedDocument(ctx context.Context, text string)
 	return embedding, nil
 }
 
+// MockCoreEmbeddingProvider is a mock implementation of the
+// core.EmbeddingProvider interface that records its requests
+type MockCoreEmbeddingProvider struct {
+	requests []*core.EmbeddingRequest
+}
+
+// Name returns the name of the provider
+func (p *MockCoreEmbeddingProvider) Name() string {
+	return "mock_embeddings"
+}
+
+// Embed generates embeddings of the requested dimensions from the text length
+func (p *MockCoreEmbeddingProvider) Embed(ctx context.Context, request *core.EmbeddingRequest) (*core.EmbeddingResponse, error) {
+	p.requests = append(p.requests, request)
+	response := &core.EmbeddingResponse{Model: request.Model}
+	for _, text := range request.Texts {
+		embedding := make([]float32, request.Dimensions)
+		for i := range embedding {
+			embedding[i] = float32(len(text) % (i + 2))
+		}
+		response.Embeddings = append(response.Embeddings, embedding)
+	}
+	return response, nil
+}
+
 // MockProvider is a mock implementation of the LLMProvider interface
 type MockProvider struct {
 	name string
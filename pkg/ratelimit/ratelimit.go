@@ -0,0 +1,326 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/optimization"
+)
+
+// RateLimitMiddleware is a middleware that limits the rate and concurrency
+// of requests to an LLM provider. Callers are served in the order they
+// arrive.
+type RateLimitMiddleware struct {
+	provider  core.LLMProvider
+	estimator optimization.TokenEstimator
+
+	requestsPerMinute int
+	tokensPerMinute   int
+	maxConcurrency    int
+
+	mu       sync.Mutex
+	requests *bucket
+	tokens   *bucket
+	slots    chan struct{}
+}
+
+// RateLimitOption configures a RateLimitMiddleware
+type RateLimitOption func(*RateLimitMiddleware)
+
+// WithRequestsPerMinute sets the maximum number of requests per minute
+func WithRequestsPerMinute(requests int) RateLimitOption {
+	return func(m *RateLimitMiddleware) {
+		m.requestsPerMinute = requests
+	}
+}
+
+// WithTokensPerMinute sets the maximum number of tokens per minute. Tokens
+// are estimated before each request and reconciled with the token usage of
+// the response.
+func WithTokensPerMinute(tokens int) RateLimitOption {
+	return func(m *RateLimitMiddleware) {
+		m.tokensPerMinute = tokens
+	}
+}
+
+// WithMaxConcurrency sets the maximum number of requests in flight
+func WithMaxConcurrency(concurrency int) RateLimitOption {
+	return func(m *RateLimitMiddleware) {
+		m.maxConcurrency = concurrency
+	}
+}
+
+// WithTokenEstimator sets the estimator used to count the tokens of a
+// prompt before the request
+func WithTokenEstimator(estimator optimization.TokenEstimator) RateLimitOption {
+	return func(m *RateLimitMiddleware) {
+		m.estimator = estimator
+	}
+}
+
+// NewRateLimitMiddleware creates a new rate limit middleware. Limits that
+// are not set are not enforced.
+func NewRateLimitMiddleware(provider core.LLMProvider, opts ...RateLimitOption) *RateLimitMiddleware {
+	m := &RateLimitMiddleware{
+		provider:  provider,
+		estimator: &optimization.SimpleTokenEstimator{},
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	now := time.Now()
+	if m.requestsPerMinute > 0 {
+		m.requests = newBucket(m.requestsPerMinute, now)
+	}
+	if m.tokensPerMinute > 0 {
+		m.tokens = newBucket(m.tokensPerMinute, now)
+	}
+	if m.maxConcurrency > 0 {
+		m.slots = make(chan struct{}, m.maxConcurrency)
+	}
+
+	return m
+}
+
+// Middleware returns a core.Middleware that limits the requests to each
+// provider it wraps. Every provider gets its own limits.
+func Middleware(opts ...RateLimitOption) core.Middleware {
+	return func(provider core.LLMProvider) core.LLMProvider {
+		return NewRateLimitMiddleware(provider, opts...)
+	}
+}
+
+// Name returns the name of the wrapped provider
+func (m *RateLimitMiddleware) Name() string {
+	return m.provider.Name()
+}
+
+// Capabilities returns the capabilities of the model of the wrapped provider
+func (m *RateLimitMiddleware) Capabilities() (core.ModelCapabilities, bool) {
+	return core.CapabilitiesOf(m.provider)
+}
+
+// Model returns the name of the model of the wrapped provider
+func (m *RateLimitMiddleware) Model() string {
+	return core.ModelOf(m.provider)
+}
+
+// Generate generates a response for a prompt once the limits allow it
+func (m *RateLimitMiddleware) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
+	estimate := m.estimateTokens(prompt)
+	if err := m.acquire(ctx, estimate); err != nil {
+		return nil, err
+	}
+	defer m.release()
+
+	response, err := m.provider.Generate(ctx, prompt)
+	if response != nil && response.TokensUsed != nil {
+		m.reconcile(estimate, response.TokensUsed.Total)
+	}
+	return response, err
+}
+
+// GenerateStream generates a streaming response for a prompt once the limits
+// allow it. The stream counts as in flight until it is closed.
+func (m *RateLimitMiddleware) GenerateStream(ctx context.Context, prompt *core.Prompt) (core.ResponseStream, error) {
+	estimate := m.estimateTokens(prompt)
+	if err := m.acquire(ctx, estimate); err != nil {
+		return nil, err
+	}
+
+	stream, err := m.provider.GenerateStream(ctx, prompt)
+	if err != nil {
+		m.release()
+		return nil, err
+	}
+
+	return &limitedStream{stream: stream, middleware: m, estimate: estimate}, nil
+}
+
+// estimateTokens estimates the number of tokens used by a request, counting
+// the prompt and the maximum completion length
+func (m *RateLimitMiddleware) estimateTokens(prompt *core.Prompt) int {
+	if m.tokens == nil {
+		return 0
+	}
+
+	tokens := m.estimator.EstimateTokens(prompt.SystemMessage) + prompt.MaxTokens
+	for _, msg := range prompt.Conversation() {
+		tokens += m.estimator.EstimateTokens(msg.Content)
+	}
+	return tokens
+}
+
+// acquire waits until the request fits in the rate limits and a concurrency
+// slot is free. Budgets are reserved on arrival, so callers are served in
+// order.
+func (m *RateLimitMiddleware) acquire(ctx context.Context, tokens int) error {
+	m.mu.Lock()
+	now := time.Now()
+	var wait time.Duration
+	if m.requests != nil {
+		wait = m.requests.reserve(1, now)
+	}
+	if m.tokens != nil {
+		if d := m.tokens.reserve(float64(tokens), now); d > wait {
+			wait = d
+		}
+	}
+	m.mu.Unlock()
+
+	// Wait for the rate limits, giving the reservation back on cancellation
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			m.cancel(tokens)
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	// Wait for a concurrency slot
+	if m.slots != nil {
+		select {
+		case m.slots <- struct{}{}:
+		case <-ctx.Done():
+			m.cancel(tokens)
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// release frees the concurrency slot of a request
+func (m *RateLimitMiddleware) release() {
+	if m.slots != nil {
+		<-m.slots
+	}
+}
+
+// cancel gives back the budget reserved by a request that was not sent
+func (m *RateLimitMiddleware) cancel(tokens int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.requests != nil {
+		m.requests.adjust(1)
+	}
+	if m.tokens != nil {
+		m.tokens.adjust(float64(tokens))
+	}
+}
+
+// reconcile corrects the token budget with the actual usage of a request
+func (m *RateLimitMiddleware) reconcile(estimate, actual int) {
+	if m.tokens == nil || actual <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens.adjust(float64(estimate - actual))
+}
+
+// limitedStream is a response stream that holds a concurrency slot until it
+// is closed, and corrects the token budget with the usage it reports
+type limitedStream struct {
+	stream     core.ResponseStream
+	middleware *RateLimitMiddleware
+	estimate   int
+	usage      *core.TokenUsage
+	reconciled sync.Once
+	once       sync.Once
+	closeErr   error
+}
+
+// Next returns the next chunk from the stream
+func (s *limitedStream) Next() (*core.ResponseChunk, error) {
+	chunk, err := s.stream.Next()
+	if chunk != nil && chunk.TokensUsed != nil {
+		s.usage = chunk.TokensUsed
+	}
+	if err == io.EOF {
+		s.reconcile()
+	}
+	return chunk, err
+}
+
+// Close closes the stream once and releases its concurrency slot. Later
+// calls return the error of the first one.
+func (s *limitedStream) Close() error {
+	s.reconcile()
+	s.once.Do(func() {
+		s.closeErr = s.stream.Close()
+		s.middleware.release()
+	})
+	return s.closeErr
+}
+
+// reconcile corrects the token budget once with the usage reported by the
+// stream, which is usually sent with the final chunk
+func (s *limitedStream) reconcile() {
+	s.reconciled.Do(func() {
+		if s.usage != nil {
+			s.middleware.reconcile(s.estimate, s.usage.Total)
+		}
+	})
+}
+
+// bucket is a token bucket that refills continuously up to a per-minute
+// capacity. Reservations may take the level below zero, in which case the
+// caller waits until the level is back to zero.
+type bucket struct {
+	capacity float64
+	level    float64
+	rate     float64 // per second
+	last     time.Time
+}
+
+// newBucket creates a full bucket
+func newBucket(perMinute int, now time.Time) *bucket {
+	return &bucket{
+		capacity: float64(perMinute),
+		level:    float64(perMinute),
+		rate:     float64(perMinute) / 60,
+		last:     now,
+	}
+}
+
+// refill adds the budget accumulated since the last update
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.level += elapsed * b.rate
+		if b.level > b.capacity {
+			b.level = b.capacity
+		}
+		b.last = now
+	}
+}
+
+// reserve takes n from the bucket and returns how long to wait before the
+// reservation is covered
+func (b *bucket) reserve(n float64, now time.Time) time.Duration {
+	b.refill(now)
+	b.level -= n
+	if b.level >= 0 {
+		return 0
+	}
+	return time.Duration(-b.level / b.rate * float64(time.Second))
+}
+
+// adjust adds n to the bucket, which may be negative
+func (b *bucket) adjust(n float64) {
+	b.refill(time.Now())
+	b.level += n
+	if b.level > b.capacity {
+		b.level = b.capacity
+	}
+}
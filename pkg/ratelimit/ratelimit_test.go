@@ -0,0 +1,195 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/ratelimit"
+)
+
+// TestMaxConcurrency tests that the number of requests in flight is capped
+func TestMaxConcurrency(t *testing.T) {
+	provider := &SlowProvider{delay: 20 * time.Millisecond}
+	middleware := ratelimit.NewRateLimitMiddleware(provider, ratelimit.WithMaxConcurrency(2))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := middleware.Generate(context.Background(), core.NewPrompt("Hello")); err != nil {
+				t.Errorf("Failed to generate response: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if provider.maxInFlight != 2 {
+		t.Fatalf("Expected at most 2 requests in flight, got %d", provider.maxInFlight)
+	}
+
+	// Open streams hold their slot until they are closed
+	stream1, err := middleware.GenerateStream(context.Background(), core.NewPrompt("Hello"))
+	if err != nil {
+		t.Fatalf("Failed to generate stream: %v", err)
+	}
+	stream2, err := middleware.GenerateStream(context.Background(), core.NewPrompt("Hello"))
+	if err != nil {
+		t.Fatalf("Failed to generate stream: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := middleware.GenerateStream(ctx, core.NewPrompt("Hello")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the third stream to wait, got %v", err)
+	}
+	stream1.Close()
+	stream2.Close()
+
+	// Closing a stream again does not close the stream of the provider twice
+	if err := stream1.Close(); err != nil {
+		t.Fatalf("Failed to close stream again: %v", err)
+	}
+	if _, err := middleware.GenerateStream(context.Background(), core.NewPrompt("Hello")); err != nil {
+		t.Fatalf("Failed to generate stream after closing: %v", err)
+	}
+}
+
+// TestRequestsPerMinute tests that requests wait for the rate limit and
+// honour context cancellation
+func TestRequestsPerMinute(t *testing.T) {
+	middleware := ratelimit.NewRateLimitMiddleware(&SlowProvider{}, ratelimit.WithRequestsPerMinute(1))
+
+	if _, err := middleware.Generate(context.Background(), core.NewPrompt("Hello")); err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+
+	// The second request would have to wait for a minute
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := middleware.Generate(ctx, core.NewPrompt("Hello")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the request to be rate limited, got %v", err)
+	}
+}
+
+// TestTokensPerMinute tests that token estimates are reconciled with the
+// actual usage
+func TestTokensPerMinute(t *testing.T) {
+	prompt := core.NewPrompt("Hello")
+	prompt.MaxTokens = 50
+
+	// The actual usage is lower than the estimate, so the budget is refunded
+	middleware := ratelimit.NewRateLimitMiddleware(&SlowProvider{tokens: 10}, ratelimit.WithTokensPerMinute(100))
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err := middleware.Generate(ctx, prompt)
+		cancel()
+		if err != nil {
+			t.Fatalf("Request %d was rate limited: %v", i, err)
+		}
+	}
+
+	// The usage reported by the final chunk of streams is refunded too
+	middleware = ratelimit.NewRateLimitMiddleware(&SlowProvider{tokens: 10}, ratelimit.WithTokensPerMinute(100))
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		stream, err := middleware.GenerateStream(ctx, prompt)
+		cancel()
+		if err != nil {
+			t.Fatalf("Stream %d was rate limited: %v", i, err)
+		}
+		for {
+			if _, err := stream.Next(); err != nil {
+				break
+			}
+		}
+		stream.Close()
+	}
+
+	// Without usage information, the estimates are kept
+	middleware = ratelimit.NewRateLimitMiddleware(&SlowProvider{}, ratelimit.WithTokensPerMinute(100))
+	if _, err := middleware.Generate(context.Background(), prompt); err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := middleware.Generate(ctx, prompt); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the request to be rate limited, got %v", err)
+	}
+}
+
+// SlowProvider is a mock provider that takes some time to respond and
+// records the number of requests in flight
+type SlowProvider struct {
+	delay       time.Duration
+	tokens      int
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+// Name returns the name of the provider
+func (p *SlowProvider) Name() string {
+	return "slow"
+}
+
+// Generate responds after the delay
+func (p *SlowProvider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
+	p.mu.Lock()
+	p.inFlight++
+	if p.inFlight > p.maxInFlight {
+		p.maxInFlight = p.inFlight
+	}
+	p.mu.Unlock()
+
+	time.Sleep(p.delay)
+
+	p.mu.Lock()
+	p.inFlight--
+	p.mu.Unlock()
+
+	response := &core.Response{Text: prompt.Text}
+	if p.tokens > 0 {
+		response.TokensUsed = &core.TokenUsage{Total: p.tokens}
+	}
+	return response, nil
+}
+
+// GenerateStream returns a stream with a final chunk only
+func (p *SlowProvider) GenerateStream(ctx context.Context, prompt *core.Prompt) (core.ResponseStream, error) {
+	return &finalStream{tokens: p.tokens}, nil
+}
+
+// finalStream is a stream with a final chunk reporting the usage, if any
+type finalStream struct {
+	tokens int
+	done   bool
+	closed bool
+}
+
+// Next returns the final chunk and then io.EOF
+func (s *finalStream) Next() (*core.ResponseChunk, error) {
+	if s.done {
+		return nil, io.EOF
+	}
+	s.done = true
+
+	chunk := &core.ResponseChunk{IsFinal: true}
+	if s.tokens > 0 {
+		chunk.TokensUsed = &core.TokenUsage{Total: s.tokens}
+	}
+	return chunk, nil
+}
+
+// Close closes the stream, failing if it is already closed
+func (s *finalStream) Close() error {
+	if s.closed {
+		return errors.New("stream already closed")
+	}
+	s.closed = true
+	return nil
+}
@@ -0,0 +1,174 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+)
+
+// RetryMiddleware is a middleware that retries failed requests to an LLM
+// provider
+type RetryMiddleware struct {
+	provider    core.LLMProvider
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	shouldRetry func(err error) bool
+}
+
+// RetryOption configures a RetryMiddleware
+type RetryOption func(*RetryMiddleware)
+
+// WithMaxAttempts sets the maximum number of attempts, including the first
+// one
+func WithMaxAttempts(attempts int) RetryOption {
+	return func(m *RetryMiddleware) {
+		m.maxAttempts = attempts
+	}
+}
+
+// WithBaseDelay sets the delay before the first retry. The delay doubles
+// with each retry.
+func WithBaseDelay(delay time.Duration) RetryOption {
+	return func(m *RetryMiddleware) {
+		m.baseDelay = delay
+	}
+}
+
+// WithMaxDelay sets the maximum delay between attempts. Requests are not
+// retried when the provider asks to wait longer.
+func WithMaxDelay(delay time.Duration) RetryOption {
+	return func(m *RetryMiddleware) {
+		m.maxDelay = delay
+	}
+}
+
+// WithRetryIf sets the function that decides whether an error is retried.
+// By default, errors are retried when core.IsRetryable returns true.
+func WithRetryIf(shouldRetry func(err error) bool) RetryOption {
+	return func(m *RetryMiddleware) {
+		m.shouldRetry = shouldRetry
+	}
+}
+
+// NewRetryMiddleware creates a new retry middleware
+func NewRetryMiddleware(provider core.LLMProvider, opts ...RetryOption) *RetryMiddleware {
+	m := &RetryMiddleware{
+		provider:    provider,
+		maxAttempts: 3,
+		baseDelay:   500 * time.Millisecond,
+		maxDelay:    30 * time.Second,
+		shouldRetry: core.IsRetryable,
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// Middleware returns a core.Middleware that retries failed requests
+func Middleware(opts ...RetryOption) core.Middleware {
+	return func(provider core.LLMProvider) core.LLMProvider {
+		return NewRetryMiddleware(provider, opts...)
+	}
+}
+
+// Name returns the name of the wrapped provider
+func (m *RetryMiddleware) Name() string {
+	return m.provider.Name()
+}
+
+// Capabilities returns the capabilities of the model of the wrapped provider
+func (m *RetryMiddleware) Capabilities() (core.ModelCapabilities, bool) {
+	return core.CapabilitiesOf(m.provider)
+}
+
+// Model returns the name of the model of the wrapped provider
+func (m *RetryMiddleware) Model() string {
+	return core.ModelOf(m.provider)
+}
+
+// Generate generates a response for a prompt, retrying failed attempts. The
+// response of the last attempt is returned with its error, as providers may
+// return both, such as for output failing its schema.
+func (m *RetryMiddleware) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
+	var response *core.Response
+	err := m.do(ctx, func() error {
+		var err error
+		response, err = m.provider.Generate(ctx, prompt)
+		return err
+	})
+	return response, err
+}
+
+// GenerateStream generates a streaming response for a prompt, retrying
+// failed attempts to open the stream. Errors in an open stream are not
+// retried, as part of the response may already have been consumed.
+func (m *RetryMiddleware) GenerateStream(ctx context.Context, prompt *core.Prompt) (core.ResponseStream, error) {
+	var stream core.ResponseStream
+	err := m.do(ctx, func() error {
+		var err error
+		stream, err = m.provider.GenerateStream(ctx, prompt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// do calls fn until it succeeds, fails with an error that is not retried or
+// runs out of attempts
+func (m *RetryMiddleware) do(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= m.maxAttempts || !m.shouldRetry(err) {
+			return err
+		}
+
+		// Give up if the provider asks to wait longer than the maximum delay,
+		// or if the context would expire before the next attempt
+		delay := m.delay(attempt, err)
+		if delay > m.maxDelay {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// delay returns the delay before the next attempt. The delay requested by
+// the provider takes precedence over the exponential backoff, which uses
+// full jitter.
+func (m *RetryMiddleware) delay(attempt int, err error) time.Duration {
+	var providerErr *core.ProviderError
+	if errors.As(err, &providerErr) && providerErr.RetryAfter > 0 {
+		return providerErr.RetryAfter
+	}
+
+	backoff := m.maxDelay
+	if shift := attempt - 1; shift < 32 {
+		if d := m.baseDelay << uint(shift); d > 0 && d < m.maxDelay {
+			backoff = d
+		}
+	}
+	if backoff <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
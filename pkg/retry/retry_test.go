@@ -0,0 +1,176 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/retry"
+)
+
+// TestRetryMiddleware tests retrying of failed requests
+func TestRetryMiddleware(t *testing.T) {
+	ctx := context.Background()
+	prompt := core.NewPrompt("Hello")
+	rateLimit := &core.ProviderError{Provider: "flaky", Category: core.ErrorCategoryRateLimit, StatusCode: 429}
+
+	// Retryable errors are retried until the request succeeds
+	provider := &FlakyProvider{errs: []error{rateLimit, rateLimit}}
+	middleware := retry.NewRetryMiddleware(provider, retry.WithBaseDelay(time.Millisecond))
+	response, err := middleware.Generate(ctx, prompt)
+	if err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	if response.Text != "Hello" || provider.calls != 3 {
+		t.Fatalf("Expected a response after 3 calls, got %d calls", provider.calls)
+	}
+
+	// The number of attempts is limited
+	provider = &FlakyProvider{errs: []error{rateLimit, rateLimit, rateLimit}}
+	middleware = retry.NewRetryMiddleware(provider, retry.WithMaxAttempts(2), retry.WithBaseDelay(time.Millisecond))
+	if _, err := middleware.Generate(ctx, prompt); !errors.Is(err, rateLimit) {
+		t.Fatalf("Expected the rate limit error, got %v", err)
+	}
+	if provider.calls != 2 {
+		t.Fatalf("Expected 2 calls, got %d", provider.calls)
+	}
+
+	// Errors that are not retryable fail immediately
+	authErr := &core.ProviderError{Provider: "flaky", Category: core.ErrorCategoryAuthentication, StatusCode: 401}
+	provider = &FlakyProvider{errs: []error{authErr}}
+	middleware = retry.NewRetryMiddleware(provider, retry.WithBaseDelay(time.Millisecond))
+	if _, err := middleware.Generate(ctx, prompt); !errors.Is(err, authErr) {
+		t.Fatalf("Expected the authentication error, got %v", err)
+	}
+	if provider.calls != 1 {
+		t.Fatalf("Expected 1 call, got %d", provider.calls)
+	}
+
+	// Responses returned with an error are passed through
+	outputErr := &core.StructuredOutputError{Provider: "flaky", Err: errors.New("invalid JSON")}
+	provider = &FlakyProvider{errs: []error{outputErr}}
+	middleware = retry.NewRetryMiddleware(provider, retry.WithBaseDelay(time.Millisecond))
+	response, err = middleware.Generate(ctx, prompt)
+	if !errors.Is(err, outputErr) || response == nil || response.Text != "Hello" {
+		t.Fatalf("Expected the response with the structured output error, got %+v, %v", response, err)
+	}
+
+	// The initial stream request is retried
+	provider = &FlakyProvider{errs: []error{rateLimit}}
+	middleware = retry.NewRetryMiddleware(provider, retry.WithBaseDelay(time.Millisecond))
+	stream, err := middleware.GenerateStream(ctx, prompt)
+	if err != nil {
+		t.Fatalf("Failed to generate stream: %v", err)
+	}
+	stream.Close()
+	if provider.calls != 2 {
+		t.Fatalf("Expected 2 calls, got %d", provider.calls)
+	}
+
+	// The name of the wrapped provider is kept
+	if middleware.Name() != "flaky" {
+		t.Fatalf("Expected name flaky, got %s", middleware.Name())
+	}
+}
+
+// TestRetryAfter tests that the delay requested by the provider is honoured
+// within the context deadline
+func TestRetryAfter(t *testing.T) {
+	prompt := core.NewPrompt("Hello")
+	retryAfter := &core.ProviderError{
+		Provider:   "flaky",
+		Category:   core.ErrorCategoryRateLimit,
+		StatusCode: 429,
+		RetryAfter: 50 * time.Millisecond,
+	}
+
+	// The requested delay overrides the backoff
+	provider := &FlakyProvider{errs: []error{retryAfter}}
+	middleware := retry.NewRetryMiddleware(provider, retry.WithBaseDelay(time.Millisecond))
+	start := time.Now()
+	if _, err := middleware.Generate(context.Background(), prompt); err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("Expected to wait for the retry delay, waited %v", elapsed)
+	}
+
+	// A requested delay beyond the maximum delay returns the last error
+	// immediately
+	retryAfter.RetryAfter = time.Minute
+	provider = &FlakyProvider{errs: []error{retryAfter}}
+	middleware = retry.NewRetryMiddleware(provider, retry.WithMaxDelay(10*time.Millisecond))
+	start = time.Now()
+	if _, err := middleware.Generate(context.Background(), prompt); !errors.Is(err, retryAfter) {
+		t.Fatalf("Expected the rate limit error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond || provider.calls != 1 {
+		t.Fatalf("Expected to give up immediately, waited %v for %d calls", elapsed, provider.calls)
+	}
+
+	// A delay beyond the context deadline returns the last error immediately
+	provider = &FlakyProvider{errs: []error{retryAfter}}
+	middleware = retry.NewRetryMiddleware(provider)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start = time.Now()
+	if _, err := middleware.Generate(ctx, prompt); !errors.Is(err, retryAfter) {
+		t.Fatalf("Expected the rate limit error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("Expected to give up immediately, waited %v", elapsed)
+	}
+}
+
+// FlakyProvider is a mock provider that fails with the given errors before
+// succeeding
+type FlakyProvider struct {
+	errs  []error
+	calls int
+}
+
+// Name returns the name of the provider
+func (p *FlakyProvider) Name() string {
+	return "flaky"
+}
+
+// Generate returns the next error, or echoes the prompt once all errors have
+// been returned. Structured output errors are returned with the response.
+func (p *FlakyProvider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
+	p.calls++
+	if len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		var outputErr *core.StructuredOutputError
+		if errors.As(err, &outputErr) {
+			return &core.Response{Text: prompt.Text}, err
+		}
+		return nil, err
+	}
+	return &core.Response{Text: prompt.Text}, nil
+}
+
+// GenerateStream returns the next error, or an empty stream once all errors
+// have been returned
+func (p *FlakyProvider) GenerateStream(ctx context.Context, prompt *core.Prompt) (core.ResponseStream, error) {
+	if _, err := p.Generate(ctx, prompt); err != nil {
+		return nil, err
+	}
+	return &emptyStream{}, nil
+}
+
+// emptyStream is a stream without chunks
+type emptyStream struct{}
+
+// Next returns io.EOF
+func (s *emptyStream) Next() (*core.ResponseChunk, error) {
+	return nil, io.EOF
+}
+
+// Close closes the stream
+func (s *emptyStream) Close() error {
+	return nil
+}
@@ -0,0 +1,120 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+)
+
+// FallbackProvider tries a list of providers in order, moving on to the next
+// provider when a request fails
+type FallbackProvider struct {
+	name           string
+	providers      []core.LLMProvider
+	shouldFallback func(err error) bool
+}
+
+// FallbackOption configures a FallbackProvider
+type FallbackOption func(*FallbackProvider)
+
+// WithFallbackIf sets the function that decides whether a failed request is
+// tried with the next provider. By default, requests fall back when
+// core.IsRetryable returns true.
+func WithFallbackIf(shouldFallback func(err error) bool) FallbackOption {
+	return func(p *FallbackProvider) {
+		p.shouldFallback = shouldFallback
+	}
+}
+
+// NewFallbackProvider creates a new fallback provider that tries the given
+// providers in order
+func NewFallbackProvider(name string, providers []core.LLMProvider, opts ...FallbackOption) (*FallbackProvider, error) {
+	if len(providers) == 0 {
+		return nil, errors.New("at least one provider is required")
+	}
+
+	p := &FallbackProvider{
+		name:           name,
+		providers:      providers,
+		shouldFallback: core.IsRetryable,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p, nil
+}
+
+// ProvidersFromRegistry looks up providers in a registry by name, keeping
+// their order
+func ProvidersFromRegistry(registry *core.Registry, names ...string) ([]core.LLMProvider, error) {
+	providers := make([]core.LLMProvider, 0, len(names))
+	for _, name := range names {
+		provider, exists := registry.GetProvider(name)
+		if !exists {
+			return nil, fmt.Errorf("provider %s is not registered", name)
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// Name returns the name of the provider
+func (p *FallbackProvider) Name() string {
+	return p.name
+}
+
+// Providers returns the providers in the order they are tried
+func (p *FallbackProvider) Providers() []core.LLMProvider {
+	return p.providers
+}
+
+// Generate generates a response with the first provider that succeeds. The
+// response of the last provider is returned with its error, as providers may
+// return both, such as for output failing its schema.
+func (p *FallbackProvider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
+	var lastResponse *core.Response
+	var lastErr error
+	for _, provider := range p.providers {
+		response, err := provider.Generate(ctx, prompt)
+		if err == nil {
+			return response, nil
+		}
+
+		lastResponse, lastErr = response, err
+		if !p.next(ctx, err) {
+			break
+		}
+	}
+
+	return lastResponse, lastErr
+}
+
+// GenerateStream opens a stream with the first provider that succeeds.
+// Errors in an open stream do not fall back, as part of the response may
+// already have been consumed.
+func (p *FallbackProvider) GenerateStream(ctx context.Context, prompt *core.Prompt) (core.ResponseStream, error) {
+	var lastErr error
+	for _, provider := range p.providers {
+		stream, err := provider.GenerateStream(ctx, prompt)
+		if err == nil {
+			return stream, nil
+		}
+
+		lastErr = err
+		if !p.next(ctx, err) {
+			break
+		}
+	}
+
+	return nil, lastErr
+}
+
+// next reports whether a failed request should be tried with the next
+// provider
+func (p *FallbackProvider) next(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && p.shouldFallback(err)
+}
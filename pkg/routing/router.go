@@ -0,0 +1,244 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/optimization"
+)
+
+// Rule decides whether a prompt matches a route
+type Rule func(prompt *core.Prompt) bool
+
+// Capability is a feature a prompt needs from a model
+type Capability string
+
+const (
+	// CapabilityTools is needed by prompts that declare tools
+	CapabilityTools Capability = "tools"
+
+	// CapabilityStructuredOutput is needed by prompts with a schema
+	CapabilityStructuredOutput Capability = "structured_output"
+
+	// CapabilityVision is needed by prompts with images
+	CapabilityVision Capability = "vision"
+
+	// CapabilityAudio is needed by prompts with audio
+	CapabilityAudio Capability = "audio"
+)
+
+// TagsParam is the key of the prompt tags in Prompt.AdditionalParams. Tags
+// can be given as a string or a list of strings.
+const TagsParam = "tags"
+
+// Tags are only used for routing and are not sent to providers
+func init() {
+	core.RegisterInternalParam(TagsParam)
+}
+
+// HasTag matches prompts tagged with the given tag
+func HasTag(tag string) Rule {
+	return func(prompt *core.Prompt) bool {
+		for _, t := range PromptTags(prompt) {
+			if t == tag {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// PromptTags returns the tags of a prompt
+func PromptTags(prompt *core.Prompt) []string {
+	switch tags := prompt.AdditionalParams[TagsParam].(type) {
+	case string:
+		return []string{tags}
+	case []string:
+		return tags
+	case []interface{}:
+		result := make([]string, 0, len(tags))
+		for _, tag := range tags {
+			if s, ok := tag.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	default:
+		return nil
+	}
+}
+
+// Needs matches prompts that need the given capability
+func Needs(capability Capability) Rule {
+	return func(prompt *core.Prompt) bool {
+		switch capability {
+		case CapabilityTools:
+			return len(prompt.Tools) > 0
+		case CapabilityStructuredOutput:
+			return prompt.Schema != nil
+		case CapabilityVision:
+			return hasPart(prompt, core.ContentImage)
+		case CapabilityAudio:
+			return hasPart(prompt, core.ContentAudio)
+		default:
+			return false
+		}
+	}
+}
+
+// PromptLength matches prompts whose estimated number of tokens is between
+// min and max. A bound of 0 is ignored. If estimator is nil, a
+// SimpleTokenEstimator is used.
+func PromptLength(min, max int, estimator optimization.TokenEstimator) Rule {
+	if estimator == nil {
+		estimator = &optimization.SimpleTokenEstimator{}
+	}
+
+	return func(prompt *core.Prompt) bool {
+		tokens := promptTokens(prompt, estimator)
+		return (min <= 0 || tokens >= min) && (max <= 0 || tokens <= max)
+	}
+}
+
+// Supports reports whether the model of a provider can serve a prompt: it
+// must have the capabilities the prompt needs, and its context window must
+// fit the prompt and the requested completion. Providers that do not know
+// their capabilities are assumed to support any prompt. If estimator is nil,
+// a SimpleTokenEstimator is used.
+func Supports(provider core.LLMProvider, prompt *core.Prompt, estimator optimization.TokenEstimator) bool {
+	capabilities, ok := core.CapabilitiesOf(provider)
+	if !ok {
+		return true
+	}
+	if capabilities.Check(provider.Name(), prompt) != nil {
+		return false
+	}
+	if capabilities.ContextWindow <= 0 {
+		return true
+	}
+
+	if estimator == nil {
+		estimator = &optimization.SimpleTokenEstimator{}
+	}
+	return promptTokens(prompt, estimator)+prompt.MaxTokens <= capabilities.ContextWindow
+}
+
+// promptTokens estimates the number of tokens of a prompt
+func promptTokens(prompt *core.Prompt, estimator optimization.TokenEstimator) int {
+	parts := []string{prompt.SystemMessage}
+	for _, msg := range prompt.Conversation() {
+		parts = append(parts, msg.Content)
+	}
+	return estimator.EstimateTokens(strings.Join(parts, "\n"))
+}
+
+// hasPart reports whether a prompt has a content part of the given type
+func hasPart(prompt *core.Prompt, contentType core.ContentType) bool {
+	for _, msg := range prompt.Conversation() {
+		for _, part := range msg.Parts {
+			if part.Type == contentType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// All matches prompts that match all the given rules
+func All(rules ...Rule) Rule {
+	return func(prompt *core.Prompt) bool {
+		for _, rule := range rules {
+			if !rule(prompt) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Any matches prompts that match at least one of the given rules
+func Any(rules ...Rule) Rule {
+	return func(prompt *core.Prompt) bool {
+		for _, rule := range rules {
+			if rule(prompt) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not matches prompts that do not match the given rule
+func Not(rule Rule) Rule {
+	return func(prompt *core.Prompt) bool {
+		return !rule(prompt)
+	}
+}
+
+// route is a rule with the provider it selects
+type route struct {
+	rule     Rule
+	provider core.LLMProvider
+}
+
+// Router selects a provider for each prompt using rules
+type Router struct {
+	name            string
+	defaultProvider core.LLMProvider
+	routes          []route
+	mu              sync.RWMutex
+}
+
+// NewRouter creates a new router that uses the default provider for prompts
+// that match no route
+func NewRouter(name string, defaultProvider core.LLMProvider) (*Router, error) {
+	if defaultProvider == nil {
+		return nil, errors.New("default provider is required")
+	}
+
+	return &Router{
+		name:            name,
+		defaultProvider: defaultProvider,
+	}, nil
+}
+
+// AddRoute adds a route. Routes are evaluated in the order they were added,
+// and the first matching route whose provider supports the prompt selects
+// the provider.
+func (r *Router) AddRoute(rule Rule, provider core.LLMProvider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = append(r.routes, route{rule: rule, provider: provider})
+}
+
+// Name returns the name of the router
+func (r *Router) Name() string {
+	return r.name
+}
+
+// Select returns the provider selected for a prompt
+func (r *Router) Select(prompt *core.Prompt) core.LLMProvider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, route := range r.routes {
+		if route.rule(prompt) && Supports(route.provider, prompt, nil) {
+			return route.provider
+		}
+	}
+	return r.defaultProvider
+}
+
+// Generate generates a response with the provider selected for the prompt
+func (r *Router) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
+	return r.Select(prompt).Generate(ctx, prompt)
+}
+
+// GenerateStream generates a streaming response with the provider selected
+// for the prompt
+func (r *Router) GenerateStream(ctx context.Context, prompt *core.Prompt) (core.ResponseStream, error) {
+	return r.Select(prompt).GenerateStream(ctx, prompt)
+}
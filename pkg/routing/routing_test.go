@@ -0,0 +1,230 @@
+package routing_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/routing"
+)
+
+// TestFallbackProvider tests falling back to the next provider on failures
+func TestFallbackProvider(t *testing.T) {
+	ctx := context.Background()
+	prompt := core.NewPrompt("Hello")
+	outage := &core.ProviderError{Provider: "primary", Category: core.ErrorCategoryServer, StatusCode: 503}
+
+	// Retryable failures fall back to the next provider
+	primary := &MockProvider{name: "primary", err: outage}
+	secondary := &MockProvider{name: "secondary"}
+	fallback, err := routing.NewFallbackProvider("main", []core.LLMProvider{primary, secondary})
+	if err != nil {
+		t.Fatalf("Failed to create fallback provider: %v", err)
+	}
+	response, err := fallback.Generate(ctx, prompt)
+	if err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+	if response.Text != "secondary" || primary.calls != 1 || secondary.calls != 1 {
+		t.Fatalf("Expected a response from the secondary provider, got %q", response.Text)
+	}
+
+	// Streams fall back as well
+	stream, err := fallback.GenerateStream(ctx, prompt)
+	if err != nil {
+		t.Fatalf("Failed to generate stream: %v", err)
+	}
+	stream.Close()
+	if secondary.calls != 2 {
+		t.Fatalf("Expected the stream to come from the secondary provider")
+	}
+
+	// Other failures are returned immediately
+	invalid := &core.ProviderError{Provider: "primary", Category: core.ErrorCategoryInvalidRequest, StatusCode: 400}
+	primary = &MockProvider{name: "primary", err: invalid}
+	secondary = &MockProvider{name: "secondary"}
+	fallback, _ = routing.NewFallbackProvider("main", []core.LLMProvider{primary, secondary})
+	if _, err := fallback.Generate(ctx, prompt); !errors.Is(err, invalid) {
+		t.Fatalf("Expected the invalid request error, got %v", err)
+	}
+	if secondary.calls != 0 {
+		t.Fatal("Secondary provider was called for a non-retryable error")
+	}
+
+	// The last error is returned when all providers fail
+	secondary = &MockProvider{name: "secondary", err: outage}
+	fallback, _ = routing.NewFallbackProvider("main", []core.LLMProvider{&MockProvider{name: "primary", err: outage}, secondary})
+	if _, err := fallback.Generate(ctx, prompt); !errors.Is(err, outage) {
+		t.Fatalf("Expected the outage error, got %v", err)
+	}
+
+	// Responses returned with an error are passed through
+	outputErr := &core.StructuredOutputError{Provider: "primary", Err: errors.New("invalid JSON")}
+	fallback, _ = routing.NewFallbackProvider("main", []core.LLMProvider{&MockProvider{name: "primary", err: outputErr}, secondary})
+	response, err = fallback.Generate(ctx, prompt)
+	if !errors.Is(err, outputErr) || response == nil || response.Text != "primary" {
+		t.Fatalf("Expected the response with the structured output error, got %+v, %v", response, err)
+	}
+
+	// Providers are looked up in the registry
+	registry := core.NewRegistry()
+	registry.RegisterProvider(primary)
+	if _, err := routing.ProvidersFromRegistry(registry, "primary", "missing"); err == nil {
+		t.Fatal("Expected an error for a missing provider")
+	}
+}
+
+// TestRouter tests selecting providers by rules
+func TestRouter(t *testing.T) {
+	defaultProvider := &MockProvider{name: "default"}
+	taggedProvider := &MockProvider{name: "tagged"}
+	toolsProvider := &MockProvider{name: "tools"}
+	longProvider := &MockProvider{name: "long"}
+
+	router, err := routing.NewRouter("router", defaultProvider)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	router.AddRoute(routing.HasTag("premium"), taggedProvider)
+	router.AddRoute(routing.Needs(routing.CapabilityTools), toolsProvider)
+	router.AddRoute(routing.PromptLength(1000, 0, nil), longProvider)
+
+	tests := []struct {
+		name     string
+		prompt   *core.Prompt
+		expected string
+	}{
+		{"default", core.NewPrompt("Hello"), "default"},
+		{"tag", &core.Prompt{Text: "Hello", AdditionalParams: map[string]interface{}{"tags": []interface{}{"batch", "premium"}}}, "tagged"},
+		{"tools", &core.Prompt{Text: "Hello", Tools: []core.Tool{{Name: "search"}}}, "tools"},
+		{"long", core.NewPrompt(strings.Repeat("word ", 1000)), "long"},
+	}
+
+	for _, tt := range tests {
+		response, err := router.Generate(context.Background(), tt.prompt)
+		if err != nil {
+			t.Fatalf("%s: failed to generate response: %v", tt.name, err)
+		}
+		if response.Text != tt.expected {
+			t.Fatalf("%s: expected provider %s, got %s", tt.name, tt.expected, response.Text)
+		}
+	}
+	// Responses returned with an error are passed through
+	outputErr := &core.StructuredOutputError{Provider: "default", Err: errors.New("invalid JSON")}
+	router, _ = routing.NewRouter("router", &MockProvider{name: "default", err: outputErr})
+	response, err := router.Generate(context.Background(), core.NewPrompt("Hello"))
+	if !errors.Is(err, outputErr) || response == nil || response.Text != "default" {
+		t.Fatalf("Expected the response with the structured output error, got %+v, %v", response, err)
+	}
+
+	// A router needs a default provider
+	if _, err := routing.NewRouter("router", nil); err == nil {
+		t.Fatal("No error when creating a router without a default provider")
+	}
+}
+
+// TestRouterCapabilities tests skipping routes whose model cannot serve the
+// prompt
+func TestRouterCapabilities(t *testing.T) {
+	defaultProvider := &MockProvider{name: "default"}
+	smallProvider := &CapableProvider{
+		MockProvider: MockProvider{name: "small"},
+		capabilities: core.ModelCapabilities{ContextWindow: 100, Tools: true},
+	}
+	visionProvider := &CapableProvider{
+		MockProvider: MockProvider{name: "vision"},
+		capabilities: core.ModelCapabilities{ContextWindow: 100000, Vision: true},
+	}
+
+	router, err := routing.NewRouter("router", defaultProvider)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	router.AddRoute(routing.HasTag("cheap"), smallProvider)
+	router.AddRoute(routing.Needs(routing.CapabilityVision), visionProvider)
+
+	image := &core.Prompt{Text: "Describe", Parts: []core.ContentPart{core.ImageURLPart("https://example.com/cat.png")}}
+	tags := map[string]interface{}{"tags": "cheap"}
+	tests := []struct {
+		name     string
+		prompt   *core.Prompt
+		expected string
+	}{
+		{"fits", &core.Prompt{Text: "Hello", AdditionalParams: tags}, "small"},
+		{"too long", &core.Prompt{Text: strings.Repeat("word ", 1000), AdditionalParams: tags}, "default"},
+		{"completion too long", &core.Prompt{Text: "Hello", MaxTokens: 500, AdditionalParams: tags}, "default"},
+		{"no vision", &core.Prompt{Text: "Describe", Parts: image.Parts, AdditionalParams: tags}, "vision"},
+		{"vision", image, "vision"},
+	}
+
+	for _, tt := range tests {
+		if provider := router.Select(tt.prompt); provider.Name() != tt.expected {
+			t.Fatalf("%s: expected provider %s, got %s", tt.name, tt.expected, provider.Name())
+		}
+	}
+
+	if !routing.Supports(defaultProvider, image, nil) {
+		t.Fatal("Expected providers without capabilities to support any prompt")
+	}
+}
+
+// CapableProvider is a mock provider that knows the capabilities of its model
+type CapableProvider struct {
+	MockProvider
+	capabilities core.ModelCapabilities
+}
+
+// Capabilities returns the capabilities of the model
+func (p *CapableProvider) Capabilities() (core.ModelCapabilities, bool) {
+	return p.capabilities, true
+}
+
+// MockProvider is a mock provider that responds with its name or fails with
+// the given error. Structured output errors are returned with the response.
+type MockProvider struct {
+	name  string
+	err   error
+	calls int
+}
+
+// Name returns the name of the provider
+func (p *MockProvider) Name() string {
+	return p.name
+}
+
+// Generate responds with the name of the provider
+func (p *MockProvider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
+	p.calls++
+	var outputErr *core.StructuredOutputError
+	if errors.As(p.err, &outputErr) {
+		return &core.Response{Text: p.name}, p.err
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &core.Response{Text: p.name}, nil
+}
+
+// GenerateStream returns an empty stream
+func (p *MockProvider) GenerateStream(ctx context.Context, prompt *core.Prompt) (core.ResponseStream, error) {
+	if _, err := p.Generate(ctx, prompt); err != nil {
+		return nil, err
+	}
+	return &emptyStream{}, nil
+}
+
+// emptyStream is a stream without chunks
+type emptyStream struct{}
+
+// Next returns io.EOF
+func (s *emptyStream) Next() (*core.ResponseChunk, error) {
+	return nil, io.EOF
+}
+
+// Close closes the stream
+func (s *emptyStream) Close() error {
+	return nil
+}
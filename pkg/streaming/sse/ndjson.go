@@ -0,0 +1,43 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// NDJSONDecoder decodes newline-delimited JSON streams
+type NDJSONDecoder struct {
+	scanner *bufio.Scanner
+}
+
+// NewNDJSONDecoder creates a decoder reading JSON values from r
+func NewNDJSONDecoder(r io.Reader) *NDJSONDecoder {
+	return &NDJSONDecoder{scanner: newLineScanner(r)}
+}
+
+// Next returns the next JSON value, or io.EOF at the end of the stream.
+// Blank lines are skipped.
+func (d *NDJSONDecoder) Next() ([]byte, error) {
+	for d.scanner.Scan() {
+		line := bytes.TrimSpace(d.scanner.Bytes())
+		if len(line) > 0 {
+			return line, nil
+		}
+	}
+
+	if err := d.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Decode decodes the next JSON value into v
+func (d *NDJSONDecoder) Decode(v interface{}) error {
+	line, err := d.Next()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(line, v)
+}
@@ -0,0 +1,157 @@
+// Package sse decodes the server-sent event and newline-delimited JSON
+// streams returned by LLM providers.
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLineSize is the maximum size of a line. Lines are buffered in full, so
+// the limit only protects against unbounded memory use.
+const maxLineSize = 64 << 20
+
+// Event is a server-sent event
+type Event struct {
+	// Type is the event type, or "message" if the event has none
+	Type string
+
+	// Data is the event data. Multiple data lines are joined with newlines.
+	Data string
+
+	// ID is the last event ID of the stream when the event was dispatched
+	ID string
+}
+
+// Decoder decodes server-sent events as specified by the HTML living
+// standard
+type Decoder struct {
+	scanner *bufio.Scanner
+	started bool
+	lastID  string
+	retry   time.Duration
+}
+
+// NewDecoder creates a decoder reading events from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{scanner: newLineScanner(r)}
+}
+
+// Next returns the next event, or io.EOF at the end of the stream. Comments
+// and events without data are skipped, and an incomplete event at the end
+// of the stream is discarded.
+func (d *Decoder) Next() (*Event, error) {
+	var eventType string
+	var data strings.Builder
+	hasData := false
+
+	for d.scanner.Scan() {
+		line := d.scanner.Text()
+
+		// Ignore the byte order mark at the start of the stream
+		if !d.started {
+			line = strings.TrimPrefix(line, "\ufeff")
+			d.started = true
+		}
+
+		// An empty line dispatches the event
+		if line == "" {
+			if !hasData {
+				eventType = ""
+				continue
+			}
+			if eventType == "" {
+				eventType = "message"
+			}
+			return &Event{
+				Type: eventType,
+				Data: strings.TrimSuffix(data.String(), "\n"),
+				ID:   d.lastID,
+			}, nil
+		}
+
+		// Lines starting with a colon are comments
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				d.lastID = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 63); err == nil {
+				d.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+
+	if err := d.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// LastEventID returns the last event ID of the stream
+func (d *Decoder) LastEventID() string {
+	return d.lastID
+}
+
+// Retry returns the reconnection time requested by the server, or 0
+func (d *Decoder) Retry() time.Duration {
+	return d.retry
+}
+
+// newLineScanner creates a scanner splitting lines on CRLF, LF or CR
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), maxLineSize)
+	scanner.Split(scanLines)
+	return scanner
+}
+
+// scanLines is a split function for lines ending with CRLF, LF or CR
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+
+		// A CR may be followed by an LF in the next read
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if !atEOF {
+			return 0, nil, nil
+		}
+		return i + 1, data[:i], nil
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
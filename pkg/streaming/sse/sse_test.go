@@ -0,0 +1,125 @@
+package sse_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/streaming/sse"
+)
+
+// TestDecoder tests decoding server-sent events
+func TestDecoder(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		events []sse.Event
+	}{
+		{
+			name:   "single line",
+			stream: "data: hello\n\n",
+			events: []sse.Event{{Type: "message", Data: "hello"}},
+		},
+		{
+			name:   "multiple data lines",
+			stream: "data: first\ndata: second\ndata\ndata:third\n\n",
+			events: []sse.Event{{Type: "message", Data: "first\nsecond\n\nthird"}},
+		},
+		{
+			name:   "event type and id",
+			stream: "event: delta\nid: 1\ndata: a\n\ndata: b\n\n",
+			events: []sse.Event{
+				{Type: "delta", Data: "a", ID: "1"},
+				{Type: "message", Data: "b", ID: "1"},
+			},
+		},
+		{
+			name:   "comments and unknown fields",
+			stream: ": keep-alive\nfoo: bar\ndata: x\n: ignored\n\n",
+			events: []sse.Event{{Type: "message", Data: "x"}},
+		},
+		{
+			name:   "line endings",
+			stream: "\ufeffdata: a\r\n\r\ndata: b\r\rdata: c\n\n",
+			events: []sse.Event{
+				{Type: "message", Data: "a"},
+				{Type: "message", Data: "b"},
+				{Type: "message", Data: "c"},
+			},
+		},
+		{
+			name:   "events without data",
+			stream: "event: ping\n\nid: 2\n\ndata: x\n\n",
+			events: []sse.Event{{Type: "message", Data: "x", ID: "2"}},
+		},
+		{
+			name:   "incomplete event",
+			stream: "data: a\n\ndata: b",
+			events: []sse.Event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:   "large event",
+			stream: "data: " + strings.Repeat("x", 3<<20) + "\n\n",
+			events: []sse.Event{{Type: "message", Data: strings.Repeat("x", 3<<20)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Read small streams one byte at a time to split lines and CRLF
+			// pairs across reads
+			var reader io.Reader = strings.NewReader(tt.stream)
+			if len(tt.stream) < 1024 {
+				reader = iotest.OneByteReader(reader)
+			}
+			decoder := sse.NewDecoder(reader)
+
+			for i, expected := range tt.events {
+				event, err := decoder.Next()
+				if err != nil {
+					t.Fatalf("Failed to decode event %d: %v", i, err)
+				}
+				if *event != expected {
+					t.Fatalf("Event %d is incorrect: %.50q", i, *event)
+				}
+			}
+
+			if _, err := decoder.Next(); err != io.EOF {
+				t.Fatalf("Expected EOF, got %v", err)
+			}
+		})
+	}
+
+	// The retry field sets the reconnection time
+	decoder := sse.NewDecoder(strings.NewReader("retry: 1500\nid: 7\ndata: x\n\nretry: soon\n\n"))
+	if _, err := decoder.Next(); err != nil {
+		t.Fatalf("Failed to decode event: %v", err)
+	}
+	decoder.Next()
+	if decoder.Retry() != 1500*time.Millisecond || decoder.LastEventID() != "7" {
+		t.Fatalf("Retry or last event ID is incorrect: %v %q", decoder.Retry(), decoder.LastEventID())
+	}
+}
+
+// TestNDJSONDecoder tests decoding newline-delimited JSON
+func TestNDJSONDecoder(t *testing.T) {
+	decoder := sse.NewNDJSONDecoder(strings.NewReader("{\"n\":1}\n\n{\"n\":2}\r\n{\"n\":3}"))
+
+	for i := 1; i <= 3; i++ {
+		var value struct {
+			N int `json:"n"`
+		}
+		if err := decoder.Decode(&value); err != nil {
+			t.Fatalf("Failed to decode value %d: %v", i, err)
+		}
+		if value.N != i {
+			t.Fatalf("Expected %d, got %d", i, value.N)
+		}
+	}
+
+	if _, err := decoder.Next(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+}
@@ -20,9 +20,11 @@ type StreamHandler interface {
 
 // StreamProcessor processes streaming responses
 type StreamProcessor struct {
-	handler StreamHandler
-	buffer  string
-	mu      sync.Mutex
+	handler   StreamHandler
+	buffer    string
+	toolCalls []core.ToolCall
+	thinking  []core.ThinkingBlock
+	mu        sync.Mutex
 }
 
 // NewStreamProcessor creates a new stream processor
@@ -37,9 +39,12 @@ func NewStreamProcessor(handler StreamHandler) *StreamProcessor {
 func (p *StreamProcessor) Process(ctx context.Context, stream core.ResponseStream) (*core.Response, error) {
 	p.mu.Lock()
 	p.buffer = ""
+	p.toolCalls = nil
+	p.thinking = nil
 	p.mu.Unlock()
 	
-	var finalResponse *core.Response
+	response := &core.Response{}
+	isFinal := false
 	
 	for {
 		select {
@@ -50,34 +55,99 @@ func (p *StreamProcessor) Process(ctx context.Context, stream core.ResponseStrea
 			if err != nil {
 				if errors.Is(err, io.EOF) {
 					// Stream is complete
-					if finalResponse != nil {
-						return finalResponse, p.handler.Complete(finalResponse)
+					response.Text = p.buffer
+					response.ToolCalls = p.toolCalls
+					response.Thinking = p.thinking
+					if isFinal {
+						return response, p.handler.Complete(response)
 					}
-					return &core.Response{
-						Text: p.buffer,
-					}, nil
+					return response, nil
 				}
 				return nil, err
 			}
 			
 			p.mu.Lock()
 			p.buffer += chunk.Text
+			p.addToolCalls(chunk.ToolCalls)
+			p.addThinking(chunk.Thinking)
 			p.mu.Unlock()
 			
+			mergeMetadata(response, chunk)
+			
 			if err := p.handler.HandleChunk(chunk); err != nil {
 				return nil, err
 			}
 			
+			// Final chunks may only carry the usage after the finish reason
 			if chunk.IsFinal {
-				finalResponse = &core.Response{
-					Text:         p.buffer,
-					FinishReason: chunk.FinishReason,
+				isFinal = true
+				if chunk.FinishReason != "" {
+					response.FinishReason = chunk.FinishReason
 				}
 			}
 		}
 	}
 }
 
+// mergeMetadata copies the metadata of a chunk to the response. Later values
+// supersede earlier ones, as usage is reported cumulatively.
+func mergeMetadata(response *core.Response, chunk *core.ResponseChunk) {
+	if chunk.ID != "" {
+		response.ID = chunk.ID
+	}
+	if chunk.TokensUsed != nil {
+		response.TokensUsed = chunk.TokensUsed
+	}
+	if chunk.ModelInfo != nil {
+		response.ModelInfo = chunk.ModelInfo
+	}
+	if chunk.ProviderInfo != nil {
+		response.ProviderInfo = chunk.ProviderInfo
+	}
+}
+
+// addToolCalls merges streamed tool call fragments into the tool calls
+// assembled so far
+func (p *StreamProcessor) addToolCalls(fragments []core.ToolCall) {
+	for _, fragment := range fragments {
+		i := 0
+		for i < len(p.toolCalls) && p.toolCalls[i].Index != fragment.Index {
+			i++
+		}
+		if i == len(p.toolCalls) {
+			p.toolCalls = append(p.toolCalls, core.ToolCall{Index: fragment.Index})
+		}
+		
+		call := &p.toolCalls[i]
+		if fragment.ID != "" {
+			call.ID = fragment.ID
+		}
+		if fragment.Name != "" {
+			call.Name = fragment.Name
+		}
+		call.Arguments += fragment.Arguments
+	}
+}
+
+// addThinking merges streamed reasoning fragments into the thinking blocks
+// assembled so far
+func (p *StreamProcessor) addThinking(fragments []core.ThinkingBlock) {
+	for _, fragment := range fragments {
+		i := 0
+		for i < len(p.thinking) && p.thinking[i].Index != fragment.Index {
+			i++
+		}
+		if i == len(p.thinking) {
+			p.thinking = append(p.thinking, core.ThinkingBlock{Index: fragment.Index})
+		}
+		
+		block := &p.thinking[i]
+		block.Text += fragment.Text
+		block.Signature += fragment.Signature
+		block.RedactedData += fragment.RedactedData
+	}
+}
+
 // DefaultStreamHandler is a simple implementation of StreamHandler
 type DefaultStreamHandler struct {
 	OnChunk    func(chunk *core.ResponseChunk) error
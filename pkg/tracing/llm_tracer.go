@@ -18,6 +18,16 @@ func (t *LLMTracer) Name() string {
 	return t.tracerName
 }
 
+// Capabilities returns the capabilities of the model of the wrapped provider
+func (t *LLMTracer) Capabilities() (core.ModelCapabilities, bool) {
+	return core.CapabilitiesOf(t.provider)
+}
+
+// Model returns the name of the model of the wrapped provider
+func (t *LLMTracer) Model() string {
+	return core.ModelOf(t.provider)
+}
+
 // NewLLMTracer creates a new LLM tracer
 func NewLLMTracer(name string, provider core.LLMProvider, tracer Tracer) *LLMTracer {
 	return &LLMTracer{
@@ -27,6 +37,14 @@ func NewLLMTracer(name string, provider core.LLMProvider, tracer Tracer) *LLMTra
 	}
 }
 
+// Middleware returns a core.Middleware that traces LLM interactions with the
+// given tracer, keeping the name of the wrapped provider
+func Middleware(tracer Tracer) core.Middleware {
+	return func(provider core.LLMProvider) core.LLMProvider {
+		return NewLLMTracer(provider.Name(), provider, tracer)
+	}
+}
+
 // Generate generates a response for a prompt with tracing
 func (t *LLMTracer) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
 	// Start a span for the generation
@@ -41,12 +59,14 @@ func (t *LLMTracer) Generate(ctx context.Context, prompt *core.Prompt) (*core.Re
 	// End the span
 	if err != nil {
 		t.tracer.EndSpan(ctx, SpanStatusError)
-		return nil, err
+		return response, err
 	}
 
 	// Add response attributes to the span
 	t.tracer.SetAttribute(ctx, "response_length", len(response.Text))
-	t.tracer.SetAttribute(ctx, "tokens_used", response.TokensUsed.Total)
+	if response.TokensUsed != nil {
+		t.tracer.SetAttribute(ctx, "tokens_used", response.TokensUsed.Total)
+	}
 
 	// End the span
 	t.tracer.EndSpan(ctx, SpanStatusOK)
@@ -107,6 +127,9 @@ func (s *tracedResponseStream) Next() (*core.ResponseChunk, error) {
 	if chunk.IsFinal {
 		s.tracer.SetAttribute(s.ctx, "finish_reason", chunk.FinishReason)
 	}
+	if chunk.TokensUsed != nil {
+		s.tracer.SetAttribute(s.ctx, "tokens_used", chunk.TokensUsed.Total)
+	}
 
 	return chunk, nil
 }
@@ -293,7 +293,7 @@ func (t *PhoenixTracer) EndSpan(ctx context.Context, status SpanStatus) {
 		Name:       span.Name,
 		StartTime:  span.StartTime.UnixNano() / 1000000, // Convert to milliseconds
 		EndTime:    span.EndTime.UnixNano() / 1000000,   // Convert to milliseconds
-		Status:     string(status),
+		Status:     status.String(),
 		Attributes: span.Attributes,
 		ProjectID:  t.projectID,
 	}
@@ -115,6 +115,20 @@ const (
 	SpanStatusCanceled
 )
 
+// String returns the name of the span status
+func (s SpanStatus) String() string {
+	switch s {
+	case SpanStatusOK:
+		return "OK"
+	case SpanStatusError:
+		return "ERROR"
+	case SpanStatusCanceled:
+		return "CANCELED"
+	default:
+		return fmt.Sprintf("SpanStatus(%d)", int(s))
+	}
+}
+
 // SpanEvent represents an event that occurred during a span
 type SpanEvent struct {
 	// Name is the name of the event
@@ -173,6 +187,13 @@ func WithParent(parent *Span) SpanOption {
 // spanKey is the context key for spans
 type spanKey struct{}
 
+// SpanFromContext returns the current span of a context, or nil if there is
+// none
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
 // ConsoleTracer is a simple tracer that logs to the console
 type ConsoleTracer struct {
 	mu    sync.Mutex
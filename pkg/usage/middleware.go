@@ -0,0 +1,215 @@
+package usage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/optimization"
+)
+
+// UsageMiddleware is a middleware that records the usage of the requests to
+// an LLM provider and rejects requests once a budget is exhausted
+type UsageMiddleware struct {
+	provider  core.LLMProvider
+	tracker   *Tracker
+	estimator optimization.TokenEstimator
+
+	mu    sync.Mutex
+	model string
+}
+
+// UsageOption configures a UsageMiddleware
+type UsageOption func(*UsageMiddleware)
+
+// WithTokenEstimator sets the estimator used to count the tokens of
+// streaming responses that do not report their usage
+func WithTokenEstimator(estimator optimization.TokenEstimator) UsageOption {
+	return func(m *UsageMiddleware) {
+		m.estimator = estimator
+	}
+}
+
+// NewUsageMiddleware creates a new usage middleware recording to the given
+// tracker
+func NewUsageMiddleware(provider core.LLMProvider, tracker *Tracker, opts ...UsageOption) *UsageMiddleware {
+	m := &UsageMiddleware{
+		provider:  provider,
+		tracker:   tracker,
+		estimator: &optimization.SimpleTokenEstimator{},
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// Middleware returns a core.Middleware that records usage to the given
+// tracker
+func Middleware(tracker *Tracker, opts ...UsageOption) core.Middleware {
+	return func(provider core.LLMProvider) core.LLMProvider {
+		return NewUsageMiddleware(provider, tracker, opts...)
+	}
+}
+
+// Name returns the name of the wrapped provider
+func (m *UsageMiddleware) Name() string {
+	return m.provider.Name()
+}
+
+// Capabilities returns the capabilities of the model of the wrapped provider
+func (m *UsageMiddleware) Capabilities() (core.ModelCapabilities, bool) {
+	return core.CapabilitiesOf(m.provider)
+}
+
+// Model returns the name of the model of the wrapped provider
+func (m *UsageMiddleware) Model() string {
+	return core.ModelOf(m.provider)
+}
+
+// Generate generates a response for a prompt and records its usage. The
+// usage of responses returned with an error, such as output failing its
+// schema, is recorded too.
+func (m *UsageMiddleware) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
+	if err := m.tracker.Check(ctx, m.provider.Name(), m.budgetModel()); err != nil {
+		return nil, err
+	}
+
+	response, err := m.provider.Generate(ctx, prompt)
+	if response == nil {
+		return nil, err
+	}
+
+	var reported string
+	if response.ModelInfo != nil {
+		reported = response.ModelInfo.Name
+	}
+	m.tracker.Record(ctx, m.provider.Name(), m.recordModel(reported), response.TokensUsed)
+
+	return response, err
+}
+
+// GenerateStream generates a streaming response for a prompt. The usage is
+// recorded when the stream ends, and estimated if the provider does not
+// report it.
+func (m *UsageMiddleware) GenerateStream(ctx context.Context, prompt *core.Prompt) (core.ResponseStream, error) {
+	if err := m.tracker.Check(ctx, m.provider.Name(), m.budgetModel()); err != nil {
+		return nil, err
+	}
+
+	stream, err := m.provider.GenerateStream(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	promptTokens := m.estimator.EstimateTokens(prompt.SystemMessage)
+	for _, msg := range prompt.Conversation() {
+		promptTokens += m.estimator.EstimateTokens(msg.Content)
+	}
+
+	return &usageStream{
+		stream:       stream,
+		middleware:   m,
+		ctx:          ctx,
+		promptTokens: promptTokens,
+	}, nil
+}
+
+// budgetModel returns the model of the wrapped provider, which is used to
+// match model budgets before a request, or the model reported by the last
+// response if the provider does not expose it
+func (m *UsageMiddleware) budgetModel() string {
+	if model := core.ModelOf(m.provider); model != "" {
+		return model
+	}
+	return m.lastModel()
+}
+
+// recordModel returns the model usage is recorded under, which is the model
+// budgets are matched with. The model reported by a response, which may be a
+// dated snapshot of the model of the provider, is only used if the provider
+// does not expose its model.
+func (m *UsageMiddleware) recordModel(reported string) string {
+	if model := core.ModelOf(m.provider); model != "" {
+		return model
+	}
+	if reported != "" {
+		m.setModel(reported)
+		return reported
+	}
+	return m.lastModel()
+}
+
+// lastModel returns the model reported by the last response
+func (m *UsageMiddleware) lastModel() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.model
+}
+
+// setModel sets the model reported by the last response
+func (m *UsageMiddleware) setModel(model string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.model = model
+}
+
+// usageStream is a response stream that records its usage once it ends or is
+// closed
+type usageStream struct {
+	stream       core.ResponseStream
+	middleware   *UsageMiddleware
+	ctx          context.Context
+	promptTokens int
+	text         strings.Builder
+	usage        *core.TokenUsage
+	model        string
+	once         sync.Once
+}
+
+// Next returns the next chunk from the stream
+func (s *usageStream) Next() (*core.ResponseChunk, error) {
+	chunk, err := s.stream.Next()
+	if chunk != nil {
+		s.text.WriteString(chunk.Text)
+		if chunk.TokensUsed != nil {
+			s.usage = chunk.TokensUsed
+		}
+		if chunk.ModelInfo != nil && chunk.ModelInfo.Name != "" {
+			s.model = chunk.ModelInfo.Name
+		}
+	}
+	if err == io.EOF {
+		s.record()
+	}
+	return chunk, err
+}
+
+// Close closes the stream and records its usage
+func (s *usageStream) Close() error {
+	s.record()
+	return s.stream.Close()
+}
+
+// record records the usage of the stream once. The usage reported by the
+// provider is preferred over the estimate.
+func (s *usageStream) record() {
+	s.once.Do(func() {
+		m := s.middleware
+
+		usage := s.usage
+		if usage == nil {
+			completionTokens := m.estimator.EstimateTokens(s.text.String())
+			usage = &core.TokenUsage{
+				Prompt:     s.promptTokens,
+				Completion: completionTokens,
+				Total:      s.promptTokens + completionTokens,
+			}
+		}
+		m.tracker.Record(s.ctx, m.provider.Name(), m.recordModel(s.model), usage)
+	})
+}
@@ -0,0 +1,453 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+)
+
+// ErrBudgetExceeded is the underlying error of the requests rejected because
+// a budget is exhausted
+var ErrBudgetExceeded = errors.New("usage budget exceeded")
+
+// tagKey is the context key of the usage tag
+type tagKey struct{}
+
+// WithTag returns a context whose requests are recorded under the given tag,
+// such as a tenant or customer ID
+func WithTag(ctx context.Context, tag string) context.Context {
+	return context.WithValue(ctx, tagKey{}, tag)
+}
+
+// TagFromContext returns the usage tag of a context
+func TagFromContext(ctx context.Context) string {
+	tag, _ := ctx.Value(tagKey{}).(string)
+	return tag
+}
+
+// Price is the price of a model in currency units per million tokens
+type Price struct {
+	// Prompt is the price of a million prompt tokens
+	Prompt float64
+
+	// Completion is the price of a million completion tokens
+	Completion float64
+
+	// CacheRead is the price of a million prompt tokens read from the prompt
+	// cache (optional, defaults to Prompt)
+	CacheRead float64
+
+	// CacheWrite is the price of a million prompt tokens written to the
+	// prompt cache (optional, defaults to Prompt)
+	CacheWrite float64
+}
+
+// Cost returns the cost of the given token counts
+func (p Price) Cost(promptTokens, completionTokens int) float64 {
+	return (float64(promptTokens)*p.Prompt + float64(completionTokens)*p.Completion) / 1e6
+}
+
+// UsageCost returns the cost of a token usage, with the cached prompt tokens
+// at their own price
+func (p Price) UsageCost(usage *core.TokenUsage) float64 {
+	cost := p.Cost(usage.Prompt, usage.Completion)
+	if p.CacheRead > 0 {
+		cost += float64(usage.CacheRead) * (p.CacheRead - p.Prompt) / 1e6
+	}
+	if p.CacheWrite > 0 {
+		cost += float64(usage.CacheWrite) * (p.CacheWrite - p.Prompt) / 1e6
+	}
+	return cost
+}
+
+// PriceTable maps models to their price. Keys are "provider/model", "model"
+// or "provider", and are looked up in that order.
+type PriceTable map[string]Price
+
+// Lookup returns the price of a model
+func (t PriceTable) Lookup(provider, model string) (Price, bool) {
+	for _, key := range []string{provider + "/" + model, model, provider} {
+		if price, ok := t[key]; ok && key != "" {
+			return price, true
+		}
+	}
+	return Price{}, false
+}
+
+// Period is the length of the periods in which usage is summarized
+type Period int
+
+const (
+	// PeriodTotal covers all recorded usage
+	PeriodTotal Period = iota
+
+	// PeriodHour covers an hour
+	PeriodHour
+
+	// PeriodDay covers a day
+	PeriodDay
+
+	// PeriodMonth covers a calendar month
+	PeriodMonth
+)
+
+// String returns the name of the period
+func (p Period) String() string {
+	switch p {
+	case PeriodTotal:
+		return "total"
+	case PeriodHour:
+		return "hour"
+	case PeriodDay:
+		return "day"
+	case PeriodMonth:
+		return "month"
+	default:
+		return fmt.Sprintf("Period(%d)", int(p))
+	}
+}
+
+// Start returns the start of the period containing t, in UTC
+func (p Period) Start(t time.Time) time.Time {
+	t = t.UTC()
+	switch p {
+	case PeriodHour:
+		return t.Truncate(time.Hour)
+	case PeriodDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case PeriodMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Time{}
+	}
+}
+
+// Summary is the usage of a set of requests
+type Summary struct {
+	// Requests is the number of requests
+	Requests int
+
+	// PromptTokens is the number of prompt tokens
+	PromptTokens int
+
+	// CompletionTokens is the number of completion tokens
+	CompletionTokens int
+
+	// TotalTokens is the total number of tokens
+	TotalTokens int
+
+	// CacheReadTokens is the number of prompt tokens read from the prompt
+	// cache
+	CacheReadTokens int
+
+	// CacheWriteTokens is the number of prompt tokens written to the prompt
+	// cache
+	CacheWriteTokens int
+
+	// Cost is the cost of the requests. Requests to models without a price
+	// cost nothing.
+	Cost float64
+}
+
+// add adds a summary to the summary
+func (s *Summary) add(other Summary) {
+	s.Requests += other.Requests
+	s.PromptTokens += other.PromptTokens
+	s.CompletionTokens += other.CompletionTokens
+	s.TotalTokens += other.TotalTokens
+	s.CacheReadTokens += other.CacheReadTokens
+	s.CacheWriteTokens += other.CacheWriteTokens
+	s.Cost += other.Cost
+}
+
+// PeriodSummary is the usage of a period
+type PeriodSummary struct {
+	Summary
+
+	// Start is the start of the period
+	Start time.Time
+}
+
+// Filter selects the usage to summarize. Empty fields match everything.
+type Filter struct {
+	// Provider is the name of the provider
+	Provider string
+
+	// Model is the name of the model
+	Model string
+
+	// Tag is the usage tag
+	Tag string
+
+	// Since excludes the usage recorded before the hour containing it
+	Since time.Time
+
+	// Until excludes the usage recorded from the hour containing it
+	Until time.Time
+}
+
+// Dimension is a dimension by which usage is grouped
+type Dimension int
+
+const (
+	// DimensionProvider groups usage by provider
+	DimensionProvider Dimension = iota
+
+	// DimensionModel groups usage by model
+	DimensionModel
+
+	// DimensionTag groups usage by tag
+	DimensionTag
+)
+
+// Budget limits the usage of the requests it matches within a period.
+// Limits set to 0 are not enforced.
+type Budget struct {
+	// Provider restricts the budget to a provider (optional)
+	Provider string
+
+	// Model restricts the budget to a model (optional)
+	Model string
+
+	// Tag restricts the budget to a tag (optional)
+	Tag string
+
+	// PerTag gives every tag its own budget instead of sharing it. Untagged
+	// requests are not limited by per-tag budgets.
+	PerTag bool
+
+	// Period is the period after which the budget is renewed
+	Period Period
+
+	// MaxCost is the maximum cost within the period
+	MaxCost float64
+
+	// MaxTokens is the maximum number of tokens within the period
+	MaxTokens int
+}
+
+// key identifies the usage aggregated in an hour
+type key struct {
+	hour     int64
+	provider string
+	model    string
+	tag      string
+}
+
+// Tracker records the token usage and cost of requests and enforces
+// budgets. Usage is aggregated by hour, provider, model and tag, so memory
+// does not grow with the number of requests.
+type Tracker struct {
+	prices  PriceTable
+	budgets []Budget
+	now     func() time.Time
+
+	mu      sync.RWMutex
+	entries map[key]*Summary
+}
+
+// TrackerOption configures a Tracker
+type TrackerOption func(*Tracker)
+
+// WithPrices sets the price table used to compute costs
+func WithPrices(prices PriceTable) TrackerOption {
+	return func(t *Tracker) {
+		t.prices = prices
+	}
+}
+
+// WithBudget adds a budget
+func WithBudget(budget Budget) TrackerOption {
+	return func(t *Tracker) {
+		t.budgets = append(t.budgets, budget)
+	}
+}
+
+// WithClock sets the function returning the current time
+func WithClock(now func() time.Time) TrackerOption {
+	return func(t *Tracker) {
+		t.now = now
+	}
+}
+
+// NewTracker creates a new usage tracker
+func NewTracker(opts ...TrackerOption) *Tracker {
+	t := &Tracker{
+		prices:  PriceTable{},
+		now:     time.Now,
+		entries: make(map[key]*Summary),
+	}
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
+}
+
+// Record records the usage of a request and returns its cost. The tag is
+// taken from the context.
+func (t *Tracker) Record(ctx context.Context, provider, model string, usage *core.TokenUsage) float64 {
+	summary := Summary{Requests: 1}
+	if usage != nil {
+		summary.PromptTokens = usage.Prompt
+		summary.CompletionTokens = usage.Completion
+		summary.TotalTokens = usage.Total
+		if summary.TotalTokens == 0 {
+			summary.TotalTokens = usage.Prompt + usage.Completion
+		}
+		summary.CacheReadTokens = usage.CacheRead
+		summary.CacheWriteTokens = usage.CacheWrite
+	}
+	if price, ok := t.prices.Lookup(provider, model); ok && usage != nil {
+		summary.Cost = price.UsageCost(usage)
+	}
+
+	k := key{
+		hour:     PeriodHour.Start(t.now()).Unix(),
+		provider: provider,
+		model:    model,
+		tag:      TagFromContext(ctx),
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, exists := t.entries[k]
+	if !exists {
+		entry = &Summary{}
+		t.entries[k] = entry
+	}
+	entry.add(summary)
+
+	return summary.Cost
+}
+
+// Check returns an error if a request to the given provider and model, with
+// the tag of the context, would exceed a budget. Requests in flight are not
+// counted, so concurrent requests may overshoot a budget.
+func (t *Tracker) Check(ctx context.Context, provider, model string) error {
+	tag := TagFromContext(ctx)
+	now := t.now()
+
+	for _, budget := range t.budgets {
+		if (budget.Provider != "" && budget.Provider != provider) ||
+			(budget.Model != "" && budget.Model != model) ||
+			(budget.Tag != "" && budget.Tag != tag) ||
+			(budget.PerTag && tag == "") {
+			continue
+		}
+
+		filter := Filter{Provider: budget.Provider, Model: budget.Model, Tag: budget.Tag}
+		if budget.PerTag {
+			filter.Tag = tag
+		}
+		if budget.Period != PeriodTotal {
+			filter.Since = budget.Period.Start(now)
+		}
+
+		spent := t.Totals(filter)
+		if (budget.MaxCost > 0 && spent.Cost >= budget.MaxCost) ||
+			(budget.MaxTokens > 0 && spent.TotalTokens >= budget.MaxTokens) {
+			return &core.ProviderError{
+				Provider: provider,
+				Category: core.ErrorCategoryQuotaExceeded,
+				Message:  fmt.Sprintf("%s: %d tokens and %.4f spent this %s", ErrBudgetExceeded, spent.TotalTokens, spent.Cost, budget.Period),
+				Err:      ErrBudgetExceeded,
+			}
+		}
+	}
+
+	return nil
+}
+
+// Totals returns the usage matching the filter
+func (t *Tracker) Totals(filter Filter) Summary {
+	var total Summary
+	t.each(filter, func(k key, s *Summary) {
+		total.add(*s)
+	})
+	return total
+}
+
+// TotalsBy returns the usage matching the filter grouped by a dimension
+func (t *Tracker) TotalsBy(dimension Dimension, filter Filter) map[string]Summary {
+	totals := make(map[string]Summary)
+	t.each(filter, func(k key, s *Summary) {
+		var group string
+		switch dimension {
+		case DimensionProvider:
+			group = k.provider
+		case DimensionModel:
+			group = k.model
+		case DimensionTag:
+			group = k.tag
+		}
+
+		total := totals[group]
+		total.add(*s)
+		totals[group] = total
+	})
+	return totals
+}
+
+// Summaries returns the usage matching the filter per period, in
+// chronological order. Periods without usage are omitted.
+func (t *Tracker) Summaries(period Period, filter Filter) []PeriodSummary {
+	periods := make(map[time.Time]*PeriodSummary)
+	t.each(filter, func(k key, s *Summary) {
+		start := period.Start(time.Unix(k.hour, 0))
+		summary, exists := periods[start]
+		if !exists {
+			summary = &PeriodSummary{Start: start}
+			periods[start] = summary
+		}
+		summary.add(*s)
+	})
+
+	summaries := make([]PeriodSummary, 0, len(periods))
+	for _, summary := range periods {
+		summaries = append(summaries, *summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Start.Before(summaries[j].Start)
+	})
+	return summaries
+}
+
+// Reset removes all recorded usage
+func (t *Tracker) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = make(map[key]*Summary)
+}
+
+// each calls fn for the aggregated usage matching the filter
+func (t *Tracker) each(filter Filter, fn func(k key, s *Summary)) {
+	var since, until int64
+	if !filter.Since.IsZero() {
+		since = PeriodHour.Start(filter.Since).Unix()
+	}
+	if !filter.Until.IsZero() {
+		until = PeriodHour.Start(filter.Until).Unix()
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for k, s := range t.entries {
+		if (filter.Provider != "" && filter.Provider != k.provider) ||
+			(filter.Model != "" && filter.Model != k.model) ||
+			(filter.Tag != "" && filter.Tag != k.tag) ||
+			(!filter.Since.IsZero() && k.hour < since) ||
+			(!filter.Until.IsZero() && k.hour >= until) {
+			continue
+		}
+		fn(k, s)
+	}
+}
@@ -0,0 +1,263 @@
+package usage_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/GeoloeG-IsT/gollem/pkg/core"
+	"github.com/GeoloeG-IsT/gollem/pkg/usage"
+)
+
+// TestUsageMiddleware tests that usage is recorded per provider, model and
+// tag, and summarized
+func TestUsageMiddleware(t *testing.T) {
+	now := time.Date(2024, 3, 31, 23, 30, 0, 0, time.UTC)
+	tracker := usage.NewTracker(
+		usage.WithPrices(usage.PriceTable{"mock/mock-model": {Prompt: 1, Completion: 2}}),
+		usage.WithClock(func() time.Time { return now }),
+	)
+	provider := usage.NewUsageMiddleware(&MockProvider{}, tracker)
+
+	acme := usage.WithTag(context.Background(), "acme")
+	globex := usage.WithTag(context.Background(), "globex")
+	for _, ctx := range []context.Context{acme, acme, globex} {
+		if _, err := provider.Generate(ctx, core.NewPrompt("Hello")); err != nil {
+			t.Fatalf("Failed to generate response: %v", err)
+		}
+	}
+	now = now.Add(time.Hour)
+	if _, err := provider.Generate(acme, core.NewPrompt("Hello")); err != nil {
+		t.Fatalf("Failed to generate response: %v", err)
+	}
+
+	// Totals
+	total := tracker.Totals(usage.Filter{})
+	if total.Requests != 4 || total.PromptTokens != 400000 || total.CompletionTokens != 200000 || total.TotalTokens != 600000 {
+		t.Fatalf("Totals are incorrect: %+v", total)
+	}
+	if total.Cost != 0.8 {
+		t.Fatalf("Cost is incorrect: %v", total.Cost)
+	}
+
+	// Totals per tag
+	byTag := tracker.TotalsBy(usage.DimensionTag, usage.Filter{Model: "mock-model"})
+	if byTag["acme"].Requests != 3 || byTag["globex"].Requests != 1 {
+		t.Fatalf("Totals per tag are incorrect: %+v", byTag)
+	}
+
+	// Summaries per month
+	summaries := tracker.Summaries(usage.PeriodMonth, usage.Filter{Tag: "acme"})
+	if len(summaries) != 2 || summaries[0].Requests != 2 || summaries[1].Requests != 1 {
+		t.Fatalf("Monthly summaries are incorrect: %+v", summaries)
+	}
+	if !summaries[1].Start.Equal(time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Period start is incorrect: %v", summaries[1].Start)
+	}
+
+	// Streams are estimated once they end
+	stream, err := provider.GenerateStream(acme, core.NewPrompt("Hello"))
+	if err != nil {
+		t.Fatalf("Failed to generate stream: %v", err)
+	}
+	for {
+		if _, err := stream.Next(); err != nil {
+			break
+		}
+	}
+	stream.Close()
+	if total := tracker.Totals(usage.Filter{Tag: "acme"}); total.Requests != 4 || total.CompletionTokens <= 150000 {
+		t.Fatalf("Stream usage was not recorded: %+v", total)
+	}
+
+	// The usage reported by streams is preferred over the estimate
+	reported := usage.NewTracker()
+	provider = usage.NewUsageMiddleware(&MockProvider{
+		streamUsage: &core.TokenUsage{Prompt: 10, Completion: 20, Total: 30},
+	}, reported)
+	stream, err = provider.GenerateStream(acme, core.NewPrompt("Hello"))
+	if err != nil {
+		t.Fatalf("Failed to generate stream: %v", err)
+	}
+	for {
+		if _, err := stream.Next(); err != nil {
+			break
+		}
+	}
+	if total := reported.Totals(usage.Filter{Model: "mock-model"}); total.Requests != 1 || total.TotalTokens != 30 {
+		t.Fatalf("Reported stream usage was not recorded: %+v", total)
+	}
+}
+
+// TestCachedTokens tests that cached prompt tokens are priced separately
+func TestCachedTokens(t *testing.T) {
+	tracker := usage.NewTracker(usage.WithPrices(usage.PriceTable{
+		"anthropic": {Prompt: 3, Completion: 15, CacheRead: 0.3, CacheWrite: 3.75},
+		"openai":    {Prompt: 2.5, Completion: 10},
+	}))
+
+	tokens := &core.TokenUsage{Prompt: 1100000, Completion: 100000, CacheRead: 800000, CacheWrite: 200000}
+	cost := tracker.Record(context.Background(), "anthropic", "claude", tokens)
+	if expected := 0.3 + 1.5 + 0.24 + 0.75; cost < expected-1e-9 || cost > expected+1e-9 {
+		t.Fatalf("Cost is incorrect: %v, expected %v", cost, expected)
+	}
+
+	// Without cache prices, cached tokens cost the prompt price
+	if cost := tracker.Record(context.Background(), "openai", "gpt-4o", tokens); cost != 3.75 {
+		t.Fatalf("Cost is incorrect: %v", cost)
+	}
+
+	total := tracker.Totals(usage.Filter{})
+	if total.CacheReadTokens != 1600000 || total.CacheWriteTokens != 400000 {
+		t.Fatalf("Totals are incorrect: %+v", total)
+	}
+}
+
+// TestFailedResponses tests that the usage of responses returned with an
+// error is recorded, and the responses passed through
+func TestFailedResponses(t *testing.T) {
+	tracker := usage.NewTracker()
+	outputErr := &core.StructuredOutputError{Provider: "mock", Err: errors.New("invalid JSON")}
+	provider := usage.NewUsageMiddleware(&MockProvider{err: outputErr}, tracker)
+
+	response, err := provider.Generate(context.Background(), core.NewPrompt("Hello"))
+	if !errors.Is(err, outputErr) || response == nil || response.Text != "Hello" {
+		t.Fatalf("Expected the response with the structured output error, got %+v, %v", response, err)
+	}
+	if total := tracker.Totals(usage.Filter{}); total.Requests != 1 || total.TotalTokens != 150000 {
+		t.Fatalf("Usage of the failed response was not recorded: %+v", total)
+	}
+}
+
+// TestBudgets tests that requests are rejected once a budget is exhausted
+func TestBudgets(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	tracker := usage.NewTracker(
+		usage.WithPrices(usage.PriceTable{"mock": {Prompt: 1, Completion: 2}}),
+		usage.WithBudget(usage.Budget{PerTag: true, Period: usage.PeriodDay, MaxCost: 0.3}),
+		usage.WithClock(func() time.Time { return now }),
+	)
+	provider := usage.NewUsageMiddleware(&MockProvider{}, tracker)
+
+	acme := usage.WithTag(context.Background(), "acme")
+	for i := 0; i < 2; i++ {
+		if _, err := provider.Generate(acme, core.NewPrompt("Hello")); err != nil {
+			t.Fatalf("Request %d was rejected: %v", i, err)
+		}
+	}
+
+	// The budget of the tag is exhausted
+	_, err := provider.Generate(acme, core.NewPrompt("Hello"))
+	if !errors.Is(err, usage.ErrBudgetExceeded) {
+		t.Fatalf("Expected a budget error, got %v", err)
+	}
+	var providerErr *core.ProviderError
+	if !errors.As(err, &providerErr) || providerErr.Category != core.ErrorCategoryQuotaExceeded {
+		t.Fatalf("Expected a quota exceeded provider error, got %v", err)
+	}
+
+	// Other tags have their own budget
+	if _, err := provider.Generate(usage.WithTag(context.Background(), "globex"), core.NewPrompt("Hello")); err != nil {
+		t.Fatalf("Request for another tag was rejected: %v", err)
+	}
+
+	// The budget is renewed the next day
+	now = now.Add(24 * time.Hour)
+	if _, err := provider.Generate(acme, core.NewPrompt("Hello")); err != nil {
+		t.Fatalf("Request was rejected after the budget was renewed: %v", err)
+	}
+
+	// Model budgets apply from the first request of a middleware, with the
+	// model of the provider
+	tracker = usage.NewTracker(usage.WithBudget(usage.Budget{Model: "mock-model", MaxTokens: 100000}))
+	if _, err := usage.NewUsageMiddleware(&MockProvider{}, tracker).Generate(context.Background(), core.NewPrompt("Hello")); err != nil {
+		t.Fatalf("Request was rejected: %v", err)
+	}
+	provider = usage.NewUsageMiddleware(&MockProvider{}, tracker)
+	if _, err := provider.Generate(context.Background(), core.NewPrompt("Hello")); !errors.Is(err, usage.ErrBudgetExceeded) {
+		t.Fatalf("Expected a budget error on the first request, got %v", err)
+	}
+
+	// Streams count toward the budget of the model of the provider, even
+	// when they report a snapshot of the model
+	tracker = usage.NewTracker(usage.WithBudget(usage.Budget{Model: "mock-model", MaxTokens: 30}))
+	provider = usage.NewUsageMiddleware(&MockProvider{
+		streamUsage: &core.TokenUsage{Prompt: 10, Completion: 20, Total: 30},
+	}, tracker)
+	stream, err := provider.GenerateStream(context.Background(), core.NewPrompt("Hello"))
+	if err != nil {
+		t.Fatalf("Stream was rejected: %v", err)
+	}
+	for {
+		if _, err := stream.Next(); err != nil {
+			break
+		}
+	}
+	if _, err := provider.GenerateStream(context.Background(), core.NewPrompt("Hello")); !errors.Is(err, usage.ErrBudgetExceeded) {
+		t.Fatalf("Expected a budget error after the stream, got %v", err)
+	}
+}
+
+// MockProvider is a mock provider reporting a fixed token usage
+type MockProvider struct {
+	streamUsage *core.TokenUsage
+	err         error
+}
+
+// Name returns the name of the provider
+func (p *MockProvider) Name() string {
+	return "mock"
+}
+
+// Model returns the name of the model
+func (p *MockProvider) Model() string {
+	return "mock-model"
+}
+
+// Generate returns a response using 100k prompt and 50k completion tokens,
+// with the error of the provider if any
+func (p *MockProvider) Generate(ctx context.Context, prompt *core.Prompt) (*core.Response, error) {
+	return &core.Response{
+		Text:       prompt.Text,
+		TokensUsed: &core.TokenUsage{Prompt: 100000, Completion: 50000, Total: 150000},
+		ModelInfo:  &core.ModelInfo{Name: "mock-model", Provider: "mock"},
+	}, p.err
+}
+
+// GenerateStream returns a stream of a long response
+func (p *MockProvider) GenerateStream(ctx context.Context, prompt *core.Prompt) (core.ResponseStream, error) {
+	return &MockStream{chunks: 4, usage: p.streamUsage}, nil
+}
+
+// MockStream is a stream of long chunks, reporting the given usage with the
+// last chunk, along with a dated snapshot of the model
+type MockStream struct {
+	chunks int
+	usage  *core.TokenUsage
+}
+
+// Next returns the next chunk
+func (s *MockStream) Next() (*core.ResponseChunk, error) {
+	if s.chunks == 0 {
+		return nil, io.EOF
+	}
+	s.chunks--
+	text := make([]byte, 200000)
+	for i := range text {
+		text[i] = 'a'
+	}
+	chunk := &core.ResponseChunk{Text: string(text)}
+	if s.chunks == 0 && s.usage != nil {
+		chunk.IsFinal = true
+		chunk.TokensUsed = s.usage
+		chunk.ModelInfo = &core.ModelInfo{Name: "mock-model-2024-08-06", Provider: "mock"}
+	}
+	return chunk, nil
+}
+
+// Close closes the stream
+func (s *MockStream) Close() error {
+	return nil
+}
@@ -138,6 +138,11 @@ func validateType(data interface{}, schema JSONSchema, path string, errors *[]Va
 		dataType = "boolean"
 	}
 	
+	// JSON numbers decode as float64, so integers are checked in validateNumber
+	if schema.Type == "integer" && dataType == "number" {
+		return
+	}
+	
 	if schema.Type != dataType {
 		*errors = append(*errors, ValidationError{
 			Path:    path,